@@ -3,4 +3,4 @@ export DYLD_LIBRARY_PATH=../lib
 export CGO_CFLAGS=-I../include
 export CGO_LDFLAGS=-L../lib
 
-go run -v roc_server.go analyze "$1"
+go run -v roc_server.go roc_face_*.go analyze "$1"
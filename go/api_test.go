@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNumFacesToDetectRange(t *testing.T) {
+	var router = newRouter()
+	var image = testImage("alice")
+	for _, test := range []struct {
+		url    string
+		images map[string][]byte
+	}{
+		{"/crop?", map[string][]byte{"image": image}},
+		{"/analyze?", map[string][]byte{"image": image}},
+		{"/verify?faceSelection=largest&", map[string][]byte{"image1": image, "image2": image}},
+	} {
+		for _, value := range []string{"0", "-1"} {
+			var w = postImages(t, router, test.url+"numFacesToDetect="+value, test.images)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s with numFacesToDetect=%s: status %d, want 400", test.url, value, w.Code)
+			}
+		}
+
+		var w = postImages(t, router, test.url+"numFacesToDetect=1", test.images)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s with numFacesToDetect=1: status %d, want 200: %s", test.url, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestCropPaddingRange(t *testing.T) {
+	var router = newRouter()
+	var images = map[string][]byte{"image": testImage("alice")}
+	for _, url := range []string{"/crop?", "/analyze?crops=true&"} {
+		for _, padding := range []string{"-1", "2.5", "1e6", "Inf", "NaN"} {
+			if w := postImages(t, router, url+"padding="+padding, images); w.Code != http.StatusBadRequest {
+				t.Errorf("%s with padding=%s: status %d, want 400", url, padding, w.Code)
+			}
+		}
+
+		if w := postImages(t, router, url+"padding=2", images); w.Code != http.StatusOK {
+			t.Errorf("%s with padding=2: status %d, want 200: %s", url, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestColorSpaceIsRead(t *testing.T) {
+	var router = newRouter()
+	var image = testImage("alice")
+	for _, test := range []struct {
+		url    string
+		images map[string][]byte
+	}{
+		{"/crop?", map[string][]byte{"image": image}},
+		{"/quality?", map[string][]byte{"image": image}},
+		{"/analyze?", map[string][]byte{"image": image}},
+		{"/verify?", map[string][]byte{"image1": image, "image2": image}},
+	} {
+		if w := postImages(t, router, test.url+"colorSpace=rgb", test.images); w.Code != http.StatusBadRequest {
+			t.Errorf("%s with colorSpace=rgb: status %d, want 400", test.url, w.Code)
+		}
+
+		if w := postImages(t, router, test.url+"colorSpace="+colorSpaceBGR24, test.images); w.Code != http.StatusOK {
+			t.Errorf("%s with colorSpace=%s: status %d, want 200: %s", test.url, colorSpaceBGR24, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestVerboseVerifyCountsFaces(t *testing.T) {
+	var w = postImages(t, newRouter(), "/verify?verbose=true", map[string][]byte{
+		"image1": testImage("alice", "bob"),
+		"image2": testImage("alice"),
+	})
+
+	var result verificationResult
+	var err = json.Unmarshal(w.Body.Bytes(), &result)
+	if err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status %d, %v: %s", w.Code, err, w.Body.String())
+	}
+
+	if len(result.Images) != 2 || result.Images[0].FaceCount != 2 || !strings.Contains(strings.Join(result.Images[0].Warnings, ","), warningMultipleFaces) {
+		t.Errorf("images = %+v, want 2 faces and a %s warning in the first", result.Images, warningMultipleFaces)
+	}
+
+	// the best face, alice, is still the one compared
+	if result.Code != "" || result.Similarity < mockSameMinSimilarity {
+		t.Errorf("similarity = %v (%s), want alice compared to alice", result.Similarity, result.Code)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// allowAttributes replaces the allowlist, and returns a function restoring it
+func allowAttributes(names ...string) func() {
+	var previous = allowedAttributes
+	allowedAttributes = map[string]bool{}
+	for _, name := range names {
+		allowedAttributes[name] = true
+	}
+
+	return func() {
+		allowedAttributes = previous
+	}
+}
+
+func TestAllowedAttributes(t *testing.T) {
+	var restore = allowAttributes("recognition", "landmarks")
+	defer restore()
+
+	if err := requireAttribute("landmarks"); err != nil {
+		t.Errorf("requireAttribute(landmarks) = %v, want no error", err)
+	}
+
+	if err := requireAttribute("spoof"); err == nil {
+		t.Errorf("requireAttribute(spoof) = nil, want an error")
+	}
+
+	if _, err := parseAttributes("recognition,pose"); err == nil {
+		t.Errorf("parseAttributes accepted pose")
+	}
+
+	var allowed = allowedOnly([]string{"recognition", "pose", "landmarks", "lips"})
+	if want := []string{"recognition", "landmarks"}; !reflect.DeepEqual(allowed, want) {
+		t.Errorf("allowedOnly = %v, want %v", allowed, want)
+	}
+
+	// checks of attributes that aren't enabled say so
+	if check := skipCheck("yaw", "Yaw"); !check.Skipped || !strings.Contains(check.Reason, "pose attribute is not enabled") {
+		t.Errorf("skipped yaw check = %+v, want the pose attribute named", check)
+	}
+
+	if check := skipCheck("quality", "Quality"); !check.Skipped || !strings.Contains(check.Reason, "returned no Quality") {
+		t.Errorf("skipped quality check = %+v, want the missing metadata named", check)
+	}
+}
+
+func TestAttributeAllowlist(t *testing.T) {
+	var router = newRouter()
+	var alice = testImage("alice")
+	var post = func(url string, images map[string][]byte, result interface{}) int {
+		var w = postImages(t, router, url, images)
+		if result != nil && w.Code == http.StatusOK {
+			json.Unmarshal(w.Body.Bytes(), result)
+		}
+
+		return w.Code
+	}
+
+	var crops cropResult
+	if status := post("/crop", map[string][]byte{"image": alice}, &crops); status != http.StatusOK || len(crops.Faces) != 1 || crops.Faces[0].Aligned == nil {
+		t.Fatalf("crop with every attribute allowed: %d, %+v, want an aligned crop", status, crops)
+	}
+
+	var restore = allowAttributes("recognition")
+	defer restore()
+
+	if status := post("/analyze?attributes=pose", map[string][]byte{"image": alice}, nil); status != http.StatusBadRequest {
+		t.Errorf("analyze pose: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	// the checks of other attributes are skipped, so the image doesn't pass
+	var quality qualityResult
+	if status := post("/quality", map[string][]byte{"image": alice}, &quality); status != http.StatusOK || quality.Passed {
+		t.Errorf("quality: %d, %+v, want a result that doesn't pass", status, quality)
+	}
+
+	for _, check := range quality.Checks {
+		var skipped = check.Name == "iod" || check.Name == "yaw" || check.Name == "pitch" || check.Name == "roll"
+		if skipped != check.Skipped || (skipped && !strings.Contains(check.Reason, "attribute is not enabled")) {
+			t.Errorf("quality check %+v, want skipped %v", check, skipped)
+		}
+	}
+
+	crops = cropResult{}
+	if status := post("/crop", map[string][]byte{"image": alice}, &crops); status != http.StatusOK || len(crops.Faces) != 1 || crops.Faces[0].Aligned != nil {
+		t.Errorf("crop: %d, %+v, want a crop that isn't aligned", status, crops)
+	}
+
+	var images = map[string][]byte{"image1": alice, "image2": alice}
+	if status := post("/verify?spoof=report", images, nil); status != http.StatusBadRequest {
+		t.Errorf("verify with a spoof policy: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	var verification verificationResult
+	if status := post("/verify?verbose=true", images, &verification); status != http.StatusOK || len(verification.Images) != 2 {
+		t.Fatalf("verbose verify: %d, %+v", status, verification)
+	}
+
+	if details := verification.Images[0]; details.Quality == nil || details.Yaw != nil || details.IOD != nil {
+		t.Errorf("verbose verify details = %+v, want the quality only", details)
+	}
+
+	// templates are still compared without the recognition attribute, but
+	// its quality isn't returned
+	allowedAttributes = map[string]bool{"pose": true}
+	verification = verificationResult{}
+	if status := post("/verify?verbose=true", images, &verification); status != http.StatusOK || verification.Similarity < mockSameMinSimilarity {
+		t.Fatalf("verify without recognition: %d, %+v", status, verification)
+	}
+
+	if details := verification.Images[0]; details.Quality != nil || details.Yaw == nil {
+		t.Errorf("verbose verify details without recognition = %+v, want the pose only", details)
+	}
+}
@@ -0,0 +1,9 @@
+#!/bin/bash
+
+# checks the audit log (see roc_face_audit.go) for tampering, no SDK or license
+# needed: ./audit.sh [DIR], DIR defaults to $ROC_FACE_AUDIT_DIR or
+# /tmp/roc-face-audit
+
+HERE=$(dirname $0)
+
+go run "$HERE"/roc_mock_server.go "$HERE"/roc_face_*.go audit verify "$@"
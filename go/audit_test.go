@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestAuditLog records the audit log in a temporary directory
+func newTestAuditLog(t *testing.T, maxBytes int64) (string, func()) {
+	var dir, err = ioutil.TempDir("", "roc-face-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audit, err = openAuditLog(dir, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() {
+		audit.file.Close()
+		audit = nil
+		os.RemoveAll(dir)
+	}
+}
+
+// readAuditEntries reads every entry of the audit log in dir
+func readAuditEntries(t *testing.T, dir string) []auditEntry {
+	var numbers, err = auditFileNumbers(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []auditEntry
+	for _, number := range numbers {
+		err = readAuditFile(filepath.Join(dir, fmt.Sprintf(auditFileFormat, number)), func(lineNumber int, entry *auditEntry, err error) {
+			if err != nil {
+				t.Errorf("audit file %d line %d: %s", number, lineNumber, err.Error())
+				return
+			}
+
+			entries = append(entries, *entry)
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return entries
+}
+
+// expectAuditProblem verifies the log in dir, expecting a problem containing
+// want, or none if want is empty
+func expectAuditProblem(t *testing.T, dir string, want string) {
+	var report, err = verifyAuditLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var problems = strings.Join(report.Problems, "\n")
+	if (want == "" && problems != "") || !strings.Contains(problems, want) {
+		t.Errorf("problems = %q, want %q", problems, want)
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	// a few entries per file
+	var dir, cleanup = newTestAuditLog(t, 1024)
+	defer cleanup()
+
+	for i := 0; i < 10; i++ {
+		var err = recordAudit(auditEntry{Route: "/verify", ImageHashes: []string{hashBytes([]byte{byte(i)})}, Decision: auditDecisionCompared}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var report, err = verifyAuditLog(dir)
+	if err != nil || len(report.Problems) != 0 || report.Entries != 10 || report.Files < 3 {
+		t.Fatalf("report = %+v, %v, want 10 entries in several files", report, err)
+	}
+
+	// the chain continues once the log is opened again
+	audit.file.Close()
+	audit, err = openAuditLog(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recordAudit(auditEntry{Route: "/analyze", Decision: auditDecisionAnalyzed}, map[string]int{"numFacesToDetect": 1})
+	report, _ = verifyAuditLog(dir)
+	if len(report.Problems) != 0 || report.Entries != 11 || report.LastHash != audit.lastHash {
+		t.Fatalf("after reopening, report = %+v, want 11 entries", report)
+	}
+
+	// a modified entry
+	var first = filepath.Join(dir, fmt.Sprintf(auditFileFormat, 1))
+	var data, _ = ioutil.ReadFile(first)
+	ioutil.WriteFile(first, bytes.Replace(data, []byte(`"compared"`), []byte(`"noMatch"`), 1), 0600)
+	expectAuditProblem(t, dir, "entry 1 was modified")
+
+	// a removed entry
+	var lines = bytes.SplitAfter(data, []byte("\n"))
+	ioutil.WriteFile(first, bytes.Join(append(lines[:1:1], lines[2:]...), nil), 0600)
+	expectAuditProblem(t, dir, "expected entry 2, found entry 3")
+	ioutil.WriteFile(first, data, 0600)
+	expectAuditProblem(t, dir, "")
+
+	// a removed file
+	os.Remove(filepath.Join(dir, fmt.Sprintf(auditFileFormat, 2)))
+	expectAuditProblem(t, dir, "missing file "+fmt.Sprintf(auditFileFormat, 2))
+}
+
+func TestJobsAreAudited(t *testing.T) {
+	var dir, cleanup = newTestAuditLog(t, defaultAuditMaxBytes)
+	defer cleanup()
+
+	var router, stop = newTestJobs(t)
+	defer stop()
+
+	var images = map[string][]byte{"alice1": testImage("alice"), "bob": testImage("bob"), "alice2": testImage("alice", "carol")}
+	var j = runJob(t, router, "/jobs?type=dedupe", images)
+	if j.Status != jobStatusSucceeded {
+		t.Fatalf("dedupe job %+v", j)
+	}
+
+	var entries = readAuditEntries(t, dir)
+	if len(entries) != 2 || entries[0].Decision != auditDecisionCompared || len(entries[0].ImageHashes) != 3 {
+		t.Fatalf("audit entries = %+v, want the comparison of 3 images and 1 duplicate", entries)
+	}
+
+	var duplicate = entries[1]
+	var aliceHashes = map[string]bool{hashImage(images["alice1"]): true, hashImage(images["alice2"]): true}
+	if duplicate.Decision != auditDecisionMatched || duplicate.Route != "/jobs" || duplicate.Similarity == nil ||
+		len(duplicate.ImageHashes) != 2 || !aliceHashes[duplicate.ImageHashes[0]] || !aliceHashes[duplicate.ImageHashes[1]] {
+		t.Errorf("duplicate entry = %+v, want alice's images matched", duplicate)
+	}
+
+	j = runJob(t, router, "/jobs?type=analyze", map[string][]byte{"alice": images["alice1"], "empty": testImage()})
+	entries = readAuditEntries(t, dir)[2:]
+	if j.Status != jobStatusSucceeded || len(entries) != 2 {
+		t.Fatalf("analyze job %+v, audit entries = %+v, want one per image", j, entries)
+	}
+
+	var decisions = map[string]string{}
+	for _, entry := range entries {
+		decisions[entry.ImageHashes[0]] = entry.Decision
+	}
+
+	// as /analyze records them
+	var results []analyzeJobResult
+	json.Unmarshal(j.Result, &results)
+	for _, result := range results {
+		var image = images["alice1"]
+		if result.Image == "empty.png" {
+			image = testImage()
+		}
+
+		if decision := decisions[hashImage(image)]; decision == "" || decision != analysisDecision(result.analysisResult) {
+			t.Errorf("%s decision = %q, want the one of %+v", result.Image, decision, result.analysisResult)
+		}
+	}
+}
+
+func TestQualityAndCropAreAudited(t *testing.T) {
+	var dir, cleanup = newTestAuditLog(t, defaultAuditMaxBytes)
+	defer cleanup()
+
+	var router = newRouter()
+	var alice = testImage("alice")
+	var quality qualityResult
+	json.NewDecoder(postImages(t, router, "/quality", map[string][]byte{"image": alice}).Body).Decode(&quality)
+	var qualityDecision = auditDecisionFailed
+	if quality.Passed {
+		qualityDecision = auditDecisionPassed
+	}
+
+	var tests = []struct {
+		url      string
+		image    []byte
+		decision string
+	}{
+		{"/quality", alice, qualityDecision},
+		{"/quality?profile=" + defaultQualityProfile, testImage(), "FaceNotDetected"},
+		{"/crop?padding=0.5", alice, auditDecisionCropped},
+		{"/crop", testImage(), "FaceNotDetected"},
+	}
+
+	for _, test := range tests[1:] {
+		if w := postImages(t, router, test.url, map[string][]byte{"image": test.image}); w.Code != http.StatusOK {
+			t.Fatalf("%s status = %d: %s", test.url, w.Code, w.Body.String())
+		}
+	}
+
+	var entries = readAuditEntries(t, dir)
+	if len(entries) != len(tests) {
+		t.Fatalf("audit entries = %+v, want one per request", entries)
+	}
+
+	for i, test := range tests {
+		var entry = entries[i]
+		if !strings.HasPrefix(test.url, entry.Route) || entry.Decision != test.decision ||
+			len(entry.ImageHashes) != 1 || entry.ImageHashes[0] != hashImage(test.image) {
+			t.Errorf("%s entry = %+v, want %q for its image", test.url, entry, test.decision)
+		}
+	}
+
+	var params faceAuditParams
+	json.Unmarshal(entries[2].Params, &params)
+	if params.Crop == nil || params.Crop.Padding != 0.5 || params.NumFacesToDetect != defaultNumFacesToDetect {
+		t.Errorf("crop params = %s, want the crop options", entries[2].Params)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// cachedFace is a detection of one face, with the template
+func cachedFace(template string) cachedDetection {
+	return cachedDetection{Faces: []detectedFace{{Template: []byte(template)}}}
+}
+
+func TestTemplateCacheEviction(t *testing.T) {
+	var cache = newTemplateCache(2, "")
+	cache.put("a", cachedFace("a"))
+	cache.put("b", cachedFace("b"))
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("a isn't cached")
+	}
+
+	// b is now the least recently used
+	cache.put("c", cachedFace("c"))
+	if _, ok := cache.get("b"); ok {
+		t.Error("b is still cached, want it evicted")
+	}
+
+	for _, key := range []string{"a", "c"} {
+		if detection, ok := cache.get(key); !ok || string(detection.Faces[0].Template) != key {
+			t.Errorf("%s: %+v, %v, want it cached", key, detection, ok)
+		}
+	}
+
+	var stats = cache.currentStats()
+	var want = cacheStats{Size: 2, Capacity: 2, Hits: 3, Misses: 1, Evictions: 1, HitRate: 0.75}
+	if stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	cache.clear()
+	if stats = cache.currentStats(); stats.Size != 0 || stats.Hits != 3 {
+		t.Errorf("stats after clear = %+v, want no entries and the counts kept", stats)
+	}
+}
+
+func TestTemplateCacheReload(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "roc-face-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	var cache = newTemplateCache(10, dir)
+	cache.put("alice", cachedFace("alice"))
+	cache.put("bob", cachedFace("bob"))
+	cache.put("alice-and-bob", cachedDetection{Faces: []detectedFace{{Template: []byte("alice")}, {Template: []byte("bob")}}})
+
+	// erased entries are removed from the directory too
+	var removed = cache.erase(func(template []byte) bool {
+		return bytes.Equal(template, []byte("bob"))
+	})
+
+	if removed != 2 {
+		t.Errorf("erase removed %d entries, want 2", removed)
+	}
+
+	var reloaded = newTemplateCache(10, dir)
+	err = reloaded.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if detection, ok := reloaded.get("alice"); !ok || string(detection.Faces[0].Template) != "alice" {
+		t.Errorf("alice after reload: %+v, %v, want it cached", detection, ok)
+	}
+
+	for _, key := range []string{"bob", "alice-and-bob"} {
+		if _, ok := reloaded.get(key); ok {
+			t.Errorf("%s is cached after reload, want it erased", key)
+		}
+	}
+
+	var files, _ = filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Errorf("cache files = %v, want alice's", files)
+	}
+}
+
+// versionedEngine is the mock engine with another SDK version
+type versionedEngine struct {
+	mockEngine
+	sdkVersion string
+}
+
+func (e versionedEngine) version() string {
+	return e.sdkVersion
+}
+
+func TestVerifyTemplateCache(t *testing.T) {
+	os.Setenv("ROC_FACE_CACHE_SIZE", "10")
+	defer os.Unsetenv("ROC_FACE_CACHE_SIZE")
+	var router = newRouter()
+	var err = enableTemplateCache(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		templates = nil
+	}()
+
+	var images = map[string][]byte{"image1": testImage("alice"), "image2": testImage("alice", "bob")}
+	var verify = func(url string, want cacheStats) {
+		var w = postImages(t, router, url, images)
+		var result verificationResult
+		json.Unmarshal(w.Body.Bytes(), &result)
+		if w.Code != http.StatusOK || result.Similarity < mockSameMinSimilarity {
+			t.Errorf("%s: status %d: %s", url, w.Code, w.Body.String())
+		}
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/cache", nil))
+		var stats cacheStats
+		json.Unmarshal(w.Body.Bytes(), &stats)
+		if stats.Size != want.Size || stats.Hits != want.Hits || stats.Misses != want.Misses {
+			t.Errorf("%s: stats = %+v, want %+v", url, stats, want)
+		}
+	}
+
+	verify("/verify", cacheStats{Size: 2, Misses: 2})
+	verify("/verify", cacheStats{Size: 2, Hits: 2, Misses: 2})
+
+	// skipCache neither reads nor fills the cache
+	verify("/verify?skipCache=true", cacheStats{Size: 2, Hits: 2, Misses: 2})
+
+	// detection parameters are part of the key
+	verify("/verify?colorSpace="+colorSpaceBGR24, cacheStats{Size: 4, Hits: 2, Misses: 4})
+
+	// so is the SDK version, templates of another one aren't reused
+	var previous = engine
+	engine = versionedEngine{mockEngine: previous.(mockEngine), sdkVersion: "mock-upgraded"}
+	defer func() {
+		engine = previous
+	}()
+
+	verify("/verify", cacheStats{Size: 6, Hits: 2, Misses: 6})
+
+	var w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/cache", nil))
+	if w.Code != http.StatusNoContent || templates.currentStats().Size != 0 {
+		t.Errorf("DELETE /cache: status %d, %+v", w.Code, templates.currentStats())
+	}
+}
@@ -0,0 +1,458 @@
+// Package client is a Go client for the roc-face HTTP API (see
+// roc_face_api.go), served by roc_server.go and roc_mock_server.go
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+const defaultMaxRetries = 3
+const defaultMinBackoff = 200 * time.Millisecond
+const defaultMaxBackoff = 5 * time.Second
+
+// Client sends requests to a roc-face server. Requests failing with a 5xx
+// status or a transport error are retried with exponential backoff. Every
+// attempt carries the same Idempotency-Key, so the server handles a request
+// once, e.g. records one /verify decision in its audit log, and answers
+// retries with the first response. The zero value uses http.DefaultClient,
+// without retries, use New for the defaults.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// APIError is a response with an error status, e.g. a 400 for an invalid
+// parameter
+type APIError struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (err *APIError) Error() string {
+	return fmt.Sprintf("roc-face: %d %s", err.StatusCode, err.Message)
+}
+
+// Image is an image to upload. Open is called on every attempt, so the
+// request can be retried, and the image is streamed to the server.
+type Image struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
+
+// ImageFromFile uploads the file at path
+func ImageFromFile(path string) Image {
+	return Image{
+		Name: filepath.Base(path),
+		Open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		},
+	}
+}
+
+// ImageFromBytes uploads an image held in memory
+func ImageFromBytes(name string, data []byte) Image {
+	return Image{
+		Name: name,
+		Open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		},
+	}
+}
+
+// ImageFromReader streams an image from r. It can only be read once, so
+// requests using it are not retried.
+func ImageFromReader(name string, r io.Reader) Image {
+	var used = false
+	return Image{
+		Name: name,
+		Open: func() (io.ReadCloser, error) {
+			if used {
+				return nil, errImageConsumed
+			}
+
+			used = true
+			return ioutil.NopCloser(r), nil
+		},
+	}
+}
+
+var errImageConsumed = errors.New("roc-face: image reader already consumed, can't retry")
+
+// VerifyOptions are the /verify query parameters, zero values leave the
+// server defaults
+type VerifyOptions struct {
+	ColorSpace         string
+	Verbose            bool
+	Spoof              string // spoof policy: none, report, reject, strict
+	SpoofLiveThreshold float64
+	SpoofThreshold     float64
+	RetryRotations     bool
+	RetryUpscale       bool
+	Detection          string // standard or tiled
+	FaceSelection      string
+	NumFacesToDetect   int
+	SkipCache          bool // detect the faces again, even for cached images
+	Receipt            bool // include a signed receipt, see VerifyReceipt
+}
+
+// AnalyzeOptions are the /analyze query parameters, zero values leave the
+// server defaults
+type AnalyzeOptions struct {
+	FDR                  float64
+	MinFaceWidthInPixels int
+	NumFacesToDetect     int
+	ColorSpace           string
+	Attributes           []string
+	Spoof                string
+	SpoofLiveThreshold   float64
+	SpoofThreshold       float64
+	RetryRotations       bool
+	RetryUpscale         bool
+	Detection            string
+	Crops                bool
+	Padding              float64
+	Format               string // crop format: jpeg or png
+}
+
+// CropOptions are the /crop query parameters, zero values leave the server
+// defaults
+type CropOptions struct {
+	FDR                  float64
+	MinFaceWidthInPixels int
+	NumFacesToDetect     int
+	ColorSpace           string
+	Padding              float64
+	Format               string
+	Unaligned            bool
+}
+
+// QualityOptions are the /quality query parameters, zero values leave the
+// server defaults
+type QualityOptions struct {
+	FDR                  float64
+	MinFaceWidthInPixels int
+	ColorSpace           string
+	Profile              string // selfie or passport
+}
+
+// New returns a client for the server at baseURL, e.g. http://localhost:8080
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		HTTPClient: http.DefaultClient,
+		MaxRetries: defaultMaxRetries,
+		MinBackoff: defaultMinBackoff,
+		MaxBackoff: defaultMaxBackoff,
+	}
+}
+
+// Verify compares the faces in two images
+func (c *Client) Verify(ctx context.Context, image1 Image, image2 Image, opts VerifyOptions) (*VerificationResult, error) {
+	var query = url.Values{}
+	setString(query, "colorSpace", opts.ColorSpace)
+	setBool(query, "verbose", opts.Verbose)
+	setString(query, "spoof", opts.Spoof)
+	setFloat(query, "spoofLiveThreshold", opts.SpoofLiveThreshold)
+	setFloat(query, "spoofThreshold", opts.SpoofThreshold)
+	setBool(query, "retryRotations", opts.RetryRotations)
+	setBool(query, "retryUpscale", opts.RetryUpscale)
+	setString(query, "detection", opts.Detection)
+	setString(query, "faceSelection", opts.FaceSelection)
+	setInt(query, "numFacesToDetect", opts.NumFacesToDetect)
+	setBool(query, "skipCache", opts.SkipCache)
+	setBool(query, "receipt", opts.Receipt)
+
+	var result VerificationResult
+	var err = c.post(ctx, "/verify", query, map[string]Image{"image1": image1, "image2": image2}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Analyze extracts the attributes of the face in an image
+func (c *Client) Analyze(ctx context.Context, image Image, opts AnalyzeOptions) (*AnalysisResult, error) {
+	var query = url.Values{}
+	setFloat(query, "fdr", opts.FDR)
+	setInt(query, "minFaceWidthInPixels", opts.MinFaceWidthInPixels)
+	setInt(query, "numFacesToDetect", opts.NumFacesToDetect)
+	setString(query, "colorSpace", opts.ColorSpace)
+	setString(query, "attributes", strings.Join(opts.Attributes, ","))
+	setString(query, "spoof", opts.Spoof)
+	setFloat(query, "spoofLiveThreshold", opts.SpoofLiveThreshold)
+	setFloat(query, "spoofThreshold", opts.SpoofThreshold)
+	setBool(query, "retryRotations", opts.RetryRotations)
+	setBool(query, "retryUpscale", opts.RetryUpscale)
+	setString(query, "detection", opts.Detection)
+	setBool(query, "crops", opts.Crops)
+	setFloat(query, "padding", opts.Padding)
+	setString(query, "format", opts.Format)
+
+	var result AnalysisResult
+	var err = c.post(ctx, "/analyze", query, map[string]Image{"image": image}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Crop returns crops of the faces in an image
+func (c *Client) Crop(ctx context.Context, image Image, opts CropOptions) (*CropResult, error) {
+	var query = url.Values{}
+	setFloat(query, "fdr", opts.FDR)
+	setInt(query, "minFaceWidthInPixels", opts.MinFaceWidthInPixels)
+	setInt(query, "numFacesToDetect", opts.NumFacesToDetect)
+	setString(query, "colorSpace", opts.ColorSpace)
+	setFloat(query, "padding", opts.Padding)
+	setString(query, "format", opts.Format)
+	if opts.Unaligned {
+		query.Set("aligned", "false")
+	}
+
+	var result CropResult
+	var err = c.post(ctx, "/crop", query, map[string]Image{"image": image}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Quality checks an image against a quality profile
+func (c *Client) Quality(ctx context.Context, image Image, opts QualityOptions) (*QualityResult, error) {
+	var query = url.Values{}
+	setFloat(query, "fdr", opts.FDR)
+	setInt(query, "minFaceWidthInPixels", opts.MinFaceWidthInPixels)
+	setString(query, "colorSpace", opts.ColorSpace)
+	setString(query, "profile", opts.Profile)
+
+	var result QualityResult
+	var err = c.post(ctx, "/quality", query, map[string]Image{"image": image}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// Ping checks that the server is up
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest("GET", c.BaseURL+"/ping", nil)
+	}, nil)
+}
+
+// post uploads the images as a multipart form and decodes the JSON response
+// into result
+func (c *Client) post(ctx context.Context, path string, query url.Values, images map[string]Image, result interface{}) error {
+	var endpoint = c.BaseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	// the server tells retries from other requests with the same key by their
+	// body, every attempt sends the same bytes
+	var boundary = multipart.NewWriter(ioutil.Discard).Boundary()
+	return c.do(ctx, func() (*http.Request, error) {
+		var body, contentType, err = multipartBody(images, boundary)
+		if err != nil {
+			return nil, err
+		}
+
+		var req *http.Request
+		req, err = http.NewRequest("POST", endpoint, body)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", contentType)
+		return req, nil
+	}, result)
+}
+
+// multipartBody streams the images as a multipart form, sorted by field
+func multipartBody(images map[string]Image, boundary string) (io.ReadCloser, string, error) {
+	var fields []string
+	for field := range images {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+	var files = map[string]io.ReadCloser{}
+	for field, image := range images {
+		var file, err = image.Open()
+		if err != nil {
+			for _, file := range files {
+				file.Close()
+			}
+
+			return nil, "", err
+		}
+
+		files[field] = file
+	}
+
+	var reader, writer = io.Pipe()
+	var form = multipart.NewWriter(writer)
+	form.SetBoundary(boundary)
+	go func() {
+		var err error
+		for _, field := range fields {
+			var file = files[field]
+			if err == nil {
+				var part io.Writer
+				part, err = form.CreateFormFile(field, images[field].Name)
+				if err == nil {
+					_, err = io.Copy(part, file)
+				}
+			}
+
+			file.Close()
+		}
+
+		if err == nil {
+			err = form.Close()
+		}
+
+		writer.CloseWithError(err)
+	}()
+
+	return reader, form.FormDataContentType(), nil
+}
+
+// do sends the request built by newRequest, retrying on 5xx statuses and
+// transport errors, and decodes the JSON response into result (if not nil).
+// A 5xx response the server replays is final.
+func (c *Client) do(ctx context.Context, newRequest func() (*http.Request, error), result interface{}) error {
+	var key, err = newIdempotencyKey()
+	if err != nil {
+		return err
+	}
+
+	var backoff = c.MinBackoff
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		var req, err = newRequest()
+		if err == errImageConsumed && lastErr != nil {
+			return lastErr
+		}
+
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set(idempotencyKeyHeader, key)
+		var res *http.Response
+		res, err = c.httpClient().Do(req.WithContext(ctx))
+		var retry = err != nil
+		if err == nil {
+			err = decodeResponse(res, result)
+			retry = res.StatusCode >= 500 && res.Header.Get(idempotentReplayedHeader) == ""
+		}
+
+		if err == nil || !retry || attempt >= c.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		lastErr = err
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+// newIdempotencyKey returns a random key for the attempts of one request
+func newIdempotencyKey() (string, error) {
+	var key = make([]byte, 16)
+	var _, err = rand.Read(key)
+	return hex.EncodeToString(key), err
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+
+	return c.HTTPClient
+}
+
+func decodeResponse(res *http.Response, result interface{}) error {
+	defer res.Body.Close()
+	var data, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		var apiErr = &APIError{StatusCode: res.StatusCode}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+func setString(query url.Values, name string, value string) {
+	if value != "" {
+		query.Set(name, value)
+	}
+}
+
+func setBool(query url.Values, name string, value bool) {
+	if value {
+		query.Set(name, "true")
+	}
+}
+
+func setInt(query url.Values, name string, value int) {
+	if value != 0 {
+		query.Set(name, strconv.Itoa(value))
+	}
+}
+
+func setFloat(query url.Values, name string, value float64) {
+	if value != 0 {
+		query.Set(name, strconv.FormatFloat(value, 'f', -1, 64))
+	}
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	// Third party packages
+	"golang.org/x/crypto/ed25519"
+)
+
+// receipt JWS algorithm, see roc_face_receipts.go
+const receiptAlgorithm = "EdDSA"
+
+// ErrInvalidReceipt is returned for receipts not signed with any of the keys
+var ErrInvalidReceipt = errors.New("roc-face: invalid receipt signature")
+
+// ReceiptClaims is what a receipt proves the server returned, for the
+// images with these hashes
+type ReceiptClaims struct {
+	Route string `json:"route"`
+
+	// the response body, without the receipt
+	Result json.RawMessage `json:"result"`
+
+	// SHA-256 of each image as processed, without its EXIF metadata
+	ImageHashes []string        `json:"imageHashes"`
+	Params      json.RawMessage `json:"params"`
+	IssuedAt    time.Time       `json:"issuedAt"`
+
+	// the key the receipt was signed with
+	KeyID string `json:"-"`
+}
+
+// VerificationResult decodes the /verify result the receipt is for
+func (claims *ReceiptClaims) VerificationResult() (*VerificationResult, error) {
+	var result VerificationResult
+	var err = json.Unmarshal(claims.Result, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// JSONWebKey is an Ed25519 public key receipts are signed with
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+// KeySet is the response of /keys, save it to check receipts offline
+type KeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// ReceiptVerification is the response of /receipts/verify
+type ReceiptVerification struct {
+	Valid   bool           `json:"valid"`
+	Message string         `json:"message,omitempty"`
+	KeyID   string         `json:"keyId,omitempty"`
+	Claims  *ReceiptClaims `json:"claims,omitempty"`
+}
+
+// Keys fetches the public keys receipts are signed with
+func (c *Client) Keys(ctx context.Context) (*KeySet, error) {
+	var keys KeySet
+	var err = c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest("GET", c.BaseURL+"/keys", nil)
+	}, &keys)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &keys, nil
+}
+
+// CheckReceipt asks the server whether it signed a receipt, use
+// VerifyReceipt to check it without the server
+func (c *Client) CheckReceipt(ctx context.Context, receipt string) (*ReceiptVerification, error) {
+	var body, err = json.Marshal(map[string]string{"receipt": receipt})
+	if err != nil {
+		return nil, err
+	}
+
+	var verification ReceiptVerification
+	err = c.do(ctx, func() (*http.Request, error) {
+		var req, err = http.NewRequest("POST", c.BaseURL+"/receipts/verify", bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+
+		return req, err
+	}, &verification)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &verification, nil
+}
+
+// VerifyReceipt checks a receipt against the keys, e.g. a saved /keys
+// response, and decodes it
+func VerifyReceipt(receipt string, keys *KeySet) (*ReceiptClaims, error) {
+	var parts = strings.Split(strings.TrimSpace(receipt), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("roc-face: malformed receipt, expected a JWS in compact serialization")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+
+	var err = decodeReceiptPart(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Algorithm != receiptAlgorithm {
+		return nil, fmt.Errorf("roc-face: unsupported receipt algorithm %q", header.Algorithm)
+	}
+
+	var publicKey ed25519.PublicKey
+	for _, key := range keys.Keys {
+		if key.KeyID == header.KeyID && key.KeyType == "OKP" && key.Curve == "Ed25519" {
+			publicKey, err = base64.RawURLEncoding.DecodeString(key.X)
+			if err != nil || len(publicKey) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("roc-face: invalid key %s", key.KeyID)
+			}
+		}
+	}
+
+	if publicKey == nil {
+		return nil, fmt.Errorf("roc-face: unknown receipt key %q", header.KeyID)
+	}
+
+	var signature []byte
+	signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(publicKey, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidReceipt
+	}
+
+	var claims ReceiptClaims
+	err = decodeReceiptPart(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	claims.KeyID = header.KeyID
+	return &claims, nil
+}
+
+func decodeReceiptPart(part string, value interface{}) error {
+	var data, err = base64.RawURLEncoding.DecodeString(part)
+	if err == nil {
+		err = json.Unmarshal(data, value)
+	}
+
+	if err != nil {
+		return fmt.Errorf("roc-face: malformed receipt: %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// SubjectErasure is what DeleteSubject erased
+type SubjectErasure struct {
+	SubjectID string  `json:"subjectId"`
+	Threshold float64 `json:"threshold"`
+
+	// number of templates removed from each gallery
+	Galleries    map[string]int `json:"galleries"`
+	CacheEntries int            `json:"cacheEntries"`
+
+	// IDs of the jobs whose results were redacted, and of those redacted
+	// once they finish
+	Jobs        []string `json:"jobs"`
+	PendingJobs []string `json:"pendingJobs"`
+
+	// number of webhook deliveries of watchlist hits redacted
+	WatchlistHits int `json:"watchlistHits"`
+}
+
+// DeleteSubject erases a subject's templates from every gallery, the cached
+// templates and job results of images whose face matches them with a
+// similarity of at least threshold (the server's default if 0), and its
+// watchlist hits. A subject in no gallery only has its watchlist hits
+// erased.
+func (c *Client) DeleteSubject(ctx context.Context, subjectID string, threshold float64) (*SubjectErasure, error) {
+	var query = url.Values{}
+	setFloat(query, "threshold", threshold)
+
+	var erasure SubjectErasure
+	var err = c.do(ctx, func() (*http.Request, error) {
+		return http.NewRequest("DELETE", c.BaseURL+"/subjects/"+url.PathEscape(subjectID)+"?"+query.Encode(), nil)
+	}, &erasure)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &erasure, nil
+}
@@ -0,0 +1,130 @@
+package client
+
+// InvalidSimilarity is the similarity of a failed verification
+const InvalidSimilarity = -1.0
+
+// VerificationResult is the response of /verify. Code is set, and
+// Similarity is InvalidSimilarity, when the images couldn't be compared (e.g.
+// FaceNotDetected).
+type VerificationResult struct {
+	Similarity float32 `json:"similarity"`
+	Code       string  `json:"code,omitempty"`
+	Message    string  `json:"message,omitempty"`
+	ColorSpace string  `json:"colorSpace,omitempty"`
+
+	// EXIF orientation transform applied to each image, if any
+	Transforms []string `json:"transforms,omitempty"`
+
+	// retry transform the face was found with in each image, if any
+	Retries []string `json:"retries,omitempty"`
+
+	// spoof verdict for each image, when requested
+	Spoof []*SpoofResult `json:"spoof,omitempty"`
+
+	Detection string `json:"detection,omitempty"`
+
+	// face selection strategy, and the face used in each image
+	FaceSelection string          `json:"faceSelection,omitempty"`
+	Selected      []FaceSelection `json:"selected,omitempty"`
+
+	// face details for each image, when verbose
+	Images []FaceDetails `json:"images,omitempty"`
+
+	// signed receipt of the rest of the result, when requested
+	Receipt string `json:"receipt,omitempty"`
+}
+
+// AnalysisResult is the response of /analyze
+type AnalysisResult struct {
+	Code                 string       `json:"code,omitempty"`
+	Message              string       `json:"message,omitempty"`
+	FDR                  float32      `json:"fdr,omitempty"`
+	MinFaceWidthInPixels int          `json:"minFaceWidthInPixels,omitempty"`
+	ColorSpace           string       `json:"colorSpace,omitempty"`
+	Transform            string       `json:"transform,omitempty"`
+	Retry                string       `json:"retry,omitempty"`
+	Detection            string       `json:"detection,omitempty"`
+	Spoof                *SpoofResult `json:"spoof,omitempty"`
+	Thumbnail            []byte       `json:"thumbnail,omitempty"`
+	Crops                []FaceCrop   `json:"crops,omitempty"`
+
+	// template metadata of the first face, e.g. "Age", "Yaw", "IOD"
+	Analysis map[string]interface{} `json:"analysis,omitempty"`
+}
+
+// CropResult is the response of /crop
+type CropResult struct {
+	Code    string     `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+	Faces   []FaceCrop `json:"faces,omitempty"`
+}
+
+// QualityResult is the response of /quality
+type QualityResult struct {
+	Code    string         `json:"code,omitempty"`
+	Message string         `json:"message,omitempty"`
+	Profile string         `json:"profile"`
+	Passed  bool           `json:"passed"`
+	Checks  []QualityCheck `json:"checks"`
+	Metrics *ImageMetrics  `json:"metrics,omitempty"`
+}
+
+// FaceBox is a face bounding box, top left corner and size in pixels
+type FaceBox struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// FaceCrop is an encoded crop of a face, and its aligned version
+type FaceCrop struct {
+	Box     FaceBox `json:"box"`
+	Format  string  `json:"format"`
+	Image   []byte  `json:"image"`
+	Aligned []byte  `json:"aligned,omitempty"`
+}
+
+// SpoofResult is a presentation attack verdict: live, spoof or uncertain
+type SpoofResult struct {
+	Verdict string  `json:"verdict"`
+	Score   float64 `json:"score"`
+}
+
+// FaceSelection tells which of the detected faces was used
+type FaceSelection struct {
+	Index     int `json:"index"`
+	FaceCount int `json:"faceCount"`
+}
+
+// FaceDetails describes the face used in one image of a verbose
+// verification
+type FaceDetails struct {
+	Template  *FaceBox `json:"template,omitempty"`
+	FaceCount int      `json:"faceCount"`
+	Quality   *float64 `json:"quality,omitempty"`
+	Pose      string   `json:"pose,omitempty"`
+	Yaw       *float64 `json:"yaw,omitempty"`
+	Pitch     *float64 `json:"pitch,omitempty"`
+	Roll      *float64 `json:"roll,omitempty"`
+	IOD       *float64 `json:"iod,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+type QualityCheck struct {
+	Name   string  `json:"name"`
+	Passed bool    `json:"passed"`
+	Value  float64 `json:"value"`
+	Reason string  `json:"reason,omitempty"`
+
+	// the server had no metadata for the check, it didn't fail, but the
+	// image doesn't pass without it
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+type ImageMetrics struct {
+	Brightness float64 `json:"brightness"`
+	Contrast   float64 `json:"contrast"`
+	Sharpness  float64 `json:"sharpness"`
+	FaceRatio  float64 `json:"faceRatio"`
+}
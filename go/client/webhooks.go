@@ -0,0 +1,71 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// webhook delivery headers, see roc_face_webhooks.go
+const WebhookSignatureHeader = "X-Roc-Face-Signature"
+const WebhookTimestampHeader = "X-Roc-Face-Timestamp"
+
+// WebhookEventJobFinished is sent when a job succeeds, fails or is canceled,
+// with the job as data
+const WebhookEventJobFinished = "job.finished"
+
+// ErrInvalidSignature is returned for deliveries not signed with the secret
+var ErrInvalidSignature = errors.New("roc-face: invalid webhook signature")
+
+// ErrStaleWebhook is returned for deliveries older than the allowed age
+var ErrStaleWebhook = errors.New("roc-face: stale webhook delivery")
+
+// WebhookEvent is the body of a webhook delivery. The same ID is sent again
+// when a delivery is retried.
+type WebhookEvent struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// VerifyWebhook checks the signature of a webhook delivery against the
+// webhook's secret, and decodes it. Deliveries signed more than maxAge ago
+// are rejected, to limit replays, unless maxAge is 0.
+func VerifyWebhook(secret string, r *http.Request, maxAge time.Duration) (*WebhookEvent, error) {
+	var body, err = ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var timestamp = r.Header.Get(WebhookTimestampHeader)
+	var mac = hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	var expected = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(WebhookSignatureHeader))) {
+		return nil, ErrInvalidSignature
+	}
+
+	if maxAge > 0 {
+		var seconds, err = strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(seconds, 0)) > maxAge {
+			return nil, ErrStaleWebhook
+		}
+	}
+
+	var event WebhookEvent
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mvayngrib/roc-face/go/client"
+)
+
+// newTestClient serves the router, and returns a client of it that retries
+// without waiting
+func newTestClient(handler http.Handler) (*client.Client, func()) {
+	var server = httptest.NewServer(handler)
+	var c = client.New(server.URL)
+	c.MinBackoff = time.Millisecond
+	c.MaxBackoff = time.Millisecond
+	return c, server.Close
+}
+
+func TestClientVerify(t *testing.T) {
+	var c, stop = newTestClient(newRouter())
+	defer stop()
+
+	var ctx = context.Background()
+	var alice = client.ImageFromBytes("alice.png", testImage("alice"))
+	var result, err = c.Verify(ctx, alice, client.ImageFromBytes("alice2.png", testImage("alice", "bob")), client.VerifyOptions{Verbose: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Code != "" || result.Similarity < mockSameMinSimilarity || len(result.Images) != 2 || result.Images[1].FaceCount != 2 {
+		t.Errorf("alice and alice: %+v", result)
+	}
+
+	result, err = c.Verify(ctx, alice, client.ImageFromBytes("bob.png", testImage("bob")), client.VerifyOptions{})
+	if err != nil || result.Similarity > mockDifferentMaxSimilarity {
+		t.Errorf("alice and bob: %+v, %v", result, err)
+	}
+
+	result, err = c.Verify(ctx, alice, client.ImageFromBytes("empty.png", testImage()), client.VerifyOptions{})
+	if err != nil || result.Code != "FaceNotDetected" || result.Similarity != client.InvalidSimilarity {
+		t.Errorf("alice and no face: %+v, %v", result, err)
+	}
+
+	_, err = c.Verify(ctx, alice, alice, client.VerifyOptions{FaceSelection: "tallest"})
+	if apiErr, ok := err.(*client.APIError); !ok || apiErr.StatusCode != http.StatusBadRequest || apiErr.Message == "" {
+		t.Errorf("invalid faceSelection: %v, want a 400 APIError", err)
+	}
+}
+
+func TestClientAnalyze(t *testing.T) {
+	var c, stop = newTestClient(newRouter())
+	defer stop()
+
+	var result, err = c.Analyze(context.Background(), client.ImageFromBytes("alice.png", testImage("alice")), client.AnalyzeOptions{
+		Attributes: []string{"demographics", "pose"},
+		Crops:      true,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Code != "" || result.Analysis["Age"] == nil || result.Analysis["Pose"] == nil || len(result.Crops) != 1 || len(result.Crops[0].Image) == 0 {
+		t.Errorf("analysis = %+v, want the age, pose and a crop", result)
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	var router = newRouter()
+	enableIdempotentRetries(router)
+	var requests, failures, resets, handled int32
+	var failureStatus = int32(http.StatusServiceUnavailable)
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&handled, 1)
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	var c, stop = newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n = atomic.AddInt32(&requests, 1)
+		if n <= atomic.LoadInt32(&failures) {
+			w.WriteHeader(int(atomic.LoadInt32(&failureStatus)))
+			return
+		}
+
+		if n <= atomic.LoadInt32(&resets) {
+			// handled, but the response is lost
+			router.ServeHTTP(httptest.NewRecorder(), r)
+			resetConnection(w)
+			return
+		}
+
+		router.ServeHTTP(w, r)
+	}))
+
+	defer stop()
+
+	// the image is uploaded again on every attempt
+	var image = client.ImageFromBytes("alice.png", testImage("alice"))
+	atomic.StoreInt32(&failures, 2)
+	var result, err = c.Analyze(context.Background(), image, client.AnalyzeOptions{})
+	if err != nil || result.Code != "" || requests != 3 {
+		t.Errorf("after 2 failures: %+v, %v, %d requests, want a result after 3", result, err, requests)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 10)
+	_, err = c.Analyze(context.Background(), image, client.AnalyzeOptions{})
+	if apiErr, ok := err.(*client.APIError); !ok || apiErr.StatusCode != http.StatusServiceUnavailable || requests != 4 {
+		t.Errorf("after 10 failures: %v, %d requests, want a 503 after 4", err, requests)
+	}
+
+	// other 5xx statuses are retried too
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 1)
+	atomic.StoreInt32(&failureStatus, http.StatusInternalServerError)
+	_, err = c.Verify(context.Background(), image, image, client.VerifyOptions{})
+	if err != nil || requests != 2 {
+		t.Errorf("after a 500: %v, %d requests, want a result after 2", err, requests)
+	}
+
+	atomic.StoreInt32(&failureStatus, http.StatusServiceUnavailable)
+
+	// a request handled before the connection was reset isn't handled again
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 0)
+	atomic.StoreInt32(&resets, 1)
+	atomic.StoreInt32(&handled, 0)
+	_, err = c.Verify(context.Background(), image, image, client.VerifyOptions{})
+	if err != nil || requests != 2 || handled != 1 {
+		t.Errorf("after a reset: %v, %d requests, %d handled, want a result after 2, handled once", err, requests, handled)
+	}
+
+	atomic.StoreInt32(&resets, 0)
+
+	// the zero value uses http.DefaultClient, and doesn't retry
+	atomic.StoreInt32(&requests, 0)
+	atomic.StoreInt32(&failures, 1)
+	var zero = &client.Client{BaseURL: c.BaseURL}
+	_, err = zero.Analyze(context.Background(), image, client.AnalyzeOptions{})
+	if _, ok := err.(*client.APIError); !ok || requests != 1 {
+		t.Errorf("zero value client: %v, %d requests, want an APIError after 1", err, requests)
+	}
+
+	err = zero.Ping(context.Background())
+	if err != nil {
+		t.Errorf("zero value client ping: %v", err)
+	}
+}
+
+func TestClientReceipts(t *testing.T) {
+	os.Setenv("ROC_FACE_RECEIPT_KEY", base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	defer os.Unsetenv("ROC_FACE_RECEIPT_KEY")
+	var router = newRouter()
+	var err = enableReceipts(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		receipts = nil
+	}()
+
+	var c, stop = newTestClient(router)
+	defer stop()
+
+	var ctx = context.Background()
+	var alice = client.ImageFromBytes("alice.png", testImage("alice"))
+	var result *client.VerificationResult
+	result, err = c.Verify(ctx, alice, alice, client.VerifyOptions{Receipt: true})
+	if err != nil || result.Receipt == "" {
+		t.Fatalf("verify with receipt: %+v, %v", result, err)
+	}
+
+	var keys *client.KeySet
+	keys, err = c.Keys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var claims *client.ReceiptClaims
+	claims, err = client.VerifyReceipt(result.Receipt, keys)
+	if err != nil || claims.Route != "/verify" || len(claims.ImageHashes) != 2 || claims.KeyID != keys.Keys[0].KeyID {
+		t.Fatalf("offline verification: %+v, %v", claims, err)
+	}
+
+	var signed *client.VerificationResult
+	signed, err = claims.VerificationResult()
+	if err != nil || signed.Similarity != result.Similarity || signed.Receipt != "" {
+		t.Errorf("signed result %+v, %v, want %+v without the receipt", signed, err, result)
+	}
+
+	var verification *client.ReceiptVerification
+	verification, err = c.CheckReceipt(ctx, result.Receipt)
+	if err != nil || !verification.Valid {
+		t.Errorf("server verification: %+v, %v", verification, err)
+	}
+
+	// a forged signature
+	var tampered = []byte(result.Receipt)
+	tampered[len(tampered)-2] ^= 1
+	_, err = client.VerifyReceipt(string(tampered), keys)
+	if err != client.ErrInvalidReceipt {
+		t.Errorf("tampered receipt: %v, want ErrInvalidReceipt", err)
+	}
+
+	verification, err = c.CheckReceipt(ctx, string(tampered))
+	if err != nil || verification.Valid {
+		t.Errorf("server verification of a tampered receipt: %+v, %v", verification, err)
+	}
+
+	// receipts with a part modified, and the original signature
+	var parts = strings.Split(result.Receipt, ".")
+	var modify = func(part string, key string, value interface{}) string {
+		var data, _ = base64.RawURLEncoding.DecodeString(part)
+		var fields map[string]interface{}
+		json.Unmarshal(data, &fields)
+		fields[key] = value
+		data, _ = json.Marshal(fields)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	var forged = map[string]string{
+		"modified payload":  strings.Join([]string{parts[0], modify(parts[1], "similarity", 0.99), parts[2]}, "."),
+		"unknown key":       strings.Join([]string{modify(parts[0], "kid", "other"), parts[1], parts[2]}, "."),
+		"another algorithm": strings.Join([]string{modify(parts[0], "alg", "none"), parts[1], parts[2]}, "."),
+	}
+
+	for name, receipt := range forged {
+		if claims, err = client.VerifyReceipt(receipt, keys); err == nil {
+			t.Errorf("%s: verified offline, %+v", name, claims)
+		}
+
+		verification, err = c.CheckReceipt(ctx, receipt)
+		if err != nil || verification.Valid || verification.Message == "" {
+			t.Errorf("%s: server verification %+v, %v, want it invalid", name, verification, err)
+		}
+	}
+
+	// a server without a key doesn't sign receipts
+	receipts = nil
+	_, err = c.Verify(ctx, alice, alice, client.VerifyOptions{Receipt: true})
+	if apiErr, ok := err.(*client.APIError); !ok || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("verify with receipt, receipts disabled: %v, want a 400", err)
+	}
+}
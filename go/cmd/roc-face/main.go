@@ -0,0 +1,372 @@
+// roc-face is a command line client for a running roc-face server, so checks
+// can be scripted without the SDK or its license:
+//
+//	roc-face remote verify [flags] REFERENCE IMAGE...
+//	roc-face remote analyze [flags] IMAGE...
+//	roc-face remote search [flags] PROBE CANDIDATE...
+//	roc-face receipt keys [flags]
+//	roc-face receipt verify [flags] RECEIPT...
+//
+// Images can be files, directories (all the images in them) or glob
+// patterns. The exit status is 0 if everything matched (or was analyzed), 1
+// on a no-match or a face not detected, and 2 on errors.
+//
+// Receipts are checked offline against a key set saved with receipt keys,
+// or against the server's keys. A receipt file holds either the receipt or
+// a JSON object with a receipt field, e.g. a /verify response.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mvayngrib/roc-face/go/client"
+)
+
+const exitNoMatch = 1
+const exitError = 2
+
+const defaultServerURL = "http://localhost:8080"
+const defaultThreshold = 0.7
+const defaultSearchResults = 5
+
+var imageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// comparison is the outcome of verifying one image against the reference
+type comparison struct {
+	Image      string                     `json:"image"`
+	Match      bool                       `json:"match"`
+	Similarity float32                    `json:"similarity"`
+	Result     *client.VerificationResult `json:"result,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+}
+
+type analysis struct {
+	Image  string                 `json:"image"`
+	Result *client.AnalysisResult `json:"result,omitempty"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "  roc-face remote verify [flags] REFERENCE IMAGE...")
+	fmt.Fprintln(os.Stderr, "  roc-face remote analyze [flags] IMAGE...")
+	fmt.Fprintln(os.Stderr, "  roc-face remote search [flags] PROBE CANDIDATE...")
+	fmt.Fprintln(os.Stderr, "  roc-face receipt keys [flags] > keys.json")
+	fmt.Fprintln(os.Stderr, "  roc-face receipt verify [flags] RECEIPT...")
+	fmt.Fprintln(os.Stderr, "images can be files, directories or glob patterns, run a command with -h for its flags")
+	os.Exit(exitError)
+}
+
+func main() {
+	if len(os.Args) >= 3 && os.Args[1] == "receipt" {
+		os.Exit(receiptMain(os.Args[2], os.Args[3:]))
+	}
+
+	if len(os.Args) < 3 || os.Args[1] != "remote" {
+		usage()
+	}
+
+	var command = os.Args[2]
+	var flags = flag.NewFlagSet(command, flag.ExitOnError)
+	var serverURL = flags.String("server", envOrDefault("ROC_FACE_URL", defaultServerURL), "server URL, defaults to $ROC_FACE_URL")
+	var jsonOutput = flags.Bool("json", false, "print JSON instead of text")
+	var timeout = flags.Duration("timeout", time.Minute, "timeout per request")
+	var threshold = flags.Float64("threshold", defaultThreshold, "minimum similarity for a match (verify, search)")
+	var top = flags.Int("top", defaultSearchResults, "number of candidates to print (search)")
+	var attributes = flags.String("attributes", "", "comma separated attributes (analyze)")
+	var receipt = flags.Bool("receipt", false, "request signed receipts, printed with -json (verify, search)")
+	var images, err = expandImages(parseInterleaved(flags, os.Args[3:]))
+	if err != nil {
+		fail(err)
+	}
+
+	var c = client.New(*serverURL)
+	var run = runner{client: c, timeout: *timeout, threshold: float32(*threshold), json: *jsonOutput, receipt: *receipt}
+	var status int
+	switch command {
+	case "verify":
+		if len(images) < 2 {
+			fail(fmt.Errorf("expected a reference image and at least one image to verify"))
+		}
+
+		status = run.verify(images[0], images[1:])
+	case "search":
+		if len(images) < 2 {
+			fail(fmt.Errorf("expected a probe image and at least one candidate"))
+		}
+
+		status = run.search(images[0], withoutImage(images[1:], images[0]), *top)
+	case "analyze":
+		if len(images) < 1 {
+			fail(fmt.Errorf("expected at least one image"))
+		}
+
+		var opts client.AnalyzeOptions
+		if *attributes != "" {
+			opts.Attributes = strings.Split(*attributes, ",")
+		}
+
+		status = run.analyze(images, opts)
+	default:
+		usage()
+	}
+
+	os.Exit(status)
+}
+
+type runner struct {
+	client    *client.Client
+	timeout   time.Duration
+	threshold float32
+	json      bool
+	receipt   bool
+}
+
+// compare verifies each image against the reference
+func (run runner) compare(reference string, images []string) []comparison {
+	var comparisons = make([]comparison, len(images))
+	for i, image := range images {
+		var ctx, cancel = context.WithTimeout(context.Background(), run.timeout)
+		var result, err = run.client.Verify(ctx, client.ImageFromFile(reference), client.ImageFromFile(image), client.VerifyOptions{Receipt: run.receipt})
+		cancel()
+		comparisons[i] = comparison{Image: image, Similarity: client.InvalidSimilarity}
+		if err != nil {
+			comparisons[i].Error = err.Error()
+			continue
+		}
+
+		comparisons[i].Result = result
+		comparisons[i].Similarity = result.Similarity
+		comparisons[i].Match = result.Code == "" && result.Similarity >= run.threshold
+	}
+
+	return comparisons
+}
+
+func (run runner) verify(reference string, images []string) int {
+	var comparisons = run.compare(reference, images)
+	if run.json {
+		printJSON(comparisons)
+	} else {
+		for _, c := range comparisons {
+			fmt.Println(describeComparison(c))
+		}
+	}
+
+	return comparisonsStatus(comparisons, true)
+}
+
+// search ranks the candidates by similarity to the probe. The exit status
+// covers every candidate, including errors ranked below the top ones printed.
+func (run runner) search(probe string, candidates []string, top int) int {
+	var comparisons = run.compare(probe, candidates)
+	var status = comparisonsStatus(comparisons, false)
+	sort.SliceStable(comparisons, func(i, j int) bool {
+		return comparisons[i].Similarity > comparisons[j].Similarity
+	})
+
+	if top > 0 && len(comparisons) > top {
+		comparisons = comparisons[:top]
+	}
+
+	if run.json {
+		printJSON(comparisons)
+	} else {
+		for i, c := range comparisons {
+			fmt.Printf("%d. %s\n", i+1, describeComparison(c))
+		}
+	}
+
+	return status
+}
+
+func (run runner) analyze(images []string, opts client.AnalyzeOptions) int {
+	var analyses = make([]analysis, len(images))
+	var status = 0
+	for i, image := range images {
+		var ctx, cancel = context.WithTimeout(context.Background(), run.timeout)
+		var result, err = run.client.Analyze(ctx, client.ImageFromFile(image), opts)
+		cancel()
+		analyses[i] = analysis{Image: image, Result: result}
+		if err != nil {
+			analyses[i].Error = err.Error()
+			status = exitError
+		} else if result.Code != "" && status == 0 {
+			status = exitNoMatch
+		}
+
+		if !run.json {
+			fmt.Println(describeAnalysis(analyses[i]))
+		}
+	}
+
+	if run.json {
+		printJSON(analyses)
+	}
+
+	return status
+}
+
+// comparisonsStatus is exitError if a request failed, otherwise exitNoMatch
+// if none of the comparisons matched, or with all set, if any didn't
+func comparisonsStatus(comparisons []comparison, all bool) int {
+	var matches = 0
+	for _, c := range comparisons {
+		if c.Error != "" {
+			return exitError
+		}
+
+		if c.Match {
+			matches++
+		}
+	}
+
+	if matches == 0 || (all && matches < len(comparisons)) {
+		return exitNoMatch
+	}
+
+	return 0
+}
+
+func describeComparison(c comparison) string {
+	if c.Error != "" {
+		return fmt.Sprintf("%s: error: %s", c.Image, c.Error)
+	}
+
+	if c.Result.Code != "" {
+		return fmt.Sprintf("%s: no match, %s: %s", c.Image, c.Result.Code, c.Result.Message)
+	}
+
+	var verdict = "no match"
+	if c.Match {
+		verdict = "match"
+	}
+
+	return fmt.Sprintf("%s: %s, similarity %.4f", c.Image, verdict, c.Similarity)
+}
+
+func describeAnalysis(a analysis) string {
+	if a.Error != "" {
+		return fmt.Sprintf("%s: error: %s", a.Image, a.Error)
+	}
+
+	if a.Result.Code != "" {
+		return fmt.Sprintf("%s: %s: %s", a.Image, a.Result.Code, a.Result.Message)
+	}
+
+	var keys []string
+	for key := range a.Result.Analysis {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	var lines = []string{a.Image + ":"}
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("  %s: %v", key, a.Result.Analysis[key]))
+	}
+
+	if a.Result.Spoof != nil {
+		lines = append(lines, fmt.Sprintf("  spoof: %s (%.2f)", a.Result.Spoof.Verdict, a.Result.Spoof.Score))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// expandImages expands directories (to the images they contain) and glob
+// patterns, keeping the order of the arguments
+func expandImages(args []string) ([]string, error) {
+	var images []string
+	for _, arg := range args {
+		var matches, err = filepath.Glob(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no such file: %s", arg)
+		}
+
+		for _, match := range matches {
+			var info os.FileInfo
+			info, err = os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+
+			if !info.IsDir() {
+				images = append(images, match)
+				continue
+			}
+
+			var files []os.FileInfo
+			files, err = ioutil.ReadDir(match)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, file := range files {
+				if !file.IsDir() && imageExtensions[strings.ToLower(filepath.Ext(file.Name()))] {
+					images = append(images, filepath.Join(match, file.Name()))
+				}
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// parseInterleaved parses flags placed anywhere among the arguments, and
+// returns the other arguments
+func parseInterleaved(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// withoutImage removes image from images, so a probe isn't compared with itself
+func withoutImage(images []string, image string) []string {
+	var others []string
+	for _, other := range images {
+		if filepath.Clean(other) != filepath.Clean(image) {
+			others = append(others, other)
+		}
+	}
+
+	return others
+}
+
+func envOrDefault(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
+
+func printJSON(value interface{}) {
+	var encoder = json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "roc-face:", err.Error())
+	os.Exit(exitError)
+}
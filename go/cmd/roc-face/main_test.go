@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mvayngrib/roc-face/go/client"
+)
+
+// newTestRunner runs against a fake server, which matches images named
+// match*, fails images named error*, and finds no face in images named
+// noface*. Output is discarded until stop is called.
+func newTestRunner(t *testing.T) (runner, func()) {
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var field = "image"
+		if r.URL.Path == "/verify" {
+			field = "image2"
+		}
+
+		var _, header, err = r.FormFile(field)
+		if err != nil {
+			t.Errorf("%s without %s: %v", r.URL.Path, field, err)
+			return
+		}
+
+		var name = header.Filename
+		switch {
+		case strings.HasPrefix(name, "error"):
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"message": "invalid image"})
+		case strings.HasPrefix(name, "noface"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"code": "FaceNotDetected", "similarity": client.InvalidSimilarity})
+		case strings.HasPrefix(name, "match"):
+			json.NewEncoder(w).Encode(map[string]interface{}{"similarity": 0.9})
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"similarity": 0.1})
+		}
+	}))
+
+	var stdout = os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	var run = runner{client: client.New(server.URL), timeout: time.Minute, threshold: defaultThreshold}
+	return run, func() {
+		os.Stdout.Close()
+		os.Stdout = stdout
+		server.Close()
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	var run, stop = newTestRunner(t)
+	defer stop()
+
+	var tests = []struct {
+		name   string
+		status int
+		run    func() int
+	}{
+		{"verify matches", 0, func() int { return run.verify("ref.png", []string{"match1.png", "match2.png"}) }},
+		{"verify with a mismatch", exitNoMatch, func() int { return run.verify("ref.png", []string{"match1.png", "other.png"}) }},
+		{"verify without a face", exitNoMatch, func() int { return run.verify("ref.png", []string{"noface.png"}) }},
+		{"verify with an error", exitError, func() int { return run.verify("ref.png", []string{"match1.png", "error.png"}) }},
+		{"search with a match", 0, func() int { return run.search("ref.png", []string{"other.png", "match.png"}, 1) }},
+		{"search without a match", exitNoMatch, func() int { return run.search("ref.png", []string{"other.png"}, 1) }},
+		{"search with an error below the top", exitError, func() int {
+			return run.search("ref.png", []string{"match.png", "other.png", "error.png"}, 1)
+		}},
+		{"analyze", 0, func() int { return run.analyze([]string{"a.png"}, client.AnalyzeOptions{}) }},
+		{"analyze without a face", exitNoMatch, func() int { return run.analyze([]string{"a.png", "noface.png"}, client.AnalyzeOptions{}) }},
+		{"analyze with an error", exitError, func() int { return run.analyze([]string{"error.png", "noface.png"}, client.AnalyzeOptions{}) }},
+	}
+
+	// the images are uploaded, so they have to exist
+	var dir, err = ioutil.TempDir("", "roc-face-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	var cwd, _ = os.Getwd()
+	defer os.Chdir(cwd)
+	os.Chdir(dir)
+	for _, name := range []string{"ref.png", "match.png", "match1.png", "match2.png", "other.png", "noface.png", "error.png", "a.png"} {
+		ioutil.WriteFile(name, []byte("image"), 0600)
+	}
+
+	for _, test := range tests {
+		if status := test.run(); status != test.status {
+			t.Errorf("%s: exit status %d, want %d", test.name, status, test.status)
+		}
+	}
+}
+
+func TestExpandImages(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "roc-face-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.png", "b.JPG", "c.jpeg", "notes.txt", "sub/d.png"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700)
+		ioutil.WriteFile(filepath.Join(dir, name), []byte("image"), 0600)
+	}
+
+	var path = func(names ...string) []string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+
+		return paths
+	}
+
+	var tests = []struct {
+		name   string
+		args   []string
+		images []string
+	}{
+		{"files in order", path("c.jpeg", "a.png"), path("c.jpeg", "a.png")},
+		{"a directory's images, not its subdirectories", path(""), path("a.png", "b.JPG", "c.jpeg")},
+		{"a glob", path("*.png"), path("a.png")},
+		{"a glob matching a directory", path("s*"), path("sub/d.png")},
+		{"a file that isn't an image", path("notes.txt"), path("notes.txt")},
+	}
+
+	for _, test := range tests {
+		var images, err = expandImages(test.args)
+		if err != nil || !reflect.DeepEqual(images, test.images) {
+			t.Errorf("%s: %v, %v, want %v", test.name, images, err, test.images)
+		}
+	}
+
+	if _, err = expandImages(path("missing.png")); err == nil {
+		t.Error("expanding a missing file succeeded, want an error")
+	}
+
+	if _, err = expandImages(path("[")); err == nil {
+		t.Error("expanding a malformed glob succeeded, want an error")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mvayngrib/roc-face/go/client"
+)
+
+// receiptCheck is the outcome of checking one receipt
+type receiptCheck struct {
+	File   string                `json:"file"`
+	Valid  bool                  `json:"valid"`
+	Claims *client.ReceiptClaims `json:"claims,omitempty"`
+	Error  string                `json:"error,omitempty"`
+}
+
+// receiptMain runs the receipt commands and returns the exit status: 1 if
+// a receipt is invalid
+func receiptMain(command string, args []string) int {
+	var flags = flag.NewFlagSet("receipt "+command, flag.ExitOnError)
+	var serverURL = flags.String("server", envOrDefault("ROC_FACE_URL", defaultServerURL), "server URL, defaults to $ROC_FACE_URL")
+	var keysFile = flags.String("keys", "", "key set saved with receipt keys, to check receipts offline (verify)")
+	var jsonOutput = flags.Bool("json", false, "print JSON instead of text (verify)")
+	var timeout = flags.Duration("timeout", time.Minute, "timeout per request")
+	var files = parseInterleaved(flags, args)
+	var c = client.New(*serverURL)
+	var fetchKeys = func() *client.KeySet {
+		var ctx, cancel = context.WithTimeout(context.Background(), *timeout)
+		defer cancel()
+		var keys, err = c.Keys(ctx)
+		if err != nil {
+			fail(err)
+		}
+
+		return keys
+	}
+
+	switch command {
+	case "keys":
+		printJSON(fetchKeys())
+		return 0
+	case "verify":
+	default:
+		usage()
+	}
+
+	if len(files) == 0 {
+		fail(fmt.Errorf("expected receipt files, or - for stdin"))
+	}
+
+	var keys *client.KeySet
+	if *keysFile != "" {
+		var data, err = ioutil.ReadFile(*keysFile)
+		if err == nil {
+			keys = &client.KeySet{}
+			err = json.Unmarshal(data, keys)
+		}
+
+		if err != nil {
+			fail(fmt.Errorf("failed to read the key set: %s", err.Error()))
+		}
+	} else {
+		keys = fetchKeys()
+	}
+
+	var checks = make([]receiptCheck, len(files))
+	var status = 0
+	for i, file := range files {
+		checks[i] = checkReceipt(file, keys)
+		if checks[i].Error != "" && status == 0 {
+			status = exitNoMatch
+		}
+
+		if !*jsonOutput {
+			fmt.Println(describeReceiptCheck(checks[i]))
+		}
+	}
+
+	if *jsonOutput {
+		printJSON(checks)
+	}
+
+	return status
+}
+
+func checkReceipt(file string, keys *client.KeySet) receiptCheck {
+	var check = receiptCheck{File: file}
+	var receipt, err = readReceipt(file)
+	if err == nil {
+		check.Claims, err = client.VerifyReceipt(receipt, keys)
+	}
+
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	check.Valid = true
+	return check
+}
+
+// readReceipt reads a receipt, or the receipt field of a JSON object, from a
+// file or from stdin with -
+func readReceipt(file string) (string, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	var text = strings.TrimSpace(string(data))
+	if !strings.HasPrefix(text, "{") {
+		return text, nil
+	}
+
+	var object struct {
+		Receipt string `json:"receipt"`
+	}
+
+	err = json.Unmarshal(data, &object)
+	if err != nil {
+		return "", err
+	}
+
+	if object.Receipt == "" {
+		return "", fmt.Errorf("no receipt in %s", file)
+	}
+
+	return object.Receipt, nil
+}
+
+func describeReceiptCheck(check receiptCheck) string {
+	if check.Error != "" {
+		return fmt.Sprintf("%s: invalid: %s", check.File, check.Error)
+	}
+
+	var description = fmt.Sprintf("%s: valid, signed with key %s at %s for %s", check.File, check.Claims.KeyID,
+		check.Claims.IssuedAt.Format(time.RFC3339), check.Claims.Route)
+	if result, err := check.Claims.VerificationResult(); err == nil {
+		if result.Code != "" {
+			description += fmt.Sprintf(", %s", result.Code)
+		} else {
+			description += fmt.Sprintf(", similarity %.4f", result.Similarity)
+		}
+	}
+
+	return description + ", images " + strings.Join(check.Claims.ImageHashes, ", ")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDescribeFaceWarnings(t *testing.T) {
+	var previous = qualityProfiles[detailsQualityProfile]
+	defer func() {
+		qualityProfiles[detailsQualityProfile] = previous
+	}()
+
+	var faces = []detectedFace{{
+		Box:      faceBox{X: 10, Y: 10, Width: 80, Height: 80},
+		Metadata: map[string]interface{}{"Quality": 0.1, "IOD": 20.0, "Yaw": -40.0, "Pitch": 30.0, "Roll": 1.0},
+	}}
+
+	var tests = []struct {
+		name     string
+		profile  qualityProfile
+		warnings []string
+	}{
+		{"limits", qualityProfile{MinQuality: 0.3, MinIOD: 40, MaxYaw: 25, MaxPitch: 25, MaxRoll: 20},
+			[]string{warningLowQuality, warningLowIOD, warningExtremeYaw, warningExtremePitch}},
+		{"zero limits are disabled", qualityProfile{MaxYaw: 25}, []string{warningExtremeYaw}},
+		{"no limits", qualityProfile{}, nil},
+	}
+
+	for _, test := range tests {
+		qualityProfiles[detailsQualityProfile] = test.profile
+		var details = describeFace(faces, 0)
+		if !reflect.DeepEqual(details.Warnings, test.warnings) || details.FaceCount != 1 {
+			t.Errorf("%s: warnings = %v, want %v", test.name, details.Warnings, test.warnings)
+		}
+	}
+
+	if details := describeFace(append(faces, faces[0]), 1); !reflect.DeepEqual(details.Warnings, []string{warningMultipleFaces}) {
+		t.Errorf("two faces without limits: warnings = %v, want %s", details.Warnings, warningMultipleFaces)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+func TestOrientImage(t *testing.T) {
+	// the stored image is
+	//	a b c
+	//	d e f
+	// and each orientation is how it's displayed
+	var labels = "abcdef"
+	var src = image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i := range labels {
+		src.Set(i%3, i/3, color.RGBA{labels[i], 0, 0, 255})
+	}
+
+	var encoded bytes.Buffer
+	png.Encode(&encoded, src)
+	for orientation, want := range map[int][]string{
+		1: {"abc", "def"},
+		2: {"cba", "fed"},
+		3: {"fed", "cba"},
+		4: {"def", "abc"},
+		5: {"ad", "be", "cf"},
+		6: {"da", "eb", "fc"},
+		7: {"fc", "eb", "da"},
+		8: {"cf", "be", "ad"},
+	} {
+		var data = stripPNGMetadata(encoded.Bytes(), orientation)
+
+		if got := exifOrientation(data); got != orientation {
+			t.Errorf("exifOrientation = %d, want %d", got, orientation)
+			continue
+		}
+
+		var img, transform, err = decodeImage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if transform != orientationTransforms[orientation] {
+			t.Errorf("orientation %d: transform %q, want %q", orientation, transform, orientationTransforms[orientation])
+		}
+
+		var got []string
+		for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+			var row []byte
+			for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+				var r, _, _, _ = img.At(x, y).RGBA()
+				row = append(row, byte(r>>8))
+			}
+
+			got = append(got, string(row))
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("orientation %d: displayed %v, want %v", orientation, got, want)
+		}
+	}
+}
+
+func TestExifOrientationJPEG(t *testing.T) {
+	var tiff = minimalTIFF(6)
+	var jpeg bytes.Buffer
+	jpeg.Write(jpegSOI)
+	writeJPEGSegment(&jpeg, 0xE1, append(append([]byte{}, exifHeader...), tiff...))
+	if got := exifOrientation(jpeg.Bytes()); got != 6 {
+		t.Errorf("big endian exifOrientation = %d, want 6", got)
+	}
+
+	// the same IFD, little endian
+	var little = []byte("II\x2a\x00\x08\x00\x00\x00\x01\x00\x12\x01\x03\x00\x01\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00\x00")
+	if got := tiffOrientation(little); got != 8 {
+		t.Errorf("little endian tiffOrientation = %d, want 8", got)
+	}
+
+	// out of range, truncated and unknown byte orders mean no transform
+	for _, tiff := range [][]byte{minimalTIFF(9), tiff[:12], []byte("XX\x00\x2a\x00\x00\x00\x08")} {
+		if got := tiffOrientation(tiff); got != 1 {
+			t.Errorf("tiffOrientation(%q) = %d, want 1", tiff, got)
+		}
+	}
+}
+
+func TestStripJPEGMetadataKeepsJFIFFirst(t *testing.T) {
+	var encoded bytes.Buffer
+	jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 4, 4)), nil)
+	var data bytes.Buffer
+	data.Write(jpegSOI)
+	writeJPEGSegment(&data, 0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))
+	writeJPEGSegment(&data, 0xE1, append(append([]byte{}, exifHeader...), minimalTIFF(6)...))
+	data.Write(encoded.Bytes()[len(jpegSOI):])
+
+	var stripped = stripImageMetadata(data.Bytes())
+	var markers []byte
+	walkJPEGSegments(stripped, func(marker byte, payload []byte) bool {
+		markers = append(markers, marker)
+		return true
+	})
+
+	if len(markers) < 2 || markers[0] != 0xE0 || markers[1] != 0xE1 {
+		t.Errorf("segments %x, want APP0 then APP1", markers)
+	}
+
+	if got := exifOrientation(stripped); got != 6 {
+		t.Errorf("exifOrientation = %d, want 6", got)
+	}
+
+	if _, _, err := decodeImage(stripped); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecodeImageTooLarge(t *testing.T) {
+	// only the header of an 8 bit RGB image of 20000 x 20000 pixels
+	var ihdr = []byte{0, 0, 0x4E, 0x20, 0, 0, 0x4E, 0x20, 8, 2, 0, 0, 0}
+	var data bytes.Buffer
+	data.Write(pngSignature)
+	writePNGChunk(&data, "IHDR", ihdr)
+	if _, _, err := decodeImage(data.Bytes()); err != errImageTooLarge {
+		t.Errorf("decodeImage error = %v, want %v", err, errImageTooLarge)
+	}
+}
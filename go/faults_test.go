@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+)
+
+// newFaultyRouter is a router with fault injection, configured with config
+func newFaultyRouter(t *testing.T, config string) *mux.Router {
+	var router = newRouter()
+	if err := enableFaultInjection(router); err != nil {
+		t.Fatal(err)
+	}
+
+	if config != "" {
+		var w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("PUT", faultsAdminPath, strings.NewReader(config)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: status %d: %s", faultsAdminPath, w.Code, w.Body.String())
+		}
+	}
+
+	return router
+}
+
+func TestFaultConfigValidate(t *testing.T) {
+	for _, config := range []string{
+		`{"routes": {"*": {"errorRate": 1.5}}}`,
+		`{"routes": {"*": {"errorRate": 1, "errorStatus": 42}}}`,
+		`{"routes": {"*": {"errorRate": 1, "errorStatus": 1000}}}`,
+		`{"routes": {"*": {"codeRate": 0.5}}}`,
+		`{"routes": {"*": {"slowBodyRate": 0.5}}}`,
+		`{"routes": {"*": {"latency": {"distribution": "pareto"}}}}`,
+		`{"routes": {"*": {"latency": {"distribution": "uniform", "minMs": 200, "maxMs": 100}}}}`,
+	} {
+		var parsed faultConfig
+		if err := json.Unmarshal([]byte(config), &parsed); err != nil {
+			t.Fatal(err)
+		}
+
+		if parsed.validate() == nil {
+			t.Errorf("validate accepted %s", config)
+		}
+	}
+
+	var parsed = faultConfig{Routes: map[string]routeFaults{
+		"*": {ErrorRate: 1, ErrorStatus: 599, Latency: &latencyFaults{Distribution: "uniform", MinMs: 100, MaxMs: 100}},
+	}}
+
+	if err := parsed.validate(); err != nil {
+		t.Errorf("validate(%+v): %v", parsed, err)
+	}
+}
+
+func TestFaultAdmin(t *testing.T) {
+	var router = newFaultyRouter(t, "")
+	var w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", faultsAdminPath, strings.NewReader(`{"routes": {"*": {"errorRate": 1, "errorStatus": 42}}}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("PUT of an invalid status: %d, want 400", w.Code)
+	}
+
+	// faults apply to every route but the admin ones
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("PUT", faultsAdminPath, strings.NewReader(`{"routes": {"*": {"errorRate": 1, "errorStatus": 503}}}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT: status %d: %s", w.Code, w.Body.String())
+	}
+
+	var config faultConfig
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", faultsAdminPath, nil))
+	if json.Unmarshal(w.Body.Bytes(), &config); config.Routes[faultsDefaultRoute].ErrorStatus != 503 {
+		t.Errorf("GET: %s", w.Body.String())
+	}
+
+	var images = map[string][]byte{"image": testImage("alice")}
+	if w = postImages(t, router, "/analyze", images); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("/analyze: status %d, want 503", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", faultsAdminPath, nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "503") {
+		t.Errorf("DELETE: status %d: %s", w.Code, w.Body.String())
+	}
+
+	if w = postImages(t, router, "/analyze", images); w.Code != http.StatusOK {
+		t.Errorf("/analyze after DELETE: status %d, want 200", w.Code)
+	}
+}
+
+func TestFaultRates(t *testing.T) {
+	var router = newFaultyRouter(t, `{"seed": 1, "routes": {"/analyze": {"errorRate": 0.5}, "/verify": {"codeRate": 1, "code": "FaceNotDetected"}}}`)
+	var images = map[string][]byte{"image": testImage("alice")}
+	var failed = 0
+	for i := 0; i < 100; i++ {
+		var w = postImages(t, router, "/analyze", images)
+		switch w.Code {
+		case http.StatusInternalServerError:
+			failed++
+		case http.StatusOK:
+		default:
+			t.Fatalf("status %d: %s", w.Code, w.Body.String())
+		}
+	}
+
+	if failed < 30 || failed > 70 {
+		t.Errorf("%d of 100 requests failed, want about half", failed)
+	}
+
+	// the route's own schema, the handler doesn't run
+	var w = postImages(t, router, "/verify", nil)
+	var result verificationResult
+	if json.Unmarshal(w.Body.Bytes(), &result); w.Code != http.StatusOK || result.Code != "FaceNotDetected" || result.Message != faultMessage {
+		t.Errorf("/verify: status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFaultResetAndSlowBody(t *testing.T) {
+	var router = newFaultyRouter(t, `{"routes": {"/reset": {"resetRate": 1}, "*": {"slowBodyRate": 1, "slowBodyBytesPerSecond": 2000}}}`)
+	router.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {})
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 200)))
+	})
+
+	var server = httptest.NewServer(router)
+	defer server.Close()
+	if response, err := http.Get(server.URL + "/reset"); err == nil {
+		response.Body.Close()
+		t.Errorf("/reset: status %d, want a reset connection", response.StatusCode)
+	}
+
+	// 200 bytes at 2000 bytes per second
+	var start = time.Now()
+	var response, err = http.Get(server.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body, readErr = ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if readErr != nil || len(body) != 200 {
+		t.Errorf("/slow: read %d bytes, %v", len(body), readErr)
+	}
+
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("/slow took %v, want about 100ms", elapsed)
+	}
+}
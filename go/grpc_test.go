@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	// Third party packages
+	"github.com/mvayngrib/roc-face/go/rocfacepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestGRPCClient serves the gRPC API in memory, and returns a client of it
+func newTestGRPCClient(t *testing.T) (rocfacepb.RocFaceClient, func()) {
+	var listener = bufconn.Listen(1 << 20)
+	var server = newGRPCServer()
+	go server.Serve(listener)
+	var conn, err = grpc.Dial("bufconn", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, address string) (net.Conn, error) {
+			return listener.Dial()
+		}))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rocfacepb.NewRocFaceClient(conn), func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func expectCode(t *testing.T, call string, err error, code codes.Code) {
+	if status.Code(err) != code {
+		t.Errorf("%s: %v, want %s", call, err, code)
+	}
+}
+
+func TestGRPCVerify(t *testing.T) {
+	var rpc, stop = newTestGRPCClient(t)
+	defer stop()
+
+	var ctx = context.Background()
+	var response, err = rpc.Verify(ctx, &rocfacepb.VerifyRequest{Image1: testImage("alice"), Image2: testImage("bob", "alice")})
+	if err != nil || response.Code != "" || response.Similarity > mockDifferentMaxSimilarity {
+		t.Errorf("alice and bob: %v, %v", response, err)
+	}
+
+	response, err = rpc.Verify(ctx, &rocfacepb.VerifyRequest{
+		Image1:  testImage("alice"),
+		Image2:  testImage("bob", "alice"),
+		Options: &rocfacepb.VerifyOptions{FaceSelection: selectionBestMatch},
+	})
+
+	if err != nil || response.Similarity < mockSameMinSimilarity || response.Selected[1].Index != 1 {
+		t.Errorf("alice and bob, alice with best-match: %v, %v", response, err)
+	}
+
+	_, err = rpc.Verify(ctx, &rocfacepb.VerifyRequest{
+		Image1:  testImage("alice"),
+		Image2:  testImage("alice"),
+		Options: &rocfacepb.VerifyOptions{FaceSelection: selectionLargest, NumFacesToDetect: -1},
+	})
+
+	expectCode(t, "Verify with num_faces_to_detect -1", err, codes.InvalidArgument)
+	_, err = rpc.Verify(ctx, &rocfacepb.VerifyRequest{Image1: testImage("alice")})
+	expectCode(t, "Verify with one image", err, codes.InvalidArgument)
+
+	var restore = allowAttributes("recognition")
+	defer restore()
+
+	_, err = rpc.Verify(ctx, &rocfacepb.VerifyRequest{
+		Image1:  testImage("alice"),
+		Image2:  testImage("alice"),
+		Options: &rocfacepb.VerifyOptions{Spoof: &rocfacepb.SpoofOptions{Policy: spoofPolicyReport}},
+	})
+
+	expectCode(t, "Verify with a spoof policy, without the spoof attribute", err, codes.InvalidArgument)
+}
+
+func TestGRPCAnalyzeCrops(t *testing.T) {
+	var rpc, stop = newTestGRPCClient(t)
+	defer stop()
+
+	var ctx = context.Background()
+	var response, err = rpc.Analyze(ctx, &rocfacepb.AnalyzeRequest{
+		Image:   testImage("alice"),
+		Options: &rocfacepb.AnalyzeOptions{Crops: &rocfacepb.CropOptions{Padding: maxCropPadding}},
+	})
+
+	if err != nil || response.Code != "" || len(response.Crops) != 1 {
+		t.Errorf("analyze alice with crops: %v, %v", response, err)
+	}
+
+	for _, padding := range []float64{-1, 1e6, math.Inf(1), math.NaN()} {
+		_, err = rpc.Analyze(ctx, &rocfacepb.AnalyzeRequest{
+			Image:   testImage("alice"),
+			Options: &rocfacepb.AnalyzeOptions{Crops: &rocfacepb.CropOptions{Padding: padding}},
+		})
+
+		expectCode(t, fmt.Sprintf("Analyze with padding %v", padding), err, codes.InvalidArgument)
+	}
+}
+
+func TestGRPCEnrollAndSearch(t *testing.T) {
+	var rpc, stop = newTestGRPCClient(t)
+	defer stop()
+
+	var ctx = context.Background()
+	var gallery = "grpc-test"
+	for _, subject := range []string{"alice", "bob"} {
+		var response, err = rpc.Enroll(ctx, &rocfacepb.EnrollRequest{
+			Gallery:   gallery,
+			SubjectId: subject,
+			Face:      &rocfacepb.EnrollRequest_Image{Image: testImage(subject)},
+		})
+
+		if err != nil || response.Code != "" || response.TemplateCount != 1 || response.Box == nil {
+			t.Fatalf("enroll %s: %v, %v", subject, response, err)
+		}
+	}
+
+	// carol's photo with dave in it isn't enrolled, even when only one face
+	// is to be detected
+	var response *rocfacepb.EnrollResponse
+	var err error
+	for _, options := range []*rocfacepb.DetectionOptions{nil, {NumFacesToDetect: 1}, {NumFacesToDetect: 3}} {
+		response, err = rpc.Enroll(ctx, &rocfacepb.EnrollRequest{
+			Gallery:   gallery,
+			SubjectId: "carol",
+			Face:      &rocfacepb.EnrollRequest_Image{Image: testImage("dave", "carol")},
+			Options:   options,
+		})
+
+		if err != nil || response.Code != "MultipleFacesDetected" || response.TemplateCount != 0 {
+			t.Errorf("enroll carol with dave, options %v: %v, %v", options, response, err)
+		}
+	}
+
+	var search *rocfacepb.SearchResponse
+	search, err = rpc.Search(ctx, &rocfacepb.SearchRequest{
+		Gallery:   gallery,
+		Probe:     &rocfacepb.SearchRequest_Image{Image: testImage("alice")},
+		Threshold: mockSameMinSimilarity,
+	})
+
+	if err != nil || len(search.Matches) != 1 || search.Matches[0].SubjectId != "alice" {
+		t.Errorf("search alice: %v, %v", search, err)
+	}
+
+	// enroll and search with templates
+	var extracted *rocfacepb.ExtractTemplateResponse
+	extracted, err = rpc.ExtractTemplate(ctx, &rocfacepb.ExtractTemplateRequest{Image: testImage("bob")})
+	if err != nil || len(extracted.Faces) != 1 {
+		t.Fatalf("extract bob: %v, %v", extracted, err)
+	}
+
+	var template = extracted.Faces[0].Template
+	var enrolled *rocfacepb.EnrollResponse
+	enrolled, err = rpc.Enroll(ctx, &rocfacepb.EnrollRequest{Gallery: gallery, SubjectId: "bob", Face: &rocfacepb.EnrollRequest_Template{Template: template}})
+	if err != nil || enrolled.TemplateCount != 2 {
+		t.Errorf("enroll bob's template: %v, %v", enrolled, err)
+	}
+
+	search, err = rpc.Search(ctx, &rocfacepb.SearchRequest{Gallery: gallery, Probe: &rocfacepb.SearchRequest_Template{Template: template}, MaxResults: 1})
+	if err != nil || len(search.Matches) != 1 || search.Matches[0].SubjectId != "bob" || search.Matches[0].Similarity != 1 {
+		t.Errorf("search bob's template: %v, %v", search, err)
+	}
+
+	// invalid arguments, which would otherwise crash the engine
+	_, err = rpc.Search(ctx, &rocfacepb.SearchRequest{Gallery: gallery, Probe: &rocfacepb.SearchRequest_Template{Template: template}, MaxResults: -1})
+	expectCode(t, "Search with max_results -1", err, codes.InvalidArgument)
+	_, err = rpc.Search(ctx, &rocfacepb.SearchRequest{Gallery: gallery, Probe: &rocfacepb.SearchRequest_Template{Template: []byte("garbage")}})
+	expectCode(t, "Search with a malformed template", err, codes.InvalidArgument)
+	_, err = rpc.Search(ctx, &rocfacepb.SearchRequest{
+		Gallery: gallery,
+		Probe:   &rocfacepb.SearchRequest_Image{Image: testImage("alice")},
+		Options: &rocfacepb.DetectionOptions{NumFacesToDetect: -1},
+	})
+
+	expectCode(t, "Search with num_faces_to_detect -1", err, codes.InvalidArgument)
+	_, err = rpc.Enroll(ctx, &rocfacepb.EnrollRequest{Gallery: gallery, SubjectId: "eve", Face: &rocfacepb.EnrollRequest_Template{Template: []byte("garbage")}})
+	expectCode(t, "Enroll with a malformed template", err, codes.InvalidArgument)
+	_, err = rpc.Enroll(ctx, &rocfacepb.EnrollRequest{Gallery: gallery, Face: &rocfacepb.EnrollRequest_Template{Template: template}})
+	expectCode(t, "Enroll without a subject ID", err, codes.InvalidArgument)
+	_, err = rpc.Compare(ctx, &rocfacepb.CompareRequest{Template1: template, Template2: template[:1]})
+	expectCode(t, "Compare with a truncated template", err, codes.InvalidArgument)
+	_, err = rpc.Search(ctx, &rocfacepb.SearchRequest{Gallery: "no-such-gallery", Probe: &rocfacepb.SearchRequest_Template{Template: template}})
+	expectCode(t, "Search in a missing gallery", err, codes.NotFound)
+}
+
+func TestGRPCAnalyzeVideo(t *testing.T) {
+	var rpc, stop = newTestGRPCClient(t)
+	defer stop()
+
+	var dir, cleanup = newTestAuditLog(t, defaultAuditMaxBytes)
+	defer cleanup()
+
+	var stream, err = rpc.AnalyzeVideo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var frames = [][]byte{testImage("alice"), testImage(), testImage("bob")}
+	for i, frame := range frames {
+		var videoFrame = &rocfacepb.VideoFrame{Image: frame, TimestampMs: int64(i * 40)}
+		if i == 0 {
+			videoFrame.Options = &rocfacepb.AnalyzeOptions{Attributes: []string{"pose"}}
+		}
+
+		err = stream.Send(videoFrame)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stream.CloseSend()
+	var frameCodes []string
+	for {
+		var analysis, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if analysis.TimestampMs != int64(len(frameCodes)*40) {
+			t.Errorf("frame %d has timestamp %d", len(frameCodes), analysis.TimestampMs)
+		}
+
+		frameCodes = append(frameCodes, analysis.Analysis.Code)
+	}
+
+	if len(frameCodes) != 3 || frameCodes[0] != "" || frameCodes[1] != "FaceNotDetected" || frameCodes[2] != "" {
+		t.Errorf("frame codes = %q, want a face, none, and a face", frameCodes)
+	}
+
+	// the stream is recorded once it ends
+	var entries = readAuditEntries(t, dir)
+	var params videoAuditParams
+	if len(entries) == 1 {
+		json.Unmarshal(entries[0].Params, &params)
+	}
+
+	if len(entries) != 1 || len(entries[0].ImageHashes) != 3 || entries[0].ImageHashes[2] != hashImage(frames[2]) ||
+		strings.Join(params.Decisions, ",") != "analyzed,FaceNotDetected,analyzed" || entries[0].Route != "/rocface.RocFace/AnalyzeVideo" {
+		t.Errorf("audit entries = %+v, want the 3 frames", entries)
+	}
+
+	// the options are checked with the first frame
+	stream, err = rpc.AnalyzeVideo(context.Background())
+	if err == nil {
+		err = stream.Send(&rocfacepb.VideoFrame{
+			Image:   testImage("alice"),
+			Options: &rocfacepb.AnalyzeOptions{Detection: &rocfacepb.DetectionOptions{NumFacesToDetect: -1}},
+		})
+	}
+
+	if err == nil {
+		_, err = stream.Recv()
+	}
+
+	expectCode(t, "AnalyzeVideo with num_faces_to_detect -1", err, codes.InvalidArgument)
+}
+
+func TestGRPCWatchlistHit(t *testing.T) {
+	var rpc, stop = newTestGRPCClient(t)
+	defer stop()
+
+	var dispatcher, router, cleanup = newTestWebhooks(t)
+	defer cleanup()
+
+	webhooks = dispatcher
+	defer func() {
+		webhooks = nil
+	}()
+
+	var receiver = &webhookReceiver{t: t}
+	var server = httptest.NewServer(receiver)
+	defer server.Close()
+
+	var _, hook = registerWebhook(t, router, server.URL, webhookEventWatchlistHit)
+	receiver.secret = hook.Secret
+	var ctx = context.Background()
+	var gallery = "grpc-watchlist"
+	var _, err = rpc.Enroll(ctx, &rocfacepb.EnrollRequest{Gallery: gallery, SubjectId: "eve", Face: &rocfacepb.EnrollRequest_Image{Image: testImage("eve")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, probe := range []string{"alice", "eve"} {
+		_, err = rpc.Search(ctx, &rocfacepb.SearchRequest{Gallery: gallery, Probe: &rocfacepb.SearchRequest_Image{Image: testImage(probe)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var deliveries = waitForDeliveries(t, router, "/webhooks/"+hook.ID+"/deliveries", 1, deliveryStatusDelivered)
+	var hit watchlistHit
+	json.Unmarshal(deliveries[0].Payload, &hit)
+	if len(deliveries) != 1 || hit.Gallery != gallery || len(hit.Matches) != 1 || hit.Matches[0].SubjectID != "eve" || hit.ProbeHash != hashImage(testImage("eve")) {
+		t.Errorf("deliveries = %+v, want a watchlist hit of eve", deliveries)
+	}
+}
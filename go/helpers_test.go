@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+)
+
+// TestMain runs the tests against the mock engine, in identity mode
+func TestMain(m *testing.M) {
+	engine = mockEngine{Mode: mockModeIdentity}
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// testImage is a PNG with one face per subject, and none without subjects
+// (see roc_mock_server.go)
+func testImage(subjects ...string) []byte {
+	var img = image.NewRGBA(image.Rect(0, 0, 600, 300))
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 600; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(len(subjects) * 50), 255})
+		}
+	}
+
+	var encoded bytes.Buffer
+	png.Encode(&encoded, img)
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	var rest = walkPNGChunks(encoded.Bytes(), func(chunkType string, payload []byte) bool {
+		writePNGChunk(&out, chunkType, payload)
+		if chunkType == "IHDR" {
+			writePNGChunk(&out, "tEXt", []byte(mockIdentityKeyword+"\x00"+strings.Join(subjects, ",")))
+		}
+
+		return true
+	})
+
+	out.Write(rest)
+	return out.Bytes()
+}
+
+// postImages posts the images, by form field, to the handler
+func postImages(t *testing.T, handler http.Handler, url string, images map[string][]byte) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	var form = multipart.NewWriter(&body)
+	for field, data := range images {
+		var part, err = form.CreateFormFile(field, field+".png")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		part.Write(data)
+	}
+
+	form.Close()
+	var r = httptest.NewRequest("POST", url, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	var w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// newTestJobs enables the jobs on a router, storing them in a temporary
+// directory
+func newTestJobs(t *testing.T) (*mux.Router, func()) {
+	var dir, err = ioutil.TempDir("", "roc-face-jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("ROC_FACE_JOBS_DIR", dir)
+	defer os.Unsetenv("ROC_FACE_JOBS_DIR")
+	var router = newRouter()
+	err = enableJobs(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return router, func() {
+		jobs = nil
+		os.RemoveAll(dir)
+	}
+}
+
+// runJob posts a job of the images, and waits for it to finish
+func runJob(t *testing.T, router http.Handler, url string, images map[string][]byte) job {
+	var w = postImages(t, router, url, images)
+	var j job
+	json.Unmarshal(w.Body.Bytes(), &j)
+	if j.ID == "" {
+		t.Fatalf("%s: status %d: %s", url, w.Code, w.Body.String())
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); !j.finished(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s didn't finish: %+v", j.ID, j)
+		}
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/jobs/"+j.ID, nil))
+		json.Unmarshal(w.Body.Bytes(), &j)
+	}
+
+	return j
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+	"github.com/mvayngrib/roc-face/go/client"
+)
+
+func TestIdempotentRetries(t *testing.T) {
+	var router = mux.NewRouter()
+	enableIdempotentRetries(router)
+	var handled int32
+	var release = make(chan struct{})
+	router.HandleFunc("/{status}", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&handled, 1)
+		if r.URL.Query().Get("wait") != "" {
+			<-release
+		}
+
+		switch mux.Vars(r)["status"] {
+		case "unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "error":
+			w.Header().Set("X-Test", "error")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("failed"))
+		default:
+			w.Write([]byte("ok"))
+		}
+	}).Methods("POST")
+
+	var send = func(path, key string) *httptest.ResponseRecorder {
+		var w = httptest.NewRecorder()
+		var r = httptest.NewRequest("POST", path, strings.NewReader("body"))
+		if key != "" {
+			r.Header.Set(idempotencyKeyHeader, key)
+		}
+
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	var tests = []struct {
+		name     string
+		path     string
+		keys     []string
+		handled  int32
+		status   int
+		replayed bool
+	}{
+		{"without a key", "/ok", []string{"", ""}, 2, http.StatusOK, false},
+		{"with a key", "/ok", []string{"a", "a"}, 1, http.StatusOK, true},
+		{"with different keys", "/ok", []string{"b", "c"}, 2, http.StatusOK, false},
+		{"with a key on another route", "/other", []string{"a", "a"}, 1, http.StatusOK, true},
+		{"500 isn't kept", "/error", []string{"d", "d"}, 2, http.StatusInternalServerError, false},
+		{"503 isn't kept", "/unavailable", []string{"f", "f"}, 2, http.StatusServiceUnavailable, false},
+	}
+
+	for _, test := range tests {
+		atomic.StoreInt32(&handled, 0)
+		var first, last *httptest.ResponseRecorder
+		for _, key := range test.keys {
+			last = send(test.path, key)
+			if first == nil {
+				first = last
+			}
+		}
+
+		var replayed = last.Header().Get(idempotentReplayedHeader) != ""
+		if handled != test.handled || last.Code != test.status || replayed != test.replayed {
+			t.Errorf("%s: handled %d times, %d, replayed %v, want %d times, %d, replayed %v",
+				test.name, handled, last.Code, replayed, test.handled, test.status, test.replayed)
+		}
+
+		if last.Body.String() != first.Body.String() || last.Header().Get("X-Test") != first.Header().Get("X-Test") {
+			t.Errorf("%s: replayed %q, %v, want %q, %v", test.name, last.Body, last.Header(), first.Body, first.Header())
+		}
+	}
+
+	// a retry waits for the first request to finish
+	atomic.StoreInt32(&handled, 0)
+	var done = make(chan *httptest.ResponseRecorder, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- send("/ok?wait=1", "e")
+		}()
+	}
+
+	select {
+	case <-done:
+		t.Fatal("a request finished before the first one was released")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if w := <-done; w.Code != http.StatusOK || w.Body.String() != "ok" {
+			t.Errorf("concurrent retry: %d, %q", w.Code, w.Body)
+		}
+	}
+
+	if handled != 1 {
+		t.Errorf("concurrent retry: handled %d times, want once", handled)
+	}
+
+	// the same key with another query, body or caller isn't a retry
+	for _, change := range []func(r *http.Request){
+		func(r *http.Request) { r.URL.RawQuery = "other=1" },
+		func(r *http.Request) { r.Body = ioutil.NopCloser(strings.NewReader("other body")) },
+		func(r *http.Request) { r.Header.Set("X-Api-Key", "other") },
+	} {
+		var w = httptest.NewRecorder()
+		var r = httptest.NewRequest("POST", "/ok", strings.NewReader("body"))
+		r.Header.Set(idempotencyKeyHeader, "a")
+		change(r)
+		router.ServeHTTP(w, r)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("different request with the same key: %d, want 422", w.Code)
+		}
+	}
+}
+
+func TestIdempotentResponsesEviction(t *testing.T) {
+	var responses = &idempotentResponses{responses: map[string]*idempotentResponse{}}
+	var stalled, _ = responses.start("stalled")
+	for i := 0; i <= maxIdempotentResponses; i++ {
+		var response, _ = responses.start(fmt.Sprint(i))
+		responses.finish(response, "", true)
+	}
+
+	// a request still running doesn't stop the eviction of the ones after it
+	if len(responses.order) != maxIdempotentResponses || responses.order[0] != stalled {
+		t.Errorf("%d responses kept, first %q, want %d, the running one first", len(responses.order), responses.order[0].key, maxIdempotentResponses)
+	}
+
+	if _, ok := responses.responses["0"]; ok {
+		t.Error("the oldest complete response wasn't evicted")
+	}
+}
+
+func TestClientReplayedErrors(t *testing.T) {
+	var requests int32
+	var c, stop = newTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get(idempotencyKeyHeader) == "" {
+			t.Error("request without an idempotency key")
+		}
+
+		// the request failed the first time, retrying won't help
+		w.Header().Set(idempotentReplayedHeader, "true")
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	defer stop()
+
+	var image = client.ImageFromBytes("alice.png", testImage("alice"))
+	var _, err = c.Analyze(context.Background(), image, client.AnalyzeOptions{})
+	if apiErr, ok := err.(*client.APIError); !ok || apiErr.StatusCode != http.StatusInternalServerError || requests != 1 {
+		t.Errorf("replayed 500: %v, %d requests, want a 500 after 1", err, requests)
+	}
+}
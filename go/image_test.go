@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestCropPadding(t *testing.T) {
+	for _, padding := range []float64{-0.1, 2.5, 1e6, math.Inf(1), math.NaN()} {
+		if validateCropPadding(padding) == nil {
+			t.Errorf("validateCropPadding accepted %v", padding)
+		}
+	}
+
+	for _, padding := range []float64{0, defaultCropPadding, maxCropPadding} {
+		if err := validateCropPadding(padding); err != nil {
+			t.Errorf("validateCropPadding(%v): %v", padding, err)
+		}
+	}
+
+	// the largest crop, outside the image
+	var img = image.NewGray(image.Rect(0, 0, 100, 100))
+	var crop = cropFace(img, faceBox{X: 40, Y: 40, Width: 20, Height: 20}, maxCropPadding)
+	if crop.Bounds().Dx() != 100 || crop.Bounds().Dy() != 100 {
+		t.Errorf("crop bounds = %v, want 100x100", crop.Bounds())
+	}
+}
+
+func TestPackPixels(t *testing.T) {
+	// a 2x2 image in a larger one, so its origin isn't (0, 0)
+	var img = image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.RGBA{255, 0, 0, 255})
+	img.Set(2, 1, color.RGBA{0, 255, 0, 255})
+	img.Set(1, 2, color.RGBA{0, 0, 255, 255})
+	img.Set(2, 2, color.RGBA{10, 20, 30, 255})
+	var sub = img.SubImage(image.Rect(1, 1, 3, 3))
+
+	var pixels, step = packPixels(sub, colorSpaceBGR24)
+	var want = []byte{0, 0, 255, 0, 255, 0, 255, 0, 0, 30, 20, 10}
+	if step != 6 || !bytes.Equal(pixels, want) {
+		t.Errorf("bgr24: step %d, pixels %v, want 6, %v", step, pixels, want)
+	}
+
+	// ITU-R BT.601 luma
+	pixels, step = packPixels(sub, colorSpaceGray8)
+	want = []byte{76, 149, 29, 18}
+	if step != 2 || !bytes.Equal(pixels, want) {
+		t.Errorf("gray8: step %d, pixels %v, want 2, %v", step, pixels, want)
+	}
+}
+
+func TestLoadDefaultColorSpace(t *testing.T) {
+	defer os.Unsetenv("ROC_FACE_COLOR_SPACE")
+	defer func() {
+		defaultColorSpace = colorSpaceGray8
+	}()
+
+	os.Setenv("ROC_FACE_COLOR_SPACE", "rgb")
+	if err := loadDefaultColorSpace(); err == nil || defaultColorSpace != colorSpaceGray8 {
+		t.Errorf("loaded color space rgb: %v, default %s", err, defaultColorSpace)
+	}
+
+	os.Setenv("ROC_FACE_COLOR_SPACE", colorSpaceBGR24)
+	if err := loadDefaultColorSpace(); err != nil || defaultColorSpace != colorSpaceBGR24 {
+		t.Errorf("loaded color space bgr24: %v, default %s", err, defaultColorSpace)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const jobTypeTest = "test"
+
+// addTestJobType adds a job type whose jobs run until canceled or released
+func addTestJobType(t *testing.T) (release func(), remove func()) {
+	var released = make(chan struct{})
+	jobTypes[jobTypeTest] = jobType{
+		options: func(r *http.Request) (interface{}, error) {
+			return struct{}{}, nil
+		},
+		run: func(ctx context.Context, task jobTask) (interface{}, error) {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-released:
+				return len(task.ImagePaths), nil
+			}
+		},
+		audit: func(task jobTask, result interface{}) []auditEntry {
+			return nil
+		},
+	}
+
+	return func() { close(released) }, func() { delete(jobTypes, jobTypeTest) }
+}
+
+// postJob posts a job of one image, and returns it
+func postJob(t *testing.T, router http.Handler, jobType string) job {
+	var w = postImages(t, router, "/jobs?type="+jobType, map[string][]byte{"image": testImage("alice")})
+	var j job
+	if json.Unmarshal(w.Body.Bytes(), &j); w.Code != http.StatusAccepted || j.ID == "" {
+		t.Fatalf("POST /jobs: status %d: %s", w.Code, w.Body.String())
+	}
+
+	return j
+}
+
+// getJobStatus returns the HTTP status of GET /jobs/{id}
+func getJobStatus(router http.Handler, id string) int {
+	var w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/jobs/"+id, nil))
+	return w.Code
+}
+
+// waitForJobStatus waits until the job has the status
+func waitForJobStatus(t *testing.T, router http.Handler, id string, status string) job {
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		var j = getJob(router, id)
+		if j.Status == status {
+			return j
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, j.Status, status)
+		}
+	}
+}
+
+// copyDir copies the files of a directory tree
+func copyDir(t *testing.T, src string, dst string) {
+	var err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		var target = filepath.Join(dst, path[len(src):])
+		if info.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+
+		var data []byte
+		data, err = ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(target, data, 0600)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobsRestart(t *testing.T) {
+	var release, remove = addTestJobType(t)
+	defer remove()
+	var router, cleanup = newTestJobs(t)
+	defer cleanup()
+
+	// a copy of the jobs while one is running is what a restarted server
+	// finds
+	var running = postJob(t, router, jobTypeTest)
+	waitForJobStatus(t, router, running.ID, jobStatusRunning)
+	var finished = runJob(t, router, "/jobs?type=analyze", map[string][]byte{"image": testImage("alice")})
+	var dir, err = ioutil.TempDir("", "roc-face-jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	copyDir(t, jobs.dir, dir)
+	release()
+
+	os.Setenv("ROC_FACE_JOBS_DIR", dir)
+	defer os.Unsetenv("ROC_FACE_JOBS_DIR")
+	router = newRouter()
+	if err = enableJobs(router); err != nil {
+		t.Fatal(err)
+	}
+
+	// the interrupted job runs again, from the beginning
+	var restarted = waitForJobStatus(t, router, running.ID, jobStatusSucceeded)
+	if string(restarted.Result) != "1" || restarted.StartedAt == nil || restarted.Progress != 1 {
+		t.Errorf("restarted job: %+v", restarted)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, running.ID, "images")); !os.IsNotExist(err) {
+		t.Errorf("the images of the restarted job weren't deleted: %v", err)
+	}
+
+	// the finished one is kept as it was, with its templates
+	var reloaded = getJob(router, finished.ID)
+	if reloaded.Status != jobStatusSucceeded || string(reloaded.Result) != string(finished.Result) || len(jobs.templates[finished.ID]) != 1 {
+		t.Errorf("finished job after a restart: %+v", reloaded)
+	}
+}
+
+func TestJobsCancel(t *testing.T) {
+	var _, remove = addTestJobType(t)
+	defer remove()
+	os.Setenv("ROC_FACE_JOB_WORKERS", "1")
+	defer os.Unsetenv("ROC_FACE_JOB_WORKERS")
+	var router, cleanup = newTestJobs(t)
+	defer cleanup()
+
+	// one worker: the first job runs, the second waits for it
+	var running = postJob(t, router, jobTypeTest)
+	waitForJobStatus(t, router, running.ID, jobStatusRunning)
+	var queued = postJob(t, router, jobTypeTest)
+	time.Sleep(20 * time.Millisecond)
+	if j := getJob(router, queued.ID); j.Status != jobStatusQueued {
+		t.Fatalf("second job with one worker: %s, want queued", j.Status)
+	}
+
+	for _, id := range []string{queued.ID, running.ID} {
+		var w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/jobs/"+id, nil))
+		var j job
+		if json.Unmarshal(w.Body.Bytes(), &j); w.Code != http.StatusOK || j.Status != jobStatusCanceled || j.ExpiresAt == nil {
+			t.Errorf("DELETE /jobs/%s: status %d: %s", id, w.Code, w.Body.String())
+		}
+	}
+
+	// the worker is free for the next job, the canceled ones stay canceled
+	var next = postJob(t, router, jobTypeTest)
+	waitForJobStatus(t, router, next.ID, jobStatusRunning)
+	defer router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/jobs/"+next.ID, nil))
+	for _, id := range []string{queued.ID, running.ID} {
+		if j := getJob(router, id); j.Status != jobStatusCanceled || (id == queued.ID && j.StartedAt != nil) {
+			t.Errorf("canceled job %s: %+v", id, j)
+		}
+	}
+
+	// deleting a finished job removes it
+	var w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/jobs/"+running.ID, nil))
+	if code := getJobStatus(router, running.ID); w.Code != http.StatusOK || code != http.StatusNotFound {
+		t.Errorf("DELETE of a finished job: %d, then GET: %d", w.Code, code)
+	}
+
+	if _, err := os.Stat(jobs.jobDir(running.ID)); !os.IsNotExist(err) {
+		t.Errorf("the deleted job's files are left: %v", err)
+	}
+}
+
+func TestJobsExpire(t *testing.T) {
+	var router, cleanup = newTestJobs(t)
+	defer cleanup()
+
+	var j = runJob(t, router, "/jobs?type=analyze", map[string][]byte{"image": testImage("alice")})
+	if j.ExpiresAt == nil || !j.ExpiresAt.After(*j.FinishedAt) {
+		t.Fatalf("finished job: %+v", j)
+	}
+
+	jobs.removeExpired(*j.FinishedAt)
+	if code := getJobStatus(router, j.ID); code != http.StatusOK {
+		t.Errorf("job removed before it expired: %d", code)
+	}
+
+	jobs.removeExpired(j.ExpiresAt.Add(time.Second))
+	if code := getJobStatus(router, j.ID); code != http.StatusNotFound {
+		t.Errorf("expired job: %d, want 404", code)
+	}
+
+	if _, err := os.Stat(jobs.jobDir(j.ID)); !os.IsNotExist(err) {
+		t.Errorf("the expired job's files are left: %v", err)
+	}
+}
+
+func TestDedupeGroups(t *testing.T) {
+	var images = []string{"a", "b", "c", "d", "e"}
+	var pair = func(i int, k int) duplicatePair {
+		return duplicatePair{Indexes: [2]int{i, k}}
+	}
+
+	for _, test := range []struct {
+		name       string
+		duplicates []duplicatePair
+		want       [][]string
+	}{
+		{"no duplicates", nil, [][]string{}},
+		{"pairs", []duplicatePair{pair(0, 3), pair(1, 2)}, [][]string{{"a", "d"}, {"b", "c"}}},
+		{"chained", []duplicatePair{pair(3, 4), pair(0, 3), pair(1, 4)}, [][]string{{"a", "b", "d", "e"}}},
+	} {
+		if got := dedupeGroups(images, test.duplicates); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDedupeThreshold(t *testing.T) {
+	var router, cleanup = newTestJobs(t)
+	defer cleanup()
+
+	for _, threshold := range []string{"0", "-1", "NaN", "1.5"} {
+		var w = postImages(t, router, "/jobs?type=dedupe&threshold="+threshold, map[string][]byte{"image": testImage("alice")})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("threshold %s: status %d, want 400", threshold, w.Code)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+#!/bin/bash
+
+# runs the mock server, no SDK or license needed. Set ROC_FACE_MOCK_SEED to
+# change the (deterministic) results, and ROC_FACE_MOCK_MODE=identity for
+# identity-aware similarities (see roc_mock_server.go). Set ROC_FACE_GRPC_PORT
+# to serve the gRPC API too.
+
+HERE=$(dirname $0)
+
+PORT=${1-10001}
+
+GRPC_FILES=""
+if [ -n "$ROC_FACE_GRPC_PORT" ]; then
+  GRPC_FILES="$HERE/roc_grpc.go"
+fi
+
+go run -v "$HERE"/roc_mock_server.go "$HERE"/roc_face_*.go $GRPC_FILES $PORT
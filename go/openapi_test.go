@@ -0,0 +1,115 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// query parameters some parameters are only read with, e.g. crop options
+// only with crops=true
+var paramContexts = map[string]url.Values{
+	"/verify":  {"faceSelection": {"largest"}},
+	"/analyze": {"crops": {"true"}},
+}
+
+// every documented parameter of the multipart routes is read by its handler:
+// an invalid value is rejected
+func TestAPIRouteParamsAreRead(t *testing.T) {
+	var router = newRouter()
+	var image = testImage("alice")
+	for _, route := range apiRoutes() {
+		var images = map[string][]byte{}
+		for _, field := range route.FormFields {
+			images[field] = image
+		}
+
+		var w = postImages(t, router, route.Path+"?"+paramContexts[route.Path].Encode(), images)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", route.Path, w.Code, w.Body.String())
+		}
+
+		for _, param := range route.Params {
+			if param.Type == "string" && param.Enum == nil && param.Name != "attributes" {
+				t.Errorf("%s: don't know an invalid value of %s", route.Path, param.Name)
+				continue
+			}
+
+			var query = url.Values{}
+			for name, values := range paramContexts[route.Path] {
+				query[name] = values
+			}
+
+			query.Set(param.Name, "invalid")
+			w = postImages(t, router, route.Path+"?"+query.Encode(), images)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s: status %d with %s=invalid, want 400, is the parameter read?", route.Path, w.Code, param.Name)
+			}
+		}
+	}
+}
+
+// every query parameter the handlers read is documented
+func TestQueryParamsAreDocumented(t *testing.T) {
+	var documented = map[string]bool{}
+	for _, pathItem := range openAPISpec()["paths"].(map[string]interface{}) {
+		for key, value := range pathItem.(map[string]interface{}) {
+			if key == "parameters" {
+				addParamNames(documented, value)
+			} else if operation, ok := value.(map[string]interface{}); ok {
+				addParamNames(documented, operation["parameters"])
+			}
+		}
+	}
+
+	var files, err = filepath.Glob("roc_face_*.go")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no sources found: %v", err)
+	}
+
+	var getter = regexp.MustCompile(`^get\w*QueryParam$`)
+	var fileSet = token.NewFileSet()
+	for _, file := range files {
+		var parsed, err = parser.ParseFile(fileSet, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ast.Inspect(parsed, func(node ast.Node) bool {
+			var call, ok = node.(*ast.CallExpr)
+			if !ok || len(call.Args) < 2 {
+				return true
+			}
+
+			var name, isIdent = call.Fun.(*ast.Ident)
+			var literal, isLiteral = call.Args[1].(*ast.BasicLit)
+			if !isIdent || !getter.MatchString(name.Name) || !isLiteral || literal.Kind != token.STRING {
+				return true
+			}
+
+			var param, _ = strconv.Unquote(literal.Value)
+			if !documented[param] {
+				t.Errorf("%s: query parameter %q isn't documented in the OpenAPI description", fileSet.Position(call.Pos()), param)
+			}
+
+			return true
+		})
+	}
+}
+
+// addParamNames adds the names of the query parameters in an OpenAPI
+// parameter list
+func addParamNames(names map[string]bool, params interface{}) {
+	var list, _ = params.([]interface{})
+	for _, param := range list {
+		if param, ok := param.(map[string]interface{}); ok && param["in"] == "query" {
+			names[param["name"].(string)] = true
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCheckQualitySkipsMissingMetadata(t *testing.T) {
+	var img = image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 37 % 256)
+	}
+
+	var profile = qualityProfile{MinQuality: 0.3, MaxYaw: 25, MinBrightness: 255}
+	var faces = []detectedFace{{
+		Box:      faceBox{X: 10, Y: 10, Width: 80, Height: 80},
+		Metadata: map[string]interface{}{"Yaw": -30.0},
+	}}
+
+	var checks, _ = checkQuality(img, faces, profile)
+	var byName = map[string]qualityCheck{}
+	for _, check := range checks {
+		byName[check.Name] = check
+	}
+
+	if check := byName["quality"]; !check.Skipped || check.Passed {
+		t.Errorf("quality check without metadata = %+v, want skipped", check)
+	}
+
+	if check := byName["yaw"]; check.Skipped || check.Passed || check.Value != -30 {
+		t.Errorf("yaw check = %+v, want failed with value -30", check)
+	}
+
+	if allChecksPassed(checks) {
+		t.Errorf("allChecksPassed = true with failed checks")
+	}
+
+	// skipped checks fail closed
+	faces[0].Metadata["Yaw"] = 10.0
+	checks, _ = checkQuality(img, faces, qualityProfile{MinQuality: 0.3, MaxYaw: 25})
+	if allChecksPassed(checks) {
+		t.Errorf("allChecksPassed = true with a skipped check: %+v", checks)
+	}
+
+	faces[0].Metadata["Quality"] = 0.5
+	checks, _ = checkQuality(img, faces, qualityProfile{MinQuality: 0.3, MaxYaw: 25})
+	if !allChecksPassed(checks) {
+		t.Errorf("allChecksPassed = false with only passed checks: %+v", checks)
+	}
+}
+
+func TestLoadQualityProfiles(t *testing.T) {
+	var file, err = ioutil.TempFile("", "roc-face-quality")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(file.Name())
+	defer os.Unsetenv("ROC_FACE_QUALITY_PROFILES")
+	defer delete(qualityProfiles, "kiosk")
+	os.Setenv("ROC_FACE_QUALITY_PROFILES", file.Name())
+
+	ioutil.WriteFile(file.Name(), []byte(`{"kiosk": {"minQuality": 0.4, "maxYaw": 15}}`), 0600)
+	err = loadQualityProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var profile qualityProfile
+	profile, err = getQualityProfile("kiosk")
+	if err != nil || profile.MinQuality != 0.4 || profile.MaxYaw != 15 {
+		t.Errorf("kiosk profile = %+v, %v", profile, err)
+	}
+
+	ioutil.WriteFile(file.Name(), []byte(`{"kiosk": {"minQualty": 0.4}}`), 0600)
+	if loadQualityProfiles() == nil {
+		t.Errorf("loadQualityProfiles accepted an unknown field")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+)
+
+func TestReceiptKey(t *testing.T) {
+	defer func() {
+		receipts = nil
+	}()
+
+	// disabled without a key
+	var err = enableReceipts(mux.NewRouter())
+	if err != nil || receipts != nil {
+		t.Fatalf("without a key: %v, receipts enabled: %t", err, receipts != nil)
+	}
+
+	var dir string
+	dir, err = ioutil.TempDir("", "roc-face-receipts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	var keyFile = filepath.Join(dir, "receipt.key")
+	os.Setenv("ROC_FACE_RECEIPT_KEY_FILE", keyFile)
+	defer os.Unsetenv("ROC_FACE_RECEIPT_KEY_FILE")
+
+	// the key file is generated once, then read
+	err = enableReceipts(mux.NewRouter())
+	if err != nil || receipts == nil {
+		t.Fatalf("with a new key file: %v", err)
+	}
+
+	var keyID = receipts.keyID
+	err = enableReceipts(mux.NewRouter())
+	if err != nil || receipts.keyID != keyID {
+		t.Errorf("with the key file: %v, key %s, want %s", err, receipts.keyID, keyID)
+	}
+
+	ioutil.WriteFile(keyFile, []byte("not a key"), 0600)
+	if enableReceipts(mux.NewRouter()) == nil {
+		t.Errorf("accepted an invalid key file")
+	}
+}
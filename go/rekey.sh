@@ -0,0 +1,9 @@
+#!/bin/bash
+
+# encrypts the stored data (see roc_face_storage.go) with the first key of the
+# keyring, after a rotation, with the server stopped: ./rekey.sh [PATH...],
+# PATH defaults to the jobs dir, webhooks file, cache dir and galleries file
+
+HERE=$(dirname $0)
+
+go run "$HERE"/roc_mock_server.go "$HERE"/roc_face_*.go rekey "$@"
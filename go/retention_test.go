@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRetentionPolicies(t *testing.T) {
+	var policies, err = parseRetentionPolicies(" default:matched:8760h, visitors:enrolled:720h ,")
+	if err != nil || len(policies) != 2 || policies[0].Gallery != "default" || policies[0].Since != retentionSinceMatched ||
+		policies[1].ttl != 720*time.Hour {
+		t.Errorf("policies = %+v, %v", policies, err)
+	}
+
+	policies, err = parseRetentionPolicies("")
+	if err != nil || len(policies) != 0 {
+		t.Errorf("no policies = %+v, %v", policies, err)
+	}
+
+	for _, value := range []string{
+		"default:720h",
+		":enrolled:720h",
+		"default:created:720h",
+		"default:enrolled:30d",
+		"default:enrolled:-1h",
+		"default:enrolled:720h,default:matched:24h",
+	} {
+		if _, err = parseRetentionPolicies(value); err == nil {
+			t.Errorf("parseRetentionPolicies accepted %q", value)
+		}
+	}
+}
+
+func TestExpire(t *testing.T) {
+	var now = time.Now()
+	var day = 24 * time.Hour
+	var store = &galleryStore{galleries: map[string][]*galleryEntry{
+		"default": {
+			{SubjectID: "old", EnrolledAt: now.Add(-10 * day)},
+			{SubjectID: "matched", EnrolledAt: now.Add(-10 * day), LastMatchedAt: now.Add(-day)},
+			{SubjectID: "new", EnrolledAt: now.Add(-day)},
+		},
+		"visitors": {
+			{SubjectID: "matched visitor", EnrolledAt: now.Add(-3 * day), LastMatchedAt: now.Add(-time.Hour)},
+		},
+		"unlimited": {
+			{SubjectID: "forever", EnrolledAt: now.Add(-1000 * day)},
+		},
+	}}
+
+	var policies, _ = parseRetentionPolicies("default:matched:168h,visitors:enrolled:48h")
+	var expired = store.expire(policies, now, true)
+	if len(expired) != 2 || expired[0].SubjectID != "old" || expired[1].SubjectID != "matched visitor" ||
+		!expired[0].ExpiredAt.Equal(now.Add(-3*day)) || expired[1].LastMatchedAt == nil {
+		t.Errorf("expired = %+v, want old then matched visitor", expired)
+	}
+
+	if len(store.galleries["default"]) != 3 || len(store.galleries["visitors"]) != 1 {
+		t.Errorf("a dry run removed templates: %+v", store.galleries)
+	}
+
+	expired = store.expire(policies, now, false)
+	if len(expired) != 2 || len(store.galleries["default"]) != 2 || len(store.galleries["visitors"]) != 0 || len(store.galleries["unlimited"]) != 1 {
+		t.Errorf("after expiring, galleries = %+v", store.galleries)
+	}
+
+	if expired = store.expire(policies, now, false); len(expired) != 0 {
+		t.Errorf("expired %+v again", expired)
+	}
+}
+
+// postSameName posts the images as a job, all named photo.png
+func postSameName(t *testing.T, handler http.Handler, url string, images ...[]byte) job {
+	var body bytes.Buffer
+	var form = multipart.NewWriter(&body)
+	for i, data := range images {
+		var part, err = form.CreateFormFile("image"+string('a'+rune(i)), "photo.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		part.Write(data)
+	}
+
+	form.Close()
+	var r = httptest.NewRequest("POST", url, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	var w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	var j job
+	json.Unmarshal(w.Body.Bytes(), &j)
+	if j.ID == "" {
+		t.Fatalf("%s: status %d: %s", url, w.Code, w.Body.String())
+	}
+
+	return j
+}
+
+// waitForJob waits for a job to finish
+func waitForJob(t *testing.T, handler http.Handler, j job) job {
+	for deadline := time.Now().Add(5 * time.Second); !j.finished(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s didn't finish: %+v", j.ID, j)
+		}
+
+		j = getJob(handler, j.ID)
+	}
+
+	return j
+}
+
+// getJob gets the job as it is now
+func getJob(handler http.Handler, id string) job {
+	var w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/jobs/"+id, nil))
+	var j job
+	json.Unmarshal(w.Body.Bytes(), &j)
+	return j
+}
+
+// enrollTestSubject enrolls the face of the subject's test image
+func enrollTestSubject(t *testing.T, gallery string, subjectID string) []byte {
+	var path = filepath.Join(os.TempDir(), "roc-face-"+subjectID+".png")
+	var err = writeStoredFile(path, testImage(subjectID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(path)
+	var img, _, _ = engine.readImage(path)
+	var faces = engine.detectFaces(img, defaultColorSpace, []string{"recognition"}, adaptiveMinFaceWidth, 1, defaultFDR)
+	if len(faces) != 1 {
+		t.Fatalf("%d faces in %s's image", len(faces), subjectID)
+	}
+
+	galleries.enroll(gallery, subjectID, faces[0].Template)
+	return faces[0].Template
+}
+
+func eraseTestSubject(t *testing.T, handler http.Handler, subjectID string) subjectErasure {
+	var w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("DELETE", "/subjects/"+subjectID, nil))
+	var erasure subjectErasure
+	json.Unmarshal(w.Body.Bytes(), &erasure)
+	if w.Code != http.StatusOK {
+		t.Fatalf("erase %s: status %d: %s", subjectID, w.Code, w.Body.String())
+	}
+
+	return erasure
+}
+
+func TestEraseSubjectFromJobs(t *testing.T) {
+	var router, stop = newTestJobs(t)
+	defer stop()
+
+	var err = enableRetention(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enrollTestSubject(t, "erasure-test", "dana")
+
+	// without the recognition attribute, and with images of the same name
+	var analyzed = waitForJob(t, router, postSameName(t, router, "/jobs?type=analyze&attributes=pose", testImage("dana"), testImage("erin")))
+	var deduped = waitForJob(t, router, postSameName(t, router, "/jobs?type=dedupe",
+		testImage("dana"), testImage("erin"), testImage("dana", "frank"), testImage("erin", "gina")))
+
+	if analyzed.Status != jobStatusSucceeded || deduped.Status != jobStatusSucceeded {
+		t.Fatalf("jobs %+v, %+v", analyzed, deduped)
+	}
+
+	var erasure = eraseTestSubject(t, router, "dana")
+	var ids = []string{analyzed.ID, deduped.ID}
+	sort.Strings(ids)
+	if strings.Join(erasure.Jobs, ",") != strings.Join(ids, ",") {
+		t.Fatalf("erased jobs %v, want both", erasure.Jobs)
+	}
+
+	analyzed = getJob(router, analyzed.ID)
+	var results []analyzeJobResult
+	json.Unmarshal(analyzed.Result, &results)
+	if len(results) != 2 || results[0].Code != "Erased" || results[1].Code == "Erased" || analyzed.Images[0] != erasedImageName || analyzed.Images[1] != "photo.png" {
+		t.Errorf("analyze job after erasing dana: %s, images %v", analyzed.Result, analyzed.Images)
+	}
+
+	// the template computed for the erasure isn't returned
+	if analysis, _ := results[1].Analysis.(map[string]interface{}); analysis["Yaw"] == nil || analysis["Quality"] != nil || bytes.Contains(analyzed.Result, []byte("emplate")) {
+		t.Errorf("analyze job without the recognition attribute: %s", analyzed.Result)
+	}
+
+	// erin's duplicates are kept, though her images have the same name as dana's
+	deduped = getJob(router, deduped.ID)
+	var dedupe dedupeResult
+	json.Unmarshal(deduped.Result, &dedupe)
+	if len(dedupe.Duplicates) != 1 || dedupe.Duplicates[0].Indexes != [2]int{1, 3} || len(dedupe.Groups) != 1 || len(dedupe.Groups[0]) != 2 {
+		t.Errorf("dedupe job after erasing dana: %s", deduped.Result)
+	}
+}
+
+func TestEraseSubjectFromUnfinishedJob(t *testing.T) {
+	var router, stop = newTestJobs(t)
+	defer stop()
+
+	var err = enableRetention(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hana = enrollTestSubject(t, "erasure-test", "hana")
+	var ivan = enrollTestSubject(t, "other-gallery", "ivan")
+
+	// a job running while hana is erased
+	var j = &job{ID: newID(), Type: jobTypeAnalyze, Status: jobStatusRunning, Images: []string{"hana.png", "ivan.png"}, CreatedAt: time.Now()}
+	os.MkdirAll(jobs.jobDir(j.ID), 0700)
+	jobs.mutex.Lock()
+	jobs.jobs[j.ID] = j
+	jobs.mutex.Unlock()
+
+	var erasure = eraseTestSubject(t, router, "hana")
+	if len(erasure.Jobs) != 0 || len(erasure.PendingJobs) != 1 || erasure.PendingJobs[0] != j.ID {
+		t.Fatalf("erasure = %+v, want the running job pending", erasure)
+	}
+
+	// only the job is stored, not the subject
+	var erasuresPath = filepath.Join(jobs.dir, jobErasuresFile)
+	var data, _ = readStoredFile(erasuresPath)
+	var ids []string
+	if err = json.Unmarshal(data, &ids); err != nil || len(ids) != 1 || ids[0] != j.ID {
+		t.Errorf("stored erasures %s, %v, want the job's ID", data, err)
+	}
+
+	// erased once it finishes
+	jobs.finish(j, []analyzeJobResult{{Image: "hana.png"}, {Image: "ivan.png"}}, [][][]byte{{hana}, {ivan}}, nil)
+	jobs.mutex.Lock()
+	var images = append([]string{}, j.Images...)
+	var result = j.Result
+	var pending = len(jobs.erasures)
+	jobs.mutex.Unlock()
+
+	var results []analyzeJobResult
+	json.Unmarshal(result, &results)
+	if images[0] != erasedImageName || images[1] != "ivan.png" || len(results) != 2 || results[0].Code != "Erased" || results[1].Code != "" {
+		t.Errorf("job after finishing: %s, images %v", result, images)
+	}
+
+	if _, err = os.Stat(erasuresPath); pending != 0 || !os.IsNotExist(err) {
+		t.Errorf("%d erasures still pending, file: %v", pending, err)
+	}
+}
+
+func TestEraseSubjectBeforeRestart(t *testing.T) {
+	var router, stop = newTestJobs(t)
+	defer stop()
+
+	var err = enableRetention(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enrollTestSubject(t, "erasure-test", "kate")
+
+	// a job running while kate is erased, and the server restarts before it
+	// finishes
+	var j = &job{ID: newID(), Type: jobTypeAnalyze, Status: jobStatusRunning, Images: []string{"kate.png"}, CreatedAt: time.Now()}
+	os.MkdirAll(jobs.jobDir(j.ID), 0700)
+	jobs.mutex.Lock()
+	jobs.jobs[j.ID] = j
+	jobs.save(j)
+	jobs.mutex.Unlock()
+	eraseTestSubject(t, router, "kate")
+
+	var restarted = &jobManager{dir: jobs.dir, ttl: defaultJobTTL, jobs: map[string]*job{}, templates: map[string][][][]byte{},
+		cancels: map[string]context.CancelFunc{}, queue: make(chan string, jobQueueSize)}
+	if err = restarted.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the job can't be erased anymore, so it doesn't run again
+	if reloaded := restarted.jobs[j.ID]; reloaded.Status != jobStatusFailed || len(restarted.queue) != 0 {
+		t.Errorf("job after a restart: %+v", reloaded)
+	}
+
+	if _, err = os.Stat(filepath.Join(jobs.dir, jobErasuresFile)); !os.IsNotExist(err) {
+		t.Errorf("the erasures file is left after a restart: %v", err)
+	}
+}
+
+func TestEraseSubjectThreshold(t *testing.T) {
+	var router = newRouter()
+	var err = enableRetention(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var template = enrollTestSubject(t, "erasure-test", "jack")
+	defer galleries.erase("jack")
+
+	// from 0, every face would be erased
+	for _, threshold := range []string{"0", "-0.5", "1.5", "NaN", "Inf", "high"} {
+		var w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("DELETE", "/subjects/jack?threshold="+threshold, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("threshold %s: status %d, want 400", threshold, w.Code)
+		}
+	}
+
+	if matches, _ := galleries.search("erasure-test", template, 1, 0); len(matches) != 1 {
+		t.Errorf("jack was erased with an invalid threshold")
+	}
+}
+
+func TestEraseSubjectWithoutTemplates(t *testing.T) {
+	var dispatcher, webhookRouter, cleanup = newTestWebhooks(t)
+	defer cleanup()
+
+	webhooks = dispatcher
+	defer func() {
+		webhooks = nil
+	}()
+
+	var receiver = &webhookReceiver{t: t, failures: 100}
+	var server = httptest.NewServer(receiver)
+	defer server.Close()
+
+	var _, hook = registerWebhook(t, webhookRouter, server.URL, webhookEventWatchlistHit)
+	receiver.secret = hook.Secret
+	dispatcher.notifyWatchlistHits("", "default", []galleryMatch{{"kate", 0.9}}, "probe")
+	waitForDeliveries(t, webhookRouter, "/webhooks/dead-letters", 1, deliveryStatusFailed)
+
+	// kate isn't in any gallery, her watchlist hits are still erased
+	var router = newRouter()
+	var err = enableRetention(router)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var erasure = eraseTestSubject(t, router, "kate")
+	if len(erasure.Galleries) != 0 || erasure.WatchlistHits != 1 {
+		t.Errorf("erasure = %+v, want 1 watchlist hit", erasure)
+	}
+
+	waitForDeliveries(t, webhookRouter, "/webhooks/dead-letters", 0, deliveryStatusFailed)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+var retryMarker = color.RGBA{255, 0, 0, 255}
+
+// markedImage is a gray image with a red rectangle at marker
+func markedImage(width int, height int, marker image.Rectangle) *image.RGBA {
+	var img = image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (image.Point{x, y}).In(marker) {
+				img.Set(x, y, retryMarker)
+			} else {
+				img.Set(x, y, color.RGBA{128, 128, 128, 255})
+			}
+		}
+	}
+
+	return img
+}
+
+// markerBounds finds the red rectangle of a markedImage (or a transformed
+// copy, the edges of which may be blurred by the scaling)
+func markerBounds(img image.Image) image.Rectangle {
+	var rgba = toRGBA(img)
+	var bounds image.Rectangle
+	for y := rgba.Rect.Min.Y; y < rgba.Rect.Max.Y; y++ {
+		for x := rgba.Rect.Min.X; x < rgba.Rect.Max.X; x++ {
+			if c := rgba.RGBAAt(x, y); int(c.R)-int(c.G) >= 128 {
+				bounds = bounds.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	return bounds
+}
+
+func boxOf(rect image.Rectangle) faceBox {
+	return faceBox{X: rect.Min.X, Y: rect.Min.Y, Width: rect.Dx(), Height: rect.Dy()}
+}
+
+// eyeLineAngle is the angle of the line from the right to the left eye, in
+// degrees clockwise
+func eyeLineAngle(metadata map[string]interface{}) float64 {
+	var rightX, rightY, _ = landmark(metadata, "RightEye")
+	var leftX, leftY, _ = landmark(metadata, "LeftEye")
+	return math.Atan2(leftY-rightY, leftX-rightX) * 180 / math.Pi
+}
+
+func TestImageTransformMapFaceBack(t *testing.T) {
+	var marker = image.Rect(10, 5, 25, 30)
+	var img = markedImage(60, 40, marker)
+	for _, transform := range []imageTransform{
+		{Name: "rotate90", Orientation: 6, Scale: 1},
+		{Name: "rotate180", Orientation: 3, Scale: 1},
+		{Name: "rotate270", Orientation: 8, Scale: 1},
+		{Name: "upscale2x", Orientation: 1, Scale: 2},
+		{Name: "rotate90 upscale2x", Orientation: 6, Scale: 2},
+	} {
+		// a face on the marker, found in the transformed image, with the eyes
+		// on a 20 degree line and the roll to match
+		var transformed = transform.apply(img)
+		var found = markerBounds(transformed)
+		var centerX, centerY = float64(found.Min.X+found.Max.X) / 2, float64(found.Min.Y+found.Max.Y) / 2
+		var dx, dy = 4 * math.Cos(20*math.Pi/180), 4 * math.Sin(20*math.Pi/180)
+		var face = detectedFace{
+			Box: boxOf(found),
+			Metadata: map[string]interface{}{
+				"RightEyeX": centerX - dx, "RightEyeY": centerY - dy,
+				"LeftEyeX": centerX + dx, "LeftEyeY": centerY + dy,
+				"Roll": 20.0, "Yaw": 5.0,
+			},
+		}
+
+		var mapped = transform.mapFaceBack(face, 60, 40)
+		if mapped.Box != boxOf(marker) {
+			t.Errorf("%s: box %+v, want %+v", transform.Name, mapped.Box, boxOf(marker))
+		}
+
+		var roll = mapped.Metadata["Roll"].(float64)
+		if math.Abs(normalizeAngle(roll-eyeLineAngle(mapped.Metadata))) > 1e-6 {
+			t.Errorf("%s: roll %v, eye line at %v", transform.Name, roll, eyeLineAngle(mapped.Metadata))
+		}
+
+		if mapped.Metadata["Yaw"] != 5.0 {
+			t.Errorf("%s: yaw %v, want 5", transform.Name, mapped.Metadata["Yaw"])
+		}
+
+		if face.Metadata["Roll"] != 20.0 {
+			t.Errorf("%s: mapFaceBack changed the face", transform.Name)
+		}
+	}
+
+	// a mirror image turns the head the other way
+	var mapped = imageTransform{Orientation: 2, Scale: 1}.mapFaceBack(detectedFace{
+		Metadata: map[string]interface{}{"Roll": 10.0, "Yaw": 5.0},
+	}, 60, 40)
+	if mapped.Metadata["Roll"] != -10.0 || mapped.Metadata["Yaw"] != -5.0 {
+		t.Errorf("flipHorizontal: %+v", mapped.Metadata)
+	}
+}
+
+func TestRetryDetection(t *testing.T) {
+	// finds the marker only when it's wider than tall, and at least 20 pixels
+	// high
+	var detect = func(img image.Image) []detectedFace {
+		var found = markerBounds(img)
+		if found.Dx() <= found.Dy() || found.Dy() < 20 {
+			return nil
+		}
+
+		return []detectedFace{{Box: boxOf(found), Metadata: map[string]interface{}{"Roll": 90.0}}}
+	}
+
+	var marker = image.Rect(10, 5, 32, 35)
+	var faces, transform = retryDetection(markedImage(60, 40, marker), retryOptions{Rotate: true}, detect)
+	if len(faces) != 1 || transform != "rotate90" {
+		t.Fatalf("rotate: %d faces, transform %q", len(faces), transform)
+	}
+
+	if faces[0].Box != boxOf(marker) || faces[0].Metadata["Roll"] != 0.0 {
+		t.Errorf("rotate: %+v, want box %+v and no roll", faces[0], boxOf(marker))
+	}
+
+	// too small until upscaled
+	marker = image.Rect(10, 5, 30, 15)
+	faces, transform = retryDetection(markedImage(60, 40, marker), retryOptions{Rotate: true, Upscale: true}, detect)
+	if len(faces) != 1 || transform != "upscale2x" || faces[0].Box != boxOf(marker) {
+		t.Errorf("upscale: %+v, transform %q, want box %+v", faces, transform, boxOf(marker))
+	}
+
+	faces, transform = retryDetection(markedImage(60, 40, marker), retryOptions{Rotate: true}, detect)
+	if len(faces) != 0 || transform != "" {
+		t.Errorf("no retry helps: %+v, transform %q", faces, transform)
+	}
+}
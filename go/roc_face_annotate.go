@@ -0,0 +1,259 @@
+// Debug images: face boxes, landmarks and labels drawn over the input image
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	// Third party packages
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// composite images are scaled to this height so the labels stay readable
+const annotateCompositeHeight = 480
+const annotateLineHeight = 14
+const annotateHeaderHeight = 24
+
+var annotateBoxColor = color.RGBA{0, 255, 0, 255}
+var annotateTextColor = color.RGBA{255, 255, 255, 255}
+var annotateLabelBackground = color.RGBA{0, 0, 0, 160}
+var annotateErrorColor = color.RGBA{255, 64, 64, 255}
+
+// landmark name -> marker color
+var annotateLandmarks = []struct {
+	Name  string
+	Color color.RGBA
+}{
+	{"RightEye", color.RGBA{0, 160, 255, 255}},
+	{"LeftEye", color.RGBA{0, 160, 255, 255}},
+	{"NoseRoot", color.RGBA{255, 200, 0, 255}},
+	{"Chin", color.RGBA{255, 0, 200, 255}},
+}
+
+// annotateImage draws every face onto a copy of img. message, if set, is
+// printed at the top of the image (e.g. an error code).
+func annotateImage(img image.Image, faces []detectedFace, message string) *image.RGBA {
+	var canvas = image.NewRGBA(img.Bounds().Sub(img.Bounds().Min))
+	draw.Draw(canvas, canvas.Bounds(), img, img.Bounds().Min, draw.Src)
+	var thickness = annotateThickness(canvas.Bounds())
+	for _, face := range faces {
+		drawFace(canvas, face, thickness)
+	}
+
+	if message != "" {
+		drawLabel(canvas, image.Pt(0, 0), []string{message}, annotateErrorColor)
+	}
+
+	return canvas
+}
+
+// annotateVerification renders both images side by side, each scaled to the
+// same height, with the similarity (or the failure) in a header
+func annotateVerification(images [2]image.Image, faces [2][]detectedFace, result verificationResult) *image.RGBA {
+	var widths [2]int
+	var scales [2]float64
+	for i, img := range images {
+		var bounds = img.Bounds()
+		scales[i] = float64(annotateCompositeHeight) / float64(bounds.Dy())
+		widths[i] = int(math.Round(float64(bounds.Dx()) * scales[i]))
+	}
+
+	var canvas = image.NewRGBA(image.Rect(0, 0, widths[0]+widths[1], annotateHeaderHeight+annotateCompositeHeight))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(color.Black), image.ZP, draw.Src)
+	var offsetX = 0
+	for i, img := range images {
+		var dst = image.Rect(offsetX, annotateHeaderHeight, offsetX+widths[i], annotateHeaderHeight+annotateCompositeHeight)
+		var panel = canvas.SubImage(dst).(*image.RGBA)
+		scaleInto(panel, img)
+		var thickness = annotateThickness(panel.Bounds())
+		for _, face := range faces[i] {
+			drawFace(panel, face.scale(scales[i]).offset(dst.Min), thickness)
+		}
+
+		offsetX += widths[i]
+	}
+
+	var header string
+	var headerColor = annotateTextColor
+	if result.Code != "" {
+		header = fmt.Sprintf("%s: %s", result.Code, result.Message)
+		headerColor = annotateErrorColor
+	} else {
+		header = fmt.Sprintf("similarity: %.4f", result.Similarity)
+	}
+
+	drawText(canvas, image.Pt(6, annotateHeaderHeight-7), header, headerColor)
+	return canvas
+}
+
+func annotateThickness(bounds image.Rectangle) int {
+	var size = bounds.Dx()
+	if bounds.Dy() > size {
+		size = bounds.Dy()
+	}
+
+	var thickness = size / 400
+	if thickness < 1 {
+		return 1
+	}
+
+	return thickness
+}
+
+// scale returns the face with its box and landmarks scaled by factor
+func (face detectedFace) scale(factor float64) detectedFace {
+	var scaled = face.clone()
+	scaled.Box = faceBox{
+		X:      int(math.Round(float64(face.Box.X) * factor)),
+		Y:      int(math.Round(float64(face.Box.Y) * factor)),
+		Width:  int(math.Round(float64(face.Box.Width) * factor)),
+		Height: int(math.Round(float64(face.Box.Height) * factor)),
+	}
+
+	for _, mark := range annotateLandmarks {
+		if x, y, ok := landmark(face.Metadata, mark.Name); ok {
+			scaled.Metadata[mark.Name+"X"] = x * factor
+			scaled.Metadata[mark.Name+"Y"] = y * factor
+		}
+	}
+
+	return scaled
+}
+
+// offset returns the face with its box and landmarks translated by p
+func (face detectedFace) offset(p image.Point) detectedFace {
+	var moved = face.clone()
+	moved.Box.X += p.X
+	moved.Box.Y += p.Y
+	for _, mark := range annotateLandmarks {
+		if x, y, ok := landmark(face.Metadata, mark.Name); ok {
+			moved.Metadata[mark.Name+"X"] = x + float64(p.X)
+			moved.Metadata[mark.Name+"Y"] = y + float64(p.Y)
+		}
+	}
+
+	return moved
+}
+
+// scaleInto fills dst with src resized to dst's bounds
+func scaleInto(dst *image.RGBA, src image.Image) {
+	var bounds = dst.Bounds()
+	var srcBounds = src.Bounds()
+	var scaleX = float64(srcBounds.Dx()) / float64(bounds.Dx())
+	var scaleY = float64(srcBounds.Dy()) / float64(bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var srcX = (float64(x-bounds.Min.X) + 0.5) * scaleX
+			var srcY = (float64(y-bounds.Min.Y) + 0.5) * scaleY
+			dst.SetRGBA(x, y, sampleBilinear(src, srcX, srcY))
+		}
+	}
+}
+
+// drawFace draws the face box, its landmarks and a pose / quality label. The
+// box is expected in canvas coordinates.
+func drawFace(canvas *image.RGBA, face detectedFace, thickness int) {
+	var box = face.Box.rect()
+	drawRect(canvas, box, thickness, annotateBoxColor)
+	for _, mark := range annotateLandmarks {
+		if x, y, ok := landmark(face.Metadata, mark.Name); ok {
+			var center = image.Pt(int(math.Round(x)), int(math.Round(y)))
+			var radius = 2 * thickness
+			draw.Draw(canvas, image.Rect(center.X-radius, center.Y-radius, center.X+radius+1, center.Y+radius+1), image.NewUniform(mark.Color), image.ZP, draw.Src)
+		}
+	}
+
+	drawLabel(canvas, image.Pt(box.Min.X, box.Max.Y+thickness), faceLabels(face.Metadata), annotateTextColor)
+}
+
+// faceLabels summarizes the pose and quality metadata, when present
+func faceLabels(metadata map[string]interface{}) []string {
+	var labels []string
+	if pose, ok := metadata["Pose"].(string); ok {
+		labels = append(labels, "pose: "+pose)
+	}
+
+	if quality, ok := metadata["Quality"].(float64); ok {
+		labels = append(labels, fmt.Sprintf("quality: %.2f", quality))
+	}
+
+	var yaw, okYaw = metadata["Yaw"].(float64)
+	var pitch, okPitch = metadata["Pitch"].(float64)
+	var roll, okRoll = metadata["Roll"].(float64)
+	if okYaw && okPitch && okRoll {
+		labels = append(labels, fmt.Sprintf("yaw %.0f pitch %.0f roll %.0f", yaw, pitch, roll))
+	}
+
+	if iod, ok := metadata["IOD"].(float64); ok {
+		labels = append(labels, fmt.Sprintf("IOD: %.0f", iod))
+	}
+
+	return labels
+}
+
+func drawRect(canvas *image.RGBA, rect image.Rectangle, thickness int, c color.Color) {
+	var src = image.NewUniform(c)
+	var edges = []image.Rectangle{
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Max.X, rect.Min.Y+thickness),
+		image.Rect(rect.Min.X, rect.Max.Y-thickness, rect.Max.X, rect.Max.Y),
+		image.Rect(rect.Min.X, rect.Min.Y, rect.Min.X+thickness, rect.Max.Y),
+		image.Rect(rect.Max.X-thickness, rect.Min.Y, rect.Max.X, rect.Max.Y),
+	}
+
+	for _, edge := range edges {
+		draw.Draw(canvas, edge, src, image.ZP, draw.Src)
+	}
+}
+
+// drawLabel draws lines of text on a translucent background, top left
+// corner at p, keeping it inside the canvas
+func drawLabel(canvas *image.RGBA, p image.Point, lines []string, c color.Color) {
+	if len(lines) == 0 {
+		return
+	}
+
+	var width = 0
+	for _, line := range lines {
+		var advance = font.MeasureString(basicfont.Face7x13, line).Ceil()
+		if advance > width {
+			width = advance
+		}
+	}
+
+	var bounds = canvas.Bounds()
+	var rect = image.Rect(p.X, p.Y, p.X+width+4, p.Y+len(lines)*annotateLineHeight+4)
+	if rect.Max.Y > bounds.Max.Y {
+		rect = rect.Add(image.Pt(0, bounds.Max.Y-rect.Max.Y))
+	}
+
+	if rect.Max.X > bounds.Max.X {
+		rect = rect.Add(image.Pt(bounds.Max.X-rect.Max.X, 0))
+	}
+
+	if rect.Min.X < bounds.Min.X {
+		rect = rect.Add(image.Pt(bounds.Min.X-rect.Min.X, 0))
+	}
+
+	draw.Draw(canvas, rect, image.NewUniform(annotateLabelBackground), image.ZP, draw.Over)
+	for i, line := range lines {
+		drawText(canvas, image.Pt(rect.Min.X+2, rect.Min.Y+(i+1)*annotateLineHeight-1), line, c)
+	}
+}
+
+// drawText draws a line of text with its baseline starting at p
+func drawText(canvas *image.RGBA, p image.Point, text string, c color.Color) {
+	var drawer = font.Drawer{
+		Dst:  canvas,
+		Src:  image.NewUniform(c),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(p.X, p.Y),
+	}
+
+	drawer.DrawString(text)
+}
@@ -0,0 +1,1194 @@
+// The HTTP API and the verify / analyze / crop / quality pipelines, shared by
+// the server and the mock server. Both provide the face engine.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+)
+
+// faceEngine is what the API needs from the face recognition SDK
+type faceEngine interface {
+	// readImage decodes an image file, applies its EXIF orientation and
+	// returns the name of the applied transform
+	readImage(filePath string) (image.Image, string, error)
+
+	// detectFaces finds up to numFacesToDetect faces in the image and
+	// computes the given attributes (see defaultAttributes) for each. With
+	// minFaceWidthInPixels set to adaptiveMinFaceWidth, the minimum face size
+	// is derived from the image size.
+	detectFaces(img image.Image, colorSpace string, attributes []string, minFaceWidthInPixels int, numFacesToDetect int, fdr float32) []detectedFace
+
+	// compareTemplates compares two flattened templates, InvalidSimilarity
+	// if either can't be read
+	compareTemplates(a []byte, b []byte) float32
+
+	// checkTemplate returns an error if a flattened template, e.g. sent by a
+	// client, can't be compared
+	checkTemplate(template []byte) error
+
+	// version is the SDK version, part of the template cache keys
+	// and recorded in the audit log
+	version() string
+}
+
+// engine is set by main: the ROC SDK in roc_server.go, a fake one in
+// roc_mock_server.go
+var engine faceEngine
+
+// serveGRPC serves the gRPC API on a listener. It is set by roc_grpc.go,
+// when that file is part of the build.
+var serveGRPC func(listener net.Listener) error
+
+// InvalidSimilarity value for similarity when verification failed for some reason
+const InvalidSimilarity = -1.0
+const _128M = (1 << 20) * 128
+const defaultFDR = 0.02
+const defaultMinFaceWidthInPixels = 36
+const defaultNumFacesToDetect = 1
+
+// minFaceWidthInPixels value to derive the minimum face size from the image size
+const adaptiveMinFaceWidth = -1
+
+var verifyFormFields = []string{"image1", "image2"}
+var analyzeFormFields = []string{"image"}
+var cropFormFields = []string{"image"}
+var qualityFormFields = []string{"image"}
+
+// faces detected by /quality, enough to tell whether there is more than one
+const qualityFacesToDetect = 5
+
+type verificationResult struct {
+	Similarity float32 `json:"similarity"`
+	Code       string  `json:"code,omitempty"`
+	Message    string  `json:"message,omitempty"`
+	ColorSpace string  `json:"colorSpace,omitempty"`
+
+	// EXIF orientation transform applied to each image, if any
+	Transforms []string `json:"transforms,omitempty"`
+
+	// retry transform the face was found with in each image, if any
+	Retries []string `json:"retries,omitempty"`
+
+	Detection string `json:"detection,omitempty"`
+
+	// face selection strategy, and the face used in each image
+	FaceSelection string          `json:"faceSelection,omitempty"`
+	Selected      []faceSelection `json:"selected,omitempty"`
+
+	// face details for each image, when verbose
+	Images []faceDetails `json:"images,omitempty"`
+
+	// spoof verdict for each image, when requested
+	Spoof []*spoofResult `json:"spoof,omitempty"`
+
+	// signed receipt of the rest of the result, when requested (see
+	// roc_face_receipts.go)
+	Receipt string `json:"receipt,omitempty"`
+
+	// faces found in each image, used for annotation
+	faces [2][]detectedFace
+}
+
+type verifyOptions struct {
+	ColorSpace string
+	Annotate   bool
+	Spoof      spoofOptions
+	Retry      retryOptions
+	Detection  string
+
+	// when set, up to NumFacesToDetect faces are detected per image and
+	// one is chosen with this strategy
+	Selection        string
+	NumFacesToDetect int
+	Verbose          bool
+
+	// don't use the template cache
+	SkipCache bool
+}
+
+type analysisResult struct {
+	Code                 string       `json:"code,omitempty"`
+	Message              string       `json:"message,omitempty"`
+	FDR                  float32      `json:"fdr,omitempty"`
+	MinFaceWidthInPixels int          `json:"minFaceWidthInPixels,omitempty"`
+	ColorSpace           string       `json:"colorSpace,omitempty"`
+	Transform            string       `json:"transform,omitempty"`
+	Retry                string       `json:"retry,omitempty"`
+	Detection            string       `json:"detection,omitempty"`
+	Analysis             interface{}  `json:"analysis,omitempty"`
+	Spoof                *spoofResult `json:"spoof,omitempty"`
+	Thumbnail            []byte       `json:"thumbnail,omitempty"`
+	Crops                []faceCrop   `json:"crops,omitempty"`
+
+	// all detected faces, used for annotation
+	faces []detectedFace
+}
+
+type cropResult struct {
+	Code    string     `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+	Faces   []faceCrop `json:"faces,omitempty"`
+}
+
+type analyzeOptions struct {
+	FDR                  float32
+	MinFaceWidthInPixels int
+	NumFacesToDetect     int
+	ColorSpace           string
+	Attributes           []string
+	Retry                retryOptions
+	Detection            string
+	Crop                 *cropOptions
+	Spoof                spoofOptions
+
+	// faces are represented even without the recognition attribute, for the
+	// templates of jobs (see roc_face_jobs.go)
+	templates bool
+}
+
+type qualityResult struct {
+	Code    string         `json:"code,omitempty"`
+	Message string         `json:"message,omitempty"`
+	Profile string         `json:"profile"`
+	Passed  bool           `json:"passed"`
+	Checks  []qualityCheck `json:"checks"`
+	Metrics *imageMetrics  `json:"metrics,omitempty"`
+}
+
+type cropOptions struct {
+	Padding float64
+	Format  string
+	Aligned bool
+}
+
+func deleteFiles(filePaths []string) {
+	log.Println("deleting files:", filePaths)
+	for _, filePath := range filePaths {
+		os.Remove(filePath)
+	}
+}
+
+type errorResponseObj struct {
+	Message string `json:"message"`
+}
+
+func newRouter() *mux.Router {
+	// no r.Schemes("http"): on a router it adds an empty route, which newer
+	// mux versions match (and 404) before the routes below
+	r := mux.NewRouter()
+	r.HandleFunc("/verify", verifyHandler).Methods("POST")
+	r.HandleFunc("/analyze", analyzeHandler).Methods("POST")
+	r.HandleFunc("/crop", cropHandler).Methods("POST")
+	r.HandleFunc("/quality", qualityHandler).Methods("POST")
+	r.HandleFunc("/ping", pingHandler).Methods("GET", "POST")
+	r.HandleFunc("/openapi.json", openAPIHandler).Methods("GET")
+	return r
+}
+
+// newServer loads the server's settings and enables its features, returning
+// the router of the HTTP API, with fault injection if faults is set. The
+// order matters: faults and idempotent retries wrap every route, storage
+// encryption must come before anything is read from storage, the audit log
+// before the features recording decisions, and the gRPC API last.
+func newServer(faults bool) (*mux.Router, error) {
+	var err = loadDefaultColorSpace()
+	if err == nil {
+		err = loadQualityProfiles()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var r = newRouter()
+	if faults {
+		err = enableFaultInjection(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	enableIdempotentRetries(r)
+	for _, enable := range []func() error{
+		enableStorageEncryption,
+		enableGalleryStorage,
+		enableAuditLog,
+		func() error { return enableTemplateCache(r) },
+		func() error { return enableReceipts(r) },
+		func() error { return enableWebhooks(r) },
+		func() error { return enableJobs(r) },
+		func() error { return enableRetention(r) },
+		startGRPCServer,
+	} {
+		err = enable()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// startGRPCServer serves the gRPC API in the background if
+// ROC_FACE_GRPC_PORT is set
+func startGRPCServer() error {
+	var value = os.Getenv("ROC_FACE_GRPC_PORT")
+	if value == "" {
+		return nil
+	}
+
+	var port, err = strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid ROC_FACE_GRPC_PORT %q", value)
+	}
+
+	if serveGRPC == nil {
+		return fmt.Errorf("ROC_FACE_GRPC_PORT is set, but the gRPC API isn't built in, run with roc_grpc.go")
+	}
+
+	var listener net.Listener
+	listener, err = net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", port))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("running gRPC server on: %s", listener.Addr())
+	go func() {
+		log.Println("the gRPC server is dead:", serveGRPC(listener))
+	}()
+
+	return nil
+}
+
+func sendError(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(errorResponseObj{
+		Message: err.Error(),
+	})
+}
+
+// sendInternalError responds to a request that failed after its result was
+// computed, e.g. when it couldn't be audited. The result isn't sent.
+func sendInternalError(w http.ResponseWriter, err error) {
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(errorResponseObj{
+		Message: err.Error(),
+	})
+}
+
+func analyzeHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("/analyze")
+	var filePaths, err = saveImagesFromRequest(r, analyzeFormFields)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	defer deleteFiles(filePaths[:])
+
+	var opts analyzeOptions
+	opts, err = getAnalyzeOptions(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	var annotate bool
+	annotate, err = getBoolQueryParam(r, "annotate", false)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	var result = analyze(filePaths[0], opts)
+	err = auditAnalysis(requestAPIKey(r), "/analyze", filePaths[0], opts, result)
+	if err != nil {
+		sendInternalError(w, err)
+		return
+	}
+
+	if annotate {
+		var img image.Image
+		img, _, err = engine.readImage(filePaths[0])
+		if err != nil {
+			sendError(w, err)
+			return
+		}
+
+		sendImage(w, annotateImage(img, result.faces, result.Code))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+func cropHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("/crop")
+	var filePaths, err = saveImagesFromRequest(r, cropFormFields)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	defer deleteFiles(filePaths[:])
+
+	var fdr float32
+	var minFaceWidthInPixels int
+	var numFacesToDetect int
+	var colorSpace string
+	var opts cropOptions
+	fdr, err = getFloatQueryParam(r, "fdr", defaultFDR)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	minFaceWidthInPixels, err = getIntQueryParam(r, "minFaceWidthInPixels", defaultMinFaceWidthInPixels)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	numFacesToDetect, err = getPositiveIntQueryParam(r, "numFacesToDetect", defaultNumFacesToDetect)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	colorSpace, err = getColorSpace(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	opts, err = getCropOptions(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	var result cropResult
+	result.Faces, err = cropImage(filePaths[0], colorSpace, fdr, minFaceWidthInPixels, numFacesToDetect, opts)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	if len(result.Faces) == 0 {
+		result.Code = "FaceNotDetected"
+		result.Message = "Failed to detect face in image"
+	}
+
+	var params = faceAuditParams{FDR: fdr, MinFaceWidthInPixels: minFaceWidthInPixels, NumFacesToDetect: numFacesToDetect, ColorSpace: colorSpace, Crop: &opts}
+	err = auditCrop(requestAPIKey(r), "/crop", filePaths[0], params, result)
+	if err != nil {
+		sendInternalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+func qualityHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("/quality")
+	var filePaths, err = saveImagesFromRequest(r, qualityFormFields)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	defer deleteFiles(filePaths[:])
+
+	var fdr float32
+	var minFaceWidthInPixels int
+	var colorSpace string
+	var profile qualityProfile
+	fdr, err = getFloatQueryParam(r, "fdr", defaultFDR)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	minFaceWidthInPixels, err = getIntQueryParam(r, "minFaceWidthInPixels", defaultMinFaceWidthInPixels)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	colorSpace, err = getColorSpace(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	var profileName = getStringQueryParam(r, "profile", defaultQualityProfile)
+	profile, err = getQualityProfile(profileName)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	var result qualityResult
+	result, err = checkImageQuality(filePaths[0], colorSpace, fdr, minFaceWidthInPixels, profile)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	result.Profile = profileName
+	var params = faceAuditParams{FDR: fdr, MinFaceWidthInPixels: minFaceWidthInPixels, ColorSpace: colorSpace, Profile: profileName}
+	err = auditQuality(requestAPIKey(r), "/quality", filePaths[0], params, result)
+	if err != nil {
+		sendInternalError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+func getAnalyzeOptions(r *http.Request) (analyzeOptions, error) {
+	var opts analyzeOptions
+	var err error
+	opts.FDR, err = getFloatQueryParam(r, "fdr", defaultFDR)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.MinFaceWidthInPixels, err = getIntQueryParam(r, "minFaceWidthInPixels", defaultMinFaceWidthInPixels)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.NumFacesToDetect, err = getPositiveIntQueryParam(r, "numFacesToDetect", defaultNumFacesToDetect)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.ColorSpace, err = getColorSpace(r)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Attributes, err = parseAttributes(getStringQueryParam(r, "attributes", ""))
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Retry, err = getRetryOptions(r)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Detection, err = getDetectionMode(r)
+	if err != nil {
+		return opts, err
+	}
+
+	var crops bool
+	crops, err = getBoolQueryParam(r, "crops", false)
+	if err != nil {
+		return opts, err
+	}
+
+	if crops {
+		var cropOpts cropOptions
+		cropOpts, err = getCropOptions(r)
+		if err != nil {
+			return opts, err
+		}
+
+		opts.Crop = &cropOpts
+	}
+
+	opts.Spoof, err = getSpoofOptions(r, spoofPolicyReport)
+	if err != nil {
+		return opts, err
+	}
+
+	return opts, checkSpoofAttribute(opts.Spoof, opts.Attributes)
+}
+
+func getCropOptions(r *http.Request) (cropOptions, error) {
+	var opts cropOptions
+	var padding float32
+	var err error
+	padding, err = getFloatQueryParam(r, "padding", defaultCropPadding)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Padding = float64(padding)
+	if err = validateCropPadding(opts.Padding); err != nil {
+		return opts, err
+	}
+
+	opts.Format = getStringQueryParam(r, "format", defaultCropFormat)
+	if err = validateImageFormat(opts.Format); err != nil {
+		return opts, err
+	}
+
+	opts.Aligned, err = getBoolQueryParam(r, "aligned", true)
+	return opts, err
+}
+
+func getRetryOptions(r *http.Request) (retryOptions, error) {
+	var opts retryOptions
+	var err error
+	opts.Rotate, err = getBoolQueryParam(r, "retryRotations", false)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Upscale, err = getBoolQueryParam(r, "retryUpscale", false)
+	return opts, err
+}
+
+func getDetectionMode(r *http.Request) (string, error) {
+	var mode = getStringQueryParam(r, "detection", detectionModeStandard)
+	return mode, validateDetectionMode(mode)
+}
+
+func getColorSpace(r *http.Request) (string, error) {
+	var colorSpace = getStringQueryParam(r, "colorSpace", defaultColorSpace)
+	return colorSpace, validateColorSpace(colorSpace)
+}
+
+func getSpoofOptions(r *http.Request, defaultPolicy string) (spoofOptions, error) {
+	var opts spoofOptions
+	var liveThreshold, threshold float32
+	var err error
+	opts.Policy = getStringQueryParam(r, "spoof", defaultPolicy)
+	liveThreshold, err = getFloatQueryParam(r, "spoofLiveThreshold", defaultSpoofLiveThreshold)
+	if err != nil {
+		return opts, err
+	}
+
+	threshold, err = getFloatQueryParam(r, "spoofThreshold", defaultSpoofThreshold)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.LiveThreshold = float64(liveThreshold)
+	opts.Threshold = float64(threshold)
+	return opts, opts.validate()
+}
+
+func getStringQueryParam(r *http.Request, param string, defaultValue string) string {
+	var val = r.URL.Query().Get(param)
+	if len(val) > 0 {
+		return val
+	}
+
+	return defaultValue
+}
+
+func getBoolQueryParam(r *http.Request, param string, defaultValue bool) (bool, error) {
+	var val = r.URL.Query().Get(param)
+	if len(val) > 0 {
+		return strconv.ParseBool(val)
+	}
+
+	return defaultValue, nil
+}
+
+func getIntQueryParam(r *http.Request, param string, defaultValue int) (int, error) {
+	var val = r.URL.Query().Get(param)
+	if len(val) > 0 {
+		return strconv.Atoi(val)
+	}
+
+	return defaultValue, nil
+}
+
+// getPositiveIntQueryParam is getIntQueryParam for counts, e.g. of faces to
+// detect, which the engine needs to be at least 1
+func getPositiveIntQueryParam(r *http.Request, param string, defaultValue int) (int, error) {
+	var val, err = getIntQueryParam(r, param, defaultValue)
+	if err == nil && val < 1 {
+		return val, fmt.Errorf("invalid %s %d, expected at least 1", param, val)
+	}
+
+	return val, err
+}
+
+// getThresholdQueryParam is getFloatQueryParam for similarity thresholds,
+// which must be more than 0 and at most 1: every face matches from 0
+func getThresholdQueryParam(r *http.Request, param string, defaultValue float32) (float32, error) {
+	var val, err = getFloatQueryParam(r, param, defaultValue)
+	if err == nil && !(val > 0 && val <= 1) {
+		return val, fmt.Errorf("invalid %s %v, expected more than 0 and at most 1", param, val)
+	}
+
+	return val, err
+}
+
+func getFloatQueryParam(r *http.Request, param string, defaultValue float32) (float32, error) {
+	var val = r.URL.Query().Get(param)
+	if len(val) > 0 {
+		var ret, err = strconv.ParseFloat(val, 32)
+		if err != nil {
+			return -1.0, err
+		}
+
+		return float32(ret), err
+	}
+
+	return defaultValue, nil
+}
+
+func pingHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("/ping")
+	w.WriteHeader(http.StatusOK)
+}
+
+func verifyHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("/verify")
+	var filePaths, err = saveImagesFromRequest(r, verifyFormFields)
+	if err != nil {
+		// TODO: in case one image was succcessfully extracted, delete it
+
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(errorResponseObj{
+			Message: err.Error(),
+		})
+
+		return
+	}
+
+	defer deleteFiles(filePaths[:])
+
+	var opts verifyOptions
+	opts.ColorSpace, err = getColorSpace(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	opts.Annotate, err = getBoolQueryParam(r, "annotate", false)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	opts.Verbose, err = getBoolQueryParam(r, "verbose", false)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	opts.Spoof, err = getSpoofOptions(r, spoofPolicyNone)
+	if err == nil && opts.Spoof.enabled() {
+		err = requireAttribute("spoof")
+	}
+
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	opts.Retry, err = getRetryOptions(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	opts.Detection, err = getDetectionMode(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	opts.SkipCache, err = getBoolQueryParam(r, "skipCache", false)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	var withReceipt bool
+	withReceipt, err = getBoolQueryParam(r, "receipt", false)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	if withReceipt && (opts.Annotate || receipts == nil) {
+		sendError(w, fmt.Errorf("receipts are only available for JSON responses of a server signing receipts"))
+		return
+	}
+
+	opts.Selection = getStringQueryParam(r, "faceSelection", "")
+	if opts.Selection != "" {
+		err = validateSelectionStrategy(opts.Selection)
+		if err != nil {
+			sendError(w, err)
+			return
+		}
+
+		opts.NumFacesToDetect, err = getPositiveIntQueryParam(r, "numFacesToDetect", defaultSelectionFacesToDetect)
+		if err != nil {
+			sendError(w, err)
+			return
+		}
+	}
+
+	var result = verify(filePaths, opts)
+	err = auditVerification(requestAPIKey(r), "/verify", filePaths, opts, result)
+	if err != nil {
+		sendInternalError(w, err)
+		return
+	}
+
+	if withReceipt {
+		result.Receipt, err = receipts.issueReceipt("/verify", filePaths, opts, result)
+		if err != nil {
+			sendInternalError(w, err)
+			return
+		}
+	}
+
+	if opts.Annotate {
+		var images [2]image.Image
+		for i := range images {
+			images[i], _, err = engine.readImage(filePaths[i])
+			if err != nil {
+				sendError(w, err)
+				return
+			}
+		}
+
+		sendImage(w, annotateVerification(images, result.faces, result))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+// sendImage responds with a PNG image
+func sendImage(w http.ResponseWriter, img image.Image) {
+	var encoded, err = encodeImage(img, "png")
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
+
+func genTmpPath() string {
+	var tmpPath = "/tmp/roc-face-" + strconv.Itoa(rand.Int())
+	log.Println("generated tmp path", tmpPath)
+	return tmpPath
+}
+
+func saveImagesFromRequest(r *http.Request, formFields []string) ([]string, error) {
+	var err error
+
+	//ParseMultipartForm parses a request body as multipart/form-data
+	r.ParseMultipartForm(_128M) // max 128MB of data (might be needed later for video)
+
+	log.Println("extracting images from request")
+	var filePaths = make([]string, len(formFields))
+	var formImages = make([]multipart.File, len(formFields))
+
+	for index, field := range formFields {
+		log.Println("extracting field", field)
+		image, _, err := r.FormFile(field)
+		if err != nil {
+			log.Println("failed to extract field:", field, "error:", err.Error())
+			return filePaths, err
+		}
+
+		defer image.Close() //close the file when we finish
+		formImages[index] = image
+	}
+
+	log.Println("saving images to disk")
+	for index, image := range formImages {
+		// EXIF metadata (GPS, device, etc.) is never written to disk, only the
+		// orientation is kept
+		var data []byte
+		data, err = ioutil.ReadAll(image)
+		if err != nil {
+			return filePaths, err
+		}
+
+		filePaths[index], err = saveImage(data)
+		if err != nil {
+			return filePaths, err
+		}
+	}
+
+	return filePaths, nil
+}
+
+// saveImage writes an uploaded image to a temporary file, without its EXIF
+// metadata
+func saveImage(data []byte) (string, error) {
+	var imagePath = genTmpPath()
+	var err = ioutil.WriteFile(imagePath, stripImageMetadata(data), 0600)
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("wrote file: %s", imagePath)
+	return imagePath, nil
+}
+
+// analyze extracts the requested attributes of the first detected face, its
+// spoof verdict if enabled, failing it as verify does under the reject and
+// strict policies, and crops of all detected faces if opts.Crop is set
+func analyze(filePath string, opts analyzeOptions) analysisResult {
+	log.Println("analyze()")
+	var img, transform, err = engine.readImage(filePath)
+	if err != nil {
+		log.Println(err.Error())
+		return analysisResult{
+			Code:    "InvalidImage",
+			Message: err.Error(),
+		}
+	}
+
+	log.Println("Analyzing face")
+	var attributes = opts.Attributes
+	if opts.templates && !hasAttribute(attributes, "recognition") {
+		attributes = append(append([]string{}, attributes...), "recognition")
+	}
+
+	var detect = func(img image.Image) []detectedFace {
+		return detectAllowedFaces(img, opts.ColorSpace, attributes, opts.MinFaceWidthInPixels, opts.NumFacesToDetect, opts.FDR)
+	}
+
+	if opts.Detection == detectionModeTiled {
+		var detectTile = detect
+		detect = func(img image.Image) []detectedFace {
+			return tiledDetection(img, opts.NumFacesToDetect, detectTile)
+		}
+	}
+
+	var faces = detect(img)
+	var retry string
+	if len(faces) == 0 && opts.Retry.enabled() {
+		faces, retry = retryDetection(img, opts.Retry, detect)
+	}
+
+	if len(faces) == 0 {
+		var message = fmt.Sprintf("Failed to detect face in image")
+		log.Println(message)
+		return analysisResult{
+			Code:    "FaceNotDetected",
+			Message: message,
+		}
+	}
+
+	// for example:
+	// {
+	// 	"Age": 33,
+	// 	"Asian": 0.0016529097920283675,
+	// 	"Black": 0.00099143886473029852,
+	// 	"ChinX": 537,
+	// 	"ChinY": 373,
+	// 	"Female": 0.0024212179705500603,
+	// 	"Hispanic": 0.043269451707601547,
+	// 	"IOD": 76,
+	// 	"LeftEyeX": 580,
+	// 	"LeftEyeY": 236,
+	// 	"Male": 0.99757874011993408,
+	// 	"NoseRootX": 544,
+	// 	"NoseRootY": 224,
+	// 	"Other": 0.0040792962536215782,
+	// 	"Path": "",
+	// 	"Pitch": 6,
+	// 	"Pose": "Frontal",
+	// 	"Quality": 0.45093154907226562,
+	// 	"RightEyeX": 505,
+	// 	"RightEyeY": 228,
+	// 	"Roll": 2,
+	// 	"SpoofAF": 0.67681902647018433,
+	// 	"White": 0.95000690221786499,
+	// 	"Yaw": -3
+	// }
+
+	var result = analysisResult{
+		FDR:                  opts.FDR,
+		MinFaceWidthInPixels: opts.MinFaceWidthInPixels,
+		ColorSpace:           opts.ColorSpace,
+		Transform:            transform,
+		Retry:                retry,
+		Detection:            opts.Detection,
+		Analysis:             filterMetadata(faces[0].Metadata, opts.Attributes),
+		Thumbnail:            faces[0].Thumbnail,
+		faces:                faces,
+	}
+
+	if opts.Spoof.enabled() && hasAttribute(opts.Attributes, "spoof") {
+		result.Spoof = spoofVerdict(faces[0].Metadata, opts.Spoof)
+		if opts.Spoof.rejects(result.Spoof) {
+			result.Code = "PresentationAttackDetected"
+			result.Message = "The face has no spoof score"
+			if result.Spoof != nil {
+				result.Message = fmt.Sprintf("The face looks like a presentation attack (%s)", result.Spoof.Verdict)
+			}
+
+			log.Println(result.Message)
+		}
+	}
+
+	if opts.Crop != nil {
+		result.Crops, err = cropFaces(img, faces, opts.Crop.Padding, opts.Crop.Format, opts.Crop.Aligned)
+		if err != nil {
+			return analysisResult{
+				Code:    "CropFailed",
+				Message: err.Error(),
+			}
+		}
+	}
+
+	return result
+}
+
+func checkImageQuality(filePath string, colorSpace string, fdr float32, minFaceWidthInPixels int, profile qualityProfile) (qualityResult, error) {
+	log.Println("quality()")
+	var img, _, err = engine.readImage(filePath)
+	if err != nil {
+		return qualityResult{}, err
+	}
+
+	var attributes = []string{"recognition", "pose", "glasses", "landmarks", "lips"}
+
+	var faces = detectAllowedFaces(img, colorSpace, attributes, minFaceWidthInPixels, qualityFacesToDetect, fdr)
+	if len(faces) == 0 {
+		return qualityResult{
+			Code:    "FaceNotDetected",
+			Message: "Failed to detect face in image",
+			Checks: []qualityCheck{{
+				Name:   "faceDetected",
+				Reason: "No face was found in the image",
+			}},
+		}, nil
+	}
+
+	var checks, metrics = checkQuality(img, faces, profile)
+	return qualityResult{
+		Passed:  allChecksPassed(checks),
+		Checks:  checks,
+		Metrics: &metrics,
+	}, nil
+}
+
+func cropImage(filePath string, colorSpace string, fdr float32, minFaceWidthInPixels int, numFacesToDetect int, opts cropOptions) ([]faceCrop, error) {
+	log.Println("crop()")
+	var img, _, err = engine.readImage(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var faces = detectAllowedFaces(img, colorSpace, []string{"landmarks"}, minFaceWidthInPixels, numFacesToDetect, fdr)
+	return cropFaces(img, faces, opts.Padding, opts.Format, opts.Aligned)
+}
+
+// verify compares the first face found in each image. With opts.Annotate set,
+// the landmarks and pose of both faces are extracted too, if allowed.
+func verify(filePaths []string, opts verifyOptions) verificationResult {
+	if len(filePaths) != 2 {
+		return verificationResult{
+			Similarity: InvalidSimilarity,
+			Code:       "InvalidImageCount",
+			Message:    "expected two image paths",
+		}
+	}
+
+	log.Println("verify()")
+	var result verificationResult
+	var attributes = []string{"recognition"}
+	if opts.Annotate || opts.Verbose {
+		attributes = append(attributes, "landmarks", "pose")
+	}
+
+	if opts.Spoof.enabled() {
+		attributes = append(attributes, "spoof")
+		result.Spoof = make([]*spoofResult, 2)
+	}
+
+	// Find and represent the faces in each image. Verbose looks for more, to
+	// count them, but without a selection strategy the first (best) face is
+	// still the one compared.
+	var numFacesToDetect = 1
+	if opts.Selection != "" {
+		numFacesToDetect = opts.NumFacesToDetect
+	} else if opts.Verbose {
+		numFacesToDetect = defaultSelectionFacesToDetect
+	}
+
+	var transforms [2]string
+	var retries [2]string
+	var bounds [2]image.Rectangle
+	for i := 0; i < 2; i++ {
+		var detection, err = detectForVerify(filePaths[i], attributes, numFacesToDetect, opts)
+		if err != nil {
+			return verificationResult{
+				Similarity: InvalidSimilarity,
+				Code:       "InvalidImage",
+				Message:    fmt.Sprintf("Failed to read image %d: %s", i, err.Error()),
+			}
+		}
+
+		transforms[i] = detection.Transform
+		retries[i] = detection.Retry
+		bounds[i] = detection.Bounds
+		var faces = detection.Faces
+		if len(faces) == 0 {
+			if result.Code == "" {
+				result.Similarity = InvalidSimilarity
+				result.Code = "FaceNotDetected"
+				result.Message = fmt.Sprintf("Failed to detect face in image %d", i)
+				log.Println(result.Message)
+			}
+
+			continue
+		}
+
+		result.faces[i] = faces
+	}
+
+	result.ColorSpace = opts.ColorSpace
+	result.Detection = opts.Detection
+	if transforms[0] != "" || transforms[1] != "" {
+		result.Transforms = transforms[:]
+	}
+
+	if retries[0] != "" || retries[1] != "" {
+		result.Retries = retries[:]
+	}
+
+	// Choose the face to use in each image
+	var selected [2]int
+	if result.Code == "" && opts.Selection != "" {
+		if opts.Selection == selectionBestMatch {
+			selected[0], selected[1], result.Similarity = selectBestMatch(result.faces, func(a detectedFace, b detectedFace) float32 {
+				return engine.compareTemplates(a.Template, b.Template)
+			})
+		} else {
+			for i := range selected {
+				selected[i] = selectFace(result.faces[i], opts.Selection, bounds[i])
+			}
+		}
+
+		result.FaceSelection = opts.Selection
+		result.Selected = make([]faceSelection, 2)
+		for i := range selected {
+			result.Selected[i] = faceSelection{Index: selected[i], FaceCount: len(result.faces[i])}
+			if opts.Selection == selectionRejectIfMultiple && len(result.faces[i]) > 1 && result.Code == "" {
+				result.Similarity = InvalidSimilarity
+				result.Code = "MultipleFacesDetected"
+				result.Message = fmt.Sprintf("Found %d faces in image %d", len(result.faces[i]), i)
+				log.Println(result.Message)
+			}
+		}
+	}
+
+	if opts.Spoof.enabled() {
+		for i := 0; i < 2; i++ {
+			if len(result.faces[i]) == 0 {
+				continue
+			}
+
+			result.Spoof[i] = spoofVerdict(result.faces[i][selected[i]].Metadata, opts.Spoof)
+			if result.Code == "" && opts.Spoof.rejects(result.Spoof[i]) {
+				result.Similarity = InvalidSimilarity
+				result.Code = "PresentationAttackDetected"
+				if result.Spoof[i] == nil {
+					result.Message = fmt.Sprintf("Image %d has no spoof score", i)
+				} else {
+					result.Message = fmt.Sprintf("Image %d looks like a presentation attack (%s)", i, result.Spoof[i].Verdict)
+				}
+				log.Println(result.Message)
+			}
+		}
+	}
+
+	if opts.Verbose {
+		result.Images = make([]faceDetails, 2)
+		for i := range result.Images {
+			result.Images[i] = describeFace(result.faces[i], selected[i])
+		}
+	}
+
+	if result.Code == "" {
+		// Compare faces (best-match already did)
+		if opts.Selection != selectionBestMatch {
+			result.Similarity = engine.compareTemplates(result.faces[0][selected[0]].Template, result.faces[1][selected[1]].Template)
+		}
+
+		log.Println("Similarity:", result.Similarity)
+	}
+
+	return result
+}
+
+// detectForVerify finds the faces in one of the images of verify(), through
+// the template cache unless the request skips it
+func detectForVerify(filePath string, attributes []string, numFacesToDetect int, opts verifyOptions) (cachedDetection, error) {
+	var key string
+	if templates != nil && !opts.SkipCache {
+		var err error
+		key, err = cacheKey(filePath, opts.ColorSpace, attributes, opts.Detection, numFacesToDetect, opts.Retry)
+		if err != nil {
+			return cachedDetection{}, err
+		}
+
+		if detection, ok := templates.get(key); ok {
+			log.Println("template cache hit")
+			return detection, nil
+		}
+	}
+
+	var img, transform, err = engine.readImage(filePath)
+	if err != nil {
+		return cachedDetection{}, err
+	}
+
+	var detection = cachedDetection{Transform: transform, Bounds: img.Bounds()}
+	var detect = func(img image.Image) []detectedFace {
+		return detectAllowedFaces(img, opts.ColorSpace, attributes, adaptiveMinFaceWidth, numFacesToDetect, defaultFDR)
+	}
+
+	if opts.Detection == detectionModeTiled {
+		// the adaptive minimum face size is relative to each tile, so
+		// smaller faces are found than in the whole image
+		var detectTile = detect
+		detect = func(img image.Image) []detectedFace {
+			return tiledDetection(img, numFacesToDetect, detectTile)
+		}
+	}
+
+	detection.Faces = detect(img)
+	if len(detection.Faces) == 0 && opts.Retry.enabled() {
+		detection.Faces, detection.Retry = retryDetection(img, opts.Retry, detect)
+	}
+
+	if key != "" {
+		templates.put(key, detection)
+	}
+
+	return detection, nil
+}
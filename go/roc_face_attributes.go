@@ -0,0 +1,167 @@
+// Face attributes that can be requested from /analyze, and the template
+// metadata keys each of them produces. The ROC_FACE_ALLOWED_ATTRIBUTES
+// allowlist applies to every route detecting faces: /quality skips the checks
+// of attributes it doesn't enable, /crop leaves out aligned crops without
+// landmarks, verbose /verify leaves out the pose, and /verify rejects spoof
+// policies without spoof.
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"sort"
+	"strings"
+)
+
+// attributes computed by /analyze when the request doesn't list any
+var defaultAttributes = []string{"recognition", "demographics", "pose", "spoof", "glasses", "landmarks", "lips"}
+
+var attributeMetadataKeys = map[string][]string{
+	"recognition":  {"Quality"},
+	"demographics": {"Age", "Male", "Female", "Asian", "Black", "Hispanic", "White", "Other"},
+	"pose":         {"Pitch", "Yaw", "Roll", "Pose"},
+	"spoof":        {"SpoofAF"},
+	"glasses":      {"Glasses", "Sunglasses"},
+	"landmarks":    {"RightEyeX", "RightEyeY", "LeftEyeX", "LeftEyeY", "ChinX", "ChinY", "NoseRootX", "NoseRootY", "IOD"},
+	"lips":         {"LipsApart"},
+	"thumbnail":    {},
+}
+
+// attributes callers may request, configured with the comma separated
+// ROC_FACE_ALLOWED_ATTRIBUTES environment variable (all of them by default)
+var allowedAttributes = loadAllowedAttributes()
+
+func attributeNames() []string {
+	var names []string
+	for name := range attributeMetadataKeys {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func loadAllowedAttributes() map[string]bool {
+	var allowed = map[string]bool{}
+	var names = attributeNames()
+	if env := os.Getenv("ROC_FACE_ALLOWED_ATTRIBUTES"); env != "" {
+		names = strings.Split(env, ",")
+	}
+
+	for _, name := range names {
+		allowed[strings.TrimSpace(name)] = true
+	}
+
+	return allowed
+}
+
+// parseAttributes validates a comma separated attribute list against the
+// allowlist. An empty list selects the allowed default attributes.
+func parseAttributes(list string) ([]string, error) {
+	if list == "" {
+		var attributes []string
+		for _, name := range defaultAttributes {
+			if allowedAttributes[name] {
+				attributes = append(attributes, name)
+			}
+		}
+
+		return attributes, nil
+	}
+
+	var attributes []string
+	var seen = map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := attributeMetadataKeys[name]; !ok {
+			return nil, fmt.Errorf("unknown attribute %q, expected one of: %s", name, strings.Join(attributeNames(), ", "))
+		}
+
+		if err := requireAttribute(name); err != nil {
+			return nil, err
+		}
+
+		if !seen[name] {
+			seen[name] = true
+			attributes = append(attributes, name)
+		}
+	}
+
+	return attributes, nil
+}
+
+// requireAttribute returns an error if the allowlist doesn't enable the
+// attribute
+func requireAttribute(name string) error {
+	if !allowedAttributes[name] {
+		return fmt.Errorf("attribute %q is not enabled on this server", name)
+	}
+
+	return nil
+}
+
+// allowedOnly returns the attributes the allowlist enables
+func allowedOnly(attributes []string) []string {
+	var allowed []string
+	for _, name := range attributes {
+		if allowedAttributes[name] {
+			allowed = append(allowed, name)
+		}
+	}
+
+	return allowed
+}
+
+// detectAllowedFaces is engine.detectFaces for the attributes the allowlist
+// enables, the others are neither computed nor returned. Recognition is
+// always computed, its templates are compared internally, but its metadata
+// is dropped unless allowed.
+func detectAllowedFaces(img image.Image, colorSpace string, attributes []string, minFaceWidthInPixels int, numFacesToDetect int, fdr float32) []detectedFace {
+	var allowed = allowedOnly(attributes)
+	var computed = allowed
+	if hasAttribute(attributes, "recognition") && !allowedAttributes["recognition"] {
+		computed = append(append([]string{}, allowed...), "recognition")
+	}
+
+	var faces = engine.detectFaces(img, colorSpace, computed, minFaceWidthInPixels, numFacesToDetect, fdr)
+	if len(computed) != len(allowed) {
+		for i := range faces {
+			faces[i].Metadata = filterMetadata(faces[i].Metadata, allowed)
+		}
+	}
+
+	return faces
+}
+
+func hasAttribute(attributes []string, name string) bool {
+	for _, attribute := range attributes {
+		if attribute == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterMetadata drops the metadata keys belonging to attributes that were
+// not requested. Keys not tied to any attribute are kept.
+func filterMetadata(metadata map[string]interface{}, attributes []string) map[string]interface{} {
+	var filtered = map[string]interface{}{}
+	for key, value := range metadata {
+		filtered[key] = value
+	}
+
+	for name, keys := range attributeMetadataKeys {
+		if hasAttribute(attributes, name) {
+			continue
+		}
+
+		for _, key := range keys {
+			delete(filtered, key)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,556 @@
+// Append-only audit log of the biometric decisions made by the HTTP and gRPC
+// APIs: who asked (a fingerprint of the API key), the route, SHA-256 hashes
+// of the input images (never the images), the parameters, the similarity,
+// the decision and the SDK version.
+//
+// Each entry holds the hash of the previous one, so editing, removing or
+// reordering entries breaks the chain. Entries are JSON lines in
+// ROC_FACE_AUDIT_DIR/audit-NNNNNN.log, a new file is started once one
+// reaches ROC_FACE_AUDIT_MAX_BYTES, and the chain continues across files.
+// Check a log with audit.sh, or:
+//
+//	go run roc_server.go roc_face_*.go audit verify [DIR]
+//
+// The chain can't tell that entries were cut off the end of the log, keep
+// the last hash verify prints somewhere else to detect that.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultAuditDir = "/tmp/roc-face-audit"
+const defaultAuditMaxBytes = 64 << 20
+const auditFileFormat = "audit-%06d.log"
+
+// longest entry read back
+const auditMaxEntryBytes = 1 << 20
+
+const auditDecisionCompared = "compared"
+const auditDecisionAnalyzed = "analyzed"
+const auditDecisionEnrolled = "enrolled"
+const auditDecisionMatched = "matched"
+const auditDecisionNoMatch = "noMatch"
+const auditDecisionPassed = "passed"
+const auditDecisionFailed = "failed"
+const auditDecisionCropped = "cropped"
+
+type auditEntry struct {
+	Seq       int64     `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// first 16 hex digits of the SHA-256 of the API key, if one was sent
+	APIKey string `json:"apiKey,omitempty"`
+	Route  string `json:"route"`
+
+	// SHA-256 of each image as processed, without its EXIF metadata, and of
+	// each template sent instead of an image
+	ImageHashes    []string `json:"imageHashes,omitempty"`
+	TemplateHashes []string `json:"templateHashes,omitempty"`
+
+	// subjects enrolled or matched
+	Subjects []string `json:"subjects,omitempty"`
+
+	// the options the request was run with, after defaults
+	Params     json.RawMessage `json:"params,omitempty"`
+	Similarity *float32        `json:"similarity,omitempty"`
+
+	// a result code (e.g. FaceNotDetected), or one of the auditDecision
+	// constants
+	Decision   string `json:"decision"`
+	SDKVersion string `json:"sdkVersion"`
+
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+}
+
+// auditLog appends entries to the highest numbered file in dir
+type auditLog struct {
+	mutex    sync.Mutex
+	dir      string
+	maxBytes int64
+	file     *os.File
+	number   int
+	size     int64
+	seq      int64
+	lastHash string
+}
+
+// audit is set by enableAuditLog, nothing is recorded when it's nil
+var audit *auditLog
+
+// enableAuditLog opens the audit log, continuing the chain of the existing
+// entries
+func enableAuditLog() error {
+	var maxBytes int64 = defaultAuditMaxBytes
+	var err error
+	if value := os.Getenv("ROC_FACE_AUDIT_MAX_BYTES"); value != "" {
+		maxBytes, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBytes <= 0 {
+			return fmt.Errorf("invalid ROC_FACE_AUDIT_MAX_BYTES %q, expected a positive number", value)
+		}
+	}
+
+	var trail *auditLog
+	trail, err = openAuditLog(auditDir(), maxBytes)
+	if err != nil {
+		return err
+	}
+
+	log.Println("writing the audit log to", trail.dir, "from entry", trail.seq+1)
+	audit = trail
+	return nil
+}
+
+func auditDir() string {
+	var dir = os.Getenv("ROC_FACE_AUDIT_DIR")
+	if dir == "" {
+		return defaultAuditDir
+	}
+
+	return dir
+}
+
+func openAuditLog(dir string, maxBytes int64) (*auditLog, error) {
+	var err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	var trail = &auditLog{dir: dir, maxBytes: maxBytes, number: 1}
+	var numbers []int
+	numbers, err = auditFileNumbers(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(numbers) > 0 {
+		trail.number = numbers[len(numbers)-1]
+	}
+
+	// the last entry may be in an earlier file, when the last one was just
+	// started
+	for i := len(numbers) - 1; i >= 0 && trail.lastHash == ""; i-- {
+		var last *auditEntry
+		last, err = lastAuditEntry(trail.path(numbers[i]))
+		if err != nil {
+			return nil, err
+		}
+
+		if last != nil {
+			trail.seq = last.Seq
+			trail.lastHash = last.Hash
+		}
+	}
+
+	err = trail.openFile()
+	if err != nil {
+		return nil, err
+	}
+
+	return trail, nil
+}
+
+func (trail *auditLog) path(number int) string {
+	return filepath.Join(trail.dir, fmt.Sprintf(auditFileFormat, number))
+}
+
+func (trail *auditLog) openFile() error {
+	var file, err = os.OpenFile(trail.path(trail.number), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	var info os.FileInfo
+	info, err = file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	trail.file = file
+	trail.size = info.Size()
+	return nil
+}
+
+// append chains the entry to the previous one and writes it, starting a new
+// file if the current one is full. The entry is synced to disk before
+// append returns.
+func (trail *auditLog) append(entry auditEntry) error {
+	trail.mutex.Lock()
+	defer trail.mutex.Unlock()
+	entry.Seq = trail.seq + 1
+	entry.Timestamp = time.Now().UTC()
+	entry.SDKVersion = engine.version()
+	entry.PrevHash = trail.lastHash
+	var err error
+	entry.Hash, err = auditHash(entry)
+	if err != nil {
+		return err
+	}
+
+	var line []byte
+	line, err = json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+	if trail.size > 0 && trail.size+int64(len(line)) > trail.maxBytes {
+		trail.file.Close()
+		trail.number++
+		err = trail.openFile()
+		if err != nil {
+			return err
+		}
+
+		log.Println("rotated the audit log to", trail.file.Name())
+	}
+
+	_, err = trail.file.Write(line)
+	if err == nil {
+		err = trail.file.Sync()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	trail.size += int64(len(line))
+	trail.seq = entry.Seq
+	trail.lastHash = entry.Hash
+	return nil
+}
+
+// auditHash is the SHA-256 of the entry's JSON, without its hash
+func auditHash(entry auditEntry) (string, error) {
+	entry.Hash = ""
+	var data, err = json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+
+	var sum = sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// recordAudit appends an entry to the audit log, if enabled
+func recordAudit(entry auditEntry, params interface{}) error {
+	if audit == nil {
+		return nil
+	}
+
+	var err error
+	if params != nil {
+		entry.Params, err = json.Marshal(params)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = audit.append(entry)
+	if err != nil {
+		log.Println("failed to write the audit log:", err.Error())
+		return fmt.Errorf("failed to write the audit log: %s", err.Error())
+	}
+
+	return nil
+}
+
+// auditVerification records a verify() call
+func auditVerification(apiKey string, route string, filePaths []string, opts verifyOptions, result verificationResult) error {
+	var entry = auditEntry{APIKey: apiKey, Route: route, Decision: auditDecisionCompared}
+	if result.Code != "" {
+		entry.Decision = result.Code
+	} else {
+		entry.Similarity = &result.Similarity
+	}
+
+	var err error
+	entry.ImageHashes, err = hashFiles(filePaths)
+	if err != nil {
+		return err
+	}
+
+	return recordAudit(entry, opts)
+}
+
+// auditAnalysis records an analyze() call
+func auditAnalysis(apiKey string, route string, filePath string, opts analyzeOptions, result analysisResult) error {
+	var entry = auditEntry{APIKey: apiKey, Route: route, Decision: analysisDecision(result)}
+	var err error
+	entry.ImageHashes, err = hashFiles([]string{filePath})
+	if err != nil {
+		return err
+	}
+
+	return recordAudit(entry, opts)
+}
+
+// faceAuditParams are the options of a /quality or /crop request
+type faceAuditParams struct {
+	FDR                  float32
+	MinFaceWidthInPixels int
+	NumFacesToDetect     int `json:",omitempty"`
+	ColorSpace           string
+	Profile              string       `json:",omitempty"`
+	Crop                 *cropOptions `json:",omitempty"`
+}
+
+// auditQuality records a checkImageQuality() call
+func auditQuality(apiKey string, route string, filePath string, params faceAuditParams, result qualityResult) error {
+	var entry = auditEntry{APIKey: apiKey, Route: route, Decision: result.Code}
+	if entry.Decision == "" && result.Passed {
+		entry.Decision = auditDecisionPassed
+	} else if entry.Decision == "" {
+		entry.Decision = auditDecisionFailed
+	}
+
+	var err error
+	entry.ImageHashes, err = hashFiles([]string{filePath})
+	if err != nil {
+		return err
+	}
+
+	return recordAudit(entry, params)
+}
+
+// auditCrop records a cropImage() call
+func auditCrop(apiKey string, route string, filePath string, params faceAuditParams, result cropResult) error {
+	var entry = auditEntry{APIKey: apiKey, Route: route, Decision: result.Code}
+	if entry.Decision == "" {
+		entry.Decision = auditDecisionCropped
+	}
+
+	var err error
+	entry.ImageHashes, err = hashFiles([]string{filePath})
+	if err != nil {
+		return err
+	}
+
+	return recordAudit(entry, params)
+}
+
+// analysisDecision is the decision recorded for an analysis: its result code,
+// or the spoof verdict when there is one
+func analysisDecision(result analysisResult) string {
+	if result.Code != "" {
+		return result.Code
+	} else if result.Spoof != nil {
+		return result.Spoof.Verdict
+	}
+
+	return auditDecisionAnalyzed
+}
+
+func hashFiles(filePaths []string) ([]string, error) {
+	var hashes = make([]string, len(filePaths))
+	for i, filePath := range filePaths {
+		var data, err = ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		hashes[i] = hashBytes(data)
+	}
+
+	return hashes, nil
+}
+
+// hashImage hashes an uploaded image the way it's stored, so it matches the
+// hash of the same image sent to another route
+func hashImage(data []byte) string {
+	return hashBytes(stripImageMetadata(data))
+}
+
+func hashBytes(data []byte) string {
+	var sum = sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// apiKeyFingerprint identifies the API key sent in an X-Api-Key or a bearer
+// Authorization header, without recording the key
+func apiKeyFingerprint(apiKey string, authorization string) string {
+	if apiKey == "" && strings.HasPrefix(authorization, "Bearer ") {
+		apiKey = strings.TrimPrefix(authorization, "Bearer ")
+	}
+
+	if apiKey == "" {
+		return ""
+	}
+
+	return hashBytes([]byte(apiKey))[:16]
+}
+
+func requestAPIKey(r *http.Request) string {
+	return apiKeyFingerprint(r.Header.Get("X-Api-Key"), r.Header.Get("Authorization"))
+}
+
+// auditFileNumbers lists the numbers of the audit log files in dir, in order
+func auditFileNumbers(dir string) ([]int, error) {
+	var entries, err = ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []int
+	for _, entry := range entries {
+		var number int
+		if _, err = fmt.Sscanf(entry.Name(), auditFileFormat, &number); err == nil && entry.Name() == fmt.Sprintf(auditFileFormat, number) {
+			numbers = append(numbers, number)
+		}
+	}
+
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// readAuditFile calls read with each line of an audit log file, and its
+// entry or the error parsing it
+func readAuditFile(path string, read func(lineNumber int, entry *auditEntry, err error)) error {
+	var file, err = os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	var scanner = bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), auditMaxEntryBytes)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var entry auditEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			read(lineNumber, nil, err)
+		} else {
+			read(lineNumber, &entry, nil)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// lastAuditEntry returns the last entry of a file, nil if it's empty
+func lastAuditEntry(path string) (*auditEntry, error) {
+	var last *auditEntry
+	var lastErr error
+	var err = readAuditFile(path, func(lineNumber int, entry *auditEntry, err error) {
+		last, lastErr = entry, err
+	})
+
+	if err == nil && lastErr != nil {
+		err = fmt.Errorf("%s ends with a malformed entry (%s), check it with: audit verify", path, lastErr.Error())
+	}
+
+	return last, err
+}
+
+type auditReport struct {
+	Files    int
+	Entries  int64
+	LastSeq  int64
+	LastHash string
+	Problems []string
+}
+
+// verifyAuditLog checks the chain of every entry in dir, reporting missing
+// files, malformed or modified entries, and gaps in the sequence
+func verifyAuditLog(dir string) (auditReport, error) {
+	var report auditReport
+	var numbers, err = auditFileNumbers(dir)
+	if err != nil {
+		return report, err
+	}
+
+	for i, number := range numbers {
+		if i > 0 {
+			for missing := numbers[i-1] + 1; missing < number; missing++ {
+				report.Problems = append(report.Problems, "missing file "+fmt.Sprintf(auditFileFormat, missing))
+			}
+		}
+
+		var name = fmt.Sprintf(auditFileFormat, number)
+		report.Files++
+		err = readAuditFile(filepath.Join(dir, name), func(lineNumber int, entry *auditEntry, err error) {
+			var problem = func(format string, args ...interface{}) {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s:%d: ", name, lineNumber)+fmt.Sprintf(format, args...))
+			}
+
+			if err != nil {
+				problem("malformed entry: %s", err.Error())
+				return
+			}
+
+			report.Entries++
+			if entry.Seq != report.LastSeq+1 {
+				problem("expected entry %d, found entry %d", report.LastSeq+1, entry.Seq)
+			}
+
+			if entry.PrevHash != report.LastHash {
+				problem("entry %d doesn't follow the previous entry, its previous hash is %s", entry.Seq, entry.PrevHash)
+			}
+
+			if hash, _ := auditHash(*entry); hash != entry.Hash {
+				problem("entry %d was modified, its hash is %s", entry.Seq, hash)
+			}
+
+			// carry on from this entry, so one problem isn't reported for
+			// every entry after it
+			report.LastSeq = entry.Seq
+			report.LastHash = entry.Hash
+		})
+
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// auditCommand runs the audit command line, e.g. audit verify [DIR], and
+// returns the exit status
+func auditCommand(args []string) int {
+	if len(args) < 1 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: audit verify [DIR], DIR defaults to $ROC_FACE_AUDIT_DIR or", defaultAuditDir)
+		return 2
+	}
+
+	var dir = auditDir()
+	if len(args) > 1 {
+		dir = args[1]
+	}
+
+	var report, err = verifyAuditLog(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+
+	fmt.Printf("checked %d entries in %d files, last entry %d, hash %s\n", report.Entries, report.Files, report.LastSeq, report.LastHash)
+	if len(report.Problems) > 0 {
+		fmt.Println("the audit log was tampered with, problems found:", len(report.Problems))
+		return 1
+	}
+
+	fmt.Println("the audit log is intact")
+	return 0
+}
@@ -0,0 +1,296 @@
+// Template cache for verify(): the faces found in an image are kept by the
+// SHA-256 of the image bytes, the detection parameters and the SDK version,
+// so a reference photo verified again only costs a compare, and templates of
+// an older SDK are never compared to new ones.
+//
+//	GET    /cache  size and hit / miss counts
+//	DELETE /cache  empties the cache
+//
+// ROC_FACE_CACHE_SIZE bounds the number of cached images (0 disables the
+// cache). With ROC_FACE_CACHE_DIR set, entries are also written there, and
+// read back when the server restarts. Requests bypass the cache with
+// skipCache=true.
+
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+)
+
+const defaultCacheSize = 1000
+
+// cachedDetection is what verify() finds in an image
+type cachedDetection struct {
+	Faces     []detectedFace
+	Transform string
+	Retry     string
+	Bounds    image.Rectangle
+}
+
+type cacheStats struct {
+	Size      int     `json:"size"`
+	Capacity  int     `json:"capacity"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	Evictions int64   `json:"evictions"`
+	HitRate   float64 `json:"hitRate"`
+}
+
+type cacheItem struct {
+	key       string
+	detection cachedDetection
+}
+
+// templateCache is a least recently used cache of detections, the front of
+// the list is the most recently used
+type templateCache struct {
+	mutex    sync.Mutex
+	capacity int
+	dir      string
+	items    map[string]*list.Element
+	order    *list.List
+	stats    cacheStats
+}
+
+// templates is set by enableTemplateCache, verify() runs without a cache
+// when it's nil
+var templates *templateCache
+
+// enableTemplateCache creates the cache and adds the /cache routes to the
+// router
+func enableTemplateCache(r *mux.Router) error {
+	var capacity = defaultCacheSize
+	var err error
+	if value := os.Getenv("ROC_FACE_CACHE_SIZE"); value != "" {
+		capacity, err = strconv.Atoi(value)
+		if err != nil || capacity < 0 {
+			return fmt.Errorf("invalid ROC_FACE_CACHE_SIZE %q, expected a number", value)
+		}
+	}
+
+	if capacity == 0 {
+		log.Println("template cache disabled")
+		return nil
+	}
+
+	var cache = newTemplateCache(capacity, os.Getenv("ROC_FACE_CACHE_DIR"))
+	if cache.dir != "" {
+		err = cache.load()
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Println("caching templates of up to", capacity, "images")
+	templates = cache
+	r.HandleFunc("/cache", cache.statsHandler).Methods("GET")
+	r.HandleFunc("/cache", cache.clearHandler).Methods("DELETE")
+	return nil
+}
+
+func newTemplateCache(capacity int, dir string) *templateCache {
+	return &templateCache{
+		capacity: capacity,
+		dir:      dir,
+		items:    map[string]*list.Element{},
+		order:    list.New(),
+		stats:    cacheStats{Capacity: capacity},
+	}
+}
+
+// cacheKey hashes the image with everything that changes what's detected in
+// it, including the SDK version, whose templates may not compare to others,
+// and the attributes the allowlist enables
+func cacheKey(filePath string, colorSpace string, attributes []string, detection string, numFacesToDetect int, retry retryOptions) (string, error) {
+	var data, err = ioutil.ReadFile(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	var hash = sha256.New()
+	hash.Write(data)
+	fmt.Fprintf(hash, "\x00%s\x00%s\x00%s\x00%s\x00%d\x00%d\x00%g\x00%t\x00%t",
+		engine.version(), colorSpace, strings.Join(allowedOnly(attributes), ","), detection, numFacesToDetect,
+		adaptiveMinFaceWidth, defaultFDR, retry.Rotate, retry.Upscale)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// get returns the cached detection, its faces must not be modified
+func (cache *templateCache) get(key string) (cachedDetection, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	var element, ok = cache.items[key]
+	if !ok {
+		cache.stats.Misses++
+		return cachedDetection{}, false
+	}
+
+	cache.stats.Hits++
+	cache.order.MoveToFront(element)
+	return element.Value.(*cacheItem).detection, true
+}
+
+// put caches the detection, evicting the least recently used ones beyond the
+// capacity
+func (cache *templateCache) put(key string, detection cachedDetection) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.items[key]; ok {
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.add(key, detection)
+	if cache.dir != "" {
+		var data, err = json.Marshal(detection)
+		if err == nil {
+			err = writeStoredFile(cache.path(key), data)
+		}
+
+		if err != nil {
+			log.Println("failed to write cache entry", key, "error:", err.Error())
+		}
+	}
+}
+
+// add inserts an entry, the mutex must be held
+func (cache *templateCache) add(key string, detection cachedDetection) {
+	cache.items[key] = cache.order.PushFront(&cacheItem{key: key, detection: detection})
+	for cache.order.Len() > cache.capacity {
+		var oldest = cache.order.Back()
+		var item = cache.order.Remove(oldest).(*cacheItem)
+		delete(cache.items, item.key)
+		cache.stats.Evictions++
+		if cache.dir != "" {
+			os.Remove(cache.path(item.key))
+		}
+	}
+}
+
+func (cache *templateCache) path(key string) string {
+	return filepath.Join(cache.dir, key+".json")
+}
+
+// load reads the entries written before a restart, the most recently written
+// ones are the most recently used
+func (cache *templateCache) load() error {
+	var err = os.MkdirAll(cache.dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	var entries []os.FileInfo
+	entries, err = ioutil.ReadDir(cache.dir)
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	for _, entry := range entries {
+		var key = strings.TrimSuffix(entry.Name(), ".json")
+		if key == entry.Name() {
+			continue
+		}
+
+		var data []byte
+		data, err = readStoredFile(filepath.Join(cache.dir, entry.Name()))
+		if err != nil {
+			log.Println("skipping cache entry", key, "error:", err.Error())
+			continue
+		}
+
+		var detection cachedDetection
+		err = json.Unmarshal(data, &detection)
+		if err != nil {
+			log.Println("skipping cache entry", key, "error:", err.Error())
+			continue
+		}
+
+		cache.add(key, detection)
+	}
+
+	log.Println("loaded", cache.order.Len(), "cached templates from", cache.dir)
+	return nil
+}
+
+func (cache *templateCache) clear() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.dir != "" {
+		for key := range cache.items {
+			os.Remove(cache.path(key))
+		}
+	}
+
+	cache.items = map[string]*list.Element{}
+	cache.order.Init()
+}
+
+// erase removes the entries with a face matching the subject, and returns how
+// many were removed
+func (cache *templateCache) erase(matches func(template []byte) bool) int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	var removed = 0
+	for key, element := range cache.items {
+		for _, face := range element.Value.(*cacheItem).detection.Faces {
+			if matches(face.Template) {
+				cache.order.Remove(element)
+				delete(cache.items, key)
+				if cache.dir != "" {
+					os.Remove(cache.path(key))
+				}
+
+				removed++
+				break
+			}
+		}
+	}
+
+	return removed
+}
+
+func (cache *templateCache) currentStats() cacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	var stats = cache.stats
+	stats.Size = cache.order.Len()
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+
+	return stats
+}
+
+func (cache *templateCache) statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cache.currentStats())
+}
+
+func (cache *templateCache) clearHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("clearing the template cache")
+	cache.clear()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,87 @@
+// Per image face details for verbose /verify responses, so rejections can be
+// explained
+
+package main
+
+import (
+	"math"
+)
+
+// warnings are raised against the limits of this quality profile
+const detailsQualityProfile = defaultQualityProfile
+
+const warningLowQuality = "lowQuality"
+const warningLowIOD = "lowIOD"
+const warningExtremeYaw = "extremeYaw"
+const warningExtremePitch = "extremePitch"
+const warningExtremeRoll = "extremeRoll"
+const warningMultipleFaces = "multipleFaces"
+
+type faceDetails struct {
+	// bounding box of the face used, absent if no face was found
+	Template  *faceBox `json:"template,omitempty"`
+	FaceCount int      `json:"faceCount"`
+	Quality   *float64 `json:"quality,omitempty"`
+	Pose      string   `json:"pose,omitempty"`
+	Yaw       *float64 `json:"yaw,omitempty"`
+	Pitch     *float64 `json:"pitch,omitempty"`
+	Roll      *float64 `json:"roll,omitempty"`
+	IOD       *float64 `json:"iod,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+// describeFace summarizes the face at index selected among the faces found
+// in an image
+func describeFace(faces []detectedFace, selected int) faceDetails {
+	var details = faceDetails{FaceCount: len(faces)}
+	if len(faces) == 0 {
+		return details
+	}
+
+	var face = faces[selected]
+	var box = face.Box
+	details.Template = &box
+	details.Quality = metadataFloat(face.Metadata, "Quality")
+	details.Pose, _ = face.Metadata["Pose"].(string)
+	details.Yaw = metadataFloat(face.Metadata, "Yaw")
+	details.Pitch = metadataFloat(face.Metadata, "Pitch")
+	details.Roll = metadataFloat(face.Metadata, "Roll")
+	details.IOD = metadataFloat(face.Metadata, "IOD")
+
+	// zero limits are disabled, as in the quality checks
+	var profile = qualityProfiles[detailsQualityProfile]
+	if profile.MinQuality > 0 && details.Quality != nil && *details.Quality < profile.MinQuality {
+		details.Warnings = append(details.Warnings, warningLowQuality)
+	}
+
+	if profile.MinIOD > 0 && details.IOD != nil && *details.IOD < profile.MinIOD {
+		details.Warnings = append(details.Warnings, warningLowIOD)
+	}
+
+	if profile.MaxYaw > 0 && details.Yaw != nil && math.Abs(*details.Yaw) > profile.MaxYaw {
+		details.Warnings = append(details.Warnings, warningExtremeYaw)
+	}
+
+	if profile.MaxPitch > 0 && details.Pitch != nil && math.Abs(*details.Pitch) > profile.MaxPitch {
+		details.Warnings = append(details.Warnings, warningExtremePitch)
+	}
+
+	if profile.MaxRoll > 0 && details.Roll != nil && math.Abs(*details.Roll) > profile.MaxRoll {
+		details.Warnings = append(details.Warnings, warningExtremeRoll)
+	}
+
+	if len(faces) > 1 {
+		details.Warnings = append(details.Warnings, warningMultipleFaces)
+	}
+
+	return details
+}
+
+func metadataFloat(metadata map[string]interface{}, key string) *float64 {
+	var value, ok = metadata[key].(float64)
+	if !ok {
+		return nil
+	}
+
+	return &value
+}
@@ -0,0 +1,341 @@
+// EXIF orientation handling, and stripping of EXIF metadata (GPS, device,
+// etc.) from uploaded images before they are written to disk. Images with
+// more than maxImagePixels pixels are refused before they are decoded.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+)
+
+const exifOrientationTag = 0x0112
+
+// a few kilobytes of image file can decode to gigabytes of pixels
+const maxImagePixels = 100 << 20
+
+var errImageTooLarge = fmt.Errorf("the image has more than %d pixels", maxImagePixels)
+
+// names of the transforms applied for each EXIF orientation value
+var orientationTransforms = map[int]string{
+	2: "flipHorizontal",
+	3: "rotate180",
+	4: "flipVertical",
+	5: "transpose",
+	6: "rotate90",
+	7: "transverse",
+	8: "rotate270",
+}
+
+var jpegSOI = []byte{0xFF, 0xD8}
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+var exifHeader = []byte("Exif\x00\x00")
+
+// exifOrientation returns the EXIF orientation of a JPEG or PNG image, 1
+// (no transform) if there is none
+func exifOrientation(data []byte) int {
+	var orientation = 1
+	if bytes.HasPrefix(data, jpegSOI) {
+		walkJPEGSegments(data, func(marker byte, payload []byte) bool {
+			if marker == 0xE1 && bytes.HasPrefix(payload, exifHeader) {
+				orientation = tiffOrientation(payload[len(exifHeader):])
+				return false
+			}
+
+			return true
+		})
+	} else if bytes.HasPrefix(data, pngSignature) {
+		walkPNGChunks(data, func(chunkType string, payload []byte) bool {
+			if chunkType == "eXIf" {
+				orientation = tiffOrientation(payload)
+				return false
+			}
+
+			return true
+		})
+	}
+
+	return orientation
+}
+
+// tiffOrientation reads the orientation tag from IFD0 of a TIFF structure
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	var offset = int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+
+	var count = int(order.Uint16(tiff[offset : offset+2]))
+	for i := 0; i < count; i++ {
+		var entry = offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+
+		if order.Uint16(tiff[entry:entry+2]) == exifOrientationTag {
+			var value = int(order.Uint16(tiff[entry+8 : entry+10]))
+			if value < 1 || value > 8 {
+				return 1
+			}
+
+			return value
+		}
+	}
+
+	return 1
+}
+
+// minimalTIFF builds a big endian TIFF structure holding only the orientation
+func minimalTIFF(orientation int) []byte {
+	var tiff = make([]byte, 26)
+	copy(tiff, "MM\x00\x2a")
+	binary.BigEndian.PutUint32(tiff[4:], 8)                   // IFD0 offset
+	binary.BigEndian.PutUint16(tiff[8:], 1)                   // entry count
+	binary.BigEndian.PutUint16(tiff[10:], exifOrientationTag) // tag
+	binary.BigEndian.PutUint16(tiff[12:], 3)                  // type SHORT
+	binary.BigEndian.PutUint32(tiff[14:], 1)                  // value count
+	binary.BigEndian.PutUint16(tiff[18:], uint16(orientation))
+	// the next IFD offset (bytes 22-26) is left at 0
+	return tiff
+}
+
+// stripImageMetadata removes EXIF and XMP metadata from a JPEG or PNG image.
+// The orientation, if any, is kept in a minimal EXIF block so the image is
+// still displayed (and processed) the right way up. Other formats are
+// returned as is.
+func stripImageMetadata(data []byte) []byte {
+	var orientation = exifOrientation(data)
+	if bytes.HasPrefix(data, jpegSOI) {
+		return stripJPEGMetadata(data, orientation)
+	}
+
+	if bytes.HasPrefix(data, pngSignature) {
+		return stripPNGMetadata(data, orientation)
+	}
+
+	return data
+}
+
+func stripJPEGMetadata(data []byte, orientation int) []byte {
+	var out bytes.Buffer
+	out.Write(jpegSOI)
+	var writeEXIF = func() {
+		if orientation != 1 {
+			writeJPEGSegment(&out, 0xE1, append(append([]byte{}, exifHeader...), minimalTIFF(orientation)...))
+			orientation = 1
+		}
+	}
+
+	var rest = walkJPEGSegments(data, func(marker byte, payload []byte) bool {
+		// a JFIF APP0 segment must come first
+		if marker != 0xE0 {
+			writeEXIF()
+		}
+
+		switch marker {
+		case 0xE1, 0xED, 0xFE: // APP1 (EXIF, XMP), APP13 (IPTC), comments
+		default:
+			writeJPEGSegment(&out, marker, payload)
+		}
+
+		return true
+	})
+
+	writeEXIF()
+	out.Write(rest)
+	return out.Bytes()
+}
+
+func writeJPEGSegment(out *bytes.Buffer, marker byte, payload []byte) {
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(payload)+2))
+	out.Write([]byte{0xFF, marker})
+	out.Write(length[:])
+	out.Write(payload)
+}
+
+// walkJPEGSegments calls fn for every marker segment before the image data,
+// until fn returns false. It returns the remaining bytes, starting at the
+// start of scan marker.
+func walkJPEGSegments(data []byte, fn func(marker byte, payload []byte) bool) []byte {
+	var pos = len(jpegSOI)
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			break
+		}
+
+		var marker = data[pos+1]
+		if marker == 0xDA || marker == 0xD9 { // start of scan, end of image
+			break
+		}
+
+		var length = int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		if length < 2 || pos+2+length > len(data) {
+			break
+		}
+
+		if !fn(marker, data[pos+4:pos+2+length]) {
+			break
+		}
+
+		pos += 2 + length
+	}
+
+	return data[pos:]
+}
+
+func stripPNGMetadata(data []byte, orientation int) []byte {
+	var out bytes.Buffer
+	out.Write(pngSignature)
+	var rest = walkPNGChunks(data, func(chunkType string, payload []byte) bool {
+		if chunkType == "IDAT" && orientation != 1 {
+			// eXIf must come before the image data
+			writePNGChunk(&out, "eXIf", minimalTIFF(orientation))
+			orientation = 1
+		}
+
+		if chunkType != "eXIf" && !isXMPChunk(chunkType, payload) {
+			writePNGChunk(&out, chunkType, payload)
+		}
+
+		return true
+	})
+
+	out.Write(rest)
+	return out.Bytes()
+}
+
+func isXMPChunk(chunkType string, payload []byte) bool {
+	return chunkType == "iTXt" && bytes.HasPrefix(payload, []byte("XML:com.adobe.xmp\x00"))
+}
+
+func writePNGChunk(out *bytes.Buffer, chunkType string, payload []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	copy(header[4:], chunkType)
+	var crc = crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(payload)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	out.Write(header[:])
+	out.Write(payload)
+	out.Write(sum[:])
+}
+
+// walkPNGChunks calls fn for every chunk until fn returns false, and returns
+// whatever could not be parsed as a chunk
+func walkPNGChunks(data []byte, fn func(chunkType string, payload []byte) bool) []byte {
+	var pos = len(pngSignature)
+	for pos+12 <= len(data) {
+		var length = int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		if length < 0 || pos+12+length > len(data) {
+			break
+		}
+
+		if !fn(string(data[pos+4:pos+8]), data[pos+8:pos+8+length]) {
+			break
+		}
+
+		pos += 12 + length
+	}
+
+	return data[pos:]
+}
+
+// orientedSize returns the size of an image of size width x height once the
+// orientation is applied
+func orientedSize(width int, height int, orientation int) (int, int) {
+	if orientation >= 5 {
+		return height, width
+	}
+
+	return width, height
+}
+
+// orientedSource maps a pixel (x, y) of the oriented image back to the source
+// image of size width x height
+func orientedSource(x int, y int, width int, height int, orientation int) (int, int) {
+	switch orientation {
+	case 2:
+		return width - 1 - x, y
+	case 3:
+		return width - 1 - x, height - 1 - y
+	case 4:
+		return x, height - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return y, height - 1 - x
+	case 7:
+		return width - 1 - y, height - 1 - x
+	case 8:
+		return width - 1 - y, x
+	}
+
+	return x, y
+}
+
+// orientImage applies an EXIF orientation to img, and returns the name of the
+// applied transform ("" for none)
+func orientImage(img image.Image, orientation int) (image.Image, string) {
+	var transform, ok = orientationTransforms[orientation]
+	if !ok {
+		return img, ""
+	}
+
+	var src = toRGBA(img)
+	var width, height = src.Rect.Dx(), src.Rect.Dy()
+	var dstWidth, dstHeight = orientedSize(width, height, orientation)
+	var dst = image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			var srcX, srcY = orientedSource(x, y, width, height, orientation)
+			var srcIndex = src.PixOffset(srcX, srcY)
+			var dstIndex = dst.PixOffset(x, y)
+			copy(dst.Pix[dstIndex:dstIndex+4], src.Pix[srcIndex:srcIndex+4])
+		}
+	}
+
+	return dst, transform
+}
+
+// decodeImage decodes a JPEG, PNG or GIF image and applies its EXIF
+// orientation. It returns errImageTooLarge, without decoding the image, if
+// it has more than maxImagePixels pixels.
+func decodeImage(data []byte) (image.Image, string, error) {
+	var config, _, err = image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return nil, "", errImageTooLarge
+	}
+
+	var img image.Image
+	img, _, err = image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var oriented, transform = orientImage(img, exifOrientation(data))
+	return oriented, transform, nil
+}
@@ -0,0 +1,354 @@
+// Fault injection, to test clients' retry and timeout logic: latency, error
+// responses, result codes, connection resets and slow bodies, per route.
+// Always on in the mock server, and in the server when ROC_FACE_DEBUG is
+// set. Faults are configured with a JSON file (ROC_FACE_FAULTS, see
+// faultConfig) or at runtime with GET / PUT / DELETE /admin/faults.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+)
+
+const faultsAdminPath = "/admin/faults"
+
+// faults for routes without their own entry
+const faultsDefaultRoute = "*"
+
+const faultMessage = "injected fault"
+
+// slow bodies are written in chunks of this size
+const slowBodyChunkSize = 64
+
+// faultConfig is the configuration format, for example:
+//
+//	{
+//		"seed": 1,
+//		"routes": {
+//			"/verify": {
+//				"latency": {"distribution": "normal", "meanMs": 300, "stddevMs": 100},
+//				"codeRate": 0.1,
+//				"code": "FaceNotDetected"
+//			},
+//			"*": {"errorRate": 0.05, "errorStatus": 503, "resetRate": 0.01}
+//		}
+//	}
+type faultConfig struct {
+	Seed   int64                  `json:"seed"`
+	Routes map[string]routeFaults `json:"routes"`
+}
+
+type routeFaults struct {
+	Latency *latencyFaults `json:"latency,omitempty"`
+
+	// share of requests answered with ErrorStatus (500 by default), an HTTP
+	// status between 100 and 599
+	ErrorRate   float64 `json:"errorRate,omitempty"`
+	ErrorStatus int     `json:"errorStatus,omitempty"`
+
+	// share of requests answered with a result carrying Code, e.g.
+	// FaceNotDetected, in the route's response schema
+	CodeRate float64 `json:"codeRate,omitempty"`
+	Code     string  `json:"code,omitempty"`
+
+	// share of requests whose connection is reset without a response
+	ResetRate float64 `json:"resetRate,omitempty"`
+
+	// share of requests whose response body is written at
+	// SlowBodyBytesPerSecond
+	SlowBodyRate           float64 `json:"slowBodyRate,omitempty"`
+	SlowBodyBytesPerSecond int     `json:"slowBodyBytesPerSecond,omitempty"`
+}
+
+// latencyFaults is a delay added before handling the request: fixed (MeanMs),
+// uniform (MinMs to MaxMs), normal (MeanMs, StddevMs) or exponential (MeanMs)
+type latencyFaults struct {
+	Distribution string  `json:"distribution"`
+	MinMs        float64 `json:"minMs,omitempty"`
+	MaxMs        float64 `json:"maxMs,omitempty"`
+	MeanMs       float64 `json:"meanMs,omitempty"`
+	StddevMs     float64 `json:"stddevMs,omitempty"`
+}
+
+// faultDraw tells which faults were drawn for a request
+type faultDraw struct {
+	Delay time.Duration
+	Reset bool
+	Fail  bool
+	Code  bool
+	Slow  bool
+}
+
+// faultInjector holds the current configuration, shared by all requests
+type faultInjector struct {
+	mutex  sync.Mutex
+	config faultConfig
+	random *rand.Rand
+}
+
+// enableFaultInjection adds the fault middleware and the admin endpoint to
+// the router, starting with the configuration in ROC_FACE_FAULTS if set
+func enableFaultInjection(r *mux.Router) error {
+	var injector = &faultInjector{}
+	var config faultConfig
+	if path := os.Getenv("ROC_FACE_FAULTS"); path != "" {
+		log.Println("loading faults from", path)
+		var data, err = ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if err = json.Unmarshal(data, &config); err != nil {
+			return fmt.Errorf("invalid faults file %s: %s", path, err.Error())
+		}
+	}
+
+	if err := injector.configure(config); err != nil {
+		return err
+	}
+
+	r.HandleFunc(faultsAdminPath, injector.adminHandler).Methods("GET", "PUT", "DELETE")
+	r.Use(injector.middleware)
+	return nil
+}
+
+func (config faultConfig) validate() error {
+	for route, faults := range config.Routes {
+		var rates = []float64{faults.ErrorRate, faults.CodeRate, faults.ResetRate, faults.SlowBodyRate}
+		for _, rate := range rates {
+			if rate < 0 || rate > 1 {
+				return fmt.Errorf("%s: expected rates between 0 and 1", route)
+			}
+		}
+
+		if faults.CodeRate > 0 && faults.Code == "" {
+			return fmt.Errorf("%s: codeRate is set without a code", route)
+		}
+
+		if faults.ErrorStatus != 0 && (faults.ErrorStatus < 100 || faults.ErrorStatus > 599) {
+			return fmt.Errorf("%s: expected errorStatus between 100 and 599", route)
+		}
+
+		if faults.SlowBodyRate > 0 && faults.SlowBodyBytesPerSecond <= 0 {
+			return fmt.Errorf("%s: slowBodyRate is set without slowBodyBytesPerSecond", route)
+		}
+
+		if faults.Latency != nil {
+			switch faults.Latency.Distribution {
+			case "fixed", "uniform", "normal", "exponential":
+			default:
+				return fmt.Errorf("%s: unsupported latency distribution %q, expected one of: fixed, uniform, normal, exponential", route, faults.Latency.Distribution)
+			}
+
+			if faults.Latency.Distribution == "uniform" && faults.Latency.MinMs > faults.Latency.MaxMs {
+				return fmt.Errorf("%s: expected minMs <= maxMs", route)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (injector *faultInjector) configure(config faultConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+	injector.config = config
+	injector.random = rand.New(rand.NewSource(config.Seed))
+	return nil
+}
+
+func (injector *faultInjector) adminHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println(r.Method, faultsAdminPath)
+	switch r.Method {
+	case "PUT":
+		var config faultConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			sendError(w, err)
+			return
+		}
+
+		if err := injector.configure(config); err != nil {
+			sendError(w, err)
+			return
+		}
+	case "DELETE":
+		injector.configure(faultConfig{})
+	}
+
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(injector.config)
+}
+
+// plan draws the faults to inject in one request
+func (injector *faultInjector) plan(path string) (routeFaults, faultDraw) {
+	injector.mutex.Lock()
+	defer injector.mutex.Unlock()
+	var faults, ok = injector.config.Routes[path]
+	if !ok {
+		faults = injector.config.Routes[faultsDefaultRoute]
+	}
+
+	var draw faultDraw
+	if faults.Latency != nil {
+		draw.Delay = faults.Latency.draw(injector.random)
+	}
+
+	draw.Reset = injector.random.Float64() < faults.ResetRate
+	draw.Fail = injector.random.Float64() < faults.ErrorRate
+	draw.Code = injector.random.Float64() < faults.CodeRate
+	draw.Slow = injector.random.Float64() < faults.SlowBodyRate
+	return faults, draw
+}
+
+func (latency latencyFaults) draw(random *rand.Rand) time.Duration {
+	var ms float64
+	switch latency.Distribution {
+	case "fixed":
+		ms = latency.MeanMs
+	case "uniform":
+		ms = latency.MinMs + (latency.MaxMs-latency.MinMs)*random.Float64()
+	case "normal":
+		ms = latency.MeanMs + latency.StddevMs*random.NormFloat64()
+	case "exponential":
+		ms = latency.MeanMs * random.ExpFloat64()
+	}
+
+	if ms < 0 {
+		ms = 0
+	}
+
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+func (injector *faultInjector) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var faults, draw = injector.plan(r.URL.Path)
+		if draw.Delay > 0 {
+			log.Println("fault: delaying", r.URL.Path, "by", draw.Delay)
+			time.Sleep(draw.Delay)
+		}
+
+		if draw.Reset {
+			log.Println("fault: resetting connection of", r.URL.Path)
+			resetConnection(w)
+			return
+		}
+
+		if draw.Slow {
+			log.Println("fault: slow body for", r.URL.Path)
+			w = &slowResponseWriter{ResponseWriter: w, bytesPerSecond: faults.SlowBodyBytesPerSecond}
+		}
+
+		if draw.Fail {
+			var status = faults.ErrorStatus
+			if status == 0 {
+				status = http.StatusInternalServerError
+			}
+
+			log.Println("fault: responding to", r.URL.Path, "with status", status)
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(errorResponseObj{Message: faultMessage})
+			return
+		}
+
+		if draw.Code {
+			log.Println("fault: responding to", r.URL.Path, "with code", faults.Code)
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(faultResult(r.URL.Path, faults.Code))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// faultResult is a failed result with the given code, in the response
+// schema of the route
+func faultResult(path string, code string) interface{} {
+	switch path {
+	case "/verify":
+		return verificationResult{Similarity: InvalidSimilarity, Code: code, Message: faultMessage}
+	case "/analyze":
+		return analysisResult{Code: code, Message: faultMessage}
+	case "/crop":
+		return cropResult{Code: code, Message: faultMessage}
+	case "/quality":
+		return qualityResult{Code: code, Message: faultMessage}
+	}
+
+	return errorResponseObj{Message: faultMessage}
+}
+
+// resetConnection closes the client connection with a TCP reset, without
+// sending a response
+func resetConnection(w http.ResponseWriter) {
+	var hijacker, ok = w.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+
+	var conn, _, err = hijacker.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+
+	conn.Close()
+}
+
+// slowResponseWriter writes the body in small chunks at a limited rate
+type slowResponseWriter struct {
+	http.ResponseWriter
+	bytesPerSecond int
+}
+
+func (w *slowResponseWriter) Write(data []byte) (int, error) {
+	var written = 0
+	for written < len(data) {
+		var end = written + slowBodyChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		var n, err = w.ResponseWriter.Write(data[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+
+		if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+			flusher.Flush()
+		}
+
+		time.Sleep(time.Duration(n) * time.Second / time.Duration(w.bytesPerSecond))
+	}
+
+	return written, nil
+}
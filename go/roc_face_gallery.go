@@ -0,0 +1,266 @@
+// In-memory galleries of enrolled subjects, searched by comparing templates
+// with the face engine. Used by the gRPC Enroll and Search calls. With
+// ROC_FACE_GALLERIES_FILE set, galleries are saved to that file, encrypted
+// like the rest of the stored data, and read back when the server restarts.
+// Enrollments and removals are saved at once, the time templates last
+// matched in a search only every minute.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultGallery = "default"
+const defaultSearchResults = 10
+
+// how often the galleries are saved when only search matches changed them
+const galleryFlushInterval = time.Minute
+
+// galleryEntry is one enrolled template of a subject
+type galleryEntry struct {
+	SubjectID     string
+	Template      []byte
+	EnrolledAt    time.Time
+	LastMatchedAt time.Time
+}
+
+type galleryMatch struct {
+	SubjectID  string  `json:"subjectId"`
+	Similarity float32 `json:"similarity"`
+}
+
+// galleryStore holds the galleries by name, saved to path if set. version
+// counts the changes to the galleries, saved being the last one written.
+type galleryStore struct {
+	mutex     sync.Mutex
+	galleries map[string][]*galleryEntry
+	path      string
+	version   int64
+	saved     int64
+
+	// held while writing the file, so the mutex isn't
+	writeMutex sync.Mutex
+}
+
+var galleries = &galleryStore{galleries: map[string][]*galleryEntry{}}
+
+// enableGalleryStorage reads the galleries saved in ROC_FACE_GALLERIES_FILE,
+// and saves them there from now on
+func enableGalleryStorage() error {
+	var path = os.Getenv("ROC_FACE_GALLERIES_FILE")
+	if path == "" {
+		return nil
+	}
+
+	galleries.mutex.Lock()
+	defer galleries.mutex.Unlock()
+	galleries.path = path
+	go galleries.flush()
+	var data, err = readStoredFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, &galleries.galleries)
+	if err != nil {
+		return fmt.Errorf("invalid galleries file %s: %s", path, err.Error())
+	}
+
+	log.Println("loaded", len(galleries.galleries), "galleries from", path)
+	return nil
+}
+
+// flush saves the search matches every galleryFlushInterval, and retries
+// failed saves
+func (store *galleryStore) flush() {
+	for range time.Tick(galleryFlushInterval) {
+		var err = store.save()
+		if err != nil {
+			log.Println("failed to save galleries, error:", err.Error())
+		}
+	}
+}
+
+// save stores the galleries if they changed since they were last saved. The
+// galleries are encoded with the mutex held, and written without it.
+func (store *galleryStore) save() error {
+	store.mutex.Lock()
+	if store.path == "" || store.version == store.saved {
+		store.mutex.Unlock()
+		return nil
+	}
+
+	var version = store.version
+	var data, err = json.Marshal(store.galleries)
+	store.mutex.Unlock()
+
+	store.writeMutex.Lock()
+	defer store.writeMutex.Unlock()
+	store.mutex.Lock()
+	var stale = version <= store.saved
+	store.mutex.Unlock()
+	if stale {
+		// a later version was written meanwhile
+		return nil
+	}
+
+	if err == nil {
+		err = writeStoredFile(store.path, data)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to save galleries: %s", err.Error())
+	}
+
+	store.mutex.Lock()
+	store.saved = version
+	store.mutex.Unlock()
+	return nil
+}
+
+// enroll adds a template for the subject, creating the gallery if needed, and
+// returns the number of templates the subject has in the gallery. If the
+// galleries can't be saved, the template is removed again.
+func (store *galleryStore) enroll(gallery string, subjectID string, template []byte) (int, error) {
+	if subjectID == "" {
+		return 0, fmt.Errorf("expected a subject ID")
+	}
+
+	if len(template) == 0 {
+		return 0, fmt.Errorf("expected a template")
+	}
+
+	store.mutex.Lock()
+	var enrolled = &galleryEntry{
+		SubjectID:  subjectID,
+		Template:   template,
+		EnrolledAt: time.Now(),
+	}
+
+	store.galleries[gallery] = append(store.galleries[gallery], enrolled)
+	store.version++
+	var count = 0
+	for _, entry := range store.galleries[gallery] {
+		if entry.SubjectID == subjectID {
+			count++
+		}
+	}
+
+	store.mutex.Unlock()
+	var err = store.save()
+	if err != nil {
+		store.remove(gallery, enrolled)
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// remove takes an entry out of the gallery
+func (store *galleryStore) remove(gallery string, removed *galleryEntry) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	var entries = store.galleries[gallery]
+	for i, entry := range entries {
+		if entry == removed {
+			store.galleries[gallery] = append(entries[:i], entries[i+1:]...)
+			store.version++
+			return
+		}
+	}
+}
+
+// search compares the probe with every template in the gallery, and returns
+// up to maxResults subjects with a similarity of at least threshold, best
+// first. A subject's similarity is that of its closest template.
+func (store *galleryStore) search(gallery string, probe []byte, maxResults int, threshold float32) ([]galleryMatch, error) {
+	if maxResults < 1 {
+		return nil, fmt.Errorf("invalid maxResults %d, expected at least 1", maxResults)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	var entries, ok = store.galleries[gallery]
+	if !ok {
+		return nil, fmt.Errorf("gallery %q not found", gallery)
+	}
+
+	var best = map[string]float32{}
+	var bestEntries = map[string]*galleryEntry{}
+	for _, entry := range entries {
+		var similarity = engine.compareTemplates(probe, entry.Template)
+		if current, ok := best[entry.SubjectID]; !ok || similarity > current {
+			best[entry.SubjectID] = similarity
+			bestEntries[entry.SubjectID] = entry
+		}
+	}
+
+	var matches []galleryMatch
+	for subjectID, similarity := range best {
+		if similarity >= threshold {
+			matches = append(matches, galleryMatch{SubjectID: subjectID, Similarity: similarity})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Similarity == matches[j].Similarity {
+			return matches[i].SubjectID < matches[j].SubjectID
+		}
+
+		return matches[i].Similarity > matches[j].Similarity
+	})
+
+	if len(matches) > maxResults {
+		matches = matches[:maxResults]
+	}
+
+	// saved by flush
+	var now = time.Now()
+	for _, match := range matches {
+		bestEntries[match.SubjectID].LastMatchedAt = now
+	}
+
+	if len(matches) > 0 {
+		store.version++
+	}
+
+	return matches, nil
+}
+
+// erase removes the subject's templates from every gallery, and returns the
+// removed ones by gallery. The error is that of saving the galleries: the
+// templates are then only removed from memory until a later save succeeds.
+func (store *galleryStore) erase(subjectID string) (map[string][]*galleryEntry, error) {
+	store.mutex.Lock()
+	var removed = map[string][]*galleryEntry{}
+	for gallery, entries := range store.galleries {
+		var kept = entries[:0]
+		for _, entry := range entries {
+			if entry.SubjectID == subjectID {
+				removed[gallery] = append(removed[gallery], entry)
+			} else {
+				kept = append(kept, entry)
+			}
+		}
+
+		store.galleries[gallery] = kept
+	}
+
+	if len(removed) > 0 {
+		store.version++
+	}
+
+	store.mutex.Unlock()
+	return removed, store.save()
+}
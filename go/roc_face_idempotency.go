@@ -0,0 +1,237 @@
+// Idempotent retries: a request sent with an Idempotency-Key header is
+// handled once. Retries with the same key, e.g. after a connection reset or
+// a 5xx status, get the first response again, marked with an
+// Idempotent-Replayed header, instead of running the request again, and
+// auditing or signing it twice. A retry arriving while the first request is
+// still running waits for its response. A retry must be the same request as
+// the first one: same query, body and caller, it's rejected with a 422
+// status otherwise. 5xx responses mean the request may be handled if tried
+// again, so they aren't kept. Responses are kept for idempotencyTTL, at most
+// maxIdempotentResponses of them and maxIdempotentBytes of bodies.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+const idempotencyTTL = 10 * time.Minute
+const maxIdempotentResponses = 1000
+const maxIdempotentBytes = 64 << 20
+
+// idempotentResponse is the response to the first request with a key, done
+// being closed once it's complete, and kept if it can be replayed. The
+// fingerprint of the request is set when it's complete.
+type idempotentResponse struct {
+	key         string
+	fingerprint string
+	done        chan struct{}
+	kept        bool
+	status      int
+	header      http.Header
+	body        bytes.Buffer
+	expiresAt   time.Time
+}
+
+// hashingBody hashes a request body as it's read
+type hashingBody struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+type idempotentResponses struct {
+	mutex     sync.Mutex
+	responses map[string]*idempotentResponse
+
+	// responses from oldest to newest, and the size of their bodies
+	order []*idempotentResponse
+	bytes int
+}
+
+// enableIdempotentRetries adds the middleware to the router. Add it after
+// fault injection, whose faults are then never replayed.
+func enableIdempotentRetries(r *mux.Router) {
+	var responses = &idempotentResponses{responses: map[string]*idempotentResponse{}}
+	r.Use(responses.middleware)
+}
+
+func (responses *idempotentResponses) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(idempotencyKeyHeader) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// keys are only unique to a client, the route tells requests apart
+		var key = r.Method + " " + r.URL.Path + " " + r.Header.Get(idempotencyKeyHeader)
+		var body = &hashingBody{ReadCloser: r.Body, hash: sha256.New()}
+		r.Body = body
+		var response, first = responses.start(key)
+		if !first {
+			var fingerprint = requestFingerprint(r, body)
+			select {
+			case <-response.done:
+			case <-r.Context().Done():
+				return
+			}
+
+			if fingerprint != response.fingerprint {
+				log.Println("rejecting an idempotent retry that isn't the same request")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(errorResponseObj{
+					Message: fmt.Sprintf("%s was used by a different request", idempotencyKeyHeader),
+				})
+
+				return
+			}
+
+			response.replay(w)
+			return
+		}
+
+		var handled = false
+		defer func() {
+			// if the handler panicked, a retry runs it again
+			responses.finish(response, requestFingerprint(r, body), handled)
+		}()
+
+		next.ServeHTTP(&recordingWriter{ResponseWriter: w, response: response}, r)
+		handled = true
+	})
+}
+
+// requestFingerprint identifies what a request asks for: its query, its
+// body, which it reads to the end, and its caller
+func requestFingerprint(r *http.Request, body *hashingBody) string {
+	io.Copy(ioutil.Discard, body)
+	var fingerprint = sha256.New()
+	fmt.Fprintf(fingerprint, "%s\n%s\n%x", r.URL.RawQuery, requestAPIKey(r), body.hash.Sum(nil))
+	return hex.EncodeToString(fingerprint.Sum(nil))
+}
+
+func (body *hashingBody) Read(data []byte) (int, error) {
+	var n, err = body.ReadCloser.Read(data)
+	body.hash.Write(data[:n])
+	return n, err
+}
+
+// start returns the response of the key, and whether this is its first
+// request
+func (responses *idempotentResponses) start(key string) (*idempotentResponse, bool) {
+	responses.mutex.Lock()
+	defer responses.mutex.Unlock()
+	var response, ok = responses.responses[key]
+	if ok && (response.expiresAt.IsZero() || response.expiresAt.After(time.Now())) {
+		return response, false
+	}
+
+	response = &idempotentResponse{key: key, done: make(chan struct{}), header: http.Header{}}
+	responses.responses[key] = response
+	responses.order = append(responses.order, response)
+	return response, true
+}
+
+// finish completes the response, and drops the complete ones that expired,
+// then the oldest complete ones beyond the limits
+func (responses *idempotentResponses) finish(response *idempotentResponse, fingerprint string, handled bool) {
+	responses.mutex.Lock()
+	defer responses.mutex.Unlock()
+	if response.status == 0 {
+		response.status = http.StatusOK
+	}
+
+	var now = time.Now()
+	response.fingerprint = fingerprint
+	response.kept = handled && response.status < 500
+	response.expiresAt = now
+	if response.kept {
+		response.expiresAt = now.Add(idempotencyTTL)
+	}
+
+	responses.bytes += response.body.Len()
+	close(response.done)
+	var count = len(responses.order)
+	var order = responses.order[:0]
+	for _, oldest := range responses.order {
+		var full = count > maxIdempotentResponses || responses.bytes > maxIdempotentBytes
+		if oldest.expiresAt.IsZero() || (oldest.expiresAt.After(now) && !full) {
+			// running, or kept
+			order = append(order, oldest)
+			continue
+		}
+
+		if responses.responses[oldest.key] == oldest {
+			delete(responses.responses, oldest.key)
+		}
+
+		responses.bytes -= oldest.body.Len()
+		count--
+	}
+
+	for i := len(order); i < len(responses.order); i++ {
+		responses.order[i] = nil
+	}
+
+	responses.order = order
+}
+
+// replay writes the response again
+func (response *idempotentResponse) replay(w http.ResponseWriter) {
+	if !response.kept {
+		// not handled, or failed, the client retries later
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	log.Println("replaying the response to an idempotent retry")
+	for name, values := range response.header {
+		w.Header()[name] = values
+	}
+
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(response.status)
+	w.Write(response.body.Bytes())
+}
+
+// recordingWriter writes the response, and records it for replays
+type recordingWriter struct {
+	http.ResponseWriter
+	response *idempotentResponse
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.response.status == 0 {
+		w.response.status = status
+		for name, values := range w.ResponseWriter.Header() {
+			w.response.header[name] = append([]string(nil), values...)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	if w.response.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	w.response.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
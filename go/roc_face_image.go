@@ -0,0 +1,315 @@
+// Image helpers shared by the server commands. Nothing in here talks to the
+// SDK, it only works on Go images decoded from the uploaded files.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+
+	// register the gif decoder for image.Decode
+	_ "image/gif"
+)
+
+// color spaces images are handed to the SDK in
+const colorSpaceGray8 = "gray8"
+const colorSpaceBGR24 = "bgr24"
+
+// server default color space, configured with the ROC_FACE_COLOR_SPACE
+// environment variable (see loadDefaultColorSpace)
+var defaultColorSpace = colorSpaceGray8
+
+const defaultCropPadding = 0.25
+const defaultCropFormat = "jpeg"
+
+// crops are at most 5 times the size of the face box
+const maxCropPadding = 2
+const cropJPEGQuality = 90
+
+// faceBox is a face bounding box in image pixel coordinates, (X, Y) being the
+// top left corner
+type faceBox struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+func (b faceBox) rect() image.Rectangle {
+	return image.Rect(b.X, b.Y, b.X+b.Width, b.Y+b.Height)
+}
+
+func (b faceBox) center() (float64, float64) {
+	return float64(b.X) + float64(b.Width)/2, float64(b.Y) + float64(b.Height)/2
+}
+
+// validateCropPadding rejects paddings the crops of which would be too large
+// to allocate
+func validateCropPadding(padding float64) error {
+	if !(padding >= 0 && padding <= maxCropPadding) {
+		return fmt.Errorf("invalid padding %v, expected 0 to %v", padding, maxCropPadding)
+	}
+
+	return nil
+}
+
+// pad grows the box by padding * width (or height) on each side
+func (b faceBox) pad(padding float64) faceBox {
+	var dx = int(math.Round(float64(b.Width) * padding))
+	var dy = int(math.Round(float64(b.Height) * padding))
+	return faceBox{
+		X:      b.X - dx,
+		Y:      b.Y - dy,
+		Width:  b.Width + 2*dx,
+		Height: b.Height + 2*dy,
+	}
+}
+
+// detectedFace is a face found by the SDK: its bounding box, the template
+// metadata (landmarks, pose, demographics, etc.), and the flattened template
+// and SDK thumbnail, if they were requested
+type detectedFace struct {
+	Box       faceBox
+	Metadata  map[string]interface{}
+	Template  []byte
+	Thumbnail []byte
+}
+
+// clone copies the face, so its metadata can be modified
+func (face detectedFace) clone() detectedFace {
+	var copied = face
+	copied.Metadata = map[string]interface{}{}
+	for key, value := range face.Metadata {
+		copied.Metadata[key] = value
+	}
+
+	return copied
+}
+
+type faceCrop struct {
+	Box     faceBox `json:"box"`
+	Format  string  `json:"format"`
+	Image   []byte  `json:"image"`
+	Aligned []byte  `json:"aligned,omitempty"`
+}
+
+// loadDefaultColorSpace sets the default color space from
+// ROC_FACE_COLOR_SPACE
+func loadDefaultColorSpace() error {
+	var colorSpace = os.Getenv("ROC_FACE_COLOR_SPACE")
+	if colorSpace == "" {
+		return nil
+	}
+
+	var err = validateColorSpace(colorSpace)
+	if err != nil {
+		return fmt.Errorf("invalid ROC_FACE_COLOR_SPACE: %s", err.Error())
+	}
+
+	defaultColorSpace = colorSpace
+	return nil
+}
+
+func validateColorSpace(colorSpace string) error {
+	if colorSpace != colorSpaceGray8 && colorSpace != colorSpaceBGR24 {
+		return fmt.Errorf("unsupported color space %q, expected one of: %s, %s", colorSpace, colorSpaceGray8, colorSpaceBGR24)
+	}
+
+	return nil
+}
+
+// packPixels packs the pixels of img in rows of step bytes, as the SDK reads
+// them: one luma byte per pixel in gray8, blue, green and red bytes in bgr24
+func packPixels(img image.Image, colorSpace string) ([]byte, int) {
+	var rgba = toRGBA(img)
+	var channels = 3
+	if colorSpace == colorSpaceGray8 {
+		channels = 1
+	}
+
+	var width, height = rgba.Rect.Dx(), rgba.Rect.Dy()
+	var step = channels * width
+	var pixels = make([]byte, step*height)
+	for i := 0; i < height; i++ {
+		for j := 0; j < width; j++ {
+			var srcIndex = i*rgba.Stride + 4*j
+			var dstIndex = i*step + channels*j
+			var r, g, b = rgba.Pix[srcIndex+0], rgba.Pix[srcIndex+1], rgba.Pix[srcIndex+2]
+			if channels == 1 {
+				pixels[dstIndex] = uint8((299*int(r) + 587*int(g) + 114*int(b)) / 1000)
+			} else {
+				pixels[dstIndex+0] = b
+				pixels[dstIndex+1] = g
+				pixels[dstIndex+2] = r
+			}
+		}
+	}
+
+	return pixels, step
+}
+
+// toRGBA returns img as an *image.RGBA with its origin at (0, 0), copying it
+// if needed
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == image.ZP {
+		return rgba
+	}
+
+	var bounds = img.Bounds()
+	var rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, bounds.Min, draw.Src)
+	return rgba
+}
+
+func validateImageFormat(format string) error {
+	if format != "jpeg" && format != "png" {
+		return fmt.Errorf("unsupported image format %q, expected one of: jpeg, png", format)
+	}
+
+	return nil
+}
+
+func encodeImage(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: cropJPEGQuality})
+	default:
+		err = validateImageFormat(format)
+	}
+
+	return buf.Bytes(), err
+}
+
+// landmark reads a landmark point (e.g. "RightEye" -> RightEyeX, RightEyeY)
+// from template metadata
+func landmark(metadata map[string]interface{}, name string) (float64, float64, bool) {
+	var x, okX = metadata[name+"X"].(float64)
+	var y, okY = metadata[name+"Y"].(float64)
+	return x, y, okX && okY
+}
+
+// cropFace copies the padded face box out of img. Parts of the box that fall
+// outside the image are left black.
+func cropFace(img image.Image, box faceBox, padding float64) *image.RGBA {
+	var padded = box.pad(padding)
+	var crop = image.NewRGBA(image.Rect(0, 0, padded.Width, padded.Height))
+	var bounds = img.Bounds()
+	for y := 0; y < padded.Height; y++ {
+		for x := 0; x < padded.Width; x++ {
+			var p = image.Pt(bounds.Min.X+padded.X+x, bounds.Min.Y+padded.Y+y)
+			if p.In(bounds) {
+				crop.Set(x, y, img.At(p.X, p.Y))
+			}
+		}
+	}
+
+	return crop
+}
+
+// alignFace produces a crop of the same size as cropFace, rotated around the
+// face center so that the eyes are level. It returns nil when the eye
+// landmarks are missing from the metadata.
+func alignFace(img image.Image, box faceBox, metadata map[string]interface{}, padding float64) *image.RGBA {
+	var rightX, rightY, okRight = landmark(metadata, "RightEye")
+	var leftX, leftY, okLeft = landmark(metadata, "LeftEye")
+	if !okRight || !okLeft {
+		return nil
+	}
+
+	// the subject's right eye is on the left side of the image
+	var angle = math.Atan2(leftY-rightY, leftX-rightX)
+	var sin, cos = math.Sincos(angle)
+	var centerX, centerY = box.center()
+	var padded = box.pad(padding)
+	var aligned = image.NewRGBA(image.Rect(0, 0, padded.Width, padded.Height))
+	var halfWidth = float64(padded.Width) / 2
+	var halfHeight = float64(padded.Height) / 2
+	for y := 0; y < padded.Height; y++ {
+		for x := 0; x < padded.Width; x++ {
+			var dx = float64(x) + 0.5 - halfWidth
+			var dy = float64(y) + 0.5 - halfHeight
+			var srcX = centerX + dx*cos - dy*sin
+			var srcY = centerY + dx*sin + dy*cos
+			aligned.SetRGBA(x, y, sampleBilinear(img, srcX, srcY))
+		}
+	}
+
+	return aligned
+}
+
+// sampleBilinear samples img at a fractional pixel position, (0.5, 0.5) being
+// the center of the top left pixel
+func sampleBilinear(img image.Image, x float64, y float64) color.RGBA {
+	var bounds = img.Bounds()
+	x -= 0.5
+	y -= 0.5
+	var x0 = int(math.Floor(x))
+	var y0 = int(math.Floor(y))
+	var fx = x - float64(x0)
+	var fy = y - float64(y0)
+
+	var sum [4]float64
+	var weights = [4]float64{(1 - fx) * (1 - fy), fx * (1 - fy), (1 - fx) * fy, fx * fy}
+	var points = [4]image.Point{{x0, y0}, {x0 + 1, y0}, {x0, y0 + 1}, {x0 + 1, y0 + 1}}
+	for i, p := range points {
+		p = p.Add(bounds.Min)
+		if !p.In(bounds) {
+			continue
+		}
+
+		var r, g, b, a = img.At(p.X, p.Y).RGBA()
+		sum[0] += weights[i] * float64(r>>8)
+		sum[1] += weights[i] * float64(g>>8)
+		sum[2] += weights[i] * float64(b>>8)
+		sum[3] += weights[i] * float64(a>>8)
+	}
+
+	return color.RGBA{
+		R: uint8(math.Round(sum[0])),
+		G: uint8(math.Round(sum[1])),
+		B: uint8(math.Round(sum[2])),
+		A: uint8(math.Round(sum[3])),
+	}
+}
+
+// cropFaces builds the crops (and aligned crops) for every detected face
+func cropFaces(img image.Image, faces []detectedFace, padding float64, format string, aligned bool) ([]faceCrop, error) {
+	var crops = make([]faceCrop, 0, len(faces))
+	for _, face := range faces {
+		var encoded, err = encodeImage(cropFace(img, face.Box, padding), format)
+		if err != nil {
+			return crops, err
+		}
+
+		var crop = faceCrop{
+			Box:    face.Box,
+			Format: format,
+			Image:  encoded,
+		}
+
+		if aligned {
+			var alignedImg = alignFace(img, face.Box, face.Metadata, padding)
+			if alignedImg != nil {
+				crop.Aligned, err = encodeImage(alignedImg, format)
+				if err != nil {
+					return crops, err
+				}
+			}
+		}
+
+		crops = append(crops, crop)
+	}
+
+	return crops, nil
+}
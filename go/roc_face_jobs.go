@@ -0,0 +1,1108 @@
+// Asynchronous jobs, for work that takes longer than an HTTP timeout:
+//
+//	POST   /jobs?type=analyze|dedupe  upload images, returns the job
+//	GET    /jobs/{id}                 status, progress and result
+//	DELETE /jobs/{id}                 cancels the job, or deletes its result
+//
+// Jobs run on their own worker pool (ROC_FACE_JOB_WORKERS), so they can't
+// starve interactive requests. A job and its images are stored under
+// ROC_FACE_JOBS_DIR, and unfinished jobs are restarted when the server is.
+// Finished jobs expire after ROC_FACE_JOB_TTL. The templates of the faces in
+// each image are kept with the result, never returned, so the results of a
+// subject can be erased (see roc_face_retention.go), analyze jobs computing
+// them even without the recognition attribute, as their thumbnails and crops
+// are of the subject's face too. Subjects erased while jobs are queued or
+// running are kept in memory, and erased from those jobs once they finish.
+// Only the IDs of those jobs are stored, in ROC_FACE_JOBS_DIR/erasures.json,
+// deleted once they all finished: the server restarted, they fail rather
+// than run again, as their results couldn't be erased.
+//
+// Videos aren't a job type, the engine doesn't decode them: send their
+// frames as an analyze job, or stream them to the gRPC AnalyzeVideo.
+//
+// The decisions of a job are recorded in the audit log once it finishes, as
+// if its images had been sent to /analyze, or for a dedupe job, one entry
+// with every image and one for each pair of duplicates. A job whose
+// decisions can't be recorded fails.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+)
+
+const defaultJobsDir = "/tmp/roc-face-jobs"
+const jobErasuresFile = "erasures.json"
+const defaultJobWorkers = 2
+const defaultJobTTL = 24 * time.Hour
+
+// jobs waiting for a worker, more are rejected with a 503
+const jobQueueSize = 100
+
+// how often expired jobs are deleted
+const jobSweepInterval = time.Minute
+
+const jobTypeAnalyze = "analyze"
+const jobTypeDedupe = "dedupe"
+
+const jobStatusQueued = "queued"
+const jobStatusRunning = "running"
+const jobStatusSucceeded = "succeeded"
+const jobStatusFailed = "failed"
+const jobStatusCanceled = "canceled"
+
+// similarity from which two images of a dedupe job are duplicates
+const defaultDedupeThreshold = 0.7
+
+type job struct {
+	ID       string  `json:"id"`
+	Type     string  `json:"type"`
+	Status   string  `json:"status"`
+	Progress float64 `json:"progress"`
+
+	// names of the uploaded images, in upload order
+	Images []string `json:"images"`
+
+	// fingerprint of the API key the job was created with, for the audit log
+	APIKey string `json:"apiKey,omitempty"`
+
+	// options of the job type, e.g. analyzeOptions
+	Options json.RawMessage `json:"options"`
+
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+func (j *job) finished() bool {
+	return j.Status == jobStatusSucceeded || j.Status == jobStatusFailed || j.Status == jobStatusCanceled
+}
+
+// jobTask is what a job runner gets: the job's image files and options, and
+// a way to report progress
+type jobTask struct {
+	Images     []string
+	ImagePaths []string
+	Options    json.RawMessage
+	Progress   func(progress float64)
+
+	// the templates of the faces found in each image, set by the runner
+	Templates [][][]byte
+
+	// SHA-256 of each image, as in the audit log
+	ImageHashes []string
+}
+
+// jobType parses the options of a job from the POST /jobs request, runs the
+// job, lists the audit entries of its result, and redacts the erased images
+// from it
+type jobType struct {
+	options func(r *http.Request) (interface{}, error)
+	run     func(ctx context.Context, task jobTask) (interface{}, error)
+	audit   func(task jobTask, result interface{}) []auditEntry
+	redact  func(result json.RawMessage, images []string, erased []bool) (json.RawMessage, error)
+}
+
+var jobTypes = map[string]jobType{
+	jobTypeAnalyze: {
+		options: func(r *http.Request) (interface{}, error) {
+			return getAnalyzeOptions(r)
+		},
+		run:    runAnalyzeJob,
+		audit:  auditAnalyzeJob,
+		redact: redactAnalyzeJob,
+	},
+	jobTypeDedupe: {
+		options: func(r *http.Request) (interface{}, error) {
+			return getDedupeOptions(r)
+		},
+		run:    runDedupeJob,
+		audit:  auditDedupeJob,
+		redact: redactDedupeJob,
+	},
+}
+
+// jobManager holds the jobs, stored in dir/{id}/job.json, with the images in
+// dir/{id}/images and the templates of a finished job in
+// dir/{id}/templates.json
+type jobManager struct {
+	mutex     sync.Mutex
+	dir       string
+	ttl       time.Duration
+	jobs      map[string]*job
+	templates map[string][][][]byte
+	cancels   map[string]context.CancelFunc
+	queue     chan string
+
+	// subjects erased while jobs were queued or running, in memory only
+	erasures []*erasedSubject
+}
+
+// jobs is set by enableJobs
+var jobs *jobManager
+
+// enableJobs adds the /jobs routes to the router, and starts the workers on
+// the stored jobs
+func enableJobs(r *mux.Router) error {
+	var manager = &jobManager{
+		dir:       os.Getenv("ROC_FACE_JOBS_DIR"),
+		ttl:       defaultJobTTL,
+		jobs:      map[string]*job{},
+		templates: map[string][][][]byte{},
+		cancels:   map[string]context.CancelFunc{},
+		queue:     make(chan string, jobQueueSize),
+	}
+
+	if manager.dir == "" {
+		manager.dir = defaultJobsDir
+	}
+
+	var workers = defaultJobWorkers
+	var err error
+	if value := os.Getenv("ROC_FACE_JOB_WORKERS"); value != "" {
+		workers, err = strconv.Atoi(value)
+		if err != nil || workers < 1 {
+			return fmt.Errorf("invalid ROC_FACE_JOB_WORKERS %q, expected a positive number", value)
+		}
+	}
+
+	if value := os.Getenv("ROC_FACE_JOB_TTL"); value != "" {
+		manager.ttl, err = time.ParseDuration(value)
+		if err != nil || manager.ttl <= 0 {
+			return fmt.Errorf("invalid ROC_FACE_JOB_TTL %q, expected a duration, e.g. 24h", value)
+		}
+	}
+
+	err = manager.load()
+	if err != nil {
+		return err
+	}
+
+	log.Println("running", workers, "job workers, storing jobs in", manager.dir)
+	for i := 0; i < workers; i++ {
+		go manager.work()
+	}
+
+	go manager.sweep()
+
+	jobs = manager
+	r.HandleFunc("/jobs", manager.createHandler).Methods("POST")
+	r.HandleFunc("/jobs/{id}", manager.getHandler).Methods("GET")
+	r.HandleFunc("/jobs/{id}", manager.deleteHandler).Methods("DELETE")
+	return nil
+}
+
+// load reads the stored jobs, queueing the unfinished ones again
+func (manager *jobManager) load() error {
+	var err = os.MkdirAll(manager.dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	var entries []os.FileInfo
+	entries, err = ioutil.ReadDir(manager.dir)
+	if err != nil {
+		return err
+	}
+
+	var pending []*job
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		var data []byte
+		data, err = readStoredFile(filepath.Join(manager.dir, entry.Name(), "job.json"))
+		if err != nil {
+			log.Println("skipping job", entry.Name(), "error:", err.Error())
+			continue
+		}
+
+		var j job
+		err = json.Unmarshal(data, &j)
+		if err != nil {
+			log.Println("skipping job", entry.Name(), "error:", err.Error())
+			continue
+		}
+
+		manager.jobs[j.ID] = &j
+		data, err = readStoredFile(filepath.Join(manager.dir, entry.Name(), "templates.json"))
+		if err == nil {
+			var templates [][][]byte
+			err = json.Unmarshal(data, &templates)
+			manager.templates[j.ID] = templates
+		}
+
+		if err != nil && !os.IsNotExist(err) {
+			log.Println("failed to read the templates of job", entry.Name(), "error:", err.Error())
+		}
+
+		if !j.finished() {
+			// restart interrupted jobs from the beginning
+			j.Status = jobStatusQueued
+			j.Progress = 0
+			j.StartedAt = nil
+			pending = append(pending, &j)
+		}
+	}
+
+	var erasing map[string]bool
+	erasing, err = manager.loadErasures()
+	if err != nil {
+		return err
+	}
+
+	// a job that finished before its erasure did, as the server stopped, is
+	// deleted
+	for id := range erasing {
+		if j, ok := manager.jobs[id]; ok && j.finished() {
+			log.Println("deleting job", id, "a subject was erased from before a restart")
+			manager.remove(id)
+		}
+	}
+
+	var restarted = pending[:0]
+	for _, j := range pending {
+		if erasing[j.ID] {
+			manager.finish(j, nil, nil, fmt.Errorf("a subject was erased while the job was unfinished, and the server restarted: send it again"))
+		} else {
+			restarted = append(restarted, j)
+		}
+	}
+
+	pending = restarted
+
+	// the subjects are gone with the restart, there's nothing left to wait for
+	manager.saveErasures()
+
+	sort.Slice(pending, func(i, k int) bool {
+		return pending[i].CreatedAt.Before(pending[k].CreatedAt)
+	})
+
+	for _, j := range pending {
+		select {
+		case manager.queue <- j.ID:
+		default:
+			manager.finish(j, nil, nil, fmt.Errorf("the job queue was full after a restart"))
+		}
+	}
+
+	log.Println("loaded", len(manager.jobs), "jobs,", len(pending), "to run")
+	return nil
+}
+
+func (manager *jobManager) jobDir(id string) string {
+	return filepath.Join(manager.dir, id)
+}
+
+// save stores the job, the manager's mutex being held
+func (manager *jobManager) save(j *job) {
+	var data, err = json.Marshal(j)
+	if err == nil {
+		err = writeStoredFile(filepath.Join(manager.jobDir(j.ID), "job.json"), data)
+	}
+
+	if err != nil {
+		log.Println("failed to save job", j.ID, "error:", err.Error())
+	}
+}
+
+// loadErasures reads the IDs of the jobs that subjects erased before a
+// restart were waiting for
+func (manager *jobManager) loadErasures() (map[string]bool, error) {
+	var path = filepath.Join(manager.dir, jobErasuresFile)
+	var data, err = readStoredFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var ids []string
+	if err == nil {
+		err = json.Unmarshal(data, &ids)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid job erasures file: %s", err.Error())
+	}
+
+	var erasing = map[string]bool{}
+	for _, id := range ids {
+		erasing[id] = true
+	}
+
+	return erasing, nil
+}
+
+// saveErasures stores the IDs of the jobs erased subjects are waiting for,
+// but not the subjects, whose templates are kept in memory only. The file is
+// deleted when there are none. The manager's mutex is held.
+func (manager *jobManager) saveErasures() {
+	var path = filepath.Join(manager.dir, jobErasuresFile)
+	var ids = []string{}
+	for _, subject := range manager.erasures {
+		for _, id := range subject.Jobs {
+			if !containsString(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	if len(ids) == 0 {
+		var err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Println("failed to delete the job erasures, error:", err.Error())
+		}
+
+		return
+	}
+
+	sort.Strings(ids)
+	var data, err = json.Marshal(ids)
+	if err == nil {
+		err = writeStoredFile(path, data)
+	}
+
+	if err != nil {
+		log.Println("failed to save the job erasures, error:", err.Error())
+	}
+}
+
+// saveTemplates stores the templates of a job, the manager's mutex being held
+func (manager *jobManager) saveTemplates(id string) {
+	var data, err = json.Marshal(manager.templates[id])
+	if err == nil {
+		err = writeStoredFile(filepath.Join(manager.jobDir(id), "templates.json"), data)
+	}
+
+	if err != nil {
+		log.Println("failed to save the templates of job", id, "error:", err.Error())
+	}
+}
+
+func (manager *jobManager) createHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /jobs")
+	var typeName = getStringQueryParam(r, "type", "")
+	var jt, ok = jobTypes[typeName]
+	if !ok {
+		sendError(w, fmt.Errorf("unsupported job type %q, expected one of: %s, %s", typeName, jobTypeAnalyze, jobTypeDedupe))
+		return
+	}
+
+	var options, err = jt.options(r)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	var j = &job{
+		ID:        newID(),
+		Type:      typeName,
+		Status:    jobStatusQueued,
+		APIKey:    requestAPIKey(r),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	j.Options, err = json.Marshal(options)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	j.Images, err = manager.saveImages(j.ID, r)
+	if err != nil {
+		os.RemoveAll(manager.jobDir(j.ID))
+		sendError(w, err)
+		return
+	}
+
+	manager.mutex.Lock()
+	select {
+	case manager.queue <- j.ID:
+	default:
+		manager.mutex.Unlock()
+		os.RemoveAll(manager.jobDir(j.ID))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(errorResponseObj{Message: "too many queued jobs, try again later"})
+		return
+	}
+
+	manager.jobs[j.ID] = j
+	manager.save(j)
+	var response = *j
+	manager.mutex.Unlock()
+
+	log.Println("queued job", j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+// saveImages stores the uploaded images (every file in the form, by field
+// name and then upload order) in the job's directory, and returns their names
+func (manager *jobManager) saveImages(id string, r *http.Request) ([]string, error) {
+	var err = r.ParseMultipartForm(_128M)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for field := range r.MultipartForm.File {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+	var imagesDir = filepath.Join(manager.jobDir(id), "images")
+	err = os.MkdirAll(imagesDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, field := range fields {
+		for _, header := range r.MultipartForm.File[field] {
+			var file, err = header.Open()
+			if err != nil {
+				return nil, err
+			}
+
+			var data []byte
+			data, err = ioutil.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return nil, err
+			}
+
+			// EXIF metadata is never written to disk, as for other requests
+			var imagePath = filepath.Join(imagesDir, strconv.Itoa(len(names)))
+			err = writeStoredFile(imagePath, stripImageMetadata(data))
+			if err != nil {
+				return nil, err
+			}
+
+			names = append(names, header.Filename)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("expected at least one image")
+	}
+
+	return names, nil
+}
+
+func (manager *jobManager) getHandler(w http.ResponseWriter, r *http.Request) {
+	var id = mux.Vars(r)["id"]
+	log.Println("GET /jobs/" + id)
+	manager.mutex.Lock()
+	var j, ok = manager.jobs[id]
+	var response job
+	if ok {
+		response = *j
+	}
+
+	manager.mutex.Unlock()
+	if !ok {
+		sendNotFound(w, fmt.Sprintf("job %q not found", id))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// deleteHandler cancels an unfinished job, or deletes a finished one
+func (manager *jobManager) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	var id = mux.Vars(r)["id"]
+	log.Println("DELETE /jobs/" + id)
+	manager.mutex.Lock()
+	var j, ok = manager.jobs[id]
+	if !ok {
+		manager.mutex.Unlock()
+		sendNotFound(w, fmt.Sprintf("job %q not found", id))
+		return
+	}
+
+	if j.finished() {
+		manager.remove(id)
+	} else {
+		if cancel, running := manager.cancels[id]; running {
+			cancel()
+		}
+
+		manager.complete(j, jobStatusCanceled, nil, "")
+	}
+
+	var response = *j
+	manager.mutex.Unlock()
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// remove deletes a job and its files, the manager's mutex being held
+func (manager *jobManager) remove(id string) {
+	delete(manager.jobs, id)
+	delete(manager.templates, id)
+	var err = os.RemoveAll(manager.jobDir(id))
+	if err != nil {
+		log.Println("failed to delete job", id, "error:", err.Error())
+	}
+}
+
+// erase redacts the images with a face of the subject from the results of
+// finished jobs, and returns the IDs of the jobs redacted. The jobs still
+// queued or running are erased once they finish, their IDs are returned
+// second.
+func (manager *jobManager) erase(subject *erasedSubject) ([]string, []string) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	var ids = []string{}
+	subject.Jobs = nil
+	for id, j := range manager.jobs {
+		if !j.finished() {
+			subject.Jobs = append(subject.Jobs, id)
+		} else if manager.redact(j, subject.matches) {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Strings(ids)
+	sort.Strings(subject.Jobs)
+	var pending = append([]string{}, subject.Jobs...)
+	if len(pending) > 0 {
+		manager.erasures = append(manager.erasures, subject)
+		manager.saveErasures()
+	}
+
+	return ids, pending
+}
+
+// settleErasures erases a job that just finished from the results of the
+// subjects erased while it was queued or running, and forgets the subjects
+// no other job is waiting for, the manager's mutex being held
+func (manager *jobManager) settleErasures(j *job) {
+	var changed = false
+	var kept = manager.erasures[:0]
+	for _, subject := range manager.erasures {
+		if containsString(subject.Jobs, j.ID) {
+			changed = true
+			var waiting []string
+			for _, id := range subject.Jobs {
+				if id != j.ID {
+					waiting = append(waiting, id)
+				}
+			}
+
+			subject.Jobs = waiting
+			if manager.redact(j, subject.matches) {
+				log.Println("erased subject", subject.SubjectID, "from job", j.ID, "once it finished")
+
+				// recordAudit logs its errors, the job is erased either way
+				recordAudit(auditEntry{Route: "/subjects/{id}", Subjects: []string{subject.SubjectID}, Decision: auditDecisionErased},
+					map[string]string{"job": j.ID})
+			}
+		}
+
+		if len(subject.Jobs) > 0 {
+			kept = append(kept, subject)
+		}
+	}
+
+	manager.erasures = kept
+	if changed {
+		manager.saveErasures()
+	}
+}
+
+// redact removes the images with a face matching from the result of a
+// succeeded job, and returns whether there were any, the manager's mutex
+// being held
+func (manager *jobManager) redact(j *job, matches func(template []byte) bool) bool {
+	var templates = manager.templates[j.ID]
+	if j.Status != jobStatusSucceeded || len(templates) != len(j.Images) {
+		return false
+	}
+
+	var erased = make([]bool, len(j.Images))
+	var found = false
+	for i, faces := range templates {
+		for _, template := range faces {
+			if matches(template) {
+				erased[i] = true
+				found = true
+				break
+			}
+		}
+	}
+
+	if !found {
+		return false
+	}
+
+	var result, err = jobTypes[j.Type].redact(j.Result, j.Images, erased)
+	if err != nil {
+		log.Println("failed to erase from job", j.ID, "error:", err.Error())
+		return false
+	}
+
+	j.Result = result
+	for i := range erased {
+		if erased[i] {
+			j.Images[i] = erasedImageName
+			templates[i] = nil
+		}
+	}
+
+	manager.save(j)
+	manager.saveTemplates(j.ID)
+	if webhooks != nil {
+		webhooks.redactJob(j)
+	}
+
+	return true
+}
+
+func (manager *jobManager) work() {
+	for id := range manager.queue {
+		manager.run(id)
+	}
+}
+
+func (manager *jobManager) run(id string) {
+	manager.mutex.Lock()
+	var j, ok = manager.jobs[id]
+	if !ok || j.Status != jobStatusQueued {
+		// canceled or deleted while queued
+		manager.mutex.Unlock()
+		return
+	}
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	manager.cancels[id] = cancel
+	var now = time.Now().UTC()
+	j.Status = jobStatusRunning
+	j.StartedAt = &now
+	manager.save(j)
+	var task = jobTask{
+		Images:    j.Images,
+		Options:   j.Options,
+		Templates: make([][][]byte, len(j.Images)),
+		Progress: func(progress float64) {
+			manager.mutex.Lock()
+			defer manager.mutex.Unlock()
+			if j.Status == jobStatusRunning {
+				j.Progress = progress
+				manager.save(j)
+			}
+		},
+	}
+
+	var jt = jobTypes[j.Type]
+	var apiKey = j.APIKey
+	manager.mutex.Unlock()
+
+	// the engine reads plaintext files, stored images are decrypted to
+	// temporary ones for the time of the job
+	var tmpPaths []string
+	var err error
+	for i := range j.Images {
+		var storedPath = filepath.Join(manager.jobDir(id), "images", strconv.Itoa(i))
+		var imagePath string
+		imagePath, err = openStoredImage(storedPath)
+		if err != nil {
+			break
+		}
+
+		if imagePath != storedPath {
+			tmpPaths = append(tmpPaths, imagePath)
+		}
+
+		task.ImagePaths = append(task.ImagePaths, imagePath)
+	}
+
+	defer deleteFiles(tmpPaths)
+	if err == nil {
+		task.ImageHashes, err = hashFiles(task.ImagePaths)
+	}
+
+	if err != nil {
+		manager.finish(j, nil, nil, err)
+		return
+	}
+
+	log.Println("running job", id)
+	var result interface{}
+	result, err = jt.run(ctx, task)
+	if err == nil && ctx.Err() == nil {
+		err = auditJob(id, apiKey, jt.audit(task, result), task.Options)
+	}
+
+	manager.finish(j, result, task.Templates, err)
+}
+
+// auditJob records the decisions of a job, its params being the job's ID and
+// options
+func auditJob(id string, apiKey string, entries []auditEntry, options json.RawMessage) error {
+	var params = map[string]interface{}{"job": id, "options": options}
+	for _, entry := range entries {
+		entry.APIKey = apiKey
+		entry.Route = "/jobs"
+		var err = recordAudit(entry, params)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// finish records the outcome of a job and the templates of its faces, unless
+// it was canceled meanwhile
+func (manager *jobManager) finish(j *job, result interface{}, templates [][][]byte, err error) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	delete(manager.cancels, j.ID)
+	if j.finished() {
+		return
+	}
+
+	if err != nil {
+		log.Println("job", j.ID, "failed:", err.Error())
+		manager.complete(j, jobStatusFailed, nil, err.Error())
+		return
+	}
+
+	var data []byte
+	data, err = json.Marshal(result)
+	if err != nil {
+		manager.complete(j, jobStatusFailed, nil, err.Error())
+		return
+	}
+
+	log.Println("job", j.ID, "succeeded")
+	manager.templates[j.ID] = templates
+	manager.saveTemplates(j.ID)
+	manager.complete(j, jobStatusSucceeded, data, "")
+}
+
+// complete sets the final status of a job, the manager's mutex being held
+func (manager *jobManager) complete(j *job, status string, result json.RawMessage, message string) {
+	var now = time.Now().UTC()
+	var expiresAt = now.Add(manager.ttl)
+	j.Status = status
+	j.Result = result
+	j.Error = message
+	j.FinishedAt = &now
+	j.ExpiresAt = &expiresAt
+	if status == jobStatusSucceeded {
+		j.Progress = 1
+	}
+
+	manager.save(j)
+	manager.settleErasures(j)
+	// the images aren't needed anymore
+	os.RemoveAll(filepath.Join(manager.jobDir(j.ID), "images"))
+	if webhooks != nil {
+		webhooks.notify(webhookEventJobFinished, j.APIKey, j)
+	}
+}
+
+// sweep deletes expired jobs
+func (manager *jobManager) sweep() {
+	for now := range time.Tick(jobSweepInterval) {
+		manager.removeExpired(now)
+	}
+}
+
+// removeExpired deletes the jobs expired at now
+func (manager *jobManager) removeExpired(now time.Time) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	for id, j := range manager.jobs {
+		if j.ExpiresAt != nil && now.After(*j.ExpiresAt) {
+			log.Println("job", id, "expired")
+			manager.remove(id)
+		}
+	}
+}
+
+func newID() string {
+	var id = make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// analyze jobs run /analyze on each image
+
+type analyzeJobResult struct {
+	Image string `json:"image"`
+	analysisResult
+}
+
+func runAnalyzeJob(ctx context.Context, task jobTask) (interface{}, error) {
+	var opts analyzeOptions
+	var err = json.Unmarshal(task.Options, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.templates = true
+	var results = make([]analyzeJobResult, len(task.ImagePaths))
+	for i, imagePath := range task.ImagePaths {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		results[i] = analyzeJobResult{Image: task.Images[i], analysisResult: analyze(imagePath, opts)}
+		// faces failed by the spoof policy aren't kept
+		if results[i].Code == "" {
+			for _, face := range results[i].faces {
+				task.Templates[i] = append(task.Templates[i], face.Template)
+			}
+		}
+
+		task.Progress(float64(i+1) / float64(len(task.ImagePaths)))
+	}
+
+	return results, nil
+}
+
+// auditAnalyzeJob records each image as /analyze would
+func auditAnalyzeJob(task jobTask, result interface{}) []auditEntry {
+	var entries []auditEntry
+	for i, analysis := range result.([]analyzeJobResult) {
+		entries = append(entries, auditEntry{ImageHashes: []string{task.ImageHashes[i]}, Decision: analysisDecision(analysis.analysisResult)})
+	}
+
+	return entries
+}
+
+// redactAnalyzeJob replaces the results of the erased images, keeping the
+// results in image order
+func redactAnalyzeJob(result json.RawMessage, images []string, erased []bool) (json.RawMessage, error) {
+	var results []json.RawMessage
+	var err = json.Unmarshal(result, &results)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		if i < len(erased) && erased[i] {
+			results[i], err = json.Marshal(analyzeJobResult{
+				Image:          erasedImageName,
+				analysisResult: analysisResult{Code: "Erased", Message: "the subject's data was erased"},
+			})
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return json.Marshal(results)
+}
+
+// dedupe jobs find the images of the same subject, by comparing the first
+// face in each image with every other
+
+type dedupeOptions struct {
+	Threshold  float32
+	ColorSpace string
+}
+
+type duplicatePair struct {
+	Images [2]string `json:"images"`
+
+	// of the images, in upload order, their names may not be unique
+	Indexes    [2]int  `json:"indexes"`
+	Similarity float32 `json:"similarity"`
+}
+
+type imageFailure struct {
+	Image   string `json:"image"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type dedupeResult struct {
+	Duplicates []duplicatePair `json:"duplicates"`
+
+	// images of the same subject, in groups of two or more
+	Groups [][]string     `json:"groups"`
+	Failed []imageFailure `json:"failed,omitempty"`
+}
+
+func getDedupeOptions(r *http.Request) (dedupeOptions, error) {
+	var opts dedupeOptions
+	var err error
+	opts.Threshold, err = getThresholdQueryParam(r, "threshold", defaultDedupeThreshold)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.ColorSpace, err = getColorSpace(r)
+	return opts, err
+}
+
+func runDedupeJob(ctx context.Context, task jobTask) (interface{}, error) {
+	var opts dedupeOptions
+	var err = json.Unmarshal(task.Options, &opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// representing the images is most of the work
+	var result = dedupeResult{Duplicates: []duplicatePair{}, Groups: [][]string{}}
+	var templates = make([][]byte, len(task.ImagePaths))
+	for i, imagePath := range task.ImagePaths {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		var img image.Image
+		img, _, err = engine.readImage(imagePath)
+		if err != nil {
+			result.Failed = append(result.Failed, imageFailure{Image: task.Images[i], Code: "InvalidImage", Message: err.Error()})
+			continue
+		}
+
+		var faces = detectAllowedFaces(img, opts.ColorSpace, []string{"recognition"}, adaptiveMinFaceWidth, 1, defaultFDR)
+		if len(faces) == 0 {
+			result.Failed = append(result.Failed, imageFailure{Image: task.Images[i], Code: "FaceNotDetected", Message: "Failed to detect face in image"})
+		} else {
+			templates[i] = faces[0].Template
+			task.Templates[i] = [][]byte{faces[0].Template}
+		}
+
+		task.Progress(0.9 * float64(i+1) / float64(len(task.ImagePaths)))
+	}
+
+	for i := range templates {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		for k := i + 1; k < len(templates); k++ {
+			if templates[i] == nil || templates[k] == nil {
+				continue
+			}
+
+			var similarity = engine.compareTemplates(templates[i], templates[k])
+			if similarity >= opts.Threshold {
+				result.Duplicates = append(result.Duplicates, duplicatePair{
+					Images:     [2]string{task.Images[i], task.Images[k]},
+					Indexes:    [2]int{i, k},
+					Similarity: similarity,
+				})
+			}
+		}
+	}
+
+	result.Groups = dedupeGroups(task.Images, result.Duplicates)
+	return result, nil
+}
+
+// dedupeGroups joins the duplicates into groups of two or more images, in
+// upload order
+func dedupeGroups(images []string, duplicates []duplicatePair) [][]string {
+	// union-find of the duplicates, by image index
+	var parents = make([]int, len(images))
+	for i := range parents {
+		parents[i] = i
+	}
+
+	var root = func(i int) int {
+		for parents[i] != i {
+			i = parents[i]
+		}
+
+		return i
+	}
+
+	for _, pair := range duplicates {
+		parents[root(pair.Indexes[1])] = root(pair.Indexes[0])
+	}
+
+	var groups = map[int][]string{}
+	var roots []int
+	for i := range images {
+		var r = root(i)
+		if _, ok := groups[r]; !ok {
+			roots = append(roots, r)
+		}
+
+		groups[r] = append(groups[r], images[i])
+	}
+
+	var result = [][]string{}
+	for _, r := range roots {
+		if len(groups[r]) > 1 {
+			result = append(result, groups[r])
+		}
+	}
+
+	return result
+}
+
+// auditDedupeJob records the comparison of every image, and each pair of
+// duplicates with its similarity
+func auditDedupeJob(task jobTask, result interface{}) []auditEntry {
+	var entries = []auditEntry{{ImageHashes: task.ImageHashes, Decision: auditDecisionCompared}}
+	for _, pair := range result.(dedupeResult).Duplicates {
+		var similarity = pair.Similarity
+		entries = append(entries, auditEntry{
+			ImageHashes: []string{task.ImageHashes[pair.Indexes[0]], task.ImageHashes[pair.Indexes[1]]},
+			Similarity:  &similarity,
+			Decision:    auditDecisionMatched,
+		})
+	}
+
+	return entries
+}
+
+// redactDedupeJob removes the erased images from the duplicates, and makes
+// the groups again from the remaining ones: an erased image may have been
+// all that joined two others in a group. Images are matched by index, their
+// names may not be unique.
+func redactDedupeJob(result json.RawMessage, images []string, erased []bool) (json.RawMessage, error) {
+	var dedupe dedupeResult
+	var err = json.Unmarshal(result, &dedupe)
+	if err != nil {
+		return nil, err
+	}
+
+	var duplicates = []duplicatePair{}
+	for _, pair := range dedupe.Duplicates {
+		if pair.Indexes[0] < 0 || pair.Indexes[1] >= len(images) || pair.Indexes[0] >= pair.Indexes[1] {
+			return nil, fmt.Errorf("invalid duplicate image indexes %v", pair.Indexes)
+		}
+
+		if !erased[pair.Indexes[0]] && !erased[pair.Indexes[1]] {
+			duplicates = append(duplicates, pair)
+		}
+	}
+
+	dedupe.Duplicates = duplicates
+	dedupe.Groups = dedupeGroups(images, duplicates)
+	return json.Marshal(dedupe)
+}
@@ -0,0 +1,577 @@
+// OpenAPI 3 description of the HTTP API, served at /openapi.json. Response
+// schemas are generated from the Go types the handlers encode, and parameter
+// defaults from the same constants, so the description can't drift from the
+// implementation. Query parameters are listed by hand, openapi_test.go checks
+// the handlers read every one of them, and that every one they read is listed.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const openAPIVersion = "3.0.3"
+const apiVersion = "1.0.0"
+
+// apiParam is a query parameter
+type apiParam struct {
+	Name        string
+	Type        string      // string, boolean, integer or number
+	Default     interface{} // a *string for a setting loaded at startup
+	Enum        []string
+	Description string
+}
+
+// apiRoute is a POST route taking multipart images
+type apiRoute struct {
+	Path        string
+	Summary     string
+	FormFields  []string
+	Params      []apiParam
+	Response    interface{}
+	Codes       []string // result codes, returned with a 200
+	ImageParams []string // boolean parameters making the route respond with a PNG
+	Audited     bool     // results are recorded in the audit log first
+}
+
+var spoofPolicies = []string{spoofPolicyNone, spoofPolicyReport, spoofPolicyReject, spoofPolicyStrict}
+
+var spoofParams = []apiParam{
+	{"spoofLiveThreshold", "number", defaultSpoofLiveThreshold, nil, "SpoofAF score below which a face is live"},
+	{"spoofThreshold", "number", defaultSpoofThreshold, nil, "SpoofAF score from which a face is a spoof"},
+}
+
+var colorSpaceParam = apiParam{"colorSpace", "string", &defaultColorSpace, []string{colorSpaceGray8, colorSpaceBGR24}, "color space the images are processed in"}
+
+var detectionParams = []apiParam{
+	colorSpaceParam,
+	{"retryRotations", "boolean", false, nil, "retry detection on rotated copies when no face is found"},
+	{"retryUpscale", "boolean", false, nil, "retry detection on an upscaled copy when no face is found"},
+	{"detection", "string", detectionModeStandard, []string{detectionModeStandard, detectionModeTiled}, "tiled scans overlapping tiles at several scales, for small faces in large images"},
+}
+
+var sizeParams = []apiParam{
+	{"fdr", "number", defaultFDR, nil, "false detection rate"},
+	{"minFaceWidthInPixels", "integer", defaultMinFaceWidthInPixels, nil, "minimum face width"},
+}
+
+var cropParams = []apiParam{
+	{"padding", "number", defaultCropPadding, nil, "padding around the face box, as a fraction of its size, at most " + fmt.Sprint(maxCropPadding)},
+	{"format", "string", defaultCropFormat, []string{"jpeg", "png"}, "crop image format"},
+	{"aligned", "boolean", true, nil, "also return crops rotated so the eyes are level, if the landmarks attribute is enabled"},
+}
+
+// apiRoutes describes the multipart routes, the quality profiles are only
+// known once loaded at startup
+func apiRoutes() []apiRoute {
+	return []apiRoute{
+		{
+			Path:       "/verify",
+			Summary:    "Compare the faces in two images",
+			FormFields: verifyFormFields,
+			Params: concatParams(detectionParams, []apiParam{
+				{"annotate", "boolean", false, nil, "respond with a PNG of both images, the faces and the result drawn over them"},
+				{"verbose", "boolean", false, nil, "include face details and warnings for each image"},
+				{"spoof", "string", spoofPolicyNone, spoofPolicies, "presentation attack policy"},
+				{"faceSelection", "string", nil, selectionStrategies, "detect several faces per image and choose one with this strategy"},
+				{"numFacesToDetect", "integer", defaultSelectionFacesToDetect, nil, "faces to detect per image, with faceSelection"},
+				{"skipCache", "boolean", false, nil, "detect the faces again, even for images in the template cache"},
+				{"receipt", "boolean", false, nil, "include a receipt: a JWS signed with the key at /keys, over the result, image hashes and options"},
+			}, spoofParams),
+			Response:    verificationResult{},
+			Codes:       []string{"InvalidImageCount", "InvalidImage", "FaceNotDetected", "MultipleFacesDetected", "PresentationAttackDetected"},
+			ImageParams: []string{"annotate"},
+			Audited:     true,
+		},
+		{
+			Path:       "/analyze",
+			Summary:    "Extract the attributes of the face in an image",
+			FormFields: analyzeFormFields,
+			Params: concatParams(sizeParams, detectionParams, []apiParam{
+				{"numFacesToDetect", "integer", defaultNumFacesToDetect, nil, "faces to detect"},
+				{"attributes", "string", strings.Join(defaultAttributes, ","), nil, "comma separated attributes: " + strings.Join(attributeNames(), ", ")},
+				{"annotate", "boolean", false, nil, "respond with a PNG of the image, the faces drawn over it"},
+				{"spoof", "string", spoofPolicyReport, spoofPolicies, "presentation attack policy, reject and strict need the spoof attribute"},
+				{"crops", "boolean", false, nil, "include crops of the detected faces"},
+			}, cropParams, spoofParams),
+			Response:    analysisResult{},
+			Codes:       []string{"InvalidImage", "FaceNotDetected", "PresentationAttackDetected", "CropFailed"},
+			ImageParams: []string{"annotate"},
+			Audited:     true,
+		},
+		{
+			Path:       "/crop",
+			Summary:    "Crop the faces in an image",
+			FormFields: cropFormFields,
+			Params: concatParams(sizeParams, []apiParam{
+				colorSpaceParam,
+				{"numFacesToDetect", "integer", defaultNumFacesToDetect, nil, "faces to detect"},
+			}, cropParams),
+			Response: cropResult{},
+			Codes:    []string{"FaceNotDetected"},
+			Audited:  true,
+		},
+		{
+			Path:       "/quality",
+			Summary:    "Check an image against a quality profile",
+			FormFields: qualityFormFields,
+			Params: concatParams(sizeParams, []apiParam{
+				colorSpaceParam,
+				{"profile", "string", defaultQualityProfile, qualityProfileNames(), "quality profile"},
+			}),
+			Response: qualityResult{},
+			Codes:    []string{"FaceNotDetected"},
+			Audited:  true,
+		},
+	}
+}
+
+func concatParams(lists ...[]apiParam) []apiParam {
+	var params []apiParam
+	for _, list := range lists {
+		params = append(params, list...)
+	}
+
+	return params
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("/openapi.json")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(openAPISpec())
+}
+
+// openAPISpec builds the OpenAPI document
+func openAPISpec() map[string]interface{} {
+	var schemas = map[string]interface{}{}
+	var paths = map[string]interface{}{
+		"/ping": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary":   "Check that the server is up",
+				"responses": map[string]interface{}{"200": map[string]interface{}{"description": "the server is up"}},
+			},
+		},
+	}
+
+	var errorResponse = map[string]interface{}{
+		"description": "invalid request, e.g. a missing image or an invalid parameter",
+		"content":     jsonContent(schemaRef(reflect.TypeOf(errorResponseObj{}), schemas)),
+	}
+
+	for _, route := range apiRoutes() {
+		var parameters []interface{}
+		for _, param := range route.Params {
+			var schema = map[string]interface{}{"type": param.Type}
+			if pointer, ok := param.Default.(*string); ok {
+				schema["default"] = *pointer
+			} else if param.Default != nil {
+				schema["default"] = param.Default
+			}
+
+			if param.Enum != nil {
+				schema["enum"] = param.Enum
+			}
+
+			parameters = append(parameters, map[string]interface{}{
+				"name":        param.Name,
+				"in":          "query",
+				"description": param.Description,
+				"schema":      schema,
+			})
+		}
+
+		var properties = map[string]interface{}{}
+		for _, field := range route.FormFields {
+			properties[field] = map[string]interface{}{"type": "string", "format": "binary"}
+		}
+
+		var content = jsonContent(schemaRef(reflect.TypeOf(route.Response), schemas))
+		if len(route.ImageParams) > 0 {
+			content["image/png"] = map[string]interface{}{
+				"schema": map[string]interface{}{"type": "string", "format": "binary"},
+			}
+		}
+
+		var description = "result, failures are reported with a code: " + strings.Join(route.Codes, ", ")
+		if len(route.ImageParams) > 0 {
+			description += ". A PNG image with " + strings.Join(route.ImageParams, " or ") + " set"
+		}
+
+		var responses = map[string]interface{}{
+			"200": map[string]interface{}{"description": description, "content": content},
+			"400": errorResponse,
+		}
+
+		if route.Audited {
+			responses["500"] = map[string]interface{}{
+				"description": "the result couldn't be written to the audit log, so it isn't sent",
+				"content":     jsonContent(schemaRef(reflect.TypeOf(errorResponseObj{}), schemas)),
+			}
+		}
+
+		paths[route.Path] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"summary":    route.Summary,
+				"parameters": parameters,
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content": map[string]interface{}{
+						"multipart/form-data": map[string]interface{}{
+							"schema": map[string]interface{}{
+								"type":       "object",
+								"required":   route.FormFields,
+								"properties": properties,
+							},
+						},
+					},
+				},
+				"responses": responses,
+			},
+		}
+	}
+
+	addJobPaths(paths, schemas, errorResponse)
+	addWebhookPaths(paths, schemas, errorResponse)
+	addCachePaths(paths, schemas)
+	addReceiptPaths(paths, schemas, errorResponse)
+	addRetentionPaths(paths, schemas, errorResponse)
+	return map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   "roc-face",
+			"version": apiVersion,
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+}
+
+// addJobPaths describes the /jobs routes (see roc_face_jobs.go)
+func addJobPaths(paths map[string]interface{}, schemas map[string]interface{}, errorResponse interface{}) {
+	var jobResponse = func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content":     jsonContent(schemaRef(reflect.TypeOf(job{}), schemas)),
+		}
+	}
+
+	var notFound = map[string]interface{}{
+		"description": "no such job, or it expired",
+		"content":     jsonContent(schemaRef(reflect.TypeOf(errorResponseObj{}), schemas)),
+	}
+
+	paths["/jobs"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary": "Queue a job on the uploaded images, every file in the form",
+			"parameters": []interface{}{
+				map[string]interface{}{
+					"name":        "type",
+					"in":          "query",
+					"required":    true,
+					"description": "analyze takes the /analyze parameters, dedupe threshold and colorSpace",
+					"schema":      map[string]interface{}{"type": "string", "enum": []string{jobTypeAnalyze, jobTypeDedupe}},
+				},
+				map[string]interface{}{
+					"name":        "threshold",
+					"in":          "query",
+					"description": "dedupe jobs: similarity from which two images are duplicates (default " + fmt.Sprint(defaultDedupeThreshold) + ")",
+					"schema":      map[string]interface{}{"type": "number"},
+				},
+			},
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"multipart/form-data": map[string]interface{}{
+						"schema": map[string]interface{}{
+							"type":                 "object",
+							"additionalProperties": map[string]interface{}{"type": "string", "format": "binary"},
+						},
+					},
+				},
+			},
+			"responses": map[string]interface{}{
+				"202": jobResponse("the queued job"),
+				"400": errorResponse,
+				"503": map[string]interface{}{"description": "too many queued jobs"},
+			},
+		},
+	}
+
+	paths["/jobs/{id}"] = map[string]interface{}{
+		"parameters": []interface{}{
+			map[string]interface{}{"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+		},
+		"get": map[string]interface{}{
+			"summary":   "Get the status, progress and result of a job",
+			"responses": map[string]interface{}{"200": jobResponse("the job"), "404": notFound},
+		},
+		"delete": map[string]interface{}{
+			"summary":   "Cancel an unfinished job, or delete a finished one",
+			"responses": map[string]interface{}{"200": jobResponse("the canceled or deleted job"), "404": notFound},
+		},
+	}
+}
+
+// addWebhookPaths describes the /webhooks routes (see roc_face_webhooks.go)
+func addWebhookPaths(paths map[string]interface{}, schemas map[string]interface{}, errorResponse interface{}) {
+	var response = func(description string, value interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content":     jsonContent(schemaRef(reflect.TypeOf(value), schemas)),
+		}
+	}
+
+	var idParam = []interface{}{
+		map[string]interface{}{"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+	}
+
+	var notFound = response("not found", errorResponseObj{})
+	paths["/webhooks"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary": "Register a callback URL for the events of the API key's requests: " + strings.Join(webhookEvents, ", ") + ", all by default",
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemaRef(reflect.TypeOf(webhook{}), schemas)),
+			},
+			"responses": map[string]interface{}{
+				"201": response("the webhook, with the secret its deliveries are signed with", webhook{}),
+				"400": errorResponse,
+			},
+		},
+		"get": map[string]interface{}{
+			"summary":   "List the webhooks of the API key",
+			"responses": map[string]interface{}{"200": response("the webhooks, without their secrets", []webhook{})},
+		},
+	}
+
+	paths["/webhooks/{id}"] = map[string]interface{}{
+		"parameters": idParam,
+		"delete": map[string]interface{}{
+			"summary":   "Delete a webhook, and stop its deliveries",
+			"responses": map[string]interface{}{"200": map[string]interface{}{"description": "deleted"}, "404": notFound},
+		},
+	}
+
+	paths["/webhooks/{id}/deliveries"] = map[string]interface{}{
+		"parameters": idParam,
+		"get": map[string]interface{}{
+			"summary":   "List the recent deliveries of a webhook and their attempts, newest first",
+			"responses": map[string]interface{}{"200": response("the deliveries", []webhookDelivery{}), "404": notFound},
+		},
+	}
+
+	paths["/webhooks/dead-letters"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary":   "List the deliveries to the API key's webhooks that failed every attempt, newest first",
+			"responses": map[string]interface{}{"200": response("the failed deliveries", []webhookDelivery{})},
+		},
+	}
+
+	paths["/webhooks/dead-letters/{id}/retry"] = map[string]interface{}{
+		"parameters": idParam,
+		"post": map[string]interface{}{
+			"summary":   "Deliver a dead letter again",
+			"responses": map[string]interface{}{"202": response("the pending delivery", webhookDelivery{}), "404": notFound},
+		},
+	}
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// schemaRef returns a reference to the schema of a named struct type, adding
+// it to schemas, or the inline schema of any other type
+func schemaRef(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return typeSchema(t, schemas)
+	}
+
+	var name = schemaName(t)
+	if _, ok := schemas[name]; !ok {
+		schemas[name] = nil // placeholder, for recursive types
+		schemas[name] = typeSchema(t, schemas)
+	}
+
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// schemaName turns a Go type name into a schema name, e.g. verificationResult
+// into VerificationResult
+func schemaName(t reflect.Type) string {
+	return strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+}
+
+func typeSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		// any JSON value
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes bytes as base64 strings
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+
+		return map[string]interface{}{"type": "array", "items": schemaRef(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaRef(t.Elem(), schemas)}
+	case reflect.Struct:
+		var properties = map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			var field = t.Field(i)
+			var tag = field.Tag.Get("json")
+			if field.PkgPath != "" || tag == "-" {
+				continue
+			}
+
+			var parts = strings.Split(tag, ",")
+			var name = parts[0]
+			if name == "" {
+				name = field.Name
+			}
+
+			properties[name] = schemaRef(field.Type, schemas)
+			if !strings.Contains(tag, ",omitempty") {
+				required = append(required, name)
+			}
+		}
+
+		var schema = map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+
+		return schema
+	}
+
+	// interface{}: any value
+	return map[string]interface{}{}
+}
+
+// addCachePaths describes the /cache routes (see roc_face_cache.go)
+func addCachePaths(paths map[string]interface{}, schemas map[string]interface{}) {
+	paths["/cache"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "Template cache size and hit / miss counts",
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "cache statistics",
+					"content":     jsonContent(schemaRef(reflect.TypeOf(cacheStats{}), schemas)),
+				},
+			},
+		},
+		"delete": map[string]interface{}{
+			"summary":   "Empty the template cache",
+			"responses": map[string]interface{}{"204": map[string]interface{}{"description": "emptied"}},
+		},
+	}
+}
+
+// addReceiptPaths describes the /keys and /receipts/verify routes (see
+// roc_face_receipts.go)
+func addReceiptPaths(paths map[string]interface{}, schemas map[string]interface{}, errorResponse interface{}) {
+	paths["/keys"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "The public key receipts are signed with, as a JSON Web Key set, only served when ROC_FACE_RECEIPT_KEY or ROC_FACE_RECEIPT_KEY_FILE is set",
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "the key set",
+					"content":     jsonContent(schemaRef(reflect.TypeOf(jsonWebKeySet{}), schemas)),
+				},
+			},
+		},
+	}
+
+	paths["/receipts/verify"] = map[string]interface{}{
+		"post": map[string]interface{}{
+			"summary": "Check that a receipt was signed by this server, and decode it",
+			"requestBody": map[string]interface{}{
+				"required": true,
+				"content": jsonContent(map[string]interface{}{
+					"type":       "object",
+					"required":   []string{"receipt"},
+					"properties": map[string]interface{}{"receipt": map[string]interface{}{"type": "string"}},
+				}),
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "whether the receipt is valid, and its claims if it is",
+					"content":     jsonContent(schemaRef(reflect.TypeOf(receiptVerification{}), schemas)),
+				},
+				"400": errorResponse,
+			},
+		},
+	}
+}
+
+// addRetentionPaths describes the /subjects and /retention routes (see
+// roc_face_retention.go)
+func addRetentionPaths(paths map[string]interface{}, schemas map[string]interface{}, errorResponse interface{}) {
+	paths["/subjects/{id}"] = map[string]interface{}{
+		"parameters": []interface{}{
+			map[string]interface{}{"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"}},
+		},
+		"delete": map[string]interface{}{
+			"summary": "Erase a subject's templates from every gallery, the cache entries and job results of images of the same face, unfinished jobs once they finish, and its watchlist hits",
+			"parameters": []interface{}{
+				map[string]interface{}{
+					"name":        "threshold",
+					"in":          "query",
+					"description": "similarity from which a face is the subject's, more than 0 and at most 1 (default " + fmt.Sprint(defaultErasureThreshold) + ")",
+					"schema":      map[string]interface{}{"type": "number"},
+				},
+			},
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "what was erased",
+					"content":     jsonContent(schemaRef(reflect.TypeOf(subjectErasure{}), schemas)),
+				},
+				"400": errorResponse,
+				"500": map[string]interface{}{
+					"description": "the subject was erased, but the erasure couldn't be written to the audit log, or the galleries couldn't be saved yet",
+					"content":     jsonContent(schemaRef(reflect.TypeOf(errorResponseObj{}), schemas)),
+				},
+			},
+		},
+	}
+
+	paths["/retention"] = map[string]interface{}{
+		"get": map[string]interface{}{
+			"summary": "The retention policies, and the templates they expire now, without removing them",
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "the dry-run report",
+					"content":     jsonContent(schemaRef(reflect.TypeOf(retentionReport{}), schemas)),
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,355 @@
+// Face image quality / compliance checks, combining the SDK metadata with
+// metrics computed from the image itself. Profiles are added to or replaced
+// by those in the JSON file in ROC_FACE_QUALITY_PROFILES, e.g.
+//
+//	{"kiosk": {"minQuality": 0.4, "maxYaw": 15, "minSharpness": 80}}
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+const defaultQualityProfile = "selfie"
+
+// face crops are resized to this width before computing sharpness, so the
+// value does not depend on the image resolution
+const qualityMetricsWidth = 128
+
+// qualityProfile holds the limits for every check. Zero values disable the
+// corresponding check.
+type qualityProfile struct {
+	MinQuality     float64 `json:"minQuality,omitempty"`
+	MinIOD         float64 `json:"minIOD,omitempty"`
+	MaxYaw         float64 `json:"maxYaw,omitempty"`
+	MaxPitch       float64 `json:"maxPitch,omitempty"`
+	MaxRoll        float64 `json:"maxRoll,omitempty"`
+	MaxGlasses     float64 `json:"maxGlasses,omitempty"`    // Glasses probability
+	MaxLipsApart   float64 `json:"maxLipsApart,omitempty"`  // LipsApart probability
+	MinBrightness  float64 `json:"minBrightness,omitempty"` // mean face luminance, 0-255
+	MaxBrightness  float64 `json:"maxBrightness,omitempty"`
+	MinContrast    float64 `json:"minContrast,omitempty"`  // standard deviation of face luminance
+	MinSharpness   float64 `json:"minSharpness,omitempty"` // variance of the Laplacian of the face
+	MinFaceRatio   float64 `json:"minFaceRatio,omitempty"` // face height / image height
+	MaxFaceRatio   float64 `json:"maxFaceRatio,omitempty"`
+	SingleFaceOnly bool    `json:"singleFaceOnly,omitempty"`
+}
+
+var qualityProfiles = map[string]qualityProfile{
+	"selfie": {
+		MinQuality:     0.3,
+		MinIOD:         40,
+		MaxYaw:         25,
+		MaxPitch:       25,
+		MaxRoll:        20,
+		MinBrightness:  50,
+		MaxBrightness:  220,
+		MinContrast:    20,
+		MinSharpness:   50,
+		MinFaceRatio:   0.15,
+		SingleFaceOnly: true,
+	},
+	"passport": {
+		MinQuality:     0.5,
+		MinIOD:         90,
+		MaxYaw:         8,
+		MaxPitch:       8,
+		MaxRoll:        8,
+		MaxGlasses:     0.5,
+		MaxLipsApart:   0.5,
+		MinBrightness:  80,
+		MaxBrightness:  200,
+		MinContrast:    30,
+		MinSharpness:   100,
+		MinFaceRatio:   0.5,
+		MaxFaceRatio:   0.8,
+		SingleFaceOnly: true,
+	},
+}
+
+type qualityCheck struct {
+	Name   string  `json:"name"`
+	Passed bool    `json:"passed"`
+	Value  float64 `json:"value"`
+	Reason string  `json:"reason,omitempty"`
+
+	// the engine didn't return the metadata the check needs, so it didn't
+	// fail, but the image doesn't pass without it
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+type imageMetrics struct {
+	Brightness float64 `json:"brightness"`
+	Contrast   float64 `json:"contrast"`
+	Sharpness  float64 `json:"sharpness"`
+	FaceRatio  float64 `json:"faceRatio"`
+}
+
+// loadQualityProfiles reads the profiles in ROC_FACE_QUALITY_PROFILES, if set
+func loadQualityProfiles() error {
+	var path = os.Getenv("ROC_FACE_QUALITY_PROFILES")
+	if path == "" {
+		return nil
+	}
+
+	var file, err = os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+	var profiles map[string]qualityProfile
+	var decoder = json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&profiles)
+	if err != nil {
+		return fmt.Errorf("invalid quality profiles in %s: %s", path, err.Error())
+	}
+
+	for name, profile := range profiles {
+		if name == "" {
+			return fmt.Errorf("invalid quality profiles in %s: a profile has no name", path)
+		}
+
+		qualityProfiles[name] = profile
+	}
+
+	log.Println("loaded", len(profiles), "quality profiles from", path)
+	return nil
+}
+
+func qualityProfileNames() []string {
+	var names []string
+	for name := range qualityProfiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func getQualityProfile(name string) (qualityProfile, error) {
+	var profile, ok = qualityProfiles[name]
+	if !ok {
+		return profile, fmt.Errorf("unknown quality profile %q, expected one of: %v", name, qualityProfileNames())
+	}
+
+	return profile, nil
+}
+
+// measureFace computes brightness, contrast and sharpness over the face box,
+// and the ratio of the face height to the image height
+func measureFace(img image.Image, box faceBox) imageMetrics {
+	var gray = faceLuminance(img, box)
+	var metrics = imageMetrics{
+		FaceRatio: float64(box.Height) / float64(img.Bounds().Dy()),
+	}
+
+	var count = 0
+	var sum, sumSquares float64
+	for _, row := range gray {
+		for _, value := range row {
+			sum += value
+			sumSquares += value * value
+			count++
+		}
+	}
+
+	if count == 0 {
+		return metrics
+	}
+
+	metrics.Brightness = sum / float64(count)
+	metrics.Contrast = math.Sqrt(math.Max(0, sumSquares/float64(count)-metrics.Brightness*metrics.Brightness))
+	metrics.Sharpness = laplacianVariance(gray)
+	return metrics
+}
+
+// faceLuminance resamples the face box to qualityMetricsWidth pixels wide and
+// returns its luminance
+func faceLuminance(img image.Image, box faceBox) [][]float64 {
+	if box.Width <= 0 || box.Height <= 0 {
+		return nil
+	}
+
+	var scale = float64(box.Width) / qualityMetricsWidth
+	var height = int(math.Round(float64(box.Height) / scale))
+	var gray = make([][]float64, height)
+	var bounds = img.Bounds()
+	for y := range gray {
+		gray[y] = make([]float64, qualityMetricsWidth)
+		for x := range gray[y] {
+			var c = sampleBilinear(img, float64(bounds.Min.X+box.X)+(float64(x)+0.5)*scale, float64(bounds.Min.Y+box.Y)+(float64(y)+0.5)*scale)
+			gray[y][x] = 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+		}
+	}
+
+	return gray
+}
+
+// laplacianVariance is a common blur measure: sharp images have strong edges,
+// so a high variance of the Laplacian
+func laplacianVariance(gray [][]float64) float64 {
+	var count = 0
+	var sum, sumSquares float64
+	for y := 1; y < len(gray)-1; y++ {
+		for x := 1; x < len(gray[y])-1; x++ {
+			var value = gray[y-1][x] + gray[y+1][x] + gray[y][x-1] + gray[y][x+1] - 4*gray[y][x]
+			sum += value
+			sumSquares += value * value
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	var mean = sum / float64(count)
+	return sumSquares/float64(count) - mean*mean
+}
+
+// checkQuality runs every check enabled in the profile against the first
+// face. faces must not be empty.
+func checkQuality(img image.Image, faces []detectedFace, profile qualityProfile) ([]qualityCheck, imageMetrics) {
+	var face = faces[0]
+	var metrics = measureFace(img, face.Box)
+	var checks []qualityCheck
+
+	if profile.SingleFaceOnly {
+		checks = append(checks, checkMax("faceCount", float64(len(faces)), 1, "Found %.0f faces, at most %.0f should be visible"))
+	}
+
+	if profile.MinQuality > 0 {
+		if quality, ok := face.Metadata["Quality"].(float64); ok {
+			checks = append(checks, checkMin("quality", quality, profile.MinQuality, "Overall face quality is too low (%.2f, minimum %.2f)"))
+		} else {
+			checks = append(checks, skipCheck("quality", "Quality"))
+		}
+	}
+
+	if profile.MinIOD > 0 {
+		if iod, ok := face.Metadata["IOD"].(float64); ok {
+			checks = append(checks, checkMin("iod", iod, profile.MinIOD, "Face is too small, the eyes are %.0f pixels apart (minimum %.0f), move closer to the camera"))
+		} else {
+			checks = append(checks, skipCheck("iod", "IOD"))
+		}
+	}
+
+	var angles = []struct {
+		Key   string
+		Name  string
+		Limit float64
+		Hint  string
+	}{
+		{"Yaw", "yaw", profile.MaxYaw, "turn to face the camera"},
+		{"Pitch", "pitch", profile.MaxPitch, "keep the head level"},
+		{"Roll", "roll", profile.MaxRoll, "keep the head straight"},
+	}
+
+	for _, angle := range angles {
+		if angle.Limit <= 0 {
+			continue
+		}
+
+		if value, ok := face.Metadata[angle.Key].(float64); ok {
+			var check = checkMax(angle.Name, math.Abs(value), angle.Limit, "Head "+angle.Name+" is %.0f degrees (maximum %.0f), "+angle.Hint)
+			check.Value = value
+			checks = append(checks, check)
+		} else {
+			checks = append(checks, skipCheck(angle.Name, angle.Key))
+		}
+	}
+
+	if profile.MaxGlasses > 0 {
+		if glasses, ok := face.Metadata["Glasses"].(float64); ok {
+			checks = append(checks, checkMax("glasses", glasses, profile.MaxGlasses, "Glasses appear to be worn (%.2f, maximum %.2f), remove them"))
+		} else {
+			checks = append(checks, skipCheck("glasses", "Glasses"))
+		}
+	}
+
+	if profile.MaxLipsApart > 0 {
+		if lipsApart, ok := face.Metadata["LipsApart"].(float64); ok {
+			checks = append(checks, checkMax("lipsApart", lipsApart, profile.MaxLipsApart, "Mouth appears to be open (%.2f, maximum %.2f), keep a neutral expression"))
+		} else {
+			checks = append(checks, skipCheck("lipsApart", "LipsApart"))
+		}
+	}
+
+	if profile.MinBrightness > 0 {
+		checks = append(checks, checkMin("brightness", metrics.Brightness, profile.MinBrightness, "Face is too dark (brightness %.0f, minimum %.0f)"))
+	}
+
+	if profile.MaxBrightness > 0 {
+		checks = append(checks, checkMax("overexposure", metrics.Brightness, profile.MaxBrightness, "Face is overexposed (brightness %.0f, maximum %.0f)"))
+	}
+
+	if profile.MinContrast > 0 {
+		checks = append(checks, checkMin("contrast", metrics.Contrast, profile.MinContrast, "Contrast is too low (%.0f, minimum %.0f), avoid flat or hazy lighting"))
+	}
+
+	if profile.MinSharpness > 0 {
+		checks = append(checks, checkMin("sharpness", metrics.Sharpness, profile.MinSharpness, "Image is blurry (sharpness %.0f, minimum %.0f), hold the camera still"))
+	}
+
+	if profile.MinFaceRatio > 0 {
+		checks = append(checks, checkMin("faceRatio", metrics.FaceRatio, profile.MinFaceRatio, "Face fills too little of the frame (%.2f, minimum %.2f), move closer"))
+	}
+
+	if profile.MaxFaceRatio > 0 {
+		checks = append(checks, checkMax("faceRatioMax", metrics.FaceRatio, profile.MaxFaceRatio, "Face fills too much of the frame (%.2f, maximum %.2f), move further away"))
+	}
+
+	return checks, metrics
+}
+
+// allChecksPassed fails closed: a skipped check didn't show the image is
+// compliant, so the image doesn't pass either
+func allChecksPassed(checks []qualityCheck) bool {
+	for _, check := range checks {
+		if !check.Passed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkMin passes if value >= min. reason is formatted with value and min.
+func checkMin(name string, value float64, min float64, reason string) qualityCheck {
+	var check = qualityCheck{Name: name, Value: value, Passed: value >= min}
+	if !check.Passed {
+		check.Reason = fmt.Sprintf(reason, value, min)
+	}
+
+	return check
+}
+
+// checkMax passes if value <= max. reason is formatted with value and max.
+func checkMax(name string, value float64, max float64, reason string) qualityCheck {
+	var check = qualityCheck{Name: name, Value: value, Passed: value <= max}
+	if !check.Passed {
+		check.Reason = fmt.Sprintf(reason, value, max)
+	}
+
+	return check
+}
+
+// skipCheck reports a check the engine returned no metadata for
+func skipCheck(name string, key string) qualityCheck {
+	var reason = fmt.Sprintf("Skipped, the face engine returned no %s", key)
+	for attribute, keys := range attributeMetadataKeys {
+		if hasAttribute(keys, key) && !allowedAttributes[attribute] {
+			reason = fmt.Sprintf("Skipped, the %s attribute is not enabled on this server", attribute)
+		}
+	}
+
+	return qualityCheck{Name: name, Skipped: true, Reason: reason}
+}
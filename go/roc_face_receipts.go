@@ -0,0 +1,288 @@
+// Signed verification receipts: with receipt=true, the /verify response holds
+// a JWS (compact serialization, signed with Ed25519) over the result, the
+// hashes of the images, the options and the time, so systems storing the
+// result can prove it came from this server.
+//
+//	GET  /keys             the public key, as a JSON Web Key set
+//	POST /receipts/verify  checks a receipt sent as {"receipt": "..."}
+//
+// Receipts can be checked offline against a saved /keys response, see the
+// client package's VerifyReceipt and roc-face receipt verify. The signing
+// key is the base64 Ed25519 seed in ROC_FACE_RECEIPT_KEY, or in the
+// ROC_FACE_RECEIPT_KEY_FILE file, created if missing. Keep that file on a
+// persistent volume: receipts signed with a lost key can't be checked
+// against /keys anymore. Receipts are disabled when neither is set.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/ed25519"
+)
+
+// JWS algorithm and type of receipts
+const receiptAlgorithm = "EdDSA"
+const receiptType = "roc-face-receipt+jws"
+
+// receiptClaims is the signed payload of a receipt
+type receiptClaims struct {
+	Route string `json:"route"`
+
+	// the response body, without the receipt
+	Result json.RawMessage `json:"result"`
+
+	// SHA-256 of each image as processed, as in the audit log
+	ImageHashes []string        `json:"imageHashes"`
+	Params      json.RawMessage `json:"params"`
+	IssuedAt    time.Time       `json:"issuedAt"`
+}
+
+type receiptHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// jsonWebKey is an Ed25519 public key (RFC 8037)
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type receiptVerification struct {
+	Valid   bool           `json:"valid"`
+	Message string         `json:"message,omitempty"`
+	KeyID   string         `json:"keyId,omitempty"`
+	Claims  *receiptClaims `json:"claims,omitempty"`
+}
+
+type receiptSigner struct {
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// receipts is set by enableReceipts, receipts can't be requested when it's
+// nil
+var receipts *receiptSigner
+
+// enableReceipts loads the signing key and adds the /keys and
+// /receipts/verify routes to the router, if a key is configured
+func enableReceipts(r *mux.Router) error {
+	if os.Getenv("ROC_FACE_RECEIPT_KEY") == "" && os.Getenv("ROC_FACE_RECEIPT_KEY_FILE") == "" {
+		log.Println("receipts are disabled, set ROC_FACE_RECEIPT_KEY or ROC_FACE_RECEIPT_KEY_FILE to sign them")
+		return nil
+	}
+
+	var key, err = loadReceiptKey()
+	if err != nil {
+		return err
+	}
+
+	var signer = &receiptSigner{key: key, keyID: receiptKeyID(key.Public().(ed25519.PublicKey))}
+	log.Println("signing receipts with key", signer.keyID)
+	receipts = signer
+	r.HandleFunc("/keys", signer.keysHandler).Methods("GET")
+	r.HandleFunc("/receipts/verify", signer.verifyHandler).Methods("POST")
+	return nil
+}
+
+// loadReceiptKey reads the key from the environment or the key file, and
+// generates the key file if it's missing
+func loadReceiptKey() (ed25519.PrivateKey, error) {
+	var encoded = os.Getenv("ROC_FACE_RECEIPT_KEY")
+	var source = "ROC_FACE_RECEIPT_KEY"
+	if encoded == "" {
+		source = os.Getenv("ROC_FACE_RECEIPT_KEY_FILE")
+		var data, err = ioutil.ReadFile(source)
+		if os.IsNotExist(err) {
+			return generateReceiptKey(source)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		encoded = string(data)
+	}
+
+	var seed, err = base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid receipt key in %s, expected a base64 Ed25519 seed of %d bytes", source, ed25519.SeedSize)
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func generateReceiptKey(keyFile string) (ed25519.PrivateKey, error) {
+	var seed = make([]byte, ed25519.SeedSize)
+	var _, err = rand.Read(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(seed)+"\n"), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("WARNING: generated a new receipt signing key in", keyFile+", receipts signed with any previous key",
+		"can't be checked against /keys anymore, back this file up")
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// receiptKeyID is the first 16 hex digits of the SHA-256 of the public key
+func receiptKeyID(key ed25519.PublicKey) string {
+	var sum = sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// issueReceipt signs a /verify result, before the receipt is added to it
+func (signer *receiptSigner) issueReceipt(route string, filePaths []string, opts verifyOptions, result verificationResult) (string, error) {
+	var claims = receiptClaims{Route: route, IssuedAt: time.Now().UTC()}
+	var err error
+	claims.Result, err = json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+
+	claims.Params, err = json.Marshal(opts)
+	if err != nil {
+		return "", err
+	}
+
+	claims.ImageHashes, err = hashFiles(filePaths)
+	if err != nil {
+		return "", err
+	}
+
+	return signer.sign(claims)
+}
+
+func (signer *receiptSigner) sign(claims receiptClaims) (string, error) {
+	var header, err = json.Marshal(receiptHeader{Algorithm: receiptAlgorithm, Type: receiptType, KeyID: signer.keyID})
+	if err != nil {
+		return "", err
+	}
+
+	var payload []byte
+	payload, err = json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	var signingInput = base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var signature = ed25519.Sign(signer.key, []byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verify checks a receipt was signed with the server's key, and returns its
+// claims
+func (signer *receiptSigner) verify(receipt string) (*receiptClaims, error) {
+	var parts = strings.Split(strings.TrimSpace(receipt), ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed receipt, expected a JWS in compact serialization")
+	}
+
+	var header receiptHeader
+	var err = decodeReceiptPart(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Algorithm != receiptAlgorithm {
+		return nil, fmt.Errorf("unsupported algorithm %q, expected %s", header.Algorithm, receiptAlgorithm)
+	}
+
+	if header.KeyID != signer.keyID {
+		return nil, fmt.Errorf("unknown key %q, receipts are signed with key %s", header.KeyID, signer.keyID)
+	}
+
+	var signature []byte
+	signature, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(signer.key.Public().(ed25519.PublicKey), []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	var claims receiptClaims
+	err = decodeReceiptPart(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+func decodeReceiptPart(part string, value interface{}) error {
+	var data, err = base64.RawURLEncoding.DecodeString(part)
+	if err == nil {
+		err = json.Unmarshal(data, value)
+	}
+
+	if err != nil {
+		return fmt.Errorf("malformed receipt: %s", err.Error())
+	}
+
+	return nil
+}
+
+func (signer *receiptSigner) keysHandler(w http.ResponseWriter, r *http.Request) {
+	var publicKey = signer.key.Public().(ed25519.PublicKey)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jsonWebKeySet{Keys: []jsonWebKey{{
+		KeyType:   "OKP",
+		Curve:     "Ed25519",
+		X:         base64.RawURLEncoding.EncodeToString(publicKey),
+		KeyID:     signer.keyID,
+		Algorithm: receiptAlgorithm,
+		Use:       "sig",
+	}}})
+}
+
+func (signer *receiptSigner) verifyHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("/receipts/verify")
+	var body struct {
+		Receipt string `json:"receipt"`
+	}
+
+	var err = json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		sendError(w, fmt.Errorf("invalid receipt request: %s", err.Error()))
+		return
+	}
+
+	if body.Receipt == "" {
+		sendError(w, fmt.Errorf("expected a receipt"))
+		return
+	}
+
+	var verification = receiptVerification{Valid: true, KeyID: signer.keyID}
+	verification.Claims, err = signer.verify(body.Receipt)
+	if err != nil {
+		verification = receiptVerification{Message: err.Error()}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verification)
+}
@@ -0,0 +1,360 @@
+// Erasure of subjects, and retention limits on the galleries:
+//
+//	DELETE /subjects/{id}  erases the subject
+//	GET    /retention      the retention policies, and what they expire now
+//
+// Erasing a subject removes its templates from every gallery, then, with
+// them as reference, the data kept for images of the same face (a similarity
+// of at least threshold): template cache entries and the images of finished
+// job results, with the webhook deliveries of those jobs. Jobs still queued
+// or running are erased once they finish. The subject's watchlist hits are
+// erased too, even if it's no longer in any gallery. The erasure is recorded
+// in the audit log, with the hashes of the removed templates.
+//
+// ROC_FACE_RETENTION limits how long each gallery keeps templates, as
+// GALLERY:SINCE:TTL entries separated by commas, SINCE being enrolled or
+// matched (since the template last matched in a search, or was enrolled if
+// it never did), e.g. default:matched:8760h,visitors:enrolled:720h. Expired
+// templates are removed every minute, and recorded in the audit log. With
+// ROC_FACE_RETENTION_DRY_RUN=true they are only logged, GET /retention
+// reports them either way.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+)
+
+// similarity from which a face is the erased subject's
+const defaultErasureThreshold = 0.7
+
+// how often expired templates are removed
+const retentionSweepInterval = time.Minute
+
+const retentionSinceEnrolled = "enrolled"
+const retentionSinceMatched = "matched"
+
+const auditDecisionErased = "erased"
+const auditDecisionExpired = "expired"
+
+// name of the erased images in job results
+const erasedImageName = "erased"
+
+type retentionPolicy struct {
+	Gallery string `json:"gallery"`
+	Since   string `json:"since"`
+	TTL     string `json:"ttl"`
+	ttl     time.Duration
+}
+
+// expiredTemplate is a template a retention policy expires
+type expiredTemplate struct {
+	Gallery       string     `json:"gallery"`
+	SubjectID     string     `json:"subjectId"`
+	EnrolledAt    time.Time  `json:"enrolledAt"`
+	LastMatchedAt *time.Time `json:"lastMatchedAt,omitempty"`
+	ExpiredAt     time.Time  `json:"expiredAt"`
+	template      []byte
+}
+
+// retentionReport is what the sweeper removes now, or only logs in a dry run
+type retentionReport struct {
+	DryRun   bool              `json:"dryRun"`
+	Policies []retentionPolicy `json:"policies"`
+	Expired  []expiredTemplate `json:"expired"`
+}
+
+// subjectErasure is what was erased of a subject
+type subjectErasure struct {
+	SubjectID string  `json:"subjectId"`
+	Threshold float32 `json:"threshold"`
+
+	// number of templates removed from each gallery
+	Galleries    map[string]int `json:"galleries"`
+	CacheEntries int            `json:"cacheEntries"`
+
+	// IDs of the jobs whose results were redacted, and of those redacted
+	// once they finish
+	Jobs        []string `json:"jobs"`
+	PendingJobs []string `json:"pendingJobs"`
+
+	// number of webhook deliveries of watchlist hits redacted
+	WatchlistHits int `json:"watchlistHits"`
+}
+
+// erasedSubject is the reference for the data of an erased subject: the
+// templates it was enrolled with. It's never stored.
+type erasedSubject struct {
+	SubjectID string
+	Threshold float32
+	Templates [][]byte
+	ErasedAt  time.Time
+
+	// jobs queued or running when the subject was erased
+	Jobs []string
+}
+
+// matches returns whether the template is of a face of the subject
+func (subject *erasedSubject) matches(template []byte) bool {
+	if len(template) == 0 {
+		return false
+	}
+
+	for _, subjectTemplate := range subject.Templates {
+		if bytes.Equal(template, subjectTemplate) || engine.compareTemplates(template, subjectTemplate) >= subject.Threshold {
+			return true
+		}
+	}
+
+	return false
+}
+
+type retentionEnforcer struct {
+	policies []retentionPolicy
+	dryRun   bool
+}
+
+// enableRetention adds the /subjects and /retention routes to the router, and
+// starts the sweeper if there are retention policies
+func enableRetention(r *mux.Router) error {
+	var enforcer = &retentionEnforcer{}
+	var err error
+	enforcer.policies, err = parseRetentionPolicies(os.Getenv("ROC_FACE_RETENTION"))
+	if err != nil {
+		return err
+	}
+
+	if value := os.Getenv("ROC_FACE_RETENTION_DRY_RUN"); value != "" {
+		enforcer.dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid ROC_FACE_RETENTION_DRY_RUN %q, expected true or false", value)
+		}
+	}
+
+	if len(enforcer.policies) > 0 {
+		for _, policy := range enforcer.policies {
+			log.Println("keeping templates of gallery", policy.Gallery, "for", policy.TTL, "since", policy.Since)
+		}
+
+		if enforcer.dryRun {
+			log.Println("retention dry run, expired templates are only logged")
+		}
+
+		go enforcer.sweep()
+	}
+
+	r.HandleFunc("/subjects/{id}", eraseSubjectHandler).Methods("DELETE")
+	r.HandleFunc("/retention", enforcer.reportHandler).Methods("GET")
+	return nil
+}
+
+func parseRetentionPolicies(value string) ([]retentionPolicy, error) {
+	var policies = []retentionPolicy{}
+	var seen = map[string]bool{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		var parts = strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid ROC_FACE_RETENTION entry %q, expected GALLERY:SINCE:TTL", entry)
+		}
+
+		var policy = retentionPolicy{Gallery: parts[0], Since: parts[1], TTL: parts[2]}
+		if policy.Since != retentionSinceEnrolled && policy.Since != retentionSinceMatched {
+			return nil, fmt.Errorf("invalid ROC_FACE_RETENTION entry %q, expected SINCE to be one of: %s, %s", entry, retentionSinceEnrolled, retentionSinceMatched)
+		}
+
+		var err error
+		policy.ttl, err = time.ParseDuration(policy.TTL)
+		if err != nil || policy.ttl <= 0 {
+			return nil, fmt.Errorf("invalid ROC_FACE_RETENTION entry %q, expected TTL to be a duration, e.g. 720h", entry)
+		}
+
+		if seen[policy.Gallery] {
+			return nil, fmt.Errorf("invalid ROC_FACE_RETENTION, gallery %q has more than one policy", policy.Gallery)
+		}
+
+		seen[policy.Gallery] = true
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// expiresAt is when the policy expires a template
+func (policy retentionPolicy) expiresAt(entry *galleryEntry) time.Time {
+	if policy.Since == retentionSinceMatched && entry.LastMatchedAt.After(entry.EnrolledAt) {
+		return entry.LastMatchedAt.Add(policy.ttl)
+	}
+
+	return entry.EnrolledAt.Add(policy.ttl)
+}
+
+// expire returns the templates expired at now, oldest first, and removes
+// them unless it's a dry run
+func (store *galleryStore) expire(policies []retentionPolicy, now time.Time, dryRun bool) []expiredTemplate {
+	store.mutex.Lock()
+	var expired = []expiredTemplate{}
+	for _, policy := range policies {
+		var entries = store.galleries[policy.Gallery]
+		var kept = entries[:0]
+		for _, entry := range entries {
+			var expiresAt = policy.expiresAt(entry)
+			if expiresAt.After(now) {
+				kept = append(kept, entry)
+				continue
+			}
+
+			var template = expiredTemplate{
+				Gallery:    policy.Gallery,
+				SubjectID:  entry.SubjectID,
+				EnrolledAt: entry.EnrolledAt,
+				ExpiredAt:  expiresAt,
+				template:   entry.Template,
+			}
+
+			if !entry.LastMatchedAt.IsZero() {
+				var lastMatchedAt = entry.LastMatchedAt
+				template.LastMatchedAt = &lastMatchedAt
+			}
+
+			expired = append(expired, template)
+			if dryRun {
+				kept = append(kept, entry)
+			}
+		}
+
+		if entries != nil {
+			store.galleries[policy.Gallery] = kept
+		}
+	}
+
+	if len(expired) > 0 && !dryRun {
+		store.version++
+	}
+
+	store.mutex.Unlock()
+	var err = store.save()
+	if err != nil {
+		// flush retries, the templates are already gone from searches
+		log.Println("expired templates are still stored,", err.Error())
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].ExpiredAt.Before(expired[j].ExpiredAt)
+	})
+
+	return expired
+}
+
+// sweep removes expired templates
+func (enforcer *retentionEnforcer) sweep() {
+	for range time.Tick(retentionSweepInterval) {
+		var expired = galleries.expire(enforcer.policies, time.Now(), enforcer.dryRun)
+		if len(expired) == 0 {
+			continue
+		}
+
+		if enforcer.dryRun {
+			log.Println("dry run,", len(expired), "templates would expire, see GET /retention")
+			continue
+		}
+
+		log.Println("expired", len(expired), "templates")
+		var entry = auditEntry{Route: "retention", Decision: auditDecisionExpired}
+		for _, template := range expired {
+			if !containsString(entry.Subjects, template.SubjectID) {
+				entry.Subjects = append(entry.Subjects, template.SubjectID)
+			}
+
+			entry.TemplateHashes = append(entry.TemplateHashes, hashBytes(template.template))
+		}
+
+		// recordAudit logs its errors, the templates are gone either way
+		recordAudit(entry, enforcer.policies)
+	}
+}
+
+// reportHandler reports what the sweeper removes now, without removing it
+func (enforcer *retentionEnforcer) reportHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("/retention")
+	var report = retentionReport{
+		DryRun:   enforcer.dryRun,
+		Policies: enforcer.policies,
+		Expired:  galleries.expire(enforcer.policies, time.Now(), true),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func eraseSubjectHandler(w http.ResponseWriter, r *http.Request) {
+	var subjectID = mux.Vars(r)["id"]
+	log.Println("DELETE /subjects/" + subjectID)
+	var threshold, err = getThresholdQueryParam(r, "threshold", defaultErasureThreshold)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	// the templates are gone from memory even if the galleries can't be saved
+	var removed, saveErr = galleries.erase(subjectID)
+	var erasure = subjectErasure{SubjectID: subjectID, Threshold: threshold, Galleries: map[string]int{}, Jobs: []string{}, PendingJobs: []string{}}
+	var entry = auditEntry{APIKey: requestAPIKey(r), Route: "/subjects/{id}", Subjects: []string{subjectID}, Decision: auditDecisionErased}
+	var subject = &erasedSubject{SubjectID: subjectID, Threshold: threshold, ErasedAt: time.Now().UTC()}
+	for gallery, entries := range removed {
+		erasure.Galleries[gallery] = len(entries)
+		for _, removedEntry := range entries {
+			subject.Templates = append(subject.Templates, removedEntry.Template)
+			entry.TemplateHashes = append(entry.TemplateHashes, hashBytes(removedEntry.Template))
+		}
+	}
+
+	sort.Strings(entry.TemplateHashes)
+
+	// without templates, no face can be matched to the subject
+	if templates != nil && len(subject.Templates) > 0 {
+		erasure.CacheEntries = templates.erase(subject.matches)
+	}
+
+	if jobs != nil && len(subject.Templates) > 0 {
+		erasure.Jobs, erasure.PendingJobs = jobs.erase(subject)
+	}
+
+	if webhooks != nil {
+		erasure.WatchlistHits = webhooks.redactSubject(subjectID)
+	}
+
+	log.Println("erased subject", subjectID, "from", len(erasure.Galleries), "galleries,", erasure.CacheEntries, "cache entries,",
+		len(erasure.Jobs), "jobs and", erasure.WatchlistHits, "watchlist hits,", len(erasure.PendingJobs), "jobs once they finish")
+	err = recordAudit(entry, erasure)
+	if err != nil {
+		// the subject is erased, but the erasure can't be proven
+		sendInternalError(w, err)
+		return
+	}
+
+	if saveErr != nil {
+		// the galleries are saved again by their flush
+		sendInternalError(w, fmt.Errorf("the subject is erased, except from the stored galleries until they can be saved: %s", saveErr.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(erasure)
+}
@@ -0,0 +1,224 @@
+// Opt-in fallback when no face is found: detection is retried on rotated and
+// upscaled copies of the image, and the faces found (boxes, landmarks and
+// roll) are mapped back to the original image coordinates
+
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// upscaled retries are skipped when the copy would be larger than this
+const retryMaxUpscaledSize = 4096
+const retryUpscaleFactor = 2
+
+type retryOptions struct {
+	Rotate  bool
+	Upscale bool
+}
+
+// imageTransform is a rotation / flip (as an EXIF orientation value) followed
+// by a scale
+type imageTransform struct {
+	Name        string
+	Orientation int
+	Scale       float64
+}
+
+func (opts retryOptions) enabled() bool {
+	return opts.Rotate || opts.Upscale
+}
+
+// orientationRotations decomposes an EXIF orientation into an optional
+// horizontal flip followed by a clockwise rotation, in degrees
+var orientationRotations = map[int]struct {
+	Flip    bool
+	Degrees float64
+}{
+	2: {true, 0},
+	3: {false, 180},
+	4: {true, 180},
+	5: {true, 270},
+	6: {false, 90},
+	7: {true, 90},
+	8: {false, 270},
+}
+
+// retryTransforms lists the transforms to try, in order
+func retryTransforms(img image.Image, opts retryOptions) []imageTransform {
+	var transforms []imageTransform
+	if opts.Rotate {
+		for _, orientation := range []int{6, 3, 8} {
+			transforms = append(transforms, imageTransform{
+				Name:        orientationTransforms[orientation],
+				Orientation: orientation,
+				Scale:       1,
+			})
+		}
+	}
+
+	var bounds = img.Bounds()
+	if opts.Upscale && bounds.Dx()*retryUpscaleFactor <= retryMaxUpscaledSize && bounds.Dy()*retryUpscaleFactor <= retryMaxUpscaledSize {
+		transforms = append(transforms, imageTransform{
+			Name:        "upscale2x",
+			Orientation: 1,
+			Scale:       retryUpscaleFactor,
+		})
+	}
+
+	return transforms
+}
+
+func (t imageTransform) apply(img image.Image) image.Image {
+	var transformed, _ = orientImage(img, t.Orientation)
+	if t.Scale == 1 {
+		return transformed
+	}
+
+	var bounds = transformed.Bounds()
+	var scaled = image.NewRGBA(image.Rect(0, 0, int(float64(bounds.Dx())*t.Scale), int(float64(bounds.Dy())*t.Scale)))
+	scaleInto(scaled, transformed)
+	return scaled
+}
+
+// mapBack maps a point of the transformed image back to the original image of
+// size width x height
+func (t imageTransform) mapBack(x float64, y float64, width int, height int) (float64, float64) {
+	x /= t.Scale
+	y /= t.Scale
+	var w, h = float64(width), float64(height)
+	switch t.Orientation {
+	case 2:
+		return w - x, y
+	case 3:
+		return w - x, h - y
+	case 4:
+		return x, h - y
+	case 5:
+		return y, x
+	case 6:
+		return y, h - x
+	case 7:
+		return w - y, h - x
+	case 8:
+		return w - y, x
+	}
+
+	return x, y
+}
+
+// mapFaceBack maps the box and landmarks of a face found in the transformed
+// image back to the original image of size width x height
+func (t imageTransform) mapFaceBack(face detectedFace, width int, height int) detectedFace {
+	var mapped = face.clone()
+	var x0, y0 = t.mapBack(float64(face.Box.X), float64(face.Box.Y), width, height)
+	var x1, y1 = t.mapBack(float64(face.Box.X+face.Box.Width), float64(face.Box.Y+face.Box.Height), width, height)
+	mapped.Box = faceBox{
+		X:      int(math.Round(math.Min(x0, x1))),
+		Y:      int(math.Round(math.Min(y0, y1))),
+		Width:  int(math.Round(math.Abs(x1 - x0))),
+		Height: int(math.Round(math.Abs(y1 - y0))),
+	}
+
+	for _, mark := range annotateLandmarks {
+		if x, y, ok := landmark(face.Metadata, mark.Name); ok {
+			var mappedX, mappedY = t.mapBack(x, y, width, height)
+			mapped.Metadata[mark.Name+"X"] = mappedX
+			mapped.Metadata[mark.Name+"Y"] = mappedY
+		}
+	}
+
+	if iod, ok := face.Metadata["IOD"].(float64); ok {
+		mapped.Metadata["IOD"] = iod / t.Scale
+	}
+
+	t.mapPoseBack(mapped.Metadata)
+	return mapped
+}
+
+// mapPoseBack corrects the roll (taken to be clockwise, like the angle of the
+// eye line in image coordinates) for the rotation, and the roll and yaw for
+// the flip. Pitch and yaw are relative to the face, a rotation doesn't change
+// them.
+func (t imageTransform) mapPoseBack(metadata map[string]interface{}) {
+	var rotation, ok = orientationRotations[t.Orientation]
+	if !ok {
+		return
+	}
+
+	if roll, ok := metadata["Roll"].(float64); ok {
+		roll -= rotation.Degrees
+		if rotation.Flip {
+			roll = -roll
+		}
+
+		metadata["Roll"] = normalizeAngle(roll)
+	}
+
+	if yaw, ok := metadata["Yaw"].(float64); ok && rotation.Flip {
+		metadata["Yaw"] = -yaw
+	}
+}
+
+// normalizeAngle brings an angle in degrees into (-180, 180]
+func normalizeAngle(degrees float64) float64 {
+	degrees = math.Mod(degrees, 360)
+	if degrees > 180 {
+		degrees -= 360
+	} else if degrees <= -180 {
+		degrees += 360
+	}
+
+	return degrees
+}
+
+// retryDetection runs detect on every retry transform of img and returns the
+// best result, mapped back to img, with the name of the transform that
+// produced it. It returns no faces if none of the transforms helped.
+func retryDetection(img image.Image, opts retryOptions, detect func(image.Image) []detectedFace) ([]detectedFace, string) {
+	var bounds = img.Bounds()
+	var best []detectedFace
+	var bestTransform string
+	for _, transform := range retryTransforms(img, opts) {
+		var faces = detect(transform.apply(img))
+		if len(faces) == 0 || !betterDetection(faces, best) {
+			continue
+		}
+
+		best = make([]detectedFace, len(faces))
+		for i, face := range faces {
+			best[i] = transform.mapFaceBack(face, bounds.Dx(), bounds.Dy())
+		}
+
+		bestTransform = transform.Name
+	}
+
+	return best, bestTransform
+}
+
+// betterDetection tells whether faces is a better detection result than
+// other: more faces, then a better quality (or larger) first face
+func betterDetection(faces []detectedFace, other []detectedFace) bool {
+	if len(faces) != len(other) {
+		return len(faces) > len(other)
+	}
+
+	if len(faces) == 0 {
+		return false
+	}
+
+	return betterFace(faces[0], other[0])
+}
+
+// betterFace tells whether face has a better quality (or, without quality
+// metadata, is larger) than other
+func betterFace(face detectedFace, other detectedFace) bool {
+	var quality, okQuality = face.Metadata["Quality"].(float64)
+	var otherQuality, okOtherQuality = other.Metadata["Quality"].(float64)
+	if okQuality && okOtherQuality && quality != otherQuality {
+		return quality > otherQuality
+	}
+
+	return face.Box.Width*face.Box.Height > other.Box.Width*other.Box.Height
+}
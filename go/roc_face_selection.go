@@ -0,0 +1,96 @@
+// Choosing the face to verify when an image contains several (a bystander in
+// a selfie, the ghost portrait on an ID document)
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+const selectionLargest = "largest"
+const selectionMostCentral = "most-central"
+const selectionHighestQuality = "highest-quality"
+const selectionBestMatch = "best-match"
+const selectionRejectIfMultiple = "reject-if-multiple"
+
+// faces detected per image when a selection strategy is set
+const defaultSelectionFacesToDetect = 5
+
+var selectionStrategies = []string{
+	selectionLargest,
+	selectionMostCentral,
+	selectionHighestQuality,
+	selectionBestMatch,
+	selectionRejectIfMultiple,
+}
+
+// faceSelection tells which of the detected faces was used
+type faceSelection struct {
+	Index     int `json:"index"`
+	FaceCount int `json:"faceCount"`
+}
+
+func validateSelectionStrategy(strategy string) error {
+	for _, name := range selectionStrategies {
+		if strategy == name {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported face selection strategy %q, expected one of: %v", strategy, selectionStrategies)
+}
+
+// selectFace returns the index of the face to use in an image with the given
+// bounds, for the strategies that look at one image at a time. best-match
+// is handled by selectBestMatch.
+func selectFace(faces []detectedFace, strategy string, bounds image.Rectangle) int {
+	var best = 0
+	for i := 1; i < len(faces); i++ {
+		switch strategy {
+		case selectionLargest:
+			if faces[i].Box.Width*faces[i].Box.Height > faces[best].Box.Width*faces[best].Box.Height {
+				best = i
+			}
+		case selectionMostCentral:
+			if centerDistance(faces[i].Box, bounds) < centerDistance(faces[best].Box, bounds) {
+				best = i
+			}
+		case selectionHighestQuality:
+			if betterFace(faces[i], faces[best]) {
+				best = i
+			}
+		}
+	}
+
+	return best
+}
+
+// centerDistance is the distance between the center of a face and the center
+// of the image
+func centerDistance(box faceBox, bounds image.Rectangle) float64 {
+	var x, y = box.center()
+	var centerX = float64(bounds.Min.X+bounds.Max.X) / 2
+	var centerY = float64(bounds.Min.Y+bounds.Max.Y) / 2
+	return math.Hypot(x-centerX, y-centerY)
+}
+
+// selectBestMatch compares every face of the first image with every face of
+// the second one, and returns the indexes of the most similar pair along with
+// its similarity
+func selectBestMatch(faces [2][]detectedFace, compare func(a detectedFace, b detectedFace) float32) (int, int, float32) {
+	var best [2]int
+	var bestSimilarity float32 = -math.MaxFloat32
+	for i, a := range faces[0] {
+		for j, b := range faces[1] {
+			var similarity = compare(a, b)
+			if similarity > bestSimilarity {
+				best = [2]int{i, j}
+				bestSimilarity = similarity
+			}
+		}
+	}
+
+	return best[0], best[1], bestSimilarity
+}
@@ -0,0 +1,101 @@
+// Presentation attack (spoof) verdicts from the SDK's SpoofAF score
+
+package main
+
+import (
+	"fmt"
+)
+
+const spoofVerdictLive = "live"
+const spoofVerdictSpoof = "spoof"
+const spoofVerdictUncertain = "uncertain"
+
+// spoof policies, from most to least permissive
+const spoofPolicyNone = "none"     // don't run spoof detection
+const spoofPolicyReport = "report" // report the verdicts only
+const spoofPolicyReject = "reject" // fail the face on a spoof verdict
+const spoofPolicyStrict = "strict" // fail the face on a spoof or uncertain verdict
+
+// SpoofAF scores below defaultSpoofLiveThreshold are live, scores at or above
+// defaultSpoofThreshold are spoofs, anything in between is uncertain
+const defaultSpoofLiveThreshold = 0.3
+const defaultSpoofThreshold = 0.7
+
+type spoofOptions struct {
+	Policy        string
+	LiveThreshold float64
+	Threshold     float64
+}
+
+type spoofResult struct {
+	Verdict string  `json:"verdict"`
+	Score   float64 `json:"score"`
+}
+
+func (opts spoofOptions) enabled() bool {
+	return opts.Policy != "" && opts.Policy != spoofPolicyNone
+}
+
+func (opts spoofOptions) validate() error {
+	switch opts.Policy {
+	case "", spoofPolicyNone, spoofPolicyReport, spoofPolicyReject, spoofPolicyStrict:
+	default:
+		return fmt.Errorf("invalid spoof policy %q, expected one of: none, report, reject, strict", opts.Policy)
+	}
+
+	// written so that NaN fails, which no score compares with
+	if !(opts.LiveThreshold >= 0 && opts.LiveThreshold <= opts.Threshold && opts.Threshold <= 1) {
+		return fmt.Errorf("expected 0 <= spoofLiveThreshold <= spoofThreshold <= 1")
+	}
+
+	return nil
+}
+
+// checkSpoofAttribute returns an error if the policy rejects faces but the
+// spoof attribute, which scores them, isn't computed
+func checkSpoofAttribute(opts spoofOptions, attributes []string) error {
+	if (opts.Policy == spoofPolicyReject || opts.Policy == spoofPolicyStrict) && !hasAttribute(attributes, "spoof") {
+		return fmt.Errorf("the %s spoof policy needs the spoof attribute", opts.Policy)
+	}
+
+	return nil
+}
+
+// spoofVerdict classifies the SpoofAF score in a face's metadata. It returns
+// nil if the face has no score.
+func spoofVerdict(metadata map[string]interface{}, opts spoofOptions) *spoofResult {
+	var score, ok = metadata["SpoofAF"].(float64)
+	if !ok {
+		return nil
+	}
+
+	var result = spoofResult{
+		Verdict: spoofVerdictUncertain,
+		Score:   score,
+	}
+
+	if score < opts.LiveThreshold {
+		result.Verdict = spoofVerdictLive
+	} else if score >= opts.Threshold {
+		result.Verdict = spoofVerdictSpoof
+	}
+
+	return &result
+}
+
+// rejects tells whether the policy fails the face for this verdict. A nil
+// result, a face without a score, is as good as uncertain to strict.
+func (opts spoofOptions) rejects(result *spoofResult) bool {
+	if result == nil {
+		return opts.Policy == spoofPolicyStrict
+	}
+
+	switch opts.Policy {
+	case spoofPolicyReject:
+		return result.Verdict == spoofVerdictSpoof
+	case spoofPolicyStrict:
+		return result.Verdict != spoofVerdictLive
+	}
+
+	return false
+}
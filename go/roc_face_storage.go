@@ -0,0 +1,574 @@
+// Envelope encryption of the biometric data the server stores: galleries,
+// cached templates, jobs (their images and results) and webhooks (their
+// secrets and pending deliveries, which carry job results). Each file is
+// encrypted with its own AES-256-GCM data key, and the data key with a key of
+// the keyring, whose ID is stored with the file. The data is authenticated
+// with the file's store and its path in the store, so encrypted files can't
+// be swapped or moved within a store or between stores, but a whole store
+// can be moved.
+//
+// The keyring is read from ROC_FACE_STORAGE_KEYS, or from the file in
+// ROC_FACE_STORAGE_KEYS_FILE: ID:KEY entries separated by commas or new
+// lines, each KEY being 32 bytes in base64. The first key encrypts, the
+// others only decrypt what was stored before a rotation. To rotate, put a
+// new key first, stop the server and rewrap every data key with it:
+//
+//	go run roc_server.go roc_face_*.go rekey
+//
+// Without a keyring, files are stored in plaintext. With one, plaintext files
+// aren't read, as anyone able to write to the stores could forge them: rekey
+// encrypts the files stored before, or ROC_FACE_STORAGE_ALLOW_PLAINTEXT=true
+// reads them meanwhile. The stores mustn't be in one another, a file's store
+// being part of its encrypted data.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sealedPrefix starts every encrypted file, followed by its sealedEnvelope
+var sealedPrefix = []byte("roc-face-sealed/1\n")
+
+const storageKeySize = 32
+
+type sealedEnvelope struct {
+	KeyID string `json:"keyId"`
+
+	// the data key, encrypted with the keyring key, and the data encrypted
+	// with the data key, each starting with its nonce
+	WrappedKey []byte `json:"wrappedKey"`
+	Data       []byte `json:"data"`
+}
+
+type keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+// storageKeys is set by enableStorageEncryption, files are stored in
+// plaintext when it's nil
+var storageKeys *keyring
+
+// plaintext files are read though there is a keyring
+var storageAllowPlaintext bool
+
+// enableStorageEncryption loads the keyring, it must run before anything is
+// read from storage
+func enableStorageEncryption() error {
+	var ring, err = loadKeyring()
+	if err != nil {
+		return err
+	}
+
+	if ring == nil {
+		log.Println("storing data in plaintext, set ROC_FACE_STORAGE_KEYS or ROC_FACE_STORAGE_KEYS_FILE to encrypt it")
+		return nil
+	}
+
+	err = checkStoredPaths()
+	if err != nil {
+		return err
+	}
+
+	if value := os.Getenv("ROC_FACE_STORAGE_ALLOW_PLAINTEXT"); value != "" {
+		storageAllowPlaintext, err = strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid ROC_FACE_STORAGE_ALLOW_PLAINTEXT %q, expected true or false", value)
+		}
+	}
+
+	if storageAllowPlaintext {
+		log.Println("reading plaintext files, run rekey to encrypt them")
+	}
+
+	log.Println("encrypting stored data with key", ring.active, "of", len(ring.keys))
+	storageKeys = ring
+	return nil
+}
+
+// loadKeyring reads the keyring from the environment, nil if there is none
+func loadKeyring() (*keyring, error) {
+	var text = os.Getenv("ROC_FACE_STORAGE_KEYS")
+	var source = "ROC_FACE_STORAGE_KEYS"
+	if text == "" {
+		source = os.Getenv("ROC_FACE_STORAGE_KEYS_FILE")
+		if source == "" {
+			return nil, nil
+		}
+
+		var data, err = ioutil.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+
+		text = string(data)
+	}
+
+	return parseKeyring(text, source)
+}
+
+func parseKeyring(text string, source string) (*keyring, error) {
+	var ring = &keyring{keys: map[string][]byte{}}
+	var entries = strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		var parts = strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid key in %s, expected ID:KEY", source)
+		}
+
+		var id = strings.TrimSpace(parts[0])
+		var key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil || len(key) != storageKeySize {
+			return nil, fmt.Errorf("invalid key %q in %s, expected %d bytes in base64", id, source, storageKeySize)
+		}
+
+		if _, ok := ring.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate key %q in %s", id, source)
+		}
+
+		if ring.active == "" {
+			ring.active = id
+		}
+
+		ring.keys[id] = key
+	}
+
+	if ring.active == "" {
+		return nil, fmt.Errorf("no keys in %s", source)
+	}
+
+	return ring, nil
+}
+
+// sealData encrypts data with a new data key, wrapped with the active key,
+// fileID being authenticated with it
+func (ring *keyring) sealData(data []byte, fileID string) ([]byte, error) {
+	var dataKey = make([]byte, storageKeySize)
+	var _, err = rand.Read(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope = sealedEnvelope{KeyID: ring.active}
+	envelope.Data, err = gcmSeal(dataKey, data, []byte(fileID))
+	if err != nil {
+		return nil, err
+	}
+
+	return ring.seal(envelope, dataKey)
+}
+
+// seal wraps the data key with the active key, and encodes the envelope
+func (ring *keyring) seal(envelope sealedEnvelope, dataKey []byte) ([]byte, error) {
+	var err error
+	envelope.KeyID = ring.active
+	envelope.WrappedKey, err = gcmSeal(ring.keys[ring.active], dataKey, []byte(ring.active))
+	if err != nil {
+		return nil, err
+	}
+
+	var encoded []byte
+	encoded, err = json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, sealedPrefix...), encoded...), nil
+}
+
+// open decodes a sealed file's envelope and unwraps its data key
+func (ring *keyring) open(sealed []byte) (sealedEnvelope, []byte, error) {
+	var envelope sealedEnvelope
+	var err = json.Unmarshal(sealed[len(sealedPrefix):], &envelope)
+	if err != nil {
+		return envelope, nil, fmt.Errorf("invalid encrypted data: %s", err.Error())
+	}
+
+	var key, ok = ring.keys[envelope.KeyID]
+	if !ok {
+		return envelope, nil, fmt.Errorf("data encrypted with key %q, which isn't in the keyring", envelope.KeyID)
+	}
+
+	var dataKey []byte
+	dataKey, err = gcmOpen(key, envelope.WrappedKey, []byte(envelope.KeyID))
+	if err != nil {
+		return envelope, nil, fmt.Errorf("failed to decrypt the data key with key %q: %s", envelope.KeyID, err.Error())
+	}
+
+	return envelope, dataKey, nil
+}
+
+func gcmSeal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	var gcm, err = newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce = make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func gcmOpen(key []byte, sealed []byte, additionalData []byte) ([]byte, error) {
+	var gcm, err = newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted data too short")
+	}
+
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	var block, err = aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// writeStoredFile replaces the file with data, encrypted if there is a
+// keyring
+func writeStoredFile(path string, data []byte) error {
+	if storageKeys != nil {
+		var fileID, err = storedFileID(path)
+		if err == nil {
+			data, err = storageKeys.sealData(data, fileID)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return replaceFile(path, data)
+}
+
+// replaceFile writes data to a temporary file next to path, then renames it
+// over path, so the file is never half written
+func replaceFile(path string, data []byte) error {
+	var file, err = ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+// readStoredFile reads a file written by writeStoredFile, or a plaintext
+// file written before encryption was enabled if they're allowed
+func readStoredFile(path string) ([]byte, error) {
+	var data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, sealedPrefix) {
+		return data, checkPlaintext(path)
+	}
+
+	if storageKeys == nil {
+		return nil, fmt.Errorf("%s is encrypted, set ROC_FACE_STORAGE_KEYS or ROC_FACE_STORAGE_KEYS_FILE", path)
+	}
+
+	var fileID string
+	var envelope sealedEnvelope
+	var dataKey []byte
+	fileID, err = storedFileID(path)
+	if err == nil {
+		envelope, dataKey, err = storageKeys.open(data)
+	}
+
+	if err == nil {
+		data, err = openData(envelope, dataKey, fileID)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %s", path, err.Error())
+	}
+
+	return data, nil
+}
+
+// openData decrypts the data of an opened envelope, which must be the
+// file's
+func openData(envelope sealedEnvelope, dataKey []byte, fileID string) ([]byte, error) {
+	var data, err = gcmOpen(dataKey, envelope.Data, []byte(fileID))
+	if err != nil {
+		return nil, fmt.Errorf("the data isn't the one stored as %s: %s", fileID, err.Error())
+	}
+
+	return data, nil
+}
+
+// checkPlaintext returns an error for a plaintext file if there is a
+// keyring, unless they're allowed
+func checkPlaintext(path string) error {
+	if storageKeys != nil && !storageAllowPlaintext {
+		return fmt.Errorf("%s isn't encrypted, run rekey to encrypt it or set ROC_FACE_STORAGE_ALLOW_PLAINTEXT=true", path)
+	}
+
+	return nil
+}
+
+// openStoredImage returns the path of a plaintext copy of an encrypted
+// image, for the engine, or the path itself if it isn't encrypted
+func openStoredImage(path string) (string, error) {
+	var data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if !bytes.HasPrefix(data, sealedPrefix) {
+		return path, checkPlaintext(path)
+	}
+
+	data, err = readStoredFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	var tmpPath = genTmpPath()
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	return tmpPath, nil
+}
+
+// storeSettings are the settings of the files and directories of stored
+// data, with their defaults
+var storeSettings = []struct{ name, defaultPath string }{
+	{"ROC_FACE_JOBS_DIR", defaultJobsDir},
+	{"ROC_FACE_WEBHOOKS_FILE", defaultWebhooksFile},
+	{"ROC_FACE_CACHE_DIR", ""},
+	{"ROC_FACE_GALLERIES_FILE", ""},
+}
+
+// storedPaths are the files and directories of stored data, as configured
+// in the environment
+func storedPaths() []string {
+	var paths []string
+	for _, setting := range storeSettings {
+		var path = os.Getenv(setting.name)
+		if path == "" {
+			path = setting.defaultPath
+		}
+
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// checkStoredPaths returns an error if a store is in another one, where its
+// files would have two IDs
+func checkStoredPaths() error {
+	var roots []string
+	for _, path := range storedPaths() {
+		var root, err = filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+
+		for _, other := range roots {
+			if pathIn(root, other) || pathIn(other, root) {
+				return fmt.Errorf("the stores %s and %s overlap, move one of them out of the other", other, root)
+			}
+		}
+
+		roots = append(roots, root)
+	}
+
+	return nil
+}
+
+// pathIn returns whether the absolute path is root or in it
+func pathIn(path string, root string) bool {
+	var relPath, err = filepath.Rel(root, path)
+	return err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
+
+// storedFileID identifies a stored file in the data encrypted in it: the
+// setting of its store and its path in the store, e.g.
+// "ROC_FACE_JOBS_DIR:<id>/job.json", or its absolute path if it's in none
+func storedFileID(path string) (string, error) {
+	var absPath, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	for _, setting := range storeSettings {
+		var root = os.Getenv(setting.name)
+		if root == "" {
+			root = setting.defaultPath
+		}
+
+		if root == "" {
+			continue
+		}
+
+		root, err = filepath.Abs(root)
+		if err != nil {
+			return "", err
+		}
+
+		if pathIn(absPath, root) {
+			var relPath, _ = filepath.Rel(root, absPath)
+			return setting.name + ":" + filepath.ToSlash(relPath), nil
+		}
+	}
+
+	return filepath.ToSlash(absPath), nil
+}
+
+// rekeyFile rewraps the data key of a file with the active key, or encrypts
+// a plaintext file, and returns whether the file changed. The data itself
+// isn't encrypted again, only checked to be the file's.
+func (ring *keyring) rekeyFile(path string) (bool, error) {
+	var data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+
+	var fileID string
+	fileID, err = storedFileID(path)
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.HasPrefix(data, sealedPrefix) {
+		// a file swapped with another one is reported, not rewrapped
+		var envelope sealedEnvelope
+		var dataKey []byte
+		envelope, dataKey, err = ring.open(data)
+		if err == nil {
+			_, err = openData(envelope, dataKey, fileID)
+		}
+
+		if err != nil || envelope.KeyID == ring.active {
+			return false, err
+		}
+
+		data, err = ring.seal(envelope, dataKey)
+	} else {
+		data, err = ring.sealData(data, fileID)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	err = replaceFile(path, data)
+	return err == nil, err
+}
+
+// rekeyCommand rewraps every stored file with the active key, the server
+// being stopped, and returns the exit status
+func rekeyCommand(args []string) int {
+	var ring, err = loadKeyring()
+	if err == nil && ring == nil {
+		err = fmt.Errorf("no keyring, set ROC_FACE_STORAGE_KEYS or ROC_FACE_STORAGE_KEYS_FILE")
+	}
+
+	if err == nil {
+		err = checkStoredPaths()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	var paths = storedPaths()
+	if len(args) > 0 {
+		paths = args
+	}
+
+	var checked, rekeyed, failed = 0, 0, 0
+	for _, root := range paths {
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) && path == root {
+				return nil
+			}
+
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			checked++
+			var changed bool
+			changed, err = ring.rekeyFile(path)
+			if err != nil {
+				fmt.Printf("%s: %s\n", path, err.Error())
+				failed++
+			} else if changed {
+				rekeyed++
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 2
+		}
+	}
+
+	fmt.Printf("checked %d files in %s, %d now encrypted with key %s, %d failed\n",
+		checked, strings.Join(paths, ", "), rekeyed, ring.active, failed)
+	if failed > 0 {
+		return 1
+	}
+
+	return 0
+}
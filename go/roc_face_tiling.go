@@ -0,0 +1,132 @@
+// Tiled multi-scale detection, for small faces in large images (e.g. the
+// portrait on a high resolution ID document scan)
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+)
+
+const detectionModeStandard = "standard"
+const detectionModeTiled = "tiled"
+
+const tileSize = 1024
+
+// overlap between neighboring tiles, as a fraction of the tile size. Faces
+// larger than the overlap may be cut by tile edges, they are found at the
+// coarser scales instead.
+const tileOverlap = 0.25
+
+// detections overlapping more than this (intersection over union) are merged
+const nmsMaxOverlap = 0.3
+
+func validateDetectionMode(mode string) error {
+	if mode != detectionModeStandard && mode != detectionModeTiled {
+		return fmt.Errorf("unsupported detection mode %q, expected one of: %s, %s", mode, detectionModeStandard, detectionModeTiled)
+	}
+
+	return nil
+}
+
+// tileScales returns the scales to scan img at: full resolution, then halving
+// until the whole image fits in a single tile
+func tileScales(img image.Image) []float64 {
+	var bounds = img.Bounds()
+	var size = math.Max(float64(bounds.Dx()), float64(bounds.Dy()))
+	var scales = []float64{1}
+	for scale := 1.0; size*scale > tileSize; {
+		scale /= 2
+		scales = append(scales, scale)
+	}
+
+	return scales
+}
+
+// tileRects splits an image of size width x height into overlapping tiles
+func tileRects(width int, height int) []image.Rectangle {
+	var stride = int(tileSize * (1 - tileOverlap))
+	var rects []image.Rectangle
+	for y := 0; ; y += stride {
+		for x := 0; ; x += stride {
+			rects = append(rects, image.Rect(x, y, x+tileSize, y+tileSize).Intersect(image.Rect(0, 0, width, height)))
+			if x+tileSize >= width {
+				break
+			}
+		}
+
+		if y+tileSize >= height {
+			break
+		}
+	}
+
+	return rects
+}
+
+// tiledDetection runs detect on overlapping tiles of img at every scale,
+// maps the faces back to img coordinates and merges the duplicates. It
+// returns at most maxFaces faces, best first.
+func tiledDetection(img image.Image, maxFaces int, detect func(image.Image) []detectedFace) []detectedFace {
+	var bounds = img.Bounds()
+	var faces []detectedFace
+	for _, scale := range tileScales(img) {
+		var scaled = toRGBA(img)
+		if scale != 1 {
+			scaled = image.NewRGBA(image.Rect(0, 0, int(float64(bounds.Dx())*scale), int(float64(bounds.Dy())*scale)))
+			scaleInto(scaled, img)
+		}
+
+		var unscale = imageTransform{Orientation: 1, Scale: scale}
+		for _, rect := range tileRects(scaled.Rect.Dx(), scaled.Rect.Dy()) {
+			for _, face := range detect(scaled.SubImage(rect)) {
+				faces = append(faces, unscale.mapFaceBack(face.offset(rect.Min), bounds.Dx(), bounds.Dy()))
+			}
+		}
+	}
+
+	faces = suppressOverlapping(faces)
+	if len(faces) > maxFaces {
+		faces = faces[:maxFaces]
+	}
+
+	return faces
+}
+
+// suppressOverlapping is a non-maximum suppression: faces are sorted best
+// first, and faces overlapping a better one are dropped
+func suppressOverlapping(faces []detectedFace) []detectedFace {
+	sort.SliceStable(faces, func(i, j int) bool {
+		return betterFace(faces[i], faces[j])
+	})
+
+	var kept []detectedFace
+	for _, face := range faces {
+		var duplicate = false
+		for _, other := range kept {
+			if boxOverlap(face.Box, other.Box) > nmsMaxOverlap {
+				duplicate = true
+				break
+			}
+		}
+
+		if !duplicate {
+			kept = append(kept, face)
+		}
+	}
+
+	return kept
+}
+
+// boxOverlap is the intersection over union of two boxes
+func boxOverlap(a faceBox, b faceBox) float64 {
+	var intersection = a.rect().Intersect(b.rect())
+	var intersectionArea = float64(intersection.Dx() * intersection.Dy())
+	var unionArea = float64(a.Width*a.Height+b.Width*b.Height) - intersectionArea
+	if unionArea <= 0 {
+		return 0
+	}
+
+	return intersectionArea / unionArea
+}
@@ -0,0 +1,886 @@
+// Webhooks: callers register a callback URL for events, job.finished and
+// watchlist.hit, and the server POSTs them there:
+//
+//	POST   /webhooks                               {"url": ..., "events": [...]}, returns the secret
+//	GET    /webhooks                               registered webhooks
+//	DELETE /webhooks/{id}
+//	GET    /webhooks/{id}/deliveries               recent deliveries and their attempts
+//	GET    /webhooks/dead-letters                  deliveries that failed every attempt
+//	POST   /webhooks/dead-letters/{id}/retry
+//
+// A webhook belongs to the API key it was registered with (X-Api-Key or a
+// bearer Authorization header, none is a key too): it only receives the
+// events of that key's requests, jobs it created and searches it ran, and
+// only requests with that key see it, its deliveries and its dead letters,
+// or delete it.
+//
+// Deliveries are signed with the webhook's secret: the X-Roc-Face-Signature
+// header is "sha256=" and the hex HMAC-SHA256 of the X-Roc-Face-Timestamp
+// header, a ".", and the body. Failed deliveries (transport errors, non-2xx
+// statuses) are retried with exponential backoff, then dead-lettered. Dead
+// letters are kept for a week. Webhooks, pending deliveries and dead letters
+// are stored in ROC_FACE_WEBHOOKS_FILE, written in the background after
+// changes: the last ones may be lost if the server stops. The delivered
+// deliveries are kept in memory only.
+//
+// watchlist.hit is sent when a gallery search matches subjects with a
+// similarity of at least ROC_FACE_WATCHLIST_THRESHOLD (0.7 by default).
+//
+// Callback URLs can't reach loopback, link-local (e.g. cloud metadata),
+// private (RFC 1918, IPv6 unique local) or unspecified addresses, checked
+// when the webhook is registered and when each delivery connects. Deliveries
+// don't go through the HTTP_PROXY / HTTPS_PROXY proxy, which would connect
+// to the callback address unchecked. Set ROC_FACE_WEBHOOKS_ALLOW_LOOPBACK=true
+// to deliver to a receiver on the same host, e.g. in development, and
+// ROC_FACE_WEBHOOKS_ALLOW_PRIVATE=true to deliver to the private network.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+)
+
+const defaultWebhooksFile = "/tmp/roc-face-webhooks.json"
+
+const webhookEventJobFinished = "job.finished"
+const webhookEventWatchlistHit = "watchlist.hit"
+
+var webhookEvents = []string{webhookEventJobFinished, webhookEventWatchlistHit}
+
+// similarity from which a search match is a watchlist hit
+const defaultWatchlistThreshold = 0.7
+
+const webhookSignatureHeader = "X-Roc-Face-Signature"
+const webhookTimestampHeader = "X-Roc-Face-Timestamp"
+const webhookEventHeader = "X-Roc-Face-Event"
+const webhookDeliveryHeader = "X-Roc-Face-Delivery"
+
+const defaultWebhookMaxAttempts = 8
+const defaultWebhookMinBackoff = time.Second
+const defaultWebhookMaxBackoff = 10 * time.Minute
+const webhookTimeout = 10 * time.Second
+
+// delivered deliveries kept for GET /webhooks/{id}/deliveries
+const webhookDeliveryHistory = 1000
+
+// dead letters are kept for this long after their last attempt, and at most
+// this many
+const webhookDeadLetterTTL = 7 * 24 * time.Hour
+const webhookDeadLetterHistory = 1000
+
+const deliveryStatusPending = "pending"
+const deliveryStatusDelivered = "delivered"
+const deliveryStatusFailed = "failed"
+
+type webhook struct {
+	ID     string   `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+
+	// fingerprint of the API key that registered the webhook, as in the
+	// audit log
+	APIKey string `json:"apiKey,omitempty"`
+
+	// only returned when the webhook is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type webhookDelivery struct {
+	ID        string           `json:"id"`
+	WebhookID string           `json:"webhookId"`
+	Event     string           `json:"event"`
+	Payload   json.RawMessage  `json:"payload"`
+	Status    string           `json:"status"`
+	Attempts  []webhookAttempt `json:"attempts"`
+	CreatedAt time.Time        `json:"createdAt"`
+}
+
+type webhookAttempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// webhookBody is what is POSTed to the callback URL
+type webhookBody struct {
+	ID        string          `json:"id"`
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// watchlistHit is the data of a watchlist.hit event
+type watchlistHit struct {
+	Gallery   string         `json:"gallery"`
+	Threshold float32        `json:"threshold"`
+	Matches   []galleryMatch `json:"matches"`
+
+	// hash of the probe image or template, as in the audit log
+	ProbeHash string    `json:"probeHash"`
+	At        time.Time `json:"at"`
+}
+
+// webhookState is the content of the webhooks file
+type webhookState struct {
+	Webhooks   []*webhook         `json:"webhooks"`
+	Deliveries []*webhookDelivery `json:"deliveries"`
+}
+
+type webhookDispatcher struct {
+	mutex      sync.Mutex
+	path       string
+	client     *http.Client
+	webhooks   map[string]*webhook
+	deliveries map[string]*webhookDelivery
+
+	// the state changed since it was last written, and a writer is running;
+	// written is signaled when it stops
+	dirty   bool
+	writing bool
+	written *sync.Cond
+
+	MaxAttempts        int
+	MinBackoff         time.Duration
+	MaxBackoff         time.Duration
+	WatchlistThreshold float32
+
+	// callback URLs may reach loopback, or private addresses
+	allowLoopback bool
+	allowPrivate  bool
+}
+
+// webhooks delivers the server's events, set by enableWebhooks
+var webhooks *webhookDispatcher
+
+func newWebhookDispatcher(path string, allowLoopback bool) *webhookDispatcher {
+	var dispatcher = &webhookDispatcher{
+		path:               path,
+		webhooks:           map[string]*webhook{},
+		deliveries:         map[string]*webhookDelivery{},
+		MaxAttempts:        defaultWebhookMaxAttempts,
+		MinBackoff:         defaultWebhookMinBackoff,
+		MaxBackoff:         defaultWebhookMaxBackoff,
+		WatchlistThreshold: defaultWatchlistThreshold,
+		allowLoopback:      allowLoopback,
+	}
+
+	dispatcher.written = sync.NewCond(&dispatcher.mutex)
+
+	// the address is checked once resolved, so redirects and DNS changes
+	// can't reach the blocked addresses either. There's no proxy, whose
+	// address would be checked instead.
+	var dialer = &net.Dialer{Timeout: webhookTimeout, Control: dispatcher.checkDial}
+	dispatcher.client = &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+	}
+
+	return dispatcher
+}
+
+// enableWebhooks adds the /webhooks routes to the router, and resumes the
+// pending deliveries
+func enableWebhooks(r *mux.Router) error {
+	var path = os.Getenv("ROC_FACE_WEBHOOKS_FILE")
+	if path == "" {
+		path = defaultWebhooksFile
+	}
+
+	var allowLoopback = false
+	var err error
+	if value := os.Getenv("ROC_FACE_WEBHOOKS_ALLOW_LOOPBACK"); value != "" {
+		allowLoopback, err = strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid ROC_FACE_WEBHOOKS_ALLOW_LOOPBACK %q, expected true or false", value)
+		}
+	}
+
+	var dispatcher = newWebhookDispatcher(path, allowLoopback)
+	if value := os.Getenv("ROC_FACE_WEBHOOKS_ALLOW_PRIVATE"); value != "" {
+		dispatcher.allowPrivate, err = strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid ROC_FACE_WEBHOOKS_ALLOW_PRIVATE %q, expected true or false", value)
+		}
+	}
+
+	if value := os.Getenv("ROC_FACE_WATCHLIST_THRESHOLD"); value != "" {
+		var threshold float64
+		threshold, err = strconv.ParseFloat(value, 32)
+		if err != nil {
+			return fmt.Errorf("invalid ROC_FACE_WATCHLIST_THRESHOLD %q, expected a number", value)
+		}
+
+		dispatcher.WatchlistThreshold = float32(threshold)
+	}
+
+	err = dispatcher.load()
+	if err != nil {
+		return err
+	}
+
+	webhooks = dispatcher
+	dispatcher.routes(r)
+	return nil
+}
+
+func (dispatcher *webhookDispatcher) routes(r *mux.Router) {
+	// before /webhooks/{id}
+	r.HandleFunc("/webhooks/dead-letters", dispatcher.deadLettersHandler).Methods("GET")
+	r.HandleFunc("/webhooks/dead-letters/{id}/retry", dispatcher.retryHandler).Methods("POST")
+	r.HandleFunc("/webhooks", dispatcher.createHandler).Methods("POST")
+	r.HandleFunc("/webhooks", dispatcher.listHandler).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", dispatcher.deleteHandler).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id}/deliveries", dispatcher.deliveriesHandler).Methods("GET")
+}
+
+func (dispatcher *webhookDispatcher) load() error {
+	var data, err = readStoredFile(dispatcher.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	var state webhookState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return fmt.Errorf("invalid webhooks file %s: %s", dispatcher.path, err.Error())
+	}
+
+	for _, hook := range state.Webhooks {
+		dispatcher.webhooks[hook.ID] = hook
+	}
+
+	var pending = 0
+	for _, delivery := range state.Deliveries {
+		dispatcher.deliveries[delivery.ID] = delivery
+		if delivery.Status == deliveryStatusPending {
+			pending++
+			go dispatcher.deliver(delivery)
+		}
+	}
+
+	dispatcher.prune(time.Now())
+
+	log.Println("loaded", len(state.Webhooks), "webhooks,", pending, "pending deliveries")
+	return nil
+}
+
+// save has the webhooks and the deliveries not delivered yet written to the
+// file, the mutex being held. The changes made while a write is running are
+// written together once it's done.
+func (dispatcher *webhookDispatcher) save() {
+	dispatcher.dirty = true
+	if !dispatcher.writing {
+		dispatcher.writing = true
+		go dispatcher.write()
+	}
+}
+
+// write writes the state until it doesn't change anymore, out of the mutex
+func (dispatcher *webhookDispatcher) write() {
+	dispatcher.mutex.Lock()
+	for dispatcher.dirty {
+		dispatcher.dirty = false
+		var state = webhookState{Webhooks: []*webhook{}, Deliveries: []*webhookDelivery{}}
+		for _, hook := range dispatcher.webhooks {
+			state.Webhooks = append(state.Webhooks, hook)
+		}
+
+		for _, delivery := range dispatcher.deliveries {
+			if delivery.Status != deliveryStatusDelivered {
+				state.Deliveries = append(state.Deliveries, delivery)
+			}
+		}
+
+		var data, err = json.Marshal(state)
+		dispatcher.mutex.Unlock()
+		if err == nil {
+			err = writeStoredFile(dispatcher.path, data)
+		}
+
+		if err != nil {
+			log.Println("failed to save webhooks, error:", err.Error())
+		}
+
+		dispatcher.mutex.Lock()
+	}
+
+	dispatcher.writing = false
+	dispatcher.written.Broadcast()
+	dispatcher.mutex.Unlock()
+}
+
+// waitWritten waits until the changes are written
+func (dispatcher *webhookDispatcher) waitWritten() {
+	dispatcher.mutex.Lock()
+	for dispatcher.writing {
+		dispatcher.written.Wait()
+	}
+
+	dispatcher.mutex.Unlock()
+}
+
+func (dispatcher *webhookDispatcher) createHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("POST /webhooks")
+	var hook webhook
+	var err = json.NewDecoder(r.Body).Decode(&hook)
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	var callback *url.URL
+	callback, err = url.Parse(hook.URL)
+	if err != nil || (callback.Scheme != "http" && callback.Scheme != "https") || callback.Host == "" {
+		sendError(w, fmt.Errorf("expected an http(s) callback url"))
+		return
+	}
+
+	err = dispatcher.checkHost(callback.Hostname())
+	if err != nil {
+		sendError(w, err)
+		return
+	}
+
+	if len(hook.Events) == 0 {
+		hook.Events = webhookEvents
+	}
+
+	for _, event := range hook.Events {
+		if !containsString(webhookEvents, event) {
+			sendError(w, fmt.Errorf("unsupported event %q, expected one of: %s", event, strings.Join(webhookEvents, ", ")))
+			return
+		}
+	}
+
+	hook.APIKey = requestAPIKey(r)
+	hook.ID = newID()
+	hook.Secret = newID() + newID()
+	hook.CreatedAt = time.Now().UTC()
+	dispatcher.mutex.Lock()
+	dispatcher.webhooks[hook.ID] = &hook
+	dispatcher.save()
+	dispatcher.mutex.Unlock()
+
+	log.Println("registered webhook", hook.ID, "for", hook.Events)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hook)
+}
+
+func (dispatcher *webhookDispatcher) listHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("GET /webhooks")
+	var apiKey = requestAPIKey(r)
+	dispatcher.mutex.Lock()
+	var hooks = []webhook{}
+	for _, hook := range dispatcher.webhooks {
+		if hook.APIKey != apiKey {
+			continue
+		}
+
+		var listed = *hook
+		listed.Secret = ""
+		hooks = append(hooks, listed)
+	}
+
+	dispatcher.mutex.Unlock()
+	sort.Slice(hooks, func(i, k int) bool {
+		return hooks[i].CreatedAt.Before(hooks[k].CreatedAt)
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(hooks)
+}
+
+func (dispatcher *webhookDispatcher) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	var id = mux.Vars(r)["id"]
+	log.Println("DELETE /webhooks/" + id)
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	if !dispatcher.owns(requestAPIKey(r), id) {
+		sendNotFound(w, fmt.Sprintf("webhook %q not found", id))
+		return
+	}
+
+	// pending deliveries stop at their next attempt
+	delete(dispatcher.webhooks, id)
+	for deliveryID, delivery := range dispatcher.deliveries {
+		if delivery.WebhookID == id && delivery.Status != deliveryStatusPending {
+			delete(dispatcher.deliveries, deliveryID)
+		}
+	}
+
+	dispatcher.save()
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"id": id})
+}
+
+func (dispatcher *webhookDispatcher) deliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	var id = mux.Vars(r)["id"]
+	log.Println("GET /webhooks/" + id + "/deliveries")
+	var apiKey = requestAPIKey(r)
+	dispatcher.mutex.Lock()
+	var owned = dispatcher.owns(apiKey, id)
+	dispatcher.mutex.Unlock()
+	if !owned {
+		sendNotFound(w, fmt.Sprintf("webhook %q not found", id))
+		return
+	}
+
+	dispatcher.sendDeliveries(w, func(delivery *webhookDelivery) bool {
+		return delivery.WebhookID == id
+	})
+}
+
+func (dispatcher *webhookDispatcher) deadLettersHandler(w http.ResponseWriter, r *http.Request) {
+	log.Println("GET /webhooks/dead-letters")
+	var apiKey = requestAPIKey(r)
+	dispatcher.sendDeliveries(w, func(delivery *webhookDelivery) bool {
+		return delivery.Status == deliveryStatusFailed && dispatcher.owns(apiKey, delivery.WebhookID)
+	})
+}
+
+// owns tells whether the webhook exists and belongs to the API key, the
+// mutex being held
+func (dispatcher *webhookDispatcher) owns(apiKey string, webhookID string) bool {
+	var hook, ok = dispatcher.webhooks[webhookID]
+	return ok && hook.APIKey == apiKey
+}
+
+// sendDeliveries responds with the matching deliveries, newest first. match
+// is called with the mutex held.
+func (dispatcher *webhookDispatcher) sendDeliveries(w http.ResponseWriter, match func(delivery *webhookDelivery) bool) {
+	dispatcher.mutex.Lock()
+	var deliveries = []webhookDelivery{}
+	for _, delivery := range dispatcher.deliveries {
+		if match(delivery) {
+			deliveries = append(deliveries, *delivery)
+		}
+	}
+
+	dispatcher.mutex.Unlock()
+	sort.Slice(deliveries, func(i, k int) bool {
+		return deliveries[i].CreatedAt.After(deliveries[k].CreatedAt)
+	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// retryHandler delivers a dead letter again, with a new set of attempts
+func (dispatcher *webhookDispatcher) retryHandler(w http.ResponseWriter, r *http.Request) {
+	var id = mux.Vars(r)["id"]
+	log.Println("POST /webhooks/dead-letters/" + id + "/retry")
+	dispatcher.mutex.Lock()
+	var delivery, ok = dispatcher.deliveries[id]
+	if !ok || delivery.Status != deliveryStatusFailed || !dispatcher.owns(requestAPIKey(r), delivery.WebhookID) {
+		dispatcher.mutex.Unlock()
+		sendNotFound(w, fmt.Sprintf("dead letter %q not found", id))
+		return
+	}
+
+	delivery.Status = deliveryStatusPending
+	delivery.Attempts = nil
+	dispatcher.save()
+	var response = *delivery
+	dispatcher.mutex.Unlock()
+
+	go dispatcher.deliver(delivery)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(response)
+}
+
+// notify queues a delivery of the event to every webhook of the API key
+// subscribed to it
+func (dispatcher *webhookDispatcher) notify(event string, apiKey string, data interface{}) {
+	var payload, err = json.Marshal(data)
+	if err != nil {
+		log.Println("failed to encode", event, "event, error:", err.Error())
+		return
+	}
+
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	for _, hook := range dispatcher.webhooks {
+		if hook.APIKey != apiKey || !containsString(hook.Events, event) {
+			continue
+		}
+
+		var delivery = &webhookDelivery{
+			ID:        newID(),
+			WebhookID: hook.ID,
+			Event:     event,
+			Payload:   payload,
+			Status:    deliveryStatusPending,
+			Attempts:  []webhookAttempt{},
+			CreatedAt: time.Now().UTC(),
+		}
+
+		dispatcher.deliveries[delivery.ID] = delivery
+		go dispatcher.deliver(delivery)
+	}
+
+	dispatcher.prune(time.Now())
+	dispatcher.save()
+}
+
+// notifyWatchlistHits sends a watchlist.hit event with the matches of a
+// search by the API key at or above the watchlist threshold, if any
+func (dispatcher *webhookDispatcher) notifyWatchlistHits(apiKey string, gallery string, matches []galleryMatch, probeHash string) {
+	var hit = watchlistHit{Gallery: gallery, Threshold: dispatcher.WatchlistThreshold, ProbeHash: probeHash, At: time.Now().UTC()}
+	for _, match := range matches {
+		if match.Similarity >= dispatcher.WatchlistThreshold {
+			hit.Matches = append(hit.Matches, match)
+		}
+	}
+
+	if len(hit.Matches) > 0 {
+		log.Println("watchlist hit in gallery", gallery, "for", len(hit.Matches), "subjects")
+		dispatcher.notify(webhookEventWatchlistHit, apiKey, hit)
+	}
+}
+
+// redactJob replaces the payload of the deliveries of a job with the job
+// redacted, after a subject was erased from it
+func (dispatcher *webhookDispatcher) redactJob(j *job) {
+	var payload, err = json.Marshal(j)
+	if err != nil {
+		log.Println("failed to encode job", j.ID, "error:", err.Error())
+		return
+	}
+
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	var redacted = 0
+	for _, delivery := range dispatcher.deliveries {
+		var delivered struct {
+			ID string `json:"id"`
+		}
+
+		if delivery.Event != webhookEventJobFinished || json.Unmarshal(delivery.Payload, &delivered) != nil || delivered.ID != j.ID {
+			continue
+		}
+
+		delivery.Payload = payload
+		redacted++
+	}
+
+	if redacted > 0 {
+		dispatcher.save()
+	}
+}
+
+// redactSubject removes an erased subject from the watchlist hits kept, and
+// drops the hits left without matches. It returns the number of deliveries
+// redacted.
+func (dispatcher *webhookDispatcher) redactSubject(subjectID string) int {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	var redacted = 0
+	for id, delivery := range dispatcher.deliveries {
+		var hit watchlistHit
+		if delivery.Event != webhookEventWatchlistHit || json.Unmarshal(delivery.Payload, &hit) != nil {
+			continue
+		}
+
+		var kept = hit.Matches[:0]
+		for _, match := range hit.Matches {
+			if match.SubjectID != subjectID {
+				kept = append(kept, match)
+			}
+		}
+
+		if len(kept) == len(hit.Matches) {
+			continue
+		}
+
+		redacted++
+		if len(kept) == 0 {
+			// a pending delivery stops at its next attempt
+			delete(dispatcher.deliveries, id)
+			continue
+		}
+
+		hit.Matches = kept
+		var payload, err = json.Marshal(hit)
+		if err != nil {
+			delete(dispatcher.deliveries, id)
+			continue
+		}
+
+		delivery.Payload = payload
+	}
+
+	if redacted > 0 {
+		dispatcher.save()
+	}
+
+	return redacted
+}
+
+// prune drops the oldest delivered deliveries beyond the history size, and
+// the dead letters past their TTL or beyond theirs, the mutex being held
+func (dispatcher *webhookDispatcher) prune(now time.Time) {
+	var delivered, failed []*webhookDelivery
+	for _, delivery := range dispatcher.deliveries {
+		switch delivery.Status {
+		case deliveryStatusDelivered:
+			delivered = append(delivered, delivery)
+		case deliveryStatusFailed:
+			if now.Sub(delivery.lastAttemptAt()) > webhookDeadLetterTTL {
+				delete(dispatcher.deliveries, delivery.ID)
+			} else {
+				failed = append(failed, delivery)
+			}
+		}
+	}
+
+	for _, list := range []struct {
+		deliveries []*webhookDelivery
+		max        int
+	}{{delivered, webhookDeliveryHistory}, {failed, webhookDeadLetterHistory}} {
+		if len(list.deliveries) <= list.max {
+			continue
+		}
+
+		sort.Slice(list.deliveries, func(i, k int) bool {
+			return list.deliveries[i].CreatedAt.Before(list.deliveries[k].CreatedAt)
+		})
+
+		for _, delivery := range list.deliveries[:len(list.deliveries)-list.max] {
+			delete(dispatcher.deliveries, delivery.ID)
+		}
+	}
+}
+
+func (delivery *webhookDelivery) lastAttemptAt() time.Time {
+	if len(delivery.Attempts) == 0 {
+		return delivery.CreatedAt
+	}
+
+	return delivery.Attempts[len(delivery.Attempts)-1].At
+}
+
+// deliver POSTs the delivery until it succeeds or runs out of attempts
+func (dispatcher *webhookDispatcher) deliver(delivery *webhookDelivery) {
+	for {
+		dispatcher.mutex.Lock()
+		var hook, ok = dispatcher.webhooks[delivery.WebhookID]
+		if _, kept := dispatcher.deliveries[delivery.ID]; !kept {
+			// redacted away
+			dispatcher.mutex.Unlock()
+			return
+		}
+
+		if !ok {
+			// the webhook was deleted
+			delete(dispatcher.deliveries, delivery.ID)
+			dispatcher.save()
+			dispatcher.mutex.Unlock()
+			return
+		}
+
+		var attempt = len(delivery.Attempts)
+		var body = webhookBody{ID: delivery.ID, Event: delivery.Event, CreatedAt: delivery.CreatedAt, Data: delivery.Payload}
+		dispatcher.mutex.Unlock()
+
+		if attempt > 0 {
+			time.Sleep(dispatcher.backoff(attempt))
+		}
+
+		var result = dispatcher.post(hook.URL, hook.Secret, body)
+		dispatcher.mutex.Lock()
+		delivery.Attempts = append(delivery.Attempts, result)
+		var done = result.Error == "" && result.StatusCode >= 200 && result.StatusCode < 300
+		if done {
+			delivery.Status = deliveryStatusDelivered
+		} else if len(delivery.Attempts) >= dispatcher.MaxAttempts {
+			log.Println("webhook delivery", delivery.ID, "failed", len(delivery.Attempts), "times, dead-lettering it")
+			delivery.Status = deliveryStatusFailed
+			dispatcher.prune(time.Now())
+			done = true
+		}
+
+		dispatcher.save()
+		dispatcher.mutex.Unlock()
+		if done {
+			return
+		}
+	}
+}
+
+// backoff is the delay before the given attempt
+func (dispatcher *webhookDispatcher) backoff(attempt int) time.Duration {
+	var delay = dispatcher.MinBackoff
+	for i := 1; i < attempt && delay < dispatcher.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > dispatcher.MaxBackoff {
+		delay = dispatcher.MaxBackoff
+	}
+
+	return delay
+}
+
+func (dispatcher *webhookDispatcher) post(callbackURL string, secret string, body webhookBody) webhookAttempt {
+	var result = webhookAttempt{At: time.Now().UTC()}
+	var data, err = json.Marshal(body)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var req *http.Request
+	req, err = http.NewRequest("POST", callbackURL, bytes.NewReader(data))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var timestamp = strconv.FormatInt(result.At.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, body.Event)
+	req.Header.Set(webhookDeliveryHeader, body.ID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(secret, timestamp, data))
+
+	var res *http.Response
+	res, err = dispatcher.client.Do(req)
+	if err != nil {
+		log.Println("webhook delivery", body.ID, "failed:", err.Error())
+		result.Error = err.Error()
+		return result
+	}
+
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	result.StatusCode = res.StatusCode
+	return result
+}
+
+// checkHost returns an error if a callback host resolves to an address
+// deliveries can't reach
+func (dispatcher *webhookDispatcher) checkHost(host string) error {
+	var ips, err = net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("can't resolve callback host %q: %s", host, err.Error())
+	}
+
+	for _, ip := range ips {
+		err = dispatcher.checkIP(ip)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkDial is the dialer's Control function, it checks the address
+// deliveries connect to
+func (dispatcher *webhookDispatcher) checkDial(network string, address string, conn syscall.RawConn) error {
+	var host, _, err = net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	var ip = net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("unexpected callback address %q", address)
+	}
+
+	return dispatcher.checkIP(ip)
+}
+
+func (dispatcher *webhookDispatcher) checkIP(ip net.IP) error {
+	var blocked = ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+	if ip.IsLoopback() && !dispatcher.allowLoopback {
+		blocked = true
+	}
+
+	if isPrivateIP(ip) && !dispatcher.allowPrivate {
+		blocked = true
+	}
+
+	if blocked {
+		return fmt.Errorf("callback address %s is loopback, link-local, private or unspecified, which webhooks can't reach", ip)
+	}
+
+	return nil
+}
+
+// privateNetworks are the RFC 1918 and IPv6 unique local ranges
+var privateNetworks = parseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		var _, network, err = net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signWebhook is the signature header value of a delivery
+func signWebhook(secret string, timestamp string, body []byte) string {
+	var mac = hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func sendNotFound(w http.ResponseWriter, message string) {
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(errorResponseObj{Message: message})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,722 @@
+// The gRPC API (see rocfacepb/roc_face.proto), served on ROC_FACE_GRPC_PORT
+// next to the HTTP API, running the same pipelines on the same engine. It
+// isn't one of the roc_face_*.go files because it needs grpc: serve.sh and
+// mock.sh add it to the build when ROC_FACE_GRPC_PORT is set.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	// Third party packages
+	"github.com/mvayngrib/roc-face/go/rocfacepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func init() {
+	serveGRPC = func(listener net.Listener) error {
+		return newGRPCServer().Serve(listener)
+	}
+}
+
+func newGRPCServer() *grpc.Server {
+	var server = grpc.NewServer(grpc.MaxRecvMsgSize(_128M))
+	rocfacepb.RegisterRocFaceServer(server, grpcServer{})
+	return server
+}
+
+type grpcServer struct {
+	rocfacepb.UnimplementedRocFaceServer
+}
+
+func (grpcServer) Verify(ctx context.Context, req *rocfacepb.VerifyRequest) (*rocfacepb.VerifyResponse, error) {
+	log.Println("gRPC Verify")
+	var opts, err = verifyOptionsFromProto(req.GetOptions())
+	if err != nil {
+		return nil, invalidArgument(err)
+	}
+
+	var result verificationResult
+	err = withImageFiles([][]byte{req.GetImage1(), req.GetImage2()}, func(filePaths []string) error {
+		result = verify(filePaths, opts)
+		return grpcAuditError(auditVerification(grpcAPIKey(ctx), grpcRoute(ctx), filePaths, opts, result))
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return verificationResultToProto(result), nil
+}
+
+func (grpcServer) Analyze(ctx context.Context, req *rocfacepb.AnalyzeRequest) (*rocfacepb.AnalyzeResponse, error) {
+	log.Println("gRPC Analyze")
+	var opts, err = analyzeOptionsFromProto(req.GetOptions())
+	if err != nil {
+		return nil, invalidArgument(err)
+	}
+
+	var result analysisResult
+	err = withImageFiles([][]byte{req.GetImage()}, func(filePaths []string) error {
+		result = analyze(filePaths[0], opts)
+		return grpcAuditError(auditAnalysis(grpcAPIKey(ctx), grpcRoute(ctx), filePaths[0], opts, result))
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return analysisResultToProto(result)
+}
+
+func (grpcServer) ExtractTemplate(ctx context.Context, req *rocfacepb.ExtractTemplateRequest) (*rocfacepb.ExtractTemplateResponse, error) {
+	log.Println("gRPC ExtractTemplate")
+	var result, err = extractTemplates(req.GetImage(), req.GetOptions(), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var response = &rocfacepb.ExtractTemplateResponse{Code: result.Code, Message: result.Message}
+	for _, face := range result.faces {
+		response.Faces = append(response.Faces, &rocfacepb.FaceTemplate{
+			Box:      faceBoxToProto(face.Box),
+			Template: face.Template,
+		})
+	}
+
+	return response, nil
+}
+
+func (grpcServer) Compare(ctx context.Context, req *rocfacepb.CompareRequest) (*rocfacepb.CompareResponse, error) {
+	log.Println("gRPC Compare")
+	if len(req.GetTemplate1()) == 0 || len(req.GetTemplate2()) == 0 {
+		return nil, invalidArgument(fmt.Errorf("expected two templates"))
+	}
+
+	for _, template := range [][]byte{req.GetTemplate1(), req.GetTemplate2()} {
+		if err := engine.checkTemplate(template); err != nil {
+			return nil, invalidArgument(err)
+		}
+	}
+
+	var similarity = engine.compareTemplates(req.GetTemplate1(), req.GetTemplate2())
+	var err = auditGRPC(ctx, auditEntry{
+		TemplateHashes: []string{hashBytes(req.GetTemplate1()), hashBytes(req.GetTemplate2())},
+		Similarity:     &similarity,
+		Decision:       auditDecisionCompared,
+	}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &rocfacepb.CompareResponse{Similarity: similarity}, nil
+}
+
+// faces Enroll detects at least, enough to tell whether there is more than
+// one
+const enrollFacesToDetect = 2
+
+func (grpcServer) Enroll(ctx context.Context, req *rocfacepb.EnrollRequest) (*rocfacepb.EnrollResponse, error) {
+	log.Println("gRPC Enroll")
+	var response = &rocfacepb.EnrollResponse{
+		Gallery:   stringOrDefault(req.GetGallery(), defaultGallery),
+		SubjectId: req.GetSubjectId(),
+	}
+
+	var template = req.GetTemplate()
+	if req.GetFace() == nil {
+		return nil, invalidArgument(fmt.Errorf("expected an image or a template"))
+	}
+
+	if response.SubjectId == "" {
+		return nil, invalidArgument(fmt.Errorf("expected a subject ID"))
+	}
+
+	if template != nil {
+		if err := engine.checkTemplate(template); err != nil {
+			return nil, invalidArgument(err)
+		}
+	}
+
+	var entry = auditEntry{Subjects: []string{response.SubjectId}, Decision: auditDecisionEnrolled}
+	var params = map[string]interface{}{"gallery": response.Gallery, "options": req.GetOptions()}
+	if template == nil {
+		var result, err = extractTemplates(req.GetImage(), req.GetOptions(), enrollFacesToDetect)
+		if err != nil {
+			return nil, err
+		}
+
+		// someone else's face mustn't be enrolled under the subject ID
+		if result.Code == "" && len(result.faces) > 1 {
+			result.Code = "MultipleFacesDetected"
+			result.Message = fmt.Sprintf("Found %d faces in the image", len(result.faces))
+			log.Println(result.Message)
+		}
+
+		if result.Code != "" {
+			response.Code = result.Code
+			response.Message = result.Message
+			entry.Decision = result.Code
+			return response, auditGRPC(ctx, withInputHash(entry, req.GetImage(), nil), params)
+		}
+
+		template = result.faces[0].Template
+		response.Box = faceBoxToProto(result.faces[0].Box)
+	}
+
+	var count, err = galleries.enroll(response.Gallery, response.SubjectId, template)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	err = auditGRPC(ctx, withInputHash(entry, req.GetImage(), req.GetTemplate()), params)
+	if err != nil {
+		return nil, err
+	}
+
+	response.TemplateCount = int32(count)
+	return response, nil
+}
+
+func (grpcServer) Search(ctx context.Context, req *rocfacepb.SearchRequest) (*rocfacepb.SearchResponse, error) {
+	log.Println("gRPC Search")
+	var response = &rocfacepb.SearchResponse{}
+	var probe = req.GetTemplate()
+	if req.GetProbe() == nil {
+		return nil, invalidArgument(fmt.Errorf("expected an image or a template"))
+	}
+
+	if probe != nil {
+		if err := engine.checkTemplate(probe); err != nil {
+			return nil, invalidArgument(err)
+		}
+	}
+
+	var gallery = stringOrDefault(req.GetGallery(), defaultGallery)
+	var maxResults, err = countOrDefault(req.GetMaxResults(), defaultSearchResults, "max_results")
+	if err != nil {
+		return nil, invalidArgument(err)
+	}
+
+	var entry = withInputHash(auditEntry{Decision: auditDecisionNoMatch}, req.GetImage(), req.GetTemplate())
+	var params = map[string]interface{}{
+		"gallery":    gallery,
+		"maxResults": maxResults,
+		"threshold":  req.GetThreshold(),
+		"options":    req.GetOptions(),
+	}
+
+	if probe == nil {
+		var result analysisResult
+		result, err = extractTemplates(req.GetImage(), req.GetOptions(), 0)
+		if err != nil {
+			return nil, err
+		}
+
+		if result.Code != "" {
+			response.Code = result.Code
+			response.Message = result.Message
+			entry.Decision = result.Code
+			return response, auditGRPC(ctx, entry, params)
+		}
+
+		probe = result.faces[0].Template
+	}
+
+	var matches []galleryMatch
+	matches, err = galleries.search(gallery, probe, maxResults, req.GetThreshold())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	for _, match := range matches {
+		response.Matches = append(response.Matches, &rocfacepb.SearchMatch{
+			SubjectId:  match.SubjectID,
+			Similarity: match.Similarity,
+		})
+
+		entry.Subjects = append(entry.Subjects, match.SubjectID)
+	}
+
+	if len(matches) > 0 {
+		entry.Decision = auditDecisionMatched
+		entry.Similarity = &matches[0].Similarity
+	}
+
+	err = auditGRPC(ctx, entry, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if webhooks != nil {
+		var probeHashes = append(entry.TemplateHashes, entry.ImageHashes...)
+		webhooks.notifyWatchlistHits(grpcAPIKey(ctx), gallery, matches, probeHashes[0])
+	}
+
+	return response, nil
+}
+
+// frames recorded in an audit entry of an AnalyzeVideo stream, longer streams
+// are recorded in several entries
+const videoAuditFrames = 1000
+
+// videoAuditParams are the params of the audit entries of an AnalyzeVideo
+// stream: the decision of each frame, in the order of the image hashes
+type videoAuditParams struct {
+	Options    analyzeOptions `json:"options"`
+	FirstFrame int            `json:"firstFrame"`
+	Decisions  []string       `json:"decisions"`
+}
+
+// AnalyzeVideo records the frames it analyzed in the audit log when the
+// stream ends, whether it succeeded or not
+func (grpcServer) AnalyzeVideo(stream rocfacepb.RocFace_AnalyzeVideoServer) (err error) {
+	log.Println("gRPC AnalyzeVideo")
+	var opts analyzeOptions
+	var entry = auditEntry{Decision: auditDecisionAnalyzed}
+	var params videoAuditParams
+	var flush = func() error {
+		if len(entry.ImageHashes) == 0 {
+			return nil
+		}
+
+		params.Options = opts
+		var err = auditGRPC(stream.Context(), entry, params)
+		entry.ImageHashes = nil
+		params.Decisions = nil
+		return err
+	}
+
+	defer func() {
+		var auditErr = flush()
+		if err == nil {
+			err = auditErr
+		}
+	}()
+
+	for frames := 0; ; frames++ {
+		var frame *rocfacepb.VideoFrame
+		frame, err = stream.Recv()
+		if err == io.EOF {
+			log.Println("analyzed", frames, "frames")
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if frames == 0 {
+			opts, err = analyzeOptionsFromProto(frame.GetOptions())
+			if err != nil {
+				return invalidArgument(err)
+			}
+		}
+
+		var result analysisResult
+		var hashes []string
+		err = withImageFiles([][]byte{frame.GetImage()}, func(filePaths []string) error {
+			result = analyze(filePaths[0], opts)
+			var err error
+			hashes, err = hashFiles(filePaths)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if len(entry.ImageHashes) == 0 {
+			params.FirstFrame = frames
+		}
+
+		entry.ImageHashes = append(entry.ImageHashes, hashes...)
+		params.Decisions = append(params.Decisions, analysisDecision(result))
+		var analysis *rocfacepb.AnalyzeResponse
+		analysis, err = analysisResultToProto(result)
+		if err != nil {
+			return err
+		}
+
+		err = stream.Send(&rocfacepb.FrameAnalysis{TimestampMs: frame.GetTimestampMs(), Analysis: analysis})
+		if err != nil {
+			return err
+		}
+
+		if len(entry.ImageHashes) == videoAuditFrames {
+			err = flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// withImageFiles saves the images to temporary files, the pipelines reading
+// images from disk, and deletes them once run returns
+func withImageFiles(images [][]byte, run func(filePaths []string) error) error {
+	var filePaths []string
+	defer func() {
+		deleteFiles(filePaths)
+	}()
+
+	for _, data := range images {
+		if len(data) == 0 {
+			return invalidArgument(fmt.Errorf("expected an image"))
+		}
+
+		var filePath, err = saveImage(data)
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+
+		filePaths = append(filePaths, filePath)
+	}
+
+	return run(filePaths)
+}
+
+// extractTemplates finds the faces in an image and represents them, at
+// least minFacesToDetect of them are looked for
+func extractTemplates(data []byte, options *rocfacepb.DetectionOptions, minFacesToDetect int) (analysisResult, error) {
+	var result analysisResult
+	var opts, err = detectionOptionsFromProto(options)
+	if err != nil {
+		return result, invalidArgument(err)
+	}
+
+	if opts.NumFacesToDetect < minFacesToDetect {
+		opts.NumFacesToDetect = minFacesToDetect
+	}
+
+	opts.Attributes = []string{"recognition"}
+	err = withImageFiles([][]byte{data}, func(filePaths []string) error {
+		result = analyze(filePaths[0], opts)
+		return nil
+	})
+
+	return result, err
+}
+
+// auditGRPC records a decision made by a gRPC call
+func auditGRPC(ctx context.Context, entry auditEntry, params interface{}) error {
+	entry.APIKey = grpcAPIKey(ctx)
+	entry.Route = grpcRoute(ctx)
+	return grpcAuditError(recordAudit(entry, params))
+}
+
+// withInputHash adds the hash of the image or template a call was sent
+func withInputHash(entry auditEntry, image []byte, template []byte) auditEntry {
+	if template != nil {
+		entry.TemplateHashes = []string{hashBytes(template)}
+	} else {
+		entry.ImageHashes = []string{hashImage(image)}
+	}
+
+	return entry
+}
+
+// grpcAPIKey identifies the API key sent in the x-api-key or authorization
+// metadata
+func grpcAPIKey(ctx context.Context) string {
+	var md, _ = metadata.FromIncomingContext(ctx)
+	var first = func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+
+		return ""
+	}
+
+	return apiKeyFingerprint(first("x-api-key"), first("authorization"))
+}
+
+// grpcRoute is the full name of the method being called
+func grpcRoute(ctx context.Context) string {
+	var method, _ = grpc.Method(ctx)
+	return method
+}
+
+func grpcAuditError(err error) error {
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	return nil
+}
+
+func invalidArgument(err error) error {
+	return status.Error(codes.InvalidArgument, err.Error())
+}
+
+// options, unset fields get the defaults of the matching query parameters
+
+func verifyOptionsFromProto(options *rocfacepb.VerifyOptions) (verifyOptions, error) {
+	var opts = verifyOptions{
+		ColorSpace: stringOrDefault(options.GetColorSpace(), defaultColorSpace),
+		Verbose:    options.GetVerbose(),
+		Retry:      retryOptions{Rotate: options.GetRetryRotations(), Upscale: options.GetRetryUpscale()},
+		Detection:  stringOrDefault(options.GetDetection(), detectionModeStandard),
+		Selection:  options.GetFaceSelection(),
+		SkipCache:  options.GetSkipCache(),
+	}
+
+	var err = validateColorSpace(opts.ColorSpace)
+	if err != nil {
+		return opts, err
+	}
+
+	err = validateDetectionMode(opts.Detection)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Spoof, err = spoofOptionsFromProto(options.GetSpoof(), spoofPolicyNone)
+	if err == nil && opts.Spoof.enabled() {
+		err = requireAttribute("spoof")
+	}
+
+	if err != nil {
+		return opts, err
+	}
+
+	if opts.Selection != "" {
+		err = validateSelectionStrategy(opts.Selection)
+		if err != nil {
+			return opts, err
+		}
+
+		opts.NumFacesToDetect, err = countOrDefault(options.GetNumFacesToDetect(), defaultSelectionFacesToDetect, "num_faces_to_detect")
+	}
+
+	return opts, err
+}
+
+func detectionOptionsFromProto(options *rocfacepb.DetectionOptions) (analyzeOptions, error) {
+	var opts = analyzeOptions{
+		FDR:                  options.GetFdr(),
+		MinFaceWidthInPixels: intOrDefault(options.GetMinFaceWidthInPixels(), defaultMinFaceWidthInPixels),
+		ColorSpace:           stringOrDefault(options.GetColorSpace(), defaultColorSpace),
+		Retry:                retryOptions{Rotate: options.GetRetryRotations(), Upscale: options.GetRetryUpscale()},
+		Detection:            stringOrDefault(options.GetDetection(), detectionModeStandard),
+	}
+
+	if opts.FDR == 0 {
+		opts.FDR = defaultFDR
+	}
+
+	var err error
+	opts.NumFacesToDetect, err = countOrDefault(options.GetNumFacesToDetect(), defaultNumFacesToDetect, "num_faces_to_detect")
+	if err != nil {
+		return opts, err
+	}
+
+	err = validateColorSpace(opts.ColorSpace)
+	if err != nil {
+		return opts, err
+	}
+
+	return opts, validateDetectionMode(opts.Detection)
+}
+
+func analyzeOptionsFromProto(options *rocfacepb.AnalyzeOptions) (analyzeOptions, error) {
+	var opts, err = detectionOptionsFromProto(options.GetDetection())
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Attributes, err = parseAttributes(strings.Join(options.GetAttributes(), ","))
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Spoof, err = spoofOptionsFromProto(options.GetSpoof(), spoofPolicyReport)
+	if err != nil {
+		return opts, err
+	}
+
+	err = checkSpoofAttribute(opts.Spoof, opts.Attributes)
+	if err != nil {
+		return opts, err
+	}
+
+	if crops := options.GetCrops(); crops != nil {
+		opts.Crop = &cropOptions{
+			Padding: crops.GetPadding(),
+			Format:  stringOrDefault(crops.GetFormat(), defaultCropFormat),
+			Aligned: !crops.GetUnaligned(),
+		}
+
+		if opts.Crop.Padding == 0 {
+			opts.Crop.Padding = defaultCropPadding
+		}
+
+		if err = validateCropPadding(opts.Crop.Padding); err != nil {
+			return opts, err
+		}
+
+		err = validateImageFormat(opts.Crop.Format)
+	}
+
+	return opts, err
+}
+
+func spoofOptionsFromProto(options *rocfacepb.SpoofOptions, defaultPolicy string) (spoofOptions, error) {
+	var opts = spoofOptions{
+		Policy:        stringOrDefault(options.GetPolicy(), defaultPolicy),
+		LiveThreshold: options.GetLiveThreshold(),
+		Threshold:     options.GetThreshold(),
+	}
+
+	if opts.LiveThreshold == 0 {
+		opts.LiveThreshold = defaultSpoofLiveThreshold
+	}
+
+	if opts.Threshold == 0 {
+		opts.Threshold = defaultSpoofThreshold
+	}
+
+	return opts, opts.validate()
+}
+
+func stringOrDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
+
+func intOrDefault(value int32, defaultValue int) int {
+	if value == 0 {
+		return defaultValue
+	}
+
+	return int(value)
+}
+
+// countOrDefault is intOrDefault for counts, which must be at least 1 when
+// set: the engine can't detect zero faces
+func countOrDefault(value int32, defaultValue int, field string) (int, error) {
+	if value < 0 {
+		return 0, fmt.Errorf("invalid %s %d, expected at least 1", field, value)
+	}
+
+	return intOrDefault(value, defaultValue), nil
+}
+
+// results
+
+func verificationResultToProto(result verificationResult) *rocfacepb.VerifyResponse {
+	var response = &rocfacepb.VerifyResponse{
+		Similarity:    result.Similarity,
+		Code:          result.Code,
+		Message:       result.Message,
+		ColorSpace:    result.ColorSpace,
+		Transforms:    result.Transforms,
+		Retries:       result.Retries,
+		Detection:     result.Detection,
+		FaceSelection: result.FaceSelection,
+	}
+
+	for _, selected := range result.Selected {
+		response.Selected = append(response.Selected, &rocfacepb.FaceSelection{
+			Index:     int32(selected.Index),
+			FaceCount: int32(selected.FaceCount),
+		})
+	}
+
+	for _, details := range result.Images {
+		var image = &rocfacepb.FaceDetails{
+			FaceCount: int32(details.FaceCount),
+			Quality:   details.Quality,
+			Pose:      details.Pose,
+			Yaw:       details.Yaw,
+			Pitch:     details.Pitch,
+			Roll:      details.Roll,
+			Iod:       details.IOD,
+			Warnings:  details.Warnings,
+		}
+
+		if details.Template != nil {
+			image.Template = faceBoxToProto(*details.Template)
+		}
+
+		response.Images = append(response.Images, image)
+	}
+
+	for _, spoof := range result.Spoof {
+		response.Spoof = append(response.Spoof, spoofResultToProto(spoof))
+	}
+
+	return response
+}
+
+func analysisResultToProto(result analysisResult) (*rocfacepb.AnalyzeResponse, error) {
+	var response = &rocfacepb.AnalyzeResponse{
+		Code:                 result.Code,
+		Message:              result.Message,
+		Fdr:                  result.FDR,
+		MinFaceWidthInPixels: int32(result.MinFaceWidthInPixels),
+		ColorSpace:           result.ColorSpace,
+		Transform:            result.Transform,
+		Retry:                result.Retry,
+		Detection:            result.Detection,
+		Thumbnail:            result.Thumbnail,
+	}
+
+	if metadata, ok := result.Analysis.(map[string]interface{}); ok {
+		var err error
+		response.Analysis, err = structpb.NewStruct(metadata)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	if result.Spoof != nil {
+		response.Spoof = spoofResultToProto(result.Spoof)
+	}
+
+	for _, crop := range result.Crops {
+		response.Crops = append(response.Crops, &rocfacepb.FaceCrop{
+			Box:     faceBoxToProto(crop.Box),
+			Format:  crop.Format,
+			Image:   crop.Image,
+			Aligned: crop.Aligned,
+		})
+	}
+
+	return response, nil
+}
+
+// spoofResultToProto converts a verdict, nil (no face) becoming an empty one
+func spoofResultToProto(result *spoofResult) *rocfacepb.SpoofResult {
+	if result == nil {
+		return &rocfacepb.SpoofResult{}
+	}
+
+	return &rocfacepb.SpoofResult{Verdict: result.Verdict, Score: result.Score}
+}
+
+func faceBoxToProto(box faceBox) *rocfacepb.FaceBox {
+	return &rocfacepb.FaceBox{
+		X:      int32(box.X),
+		Y:      int32(box.Y),
+		Width:  int32(box.Width),
+		Height: int32(box.Height),
+	}
+}
@@ -1,41 +1,61 @@
-// Compare to examples/roc_example_verify.c
+// A stand-in for roc_server.go that doesn't need the ROC SDK or license: the
+// same routes and response schemas (see roc_face_api.go), backed by a fake
+// face engine. Results are deterministic for a given image and seed, set with
+// the ROC_FACE_MOCK_SEED environment variable.
+//
+// With ROC_FACE_MOCK_MODE=identity, similarities depend on who is in the
+// images: high for the same subject, low otherwise. Test fixtures declare
+// their subjects in a PNG tEXt chunk with the keyword "roc-face:identity",
+// a comma separated list with one subject per face, left to right. An empty
+// list means there is no face. Images without the chunk have one face whose
+// identity is derived from the image content. Tags are lost on the rotated /
+// upscaled copies retries use, and on tiles.
 
 package main
 
 import (
-	"encoding/json"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
-	"io"
+	"image"
+	"io/ioutil"
 	"log"
+	"math"
 	"math/rand"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"strconv"
-
-	// Third party packages
-	"github.com/gorilla/mux"
+	"strings"
 )
 
-// InvalidSimilarity value for similarity when verification failed for some reason
-const InvalidSimilarity = -1.0
-const _32M = (1 << 20) * 32
+// the fake face covers this fraction of the smallest image dimension (or of
+// its slot, with several faces)
+const mockFaceRatio = 0.4
 
-type verificationResult struct {
-	Similarity float32 `json:"similarity,omitempty"`
-	Code       string  `json:"code,omitempty"`
-	Message    string  `json:"message,omitempty"`
-}
+const mockModeRandom = "random"
+const mockModeIdentity = "identity"
 
-func deleteFiles(filePaths []string) {
-	log.Println("deleting files:", filePaths)
-	for _, filePath := range filePaths {
-		os.Remove(filePath)
-	}
+// size of the fake templates, in bytes
+const mockTemplateSize = 32
+
+// PNG tEXt keyword listing the subjects in a fixture
+const mockIdentityKeyword = "roc-face:identity"
+
+// similarity ranges for the same and different subjects, in identity mode
+const mockSameMinSimilarity = 0.85
+const mockSameMaxSimilarity = 0.99
+const mockDifferentMaxSimilarity = 0.3
+
+type mockEngine struct {
+	Seed int64
+	Mode string
 }
 
-type errorResponseObj struct {
-	Message string `json:"message"`
+// taggedImage is an image along with the subjects listed in its tEXt chunk
+type taggedImage struct {
+	image.Image
+	Identities []string
 }
 
 func main() {
@@ -45,18 +65,43 @@ func main() {
 		log.Fatal("Expected one argument: port")
 	}
 
+	if os.Args[1] == "audit" {
+		os.Exit(auditCommand(os.Args[2:]))
+	}
+
+	if os.Args[1] == "rekey" {
+		os.Exit(rekeyCommand(os.Args[2:]))
+	}
+
 	if port, err = strconv.Atoi(os.Args[1]); err != nil {
 		log.Fatal("Expected port to be a number")
 	}
 
+	var seed int64
+	if env := os.Getenv("ROC_FACE_MOCK_SEED"); env != "" {
+		if seed, err = strconv.ParseInt(env, 10, 64); err != nil {
+			log.Fatal("Expected ROC_FACE_MOCK_SEED to be a number")
+		}
+	}
+
+	var mode = os.Getenv("ROC_FACE_MOCK_MODE")
+	if mode == "" {
+		mode = mockModeRandom
+	}
+
+	if mode != mockModeRandom && mode != mockModeIdentity {
+		log.Fatalf("Expected ROC_FACE_MOCK_MODE to be one of: %s, %s", mockModeRandom, mockModeIdentity)
+	}
+
 	// init SDK
-	log.Println("inializing sdk")
-	log.Println("inialized sdk")
+	log.Println("inializing mock engine, mode:", mode, "seed:", seed)
+	engine = mockEngine{Seed: seed, Mode: mode}
+	log.Println("inialized mock engine")
 
-	r := mux.NewRouter()
-	r.Schemes("http")
-	r.HandleFunc("/verify", verifyHandler).Methods("POST")
-	r.HandleFunc("/ping", pingHandler).Methods("GET", "POST")
+	r, err := newServer(true)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	var host = fmt.Sprintf("0.0.0.0:%d", port)
 	log.Printf("running server on: %s", host)
@@ -77,81 +122,257 @@ func main() {
 	}
 }
 
-func pingHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("/ping")
-	w.WriteHeader(http.StatusOK)
-}
-
-func verifyHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("/verify")
-	var filePaths, err = saveImagesFromRequest(r)
+func (e mockEngine) readImage(filePath string) (image.Image, string, error) {
+	log.Println("Checking image path", filePath)
+	var data, err = ioutil.ReadFile(filePath)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errorResponseObj{
-			Message: err.Error(),
-		})
-
-		return
+		return nil, "", err
 	}
 
-	var result = verificationResult{
-		Similarity: rand.Float32(),
+	var img image.Image
+	var transform string
+	img, transform, err = decodeImage(data)
+	if err != nil {
+		return nil, "", err
 	}
 
-	deleteFiles(filePaths[:])
+	if identities, ok := pngIdentities(data); ok && e.Mode == mockModeIdentity {
+		img = taggedImage{Image: img, Identities: identities}
+	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	return img, transform, nil
 }
 
-func genTmpPath() string {
-	var tmpPath = "/tmp/roc-face-" + strconv.Itoa(rand.Int())
-	log.Println("generated tmp path", tmpPath)
-	return tmpPath
-}
+// detectFaces finds one face in the middle of any image that isn't a single
+// flat color (or one face per tagged subject, side by side), with the box,
+// landmarks and attributes jittered by the image content
+func (e mockEngine) detectFaces(img image.Image, colorSpace string, attributes []string, minFaceWidthInPixels int, numFacesToDetect int, fdr float32) []detectedFace {
+	if numFacesToDetect < 1 {
+		// like the SDK, which can't represent zero faces
+		panic(fmt.Sprintf("expected numFacesToDetect to be at least 1, got %d", numFacesToDetect))
+	}
 
-func saveImagesFromRequest(r *http.Request) ([2]string, error) {
-	var err error
+	var rgba = toRGBA(img)
+	var digest = imageDigest(rgba)
+	var identities = []string{""}
+	if tagged, ok := img.(taggedImage); ok {
+		identities = tagged.Identities
+	} else if isFlatImage(rgba) {
+		return nil
+	}
 
-	//ParseMultipartForm parses a request body as multipart/form-data
-	r.ParseMultipartForm(_32M) // max 16MB images
+	var faces []detectedFace
+	var slotWidth = float64(rgba.Rect.Dx()) / float64(len(identities))
+	var height = float64(rgba.Rect.Dy())
+	for i, identity := range identities {
+		if len(faces) == numFacesToDetect {
+			break
+		}
 
-	log.Println("extracting images from request")
-	formFields := [2]string{"image1", "image2"}
-	var filePaths [2]string
-	var formImages [2]multipart.File
+		// the identity key defaults to the image content
+		var key = digest[:]
+		if identity != "" {
+			key = []byte(identity)
+		}
 
-	for index, field := range formFields {
-		log.Println("extracting field", field)
-		image, _, err := r.FormFile(field)
-		if err != nil {
-			log.Println("failed to extract field:", field, "error:", err.Error())
-			return filePaths, err
+		var random = e.random(append(append([]byte{}, digest[:]...), key...))
+		var size = mockFaceRatio * math.Min(slotWidth, height) * (0.9 + 0.2*random.Float64())
+		if minFaceWidthInPixels != adaptiveMinFaceWidth && size < float64(minFaceWidthInPixels) {
+			continue
 		}
 
-		defer image.Close() //close the file when we finish
-		formImages[index] = image
+		var centerX = slotWidth * (float64(i) + 0.45 + 0.1*random.Float64())
+		var centerY = height * (0.4 + 0.1*random.Float64())
+		var face = detectedFace{
+			Box: faceBox{
+				X:      int(centerX - size/2),
+				Y:      int(centerY - size/2),
+				Width:  int(size),
+				Height: int(size),
+			},
+			Metadata: map[string]interface{}{},
+		}
+
+		for _, attribute := range attributes {
+			e.addAttribute(&face, attribute, rgba, random, key, digest[:])
+		}
+
+		faces = append(faces, face)
 	}
 
-	log.Println("saving images to disk")
-	for index, image := range formImages {
-		var imagePath = genTmpPath()
-		var outfile *os.File
-		outfile, err = os.Create(imagePath)
-		if err != nil {
-			return filePaths, err
+	return faces
+}
+
+// pngIdentities reads the subjects listed in a PNG's tEXt chunk
+func pngIdentities(data []byte) ([]string, bool) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, false
+	}
+
+	var identities []string
+	var found = false
+	walkPNGChunks(data, func(chunkType string, payload []byte) bool {
+		var keyword = []byte(mockIdentityKeyword + "\x00")
+		if chunkType != "tEXt" || !bytes.HasPrefix(payload, keyword) {
+			return true
+		}
+
+		found = true
+		for _, identity := range strings.Split(string(payload[len(keyword):]), ",") {
+			if identity = strings.TrimSpace(identity); identity != "" {
+				identities = append(identities, identity)
+			}
+		}
+
+		return false
+	})
+
+	return identities, found
+}
+
+// addAttribute fills in the metadata (and template / thumbnail) the SDK
+// produces for an attribute, with made up values
+func (e mockEngine) addAttribute(face *detectedFace, attribute string, img *image.RGBA, random *rand.Rand, identity []byte, digest []byte) {
+	var md = face.Metadata
+	var centerX, centerY = face.Box.center()
+	var size = float64(face.Box.Width)
+	switch attribute {
+	case "recognition":
+		md["Quality"] = 0.3 + 0.6*random.Float64()
+		face.Template = e.template(identity, digest)
+	case "demographics":
+		var male = random.Float64()
+		md["Age"] = float64(18 + random.Intn(60))
+		md["Male"] = male
+		md["Female"] = 1 - male
+		var total = 0.0
+		var races = []string{"Asian", "Black", "Hispanic", "White", "Other"}
+		var scores = make([]float64, len(races))
+		for i := range races {
+			scores[i] = random.Float64()
+			total += scores[i]
 		}
 
-		_, err = io.Copy(outfile, image)
-		if err != nil {
-			return filePaths, err
+		for i, race := range races {
+			md[race] = scores[i] / total
 		}
+	case "pose":
+		md["Pose"] = "Frontal"
+		md["Yaw"] = float64(random.Intn(21) - 10)
+		md["Pitch"] = float64(random.Intn(21) - 10)
+		md["Roll"] = float64(random.Intn(11) - 5)
+	case "spoof":
+		md["SpoofAF"] = random.Float64()
+	case "glasses":
+		md["Glasses"] = 0.3 * random.Float64()
+		md["Sunglasses"] = 0.1 * random.Float64()
+	case "landmarks":
+		var iod = 0.4 * size
+		md["RightEyeX"] = math.Round(centerX - iod/2)
+		md["RightEyeY"] = math.Round(centerY - 0.1*size)
+		md["LeftEyeX"] = math.Round(centerX + iod/2)
+		md["LeftEyeY"] = math.Round(centerY - 0.1*size)
+		md["NoseRootX"] = math.Round(centerX)
+		md["NoseRootY"] = math.Round(centerY - 0.12*size)
+		md["ChinX"] = math.Round(centerX)
+		md["ChinY"] = math.Round(centerY + 0.45*size)
+		md["IOD"] = math.Round(iod)
+	case "lips":
+		md["LipsApart"] = 0.5 * random.Float64()
+	case "thumbnail":
+		face.Thumbnail, _ = encodeImage(cropFace(img, face.Box, 0), "jpeg")
+	}
+}
+
+// random returns a generator seeded with the engine seed and the given bytes
+func (e mockEngine) random(key []byte) *rand.Rand {
+	var digest = sha256.Sum256(key)
+	return rand.New(rand.NewSource(e.Seed ^ int64(binary.BigEndian.Uint64(digest[:8]))))
+}
+
+// template derives a fake flattened template of mockTemplateSize bytes: a
+// digest of the identity key, followed by a digest of the image
+func (e mockEngine) template(identity []byte, digest []byte) []byte {
+	var seed [8]byte
+	binary.BigEndian.PutUint64(seed[:], uint64(e.Seed))
+	var identityDigest = sha256.Sum256(append(seed[:], identity...))
+	return append(identityDigest[:16], digest[:16]...)
+}
+
+// compareTemplates returns 1 for identical templates. Other pairs get a
+// stable pseudo random similarity: in [0, 1) in random mode, and in identity
+// mode high for the same subject and low otherwise.
+func (e mockEngine) compareTemplates(a []byte, b []byte) float32 {
+	if e.checkTemplate(a) != nil || e.checkTemplate(b) != nil {
+		return InvalidSimilarity
+	}
+
+	if string(a) == string(b) {
+		return 1
+	}
+
+	if string(a) > string(b) {
+		a, b = b, a
+	}
+
+	var random = e.random(append(append([]byte{}, a...), b...)).Float32()
+	if e.Mode != mockModeIdentity {
+		return random
+	}
 
-		filePaths[index] = imagePath
-		defer outfile.Close()
-		io.Copy(outfile, image)
-		log.Printf("wrote file: %s", imagePath)
+	if string(a[:16]) == string(b[:16]) {
+		return mockSameMinSimilarity + (mockSameMaxSimilarity-mockSameMinSimilarity)*random
 	}
 
-	return filePaths, nil
+	return mockDifferentMaxSimilarity * random
+}
+
+func (e mockEngine) checkTemplate(template []byte) error {
+	if len(template) != mockTemplateSize {
+		return fmt.Errorf("invalid template of %d bytes, expected %d", len(template), mockTemplateSize)
+	}
+
+	return nil
+}
+
+func (e mockEngine) version() string {
+	return "mock-" + e.Mode
+}
+
+func imageDigest(img *image.RGBA) [sha256.Size]byte {
+	var hash = sha256.New()
+	binary.Write(hash, binary.BigEndian, []uint32{uint32(img.Rect.Dx()), uint32(img.Rect.Dy())})
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		hash.Write(imageRow(img, y))
+	}
+
+	var digest [sha256.Size]byte
+	copy(digest[:], hash.Sum(nil))
+	return digest
+}
+
+// isFlatImage tells whether every pixel has the same color, such images have
+// no face
+func isFlatImage(img *image.RGBA) bool {
+	if img.Rect.Empty() {
+		return true
+	}
+
+	var first = imageRow(img, img.Rect.Min.Y)[:4]
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		var row = imageRow(img, y)
+		for i := 0; i < len(row); i += 4 {
+			if string(row[i:i+4]) != string(first) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// imageRow returns the pixels of row y
+func imageRow(img *image.RGBA, y int) []byte {
+	var start = img.PixOffset(img.Rect.Min.X, y)
+	return img.Pix[start : start+4*img.Rect.Dx()]
 }
@@ -5,57 +5,20 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io"
+	"image"
+	"io/ioutil"
 	"log"
-	"math/rand"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"strconv"
-
-	// Third party packages
-	"github.com/gorilla/mux"
+	"unsafe"
 )
 
 // #cgo LDFLAGS: -lroc
+// #include <stdlib.h>
 // #include <roc.h>
 import "C"
 
-// InvalidSimilarity value for similarity when verification failed for some reason
-const InvalidSimilarity = -1.0
-const _128M = (1 << 20) * 128
-const defaultFDR = 0.02
-const defaultMinFaceWidthInPixels = 36
-const defaultNumFacesToDetect = 1
-
-var verifyFormFields = []string{"image1", "image2"}
-var analyzeFormFields = []string{"image"}
-
-type verificationResult struct {
-	Similarity float32 `json:"similarity"`
-	Code       string  `json:"code,omitempty"`
-	Message    string  `json:"message,omitempty"`
-}
-
-type analysisResult struct {
-	Code                 string      `json:"code,omitempty"`
-	Message              string      `json:"message,omitempty"`
-	FDR                  float32     `json:"fdr,omitempty"`
-	MinFaceWidthInPixels int         `json:"minFaceWidthInPixels,omitempty"`
-	Analysis             interface{} `json:"analysis,omitempty"`
-}
-
-func deleteFiles(filePaths []string) {
-	log.Println("deleting files:", filePaths)
-	for _, filePath := range filePaths {
-		os.Remove(filePath)
-	}
-}
-
-type errorResponseObj struct {
-	Message string `json:"message"`
-}
-
 // func init() {
 // 	log.Println("inializing sdk")
 // 	C.roc_ensure(C.roc_initialize(nil, nil))
@@ -65,10 +28,19 @@ type errorResponseObj struct {
 func main() {
 	var port int
 	var err error
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		// no need for the SDK (or its license) to check the audit log
+		os.Exit(auditCommand(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "rekey" {
+		os.Exit(rekeyCommand(os.Args[2:]))
+	}
 
 	log.Println("inializing sdk")
 	C.roc_ensure(C.roc_initialize(nil, nil))
 	log.Println("inialized sdk")
+	engine = newRocEngine()
 
 	var command = os.Args[1]
 	if command == "verify" {
@@ -78,7 +50,7 @@ func main() {
 
 		var filePaths = []string{os.Args[2], os.Args[3]}
 		log.Println("Checking image paths", filePaths)
-		var result = verify(filePaths)
+		var result = verify(filePaths, verifyOptions{ColorSpace: defaultColorSpace, Detection: detectionModeStandard})
 		if result.Similarity == InvalidSimilarity {
 			log.Panic(result.Message)
 		} else {
@@ -95,25 +67,36 @@ func main() {
 
 		var filePath = os.Args[2]
 		log.Println("Analyzing image")
-		var result = analyze(filePath, defaultFDR, defaultMinFaceWidthInPixels, defaultNumFacesToDetect)
+		var result = analyze(filePath, analyzeOptions{
+			FDR:                  defaultFDR,
+			MinFaceWidthInPixels: defaultMinFaceWidthInPixels,
+			NumFacesToDetect:     defaultNumFacesToDetect,
+			ColorSpace:           defaultColorSpace,
+			Attributes:           defaultAttributes,
+			Detection:            detectionModeStandard,
+		})
 		log.Println("Analysis:", result)
 
 		return
 	}
 
 	if command != "serve" {
-		log.Fatal("invalid command, expected one of: verify, analyze, serve")
+		log.Fatal("invalid command, expected one of: verify, analyze, serve, audit")
 	}
 
 	if port, err = strconv.Atoi(os.Args[2]); err != nil {
 		C.roc_ensure(C.CString("Expected port to be a number"))
 	}
 
-	r := mux.NewRouter()
-	r.Schemes("http")
-	r.HandleFunc("/verify", verifyHandler).Methods("POST")
-	r.HandleFunc("/analyze", analyzeHandler).Methods("POST")
-	r.HandleFunc("/ping", pingHandler).Methods("GET", "POST")
+	var debug, _ = strconv.ParseBool(os.Getenv("ROC_FACE_DEBUG"))
+	if debug {
+		log.Println("debug mode, enabling fault injection")
+	}
+
+	r, err := newServer(debug)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	var host = fmt.Sprintf("0.0.0.0:%d", port)
 	log.Printf("running server on: %s", host)
@@ -136,272 +119,254 @@ func main() {
 	}
 }
 
-func sendError(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusBadRequest)
-	json.NewEncoder(w).Encode(errorResponseObj{
-		Message: err.Error(),
-	})
+// attributeAlgorithms maps attribute names to the SDK algorithms computing them
+var attributeAlgorithms = map[string]C.roc_algorithm_id{
+	"recognition":  C.ROC_FR,           // represent the face
+	"demographics": C.ROC_DEMOGRAPHICS, // extract demographics
+	"pose":         C.ROC_PITCHYAW,     // extract face position information
+	"spoof":        C.ROC_SPOOF_AF,     // static image spoof detection
+	"glasses":      C.ROC_GLASSES,
+	"landmarks":    C.ROC_LANDMARKS, // Add RightEyeX, RightEyeY, LeftEyeX, LeftEyeY, ChinX, ChinY, NoseRootX and NoseRootY pixel locations, and IOD (inter-occular pixel distance) to the template metadata.
+	"thumbnail":    C.ROC_THUMBNAIL,
+	"lips":         C.ROC_LIPS, // lips apart vs together
 }
 
-func analyzeHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("/analyze")
-	var filePaths, err = saveImagesFromRequest(r, analyzeFormFields)
-	if err != nil {
-		sendError(w, err)
-		return
+func attributesAlgorithmID(attributes []string) C.roc_algorithm_id {
+	var algorithmID C.roc_algorithm_id = C.ROC_FRONTAL // detect frontal faces
+	for _, attribute := range attributes {
+		algorithmID |= attributeAlgorithms[attribute]
 	}
 
-	var fdr float32
-	var minFaceWidthInPixels int
-	var numFacesToDetect int
-	fdr, err = getFloatQueryParam(r, "fdr", defaultFDR)
-	if err != nil {
-		sendError(w, err)
-		return
-	}
-
-	minFaceWidthInPixels, err = getIntQueryParam(r, "minFaceWidthInPixels", defaultMinFaceWidthInPixels)
-	if err != nil {
-		sendError(w, err)
-		return
-	}
-
-	numFacesToDetect, err = getIntQueryParam(r, "numFacesToDetect", defaultNumFacesToDetect)
-	if err != nil {
-		sendError(w, err)
-		return
-	}
+	return algorithmID
+}
 
-	var result = analyze(filePaths[0], fdr, minFaceWidthInPixels, numFacesToDetect)
-	deleteFiles(filePaths[:])
+// rocEngine is the faceEngine backed by the ROC SDK
+type rocEngine struct {
+	// the flattened size of a template without feature vector, metadata or
+	// thumbnail: the header roc_unflatten reads the size of the rest from
+	flattenedHeaderSize int
+}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+// newRocEngine returns the engine, the SDK being initialized
+func newRocEngine() rocEngine {
+	var empty C.roc_template
+	var md = C.CString("")
+	defer C.free(unsafe.Pointer(md))
+	empty.md = md
+	var size C.size_t
+	C.roc_ensure(C.roc_flattened_bytes(empty, &size))
+	return rocEngine{flattenedHeaderSize: int(size)}
 }
 
-func getIntQueryParam(r *http.Request, param string, defaultValue int) (int, error) {
-	var val = r.URL.Query().Get(param)
-	if len(val) > 0 {
-		return strconv.Atoi(val)
+func (rocEngine) detectFaces(img image.Image, colorSpace string, attributes []string, minFaceWidthInPixels int, numFacesToDetect int, fdr float32) []detectedFace {
+	var algorithmID = attributesAlgorithmID(attributes)
+	var rocImage = rocImageFromGo(img, colorSpace)
+	var minimumSize = C.size_t(minFaceWidthInPixels)
+	if minFaceWidthInPixels == adaptiveMinFaceWidth {
+		C.roc_ensure(C.roc_adaptive_minimum_size(rocImage, 0.08, 36, &minimumSize))
 	}
 
-	return defaultValue, nil
-}
+	if numFacesToDetect < 1 {
+		panic(fmt.Sprintf("expected numFacesToDetect to be at least 1, got %d", numFacesToDetect))
+	}
+
+	var templates = make([]C.roc_template, numFacesToDetect)
+	C.roc_ensure(C.roc_represent(rocImage, algorithmID, minimumSize, C.int(numFacesToDetect), C.float(fdr), &templates[0]))
 
-func getFloatQueryParam(r *http.Request, param string, defaultValue float32) (float32, error) {
-	var val = r.URL.Query().Get(param)
-	if len(val) > 0 {
-		var ret, err = strconv.ParseFloat(val, 32)
-		if err != nil {
-			return -1.0, err
+	var faces []detectedFace
+	for i := range templates {
+		if templates[i].algorithm_id&C.ROC_INVALID != 0 {
+			break
 		}
 
-		return float32(ret), err
+		faces = append(faces, faceFromTemplate(templates[i]))
 	}
 
-	return defaultValue, nil
+	// Cleanup
+	for i := range templates {
+		C.roc_ensure(C.roc_free_template(&templates[i]))
+	}
+
+	freeRocImage(rocImage)
+	return faces
 }
 
-func pingHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("/ping")
-	w.WriteHeader(http.StatusOK)
+// rocColorSpaces maps color space names to the SDK's
+var rocColorSpaces = map[string]C.roc_color_space{
+	colorSpaceGray8: C.ROC_GRAY8,
+	colorSpaceBGR24: C.ROC_BGR24,
 }
 
-func verifyHandler(w http.ResponseWriter, r *http.Request) {
-	log.Println("/verify")
-	var filePaths, err = saveImagesFromRequest(r, verifyFormFields)
+// readImage decodes an image file with Go's decoders, falling back to the SDK
+// for formats Go doesn't support. The EXIF orientation is applied either way,
+// the name of the applied transform is returned with the image.
+func (rocEngine) readImage(filePath string) (image.Image, string, error) {
+	log.Println("Checking image path", filePath)
+	var data, err = ioutil.ReadFile(filePath)
 	if err != nil {
-		// TODO: in case one image was succcessfully extracted, delete it
-
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errorResponseObj{
-			Message: err.Error(),
-		})
+		return nil, "", err
+	}
 
-		return
+	var img image.Image
+	var transform string
+	img, transform, err = decodeImage(data)
+	if err == nil || err == errImageTooLarge {
+		return img, transform, err
 	}
 
-	var result = verify(filePaths)
-	deleteFiles(filePaths[:])
+	log.Println("failed to decode image, falling back to the SDK:", err.Error())
+	var rocImage C.roc_image
+	var cFilePath = C.CString(filePath)
+	defer C.free(unsafe.Pointer(cFilePath))
+	if rocErr := C.roc_read_image(cFilePath, C.ROC_BGR24, &rocImage); rocErr != nil {
+		return nil, "", fmt.Errorf("failed to read image: %s", C.GoString(rocErr))
+	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(result)
+	img, transform = orientImage(goImageFromRoc(rocImage), exifOrientation(data))
+	C.roc_ensure(C.roc_free_image(rocImage))
+	return img, transform, nil
 }
 
-func genTmpPath() string {
-	var tmpPath = "/tmp/roc-face-" + strconv.Itoa(rand.Int())
-	log.Println("generated tmp path", tmpPath)
-	return tmpPath
-}
+// goImageFromRoc converts a GRAY8 or BGR24 roc_image to a Go image, compare
+// to roc_example_convert_image.go
+func goImageFromRoc(img C.roc_image) *image.RGBA {
+	var width = int(img.width)
+	var height = int(img.height)
+	var step = int(img.step)
+	var imgGo = image.NewRGBA(image.Rect(0, 0, width, height))
+	var imgData = C.GoBytes(unsafe.Pointer(img.data), C.int(step*height))
+	for i := 0; i < height; i++ {
+		for j := 0; j < width; j++ {
+			var dstIndex = i*imgGo.Stride + 4*j
+			if img.color_space == C.ROC_GRAY8 {
+				var value = imgData[i*step+j]
+				imgGo.Pix[dstIndex+0] = value // R
+				imgGo.Pix[dstIndex+1] = value // G
+				imgGo.Pix[dstIndex+2] = value // B
+			} else {
+				var srcIndex = i*step + 3*j
+				imgGo.Pix[dstIndex+0] = imgData[srcIndex+2] // R
+				imgGo.Pix[dstIndex+1] = imgData[srcIndex+1] // G
+				imgGo.Pix[dstIndex+2] = imgData[srcIndex+0] // B
+			}
 
-func saveImagesFromRequest(r *http.Request, formFields []string) ([]string, error) {
-	var err error
+			imgGo.Pix[dstIndex+3] = 255 // A
+		}
+	}
 
-	//ParseMultipartForm parses a request body as multipart/form-data
-	r.ParseMultipartForm(_128M) // max 128MB of data (might be needed later for video)
+	return imgGo
+}
 
-	log.Println("extracting images from request")
-	var filePaths = make([]string, len(formFields))
-	var formImages = make([]multipart.File, len(formFields))
+// rocImageFromGo copies a Go image into a roc_image in the given color space.
+// The pixels are allocated in C memory, release them with freeRocImage.
+func rocImageFromGo(img image.Image, colorSpace string) C.roc_image {
+	var pixels, step = packPixels(img, colorSpace)
+	var imgRoc C.roc_image
+	imgRoc.width = C.size_t(img.Bounds().Dx())
+	imgRoc.height = C.size_t(img.Bounds().Dy())
+	imgRoc.step = C.size_t(step)
+	imgRoc.color_space = rocColorSpaces[colorSpace]
+	imgRoc.data = (*C.uint8_t)(C.CBytes(pixels))
+	return imgRoc
+}
 
-	for index, field := range formFields {
-		log.Println("extracting field", field)
-		image, _, err := r.FormFile(field)
-		if err != nil {
-			log.Println("failed to extract field:", field, "error:", err.Error())
-			return filePaths, err
-		}
+func freeRocImage(img C.roc_image) {
+	C.free(unsafe.Pointer(img.data))
+}
 
-		defer image.Close() //close the file when we finish
-		formImages[index] = image
+func faceFromTemplate(template C.roc_template) detectedFace {
+	var metadata map[string]interface{}
+	json.Unmarshal([]byte(C.GoString(template.md)), &metadata)
+	var face = detectedFace{
+		Box:      templateBox(template),
+		Metadata: metadata,
 	}
 
-	log.Println("saving images to disk")
-	for index, image := range formImages {
-		var imagePath = genTmpPath()
-		var outfile *os.File
-		outfile, err = os.Create(imagePath)
-		if err != nil {
-			return filePaths, err
-		}
-
-		_, err = io.Copy(outfile, image)
-		if err != nil {
-			return filePaths, err
-		}
+	if template.algorithm_id&C.ROC_FR != 0 {
+		var size C.size_t
+		C.roc_ensure(C.roc_flattened_bytes(template, &size))
+		face.Template = make([]byte, size)
+		C.roc_ensure(C.roc_flatten(template, (*C.uint8_t)(&face.Template[0])))
+	}
 
-		filePaths[index] = imagePath
-		defer outfile.Close()
-		io.Copy(outfile, image)
-		log.Printf("wrote file: %s", imagePath)
+	if template.algorithm_id&C.ROC_THUMBNAIL != 0 && template.tn_size > 0 {
+		face.Thumbnail = C.GoBytes(unsafe.Pointer(template.tn), C.int(template.tn_size))
 	}
 
-	return filePaths, nil
+	return face
 }
 
-func analyze(filePath string, fdr float32, minFaceWidthInPixels int, numFacesToDetect int) analysisResult {
-	log.Println("analyze()")
-	var algorithmID C.roc_algorithm_id = C.ROC_FRONTAL | // detect frontal faces
-		C.ROC_FR | // represent the face
-		C.ROC_DEMOGRAPHICS | // extract demographics
-		C.ROC_PITCHYAW | // extract face position information
-		C.ROC_SPOOF_AF | // static image spoof detection
-		C.ROC_GLASSES |
-		C.ROC_LANDMARKS | // Add RightEyeX, RightEyeY, LeftEyeX, LeftEyeY, ChinX, ChinY, NoseRootX and NoseRootY pixel locations, and IOD (inter-occular pixel distance) to the template metadata.
-		// C.ROC_THUMBNAIL |
-		C.ROC_LIPS // lips apart vs together
-
-	var image C.roc_image
-	var template C.roc_template
-
-	log.Println("Checking image path", filePath)
-	C.roc_ensure(C.roc_read_image(C.CString(filePath), C.ROC_GRAY8, &image))
-
-	log.Println("Analyzing face")
-	C.roc_ensure(C.roc_represent(image, algorithmID, C.size_t(minFaceWidthInPixels), C.int(numFacesToDetect), C.float(fdr), &template))
-	if template.algorithm_id&C.ROC_INVALID != 0 {
-		var message = fmt.Sprintf("Failed to detect face in image")
-		log.Println(message)
-		return analysisResult{
-			Code:    "FaceNotDetected",
-			Message: message,
-		}
+func (e rocEngine) compareTemplates(a []byte, b []byte) float32 {
+	var templates [2]C.roc_template
+	var err error
+	templates[0], err = e.unflattenTemplate(a)
+	if err != nil {
+		log.Println("failed to compare templates, error:", err.Error())
+		return InvalidSimilarity
 	}
 
-	var bytes = []byte(C.GoString(template.md))
-	var data interface{}
-	json.Unmarshal(bytes, &data)
-
-	// for example:
-	// {
-	// 	"Age": 33,
-	// 	"Asian": 0.0016529097920283675,
-	// 	"Black": 0.00099143886473029852,
-	// 	"ChinX": 537,
-	// 	"ChinY": 373,
-	// 	"Female": 0.0024212179705500603,
-	// 	"Hispanic": 0.043269451707601547,
-	// 	"IOD": 76,
-	// 	"LeftEyeX": 580,
-	// 	"LeftEyeY": 236,
-	// 	"Male": 0.99757874011993408,
-	// 	"NoseRootX": 544,
-	// 	"NoseRootY": 224,
-	// 	"Other": 0.0040792962536215782,
-	// 	"Path": "",
-	// 	"Pitch": 6,
-	// 	"Pose": "Frontal",
-	// 	"Quality": 0.45093154907226562,
-	// 	"RightEyeX": 505,
-	// 	"RightEyeY": 228,
-	// 	"Roll": 2,
-	// 	"SpoofAF": 0.67681902647018433,
-	// 	"White": 0.95000690221786499,
-	// 	"Yaw": -3
-	// }
-
-	// var similarity C.roc_similarity
-	// C.roc_ensure(C.roc_(templates[0], templates[1], &similarity))
-	// log.Println("Similarity:", similarity)
+	defer C.roc_free_template(&templates[0])
+	templates[1], err = e.unflattenTemplate(b)
+	if err != nil {
+		log.Println("failed to compare templates, error:", err.Error())
+		return InvalidSimilarity
+	}
 
-	// Cleanup
-	C.roc_ensure(C.roc_free_template(&template))
-	C.roc_ensure(C.roc_free_image(image))
+	defer C.roc_free_template(&templates[1])
+	var similarity C.roc_similarity
+	C.roc_ensure(C.roc_compare_templates(templates[0], templates[1], &similarity))
+	return float32(similarity)
+}
 
-	return analysisResult{
-		FDR:                  fdr,
-		MinFaceWidthInPixels: minFaceWidthInPixels,
-		Analysis:             data,
+func (e rocEngine) checkTemplate(data []byte) error {
+	var template, err = e.unflattenTemplate(data)
+	if err != nil {
+		return err
 	}
+
+	C.roc_ensure(C.roc_free_template(&template))
+	return nil
 }
 
-func verify(filePaths []string) verificationResult {
-	if len(filePaths) != 2 {
-		return verificationResult{
-			Similarity: InvalidSimilarity,
-			Code:       "InvalidImageCount",
-			Message:    "expected two image paths",
-		}
-	}
+// unflattenTemplate reads a flattened template. Templates can come from
+// clients, so SDK errors are returned rather than aborting like roc_ensure.
+func (e rocEngine) unflattenTemplate(data []byte) (C.roc_template, error) {
+	var template C.roc_template
 
-	log.Println("verify()")
+	// roc_unflatten reads the header without knowing the size of data, and a
+	// recognition template has a feature vector after it
+	if len(data) <= e.flattenedHeaderSize {
+		return template, fmt.Errorf("invalid template of %d bytes, shorter than a flattened recognition template", len(data))
+	}
 
-	// Open both images
-	var images [2]C.roc_image
-	for i := 0; i < 2; i++ {
-		log.Println("Checking image path", filePaths[i])
-		C.roc_ensure(C.roc_read_image(C.CString(filePaths[i]), C.ROC_GRAY8, &images[i]))
+	if rocErr := C.roc_unflatten((*C.uint8_t)(&data[0]), &template); rocErr != nil {
+		return template, fmt.Errorf("invalid template: %s", C.GoString(rocErr))
 	}
 
-	// Find and represent one face in each image
-	var templates [2]C.roc_template
-	for i := 0; i < 2; i++ {
-		var adaptiveMinimumSize C.size_t
-		C.roc_ensure(C.roc_adaptive_minimum_size(images[i], 0.08, 36, &adaptiveMinimumSize))
-		C.roc_ensure(C.roc_represent(images[i], C.ROC_FRONTAL|C.ROC_FR, adaptiveMinimumSize, 1, 0.02, &templates[i]))
-		if templates[i].algorithm_id&C.ROC_INVALID != 0 {
-			var message = fmt.Sprintf("Failed to detect face in image %d", i)
-			log.Println(message)
-			return verificationResult{
-				Similarity: InvalidSimilarity,
-				Code:       "FaceNotDetected",
-				Message:    message,
-			}
-		}
+	var size C.size_t
+	C.roc_ensure(C.roc_flattened_bytes(template, &size))
+	if int(size) != len(data) || template.algorithm_id&C.ROC_FR == 0 {
+		C.roc_ensure(C.roc_free_template(&template))
+		return template, fmt.Errorf("invalid template of %d bytes, not a flattened recognition template", len(data))
 	}
 
-	// Compare faces
-	var similarity C.roc_similarity
-	C.roc_ensure(C.roc_compare_templates(templates[0], templates[1], &similarity))
-	log.Println("Similarity:", similarity)
+	return template, nil
+}
 
-	// Cleanup
-	for i := 0; i < 2; i++ {
-		C.roc_ensure(C.roc_free_template(&templates[i]))
-		C.roc_ensure(C.roc_free_image(images[i]))
-	}
+func (rocEngine) version() string {
+	var version *C.char
+	C.roc_ensure(C.roc_version(&version))
+	return C.GoString(version)
+}
 
-	return verificationResult{
-		Similarity: float32(similarity),
+// templateBox converts the template's face center and size to a faceBox
+func templateBox(template C.roc_template) faceBox {
+	var width = int(template.width)
+	var height = int(template.height)
+	return faceBox{
+		X:      int(template.x) - width/2,
+		Y:      int(template.y) - height/2,
+		Width:  width,
+		Height: height,
 	}
 }
@@ -0,0 +1,12 @@
+#!/bin/bash
+
+# regenerates the Go code for roc_face.proto, needs protoc, protoc-gen-go and
+# protoc-gen-go-grpc:
+#   go install google.golang.org/protobuf/cmd/protoc-gen-go@latest
+#   go install google.golang.org/grpc/cmd/protoc-gen-go-grpc@latest
+
+cd $(dirname $0)
+
+protoc --go_out=. --go_opt=paths=source_relative \
+  --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+  roc_face.proto
@@ -0,0 +1,2250 @@
+// gRPC API of roc-face, served next to the HTTP API by roc_server.go and
+// roc_mock_server.go when ROC_FACE_GRPC_PORT is set (see roc_grpc.go).
+//
+// Options left unset get the same defaults as the HTTP query parameters, and
+// results mirror the JSON responses: failures like FaceNotDetected are
+// reported with a code, invalid options with an INVALID_ARGUMENT status.
+//
+// Regenerate the Go code with ./generate.sh
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v3.21.12
+// source: roc_face.proto
+
+package rocfacepb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type FaceBox struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	X             int32                  `protobuf:"varint,1,opt,name=x,proto3" json:"x,omitempty"`
+	Y             int32                  `protobuf:"varint,2,opt,name=y,proto3" json:"y,omitempty"`
+	Width         int32                  `protobuf:"varint,3,opt,name=width,proto3" json:"width,omitempty"`
+	Height        int32                  `protobuf:"varint,4,opt,name=height,proto3" json:"height,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FaceBox) Reset() {
+	*x = FaceBox{}
+	mi := &file_roc_face_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FaceBox) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FaceBox) ProtoMessage() {}
+
+func (x *FaceBox) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FaceBox.ProtoReflect.Descriptor instead.
+func (*FaceBox) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *FaceBox) GetX() int32 {
+	if x != nil {
+		return x.X
+	}
+	return 0
+}
+
+func (x *FaceBox) GetY() int32 {
+	if x != nil {
+		return x.Y
+	}
+	return 0
+}
+
+func (x *FaceBox) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *FaceBox) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+type SpoofOptions struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// none, report, reject or strict
+	Policy        string  `protobuf:"bytes,1,opt,name=policy,proto3" json:"policy,omitempty"`
+	LiveThreshold float64 `protobuf:"fixed64,2,opt,name=live_threshold,json=liveThreshold,proto3" json:"live_threshold,omitempty"`
+	Threshold     float64 `protobuf:"fixed64,3,opt,name=threshold,proto3" json:"threshold,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SpoofOptions) Reset() {
+	*x = SpoofOptions{}
+	mi := &file_roc_face_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SpoofOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SpoofOptions) ProtoMessage() {}
+
+func (x *SpoofOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SpoofOptions.ProtoReflect.Descriptor instead.
+func (*SpoofOptions) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SpoofOptions) GetPolicy() string {
+	if x != nil {
+		return x.Policy
+	}
+	return ""
+}
+
+func (x *SpoofOptions) GetLiveThreshold() float64 {
+	if x != nil {
+		return x.LiveThreshold
+	}
+	return 0
+}
+
+func (x *SpoofOptions) GetThreshold() float64 {
+	if x != nil {
+		return x.Threshold
+	}
+	return 0
+}
+
+type SpoofResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// live, spoof or uncertain, empty if no face was found
+	Verdict       string  `protobuf:"bytes,1,opt,name=verdict,proto3" json:"verdict,omitempty"`
+	Score         float64 `protobuf:"fixed64,2,opt,name=score,proto3" json:"score,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SpoofResult) Reset() {
+	*x = SpoofResult{}
+	mi := &file_roc_face_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SpoofResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SpoofResult) ProtoMessage() {}
+
+func (x *SpoofResult) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SpoofResult.ProtoReflect.Descriptor instead.
+func (*SpoofResult) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SpoofResult) GetVerdict() string {
+	if x != nil {
+		return x.Verdict
+	}
+	return ""
+}
+
+func (x *SpoofResult) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+type DetectionOptions struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	Fdr                  float32                `protobuf:"fixed32,1,opt,name=fdr,proto3" json:"fdr,omitempty"`
+	MinFaceWidthInPixels int32                  `protobuf:"varint,2,opt,name=min_face_width_in_pixels,json=minFaceWidthInPixels,proto3" json:"min_face_width_in_pixels,omitempty"`
+	NumFacesToDetect     int32                  `protobuf:"varint,3,opt,name=num_faces_to_detect,json=numFacesToDetect,proto3" json:"num_faces_to_detect,omitempty"`
+	// gray8 or bgr24
+	ColorSpace     string `protobuf:"bytes,4,opt,name=color_space,json=colorSpace,proto3" json:"color_space,omitempty"`
+	RetryRotations bool   `protobuf:"varint,5,opt,name=retry_rotations,json=retryRotations,proto3" json:"retry_rotations,omitempty"`
+	RetryUpscale   bool   `protobuf:"varint,6,opt,name=retry_upscale,json=retryUpscale,proto3" json:"retry_upscale,omitempty"`
+	// standard or tiled
+	Detection     string `protobuf:"bytes,7,opt,name=detection,proto3" json:"detection,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DetectionOptions) Reset() {
+	*x = DetectionOptions{}
+	mi := &file_roc_face_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DetectionOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DetectionOptions) ProtoMessage() {}
+
+func (x *DetectionOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DetectionOptions.ProtoReflect.Descriptor instead.
+func (*DetectionOptions) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DetectionOptions) GetFdr() float32 {
+	if x != nil {
+		return x.Fdr
+	}
+	return 0
+}
+
+func (x *DetectionOptions) GetMinFaceWidthInPixels() int32 {
+	if x != nil {
+		return x.MinFaceWidthInPixels
+	}
+	return 0
+}
+
+func (x *DetectionOptions) GetNumFacesToDetect() int32 {
+	if x != nil {
+		return x.NumFacesToDetect
+	}
+	return 0
+}
+
+func (x *DetectionOptions) GetColorSpace() string {
+	if x != nil {
+		return x.ColorSpace
+	}
+	return ""
+}
+
+func (x *DetectionOptions) GetRetryRotations() bool {
+	if x != nil {
+		return x.RetryRotations
+	}
+	return false
+}
+
+func (x *DetectionOptions) GetRetryUpscale() bool {
+	if x != nil {
+		return x.RetryUpscale
+	}
+	return false
+}
+
+func (x *DetectionOptions) GetDetection() string {
+	if x != nil {
+		return x.Detection
+	}
+	return ""
+}
+
+type CropOptions struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Padding float64                `protobuf:"fixed64,1,opt,name=padding,proto3" json:"padding,omitempty"`
+	// jpeg or png
+	Format string `protobuf:"bytes,2,opt,name=format,proto3" json:"format,omitempty"`
+	// skip the crops rotated so the eyes are level
+	Unaligned     bool `protobuf:"varint,3,opt,name=unaligned,proto3" json:"unaligned,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CropOptions) Reset() {
+	*x = CropOptions{}
+	mi := &file_roc_face_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CropOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CropOptions) ProtoMessage() {}
+
+func (x *CropOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CropOptions.ProtoReflect.Descriptor instead.
+func (*CropOptions) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CropOptions) GetPadding() float64 {
+	if x != nil {
+		return x.Padding
+	}
+	return 0
+}
+
+func (x *CropOptions) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *CropOptions) GetUnaligned() bool {
+	if x != nil {
+		return x.Unaligned
+	}
+	return false
+}
+
+type FaceCrop struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Box           *FaceBox               `protobuf:"bytes,1,opt,name=box,proto3" json:"box,omitempty"`
+	Format        string                 `protobuf:"bytes,2,opt,name=format,proto3" json:"format,omitempty"`
+	Image         []byte                 `protobuf:"bytes,3,opt,name=image,proto3" json:"image,omitempty"`
+	Aligned       []byte                 `protobuf:"bytes,4,opt,name=aligned,proto3" json:"aligned,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FaceCrop) Reset() {
+	*x = FaceCrop{}
+	mi := &file_roc_face_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FaceCrop) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FaceCrop) ProtoMessage() {}
+
+func (x *FaceCrop) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FaceCrop.ProtoReflect.Descriptor instead.
+func (*FaceCrop) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *FaceCrop) GetBox() *FaceBox {
+	if x != nil {
+		return x.Box
+	}
+	return nil
+}
+
+func (x *FaceCrop) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *FaceCrop) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *FaceCrop) GetAligned() []byte {
+	if x != nil {
+		return x.Aligned
+	}
+	return nil
+}
+
+type VerifyOptions struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	ColorSpace     string                 `protobuf:"bytes,1,opt,name=color_space,json=colorSpace,proto3" json:"color_space,omitempty"`
+	RetryRotations bool                   `protobuf:"varint,2,opt,name=retry_rotations,json=retryRotations,proto3" json:"retry_rotations,omitempty"`
+	RetryUpscale   bool                   `protobuf:"varint,3,opt,name=retry_upscale,json=retryUpscale,proto3" json:"retry_upscale,omitempty"`
+	Detection      string                 `protobuf:"bytes,4,opt,name=detection,proto3" json:"detection,omitempty"`
+	Spoof          *SpoofOptions          `protobuf:"bytes,5,opt,name=spoof,proto3" json:"spoof,omitempty"`
+	Verbose        bool                   `protobuf:"varint,6,opt,name=verbose,proto3" json:"verbose,omitempty"`
+	// when set, up to num_faces_to_detect faces are detected per image and one
+	// is chosen with this strategy
+	FaceSelection    string `protobuf:"bytes,7,opt,name=face_selection,json=faceSelection,proto3" json:"face_selection,omitempty"`
+	NumFacesToDetect int32  `protobuf:"varint,8,opt,name=num_faces_to_detect,json=numFacesToDetect,proto3" json:"num_faces_to_detect,omitempty"`
+	// detect the faces again, even for images in the template cache
+	SkipCache     bool `protobuf:"varint,9,opt,name=skip_cache,json=skipCache,proto3" json:"skip_cache,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VerifyOptions) Reset() {
+	*x = VerifyOptions{}
+	mi := &file_roc_face_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifyOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyOptions) ProtoMessage() {}
+
+func (x *VerifyOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyOptions.ProtoReflect.Descriptor instead.
+func (*VerifyOptions) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *VerifyOptions) GetColorSpace() string {
+	if x != nil {
+		return x.ColorSpace
+	}
+	return ""
+}
+
+func (x *VerifyOptions) GetRetryRotations() bool {
+	if x != nil {
+		return x.RetryRotations
+	}
+	return false
+}
+
+func (x *VerifyOptions) GetRetryUpscale() bool {
+	if x != nil {
+		return x.RetryUpscale
+	}
+	return false
+}
+
+func (x *VerifyOptions) GetDetection() string {
+	if x != nil {
+		return x.Detection
+	}
+	return ""
+}
+
+func (x *VerifyOptions) GetSpoof() *SpoofOptions {
+	if x != nil {
+		return x.Spoof
+	}
+	return nil
+}
+
+func (x *VerifyOptions) GetVerbose() bool {
+	if x != nil {
+		return x.Verbose
+	}
+	return false
+}
+
+func (x *VerifyOptions) GetFaceSelection() string {
+	if x != nil {
+		return x.FaceSelection
+	}
+	return ""
+}
+
+func (x *VerifyOptions) GetNumFacesToDetect() int32 {
+	if x != nil {
+		return x.NumFacesToDetect
+	}
+	return 0
+}
+
+func (x *VerifyOptions) GetSkipCache() bool {
+	if x != nil {
+		return x.SkipCache
+	}
+	return false
+}
+
+type VerifyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Image1        []byte                 `protobuf:"bytes,1,opt,name=image1,proto3" json:"image1,omitempty"`
+	Image2        []byte                 `protobuf:"bytes,2,opt,name=image2,proto3" json:"image2,omitempty"`
+	Options       *VerifyOptions         `protobuf:"bytes,3,opt,name=options,proto3" json:"options,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VerifyRequest) Reset() {
+	*x = VerifyRequest{}
+	mi := &file_roc_face_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyRequest) ProtoMessage() {}
+
+func (x *VerifyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyRequest.ProtoReflect.Descriptor instead.
+func (*VerifyRequest) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *VerifyRequest) GetImage1() []byte {
+	if x != nil {
+		return x.Image1
+	}
+	return nil
+}
+
+func (x *VerifyRequest) GetImage2() []byte {
+	if x != nil {
+		return x.Image2
+	}
+	return nil
+}
+
+func (x *VerifyRequest) GetOptions() *VerifyOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+type FaceSelection struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         int32                  `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	FaceCount     int32                  `protobuf:"varint,2,opt,name=face_count,json=faceCount,proto3" json:"face_count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FaceSelection) Reset() {
+	*x = FaceSelection{}
+	mi := &file_roc_face_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FaceSelection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FaceSelection) ProtoMessage() {}
+
+func (x *FaceSelection) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FaceSelection.ProtoReflect.Descriptor instead.
+func (*FaceSelection) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *FaceSelection) GetIndex() int32 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *FaceSelection) GetFaceCount() int32 {
+	if x != nil {
+		return x.FaceCount
+	}
+	return 0
+}
+
+type FaceDetails struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// absent if no face was found
+	Template      *FaceBox `protobuf:"bytes,1,opt,name=template,proto3" json:"template,omitempty"`
+	FaceCount     int32    `protobuf:"varint,2,opt,name=face_count,json=faceCount,proto3" json:"face_count,omitempty"`
+	Quality       *float64 `protobuf:"fixed64,3,opt,name=quality,proto3,oneof" json:"quality,omitempty"`
+	Pose          string   `protobuf:"bytes,4,opt,name=pose,proto3" json:"pose,omitempty"`
+	Yaw           *float64 `protobuf:"fixed64,5,opt,name=yaw,proto3,oneof" json:"yaw,omitempty"`
+	Pitch         *float64 `protobuf:"fixed64,6,opt,name=pitch,proto3,oneof" json:"pitch,omitempty"`
+	Roll          *float64 `protobuf:"fixed64,7,opt,name=roll,proto3,oneof" json:"roll,omitempty"`
+	Iod           *float64 `protobuf:"fixed64,8,opt,name=iod,proto3,oneof" json:"iod,omitempty"`
+	Warnings      []string `protobuf:"bytes,9,rep,name=warnings,proto3" json:"warnings,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FaceDetails) Reset() {
+	*x = FaceDetails{}
+	mi := &file_roc_face_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FaceDetails) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FaceDetails) ProtoMessage() {}
+
+func (x *FaceDetails) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FaceDetails.ProtoReflect.Descriptor instead.
+func (*FaceDetails) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *FaceDetails) GetTemplate() *FaceBox {
+	if x != nil {
+		return x.Template
+	}
+	return nil
+}
+
+func (x *FaceDetails) GetFaceCount() int32 {
+	if x != nil {
+		return x.FaceCount
+	}
+	return 0
+}
+
+func (x *FaceDetails) GetQuality() float64 {
+	if x != nil && x.Quality != nil {
+		return *x.Quality
+	}
+	return 0
+}
+
+func (x *FaceDetails) GetPose() string {
+	if x != nil {
+		return x.Pose
+	}
+	return ""
+}
+
+func (x *FaceDetails) GetYaw() float64 {
+	if x != nil && x.Yaw != nil {
+		return *x.Yaw
+	}
+	return 0
+}
+
+func (x *FaceDetails) GetPitch() float64 {
+	if x != nil && x.Pitch != nil {
+		return *x.Pitch
+	}
+	return 0
+}
+
+func (x *FaceDetails) GetRoll() float64 {
+	if x != nil && x.Roll != nil {
+		return *x.Roll
+	}
+	return 0
+}
+
+func (x *FaceDetails) GetIod() float64 {
+	if x != nil && x.Iod != nil {
+		return *x.Iod
+	}
+	return 0
+}
+
+func (x *FaceDetails) GetWarnings() []string {
+	if x != nil {
+		return x.Warnings
+	}
+	return nil
+}
+
+type VerifyResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Similarity    float32                `protobuf:"fixed32,1,opt,name=similarity,proto3" json:"similarity,omitempty"`
+	Code          string                 `protobuf:"bytes,2,opt,name=code,proto3" json:"code,omitempty"`
+	Message       string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	ColorSpace    string                 `protobuf:"bytes,4,opt,name=color_space,json=colorSpace,proto3" json:"color_space,omitempty"`
+	Transforms    []string               `protobuf:"bytes,5,rep,name=transforms,proto3" json:"transforms,omitempty"`
+	Retries       []string               `protobuf:"bytes,6,rep,name=retries,proto3" json:"retries,omitempty"`
+	Detection     string                 `protobuf:"bytes,7,opt,name=detection,proto3" json:"detection,omitempty"`
+	FaceSelection string                 `protobuf:"bytes,8,opt,name=face_selection,json=faceSelection,proto3" json:"face_selection,omitempty"`
+	Selected      []*FaceSelection       `protobuf:"bytes,9,rep,name=selected,proto3" json:"selected,omitempty"`
+	Images        []*FaceDetails         `protobuf:"bytes,10,rep,name=images,proto3" json:"images,omitempty"`
+	Spoof         []*SpoofResult         `protobuf:"bytes,11,rep,name=spoof,proto3" json:"spoof,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VerifyResponse) Reset() {
+	*x = VerifyResponse{}
+	mi := &file_roc_face_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifyResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyResponse) ProtoMessage() {}
+
+func (x *VerifyResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyResponse.ProtoReflect.Descriptor instead.
+func (*VerifyResponse) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *VerifyResponse) GetSimilarity() float32 {
+	if x != nil {
+		return x.Similarity
+	}
+	return 0
+}
+
+func (x *VerifyResponse) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *VerifyResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *VerifyResponse) GetColorSpace() string {
+	if x != nil {
+		return x.ColorSpace
+	}
+	return ""
+}
+
+func (x *VerifyResponse) GetTransforms() []string {
+	if x != nil {
+		return x.Transforms
+	}
+	return nil
+}
+
+func (x *VerifyResponse) GetRetries() []string {
+	if x != nil {
+		return x.Retries
+	}
+	return nil
+}
+
+func (x *VerifyResponse) GetDetection() string {
+	if x != nil {
+		return x.Detection
+	}
+	return ""
+}
+
+func (x *VerifyResponse) GetFaceSelection() string {
+	if x != nil {
+		return x.FaceSelection
+	}
+	return ""
+}
+
+func (x *VerifyResponse) GetSelected() []*FaceSelection {
+	if x != nil {
+		return x.Selected
+	}
+	return nil
+}
+
+func (x *VerifyResponse) GetImages() []*FaceDetails {
+	if x != nil {
+		return x.Images
+	}
+	return nil
+}
+
+func (x *VerifyResponse) GetSpoof() []*SpoofResult {
+	if x != nil {
+		return x.Spoof
+	}
+	return nil
+}
+
+type AnalyzeOptions struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Detection *DetectionOptions      `protobuf:"bytes,1,opt,name=detection,proto3" json:"detection,omitempty"`
+	// defaults to the server's default attributes
+	Attributes []string `protobuf:"bytes,2,rep,name=attributes,proto3" json:"attributes,omitempty"`
+	// defaults to the report policy
+	Spoof *SpoofOptions `protobuf:"bytes,3,opt,name=spoof,proto3" json:"spoof,omitempty"`
+	// when set, crops of the detected faces are returned
+	Crops         *CropOptions `protobuf:"bytes,4,opt,name=crops,proto3" json:"crops,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnalyzeOptions) Reset() {
+	*x = AnalyzeOptions{}
+	mi := &file_roc_face_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalyzeOptions) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalyzeOptions) ProtoMessage() {}
+
+func (x *AnalyzeOptions) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalyzeOptions.ProtoReflect.Descriptor instead.
+func (*AnalyzeOptions) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *AnalyzeOptions) GetDetection() *DetectionOptions {
+	if x != nil {
+		return x.Detection
+	}
+	return nil
+}
+
+func (x *AnalyzeOptions) GetAttributes() []string {
+	if x != nil {
+		return x.Attributes
+	}
+	return nil
+}
+
+func (x *AnalyzeOptions) GetSpoof() *SpoofOptions {
+	if x != nil {
+		return x.Spoof
+	}
+	return nil
+}
+
+func (x *AnalyzeOptions) GetCrops() *CropOptions {
+	if x != nil {
+		return x.Crops
+	}
+	return nil
+}
+
+type AnalyzeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Image         []byte                 `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	Options       *AnalyzeOptions        `protobuf:"bytes,2,opt,name=options,proto3" json:"options,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnalyzeRequest) Reset() {
+	*x = AnalyzeRequest{}
+	mi := &file_roc_face_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalyzeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalyzeRequest) ProtoMessage() {}
+
+func (x *AnalyzeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalyzeRequest.ProtoReflect.Descriptor instead.
+func (*AnalyzeRequest) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *AnalyzeRequest) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *AnalyzeRequest) GetOptions() *AnalyzeOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+type AnalyzeResponse struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	Code                 string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message              string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Fdr                  float32                `protobuf:"fixed32,3,opt,name=fdr,proto3" json:"fdr,omitempty"`
+	MinFaceWidthInPixels int32                  `protobuf:"varint,4,opt,name=min_face_width_in_pixels,json=minFaceWidthInPixels,proto3" json:"min_face_width_in_pixels,omitempty"`
+	ColorSpace           string                 `protobuf:"bytes,5,opt,name=color_space,json=colorSpace,proto3" json:"color_space,omitempty"`
+	Transform            string                 `protobuf:"bytes,6,opt,name=transform,proto3" json:"transform,omitempty"`
+	Retry                string                 `protobuf:"bytes,7,opt,name=retry,proto3" json:"retry,omitempty"`
+	Detection            string                 `protobuf:"bytes,8,opt,name=detection,proto3" json:"detection,omitempty"`
+	// template metadata of the first face, e.g. "Age", "Yaw", "IOD"
+	Analysis      *structpb.Struct `protobuf:"bytes,9,opt,name=analysis,proto3" json:"analysis,omitempty"`
+	Spoof         *SpoofResult     `protobuf:"bytes,10,opt,name=spoof,proto3" json:"spoof,omitempty"`
+	Thumbnail     []byte           `protobuf:"bytes,11,opt,name=thumbnail,proto3" json:"thumbnail,omitempty"`
+	Crops         []*FaceCrop      `protobuf:"bytes,12,rep,name=crops,proto3" json:"crops,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnalyzeResponse) Reset() {
+	*x = AnalyzeResponse{}
+	mi := &file_roc_face_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalyzeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalyzeResponse) ProtoMessage() {}
+
+func (x *AnalyzeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalyzeResponse.ProtoReflect.Descriptor instead.
+func (*AnalyzeResponse) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *AnalyzeResponse) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetFdr() float32 {
+	if x != nil {
+		return x.Fdr
+	}
+	return 0
+}
+
+func (x *AnalyzeResponse) GetMinFaceWidthInPixels() int32 {
+	if x != nil {
+		return x.MinFaceWidthInPixels
+	}
+	return 0
+}
+
+func (x *AnalyzeResponse) GetColorSpace() string {
+	if x != nil {
+		return x.ColorSpace
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetTransform() string {
+	if x != nil {
+		return x.Transform
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetRetry() string {
+	if x != nil {
+		return x.Retry
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetDetection() string {
+	if x != nil {
+		return x.Detection
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetAnalysis() *structpb.Struct {
+	if x != nil {
+		return x.Analysis
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetSpoof() *SpoofResult {
+	if x != nil {
+		return x.Spoof
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetThumbnail() []byte {
+	if x != nil {
+		return x.Thumbnail
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetCrops() []*FaceCrop {
+	if x != nil {
+		return x.Crops
+	}
+	return nil
+}
+
+type ExtractTemplateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Image         []byte                 `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	Options       *DetectionOptions      `protobuf:"bytes,2,opt,name=options,proto3" json:"options,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExtractTemplateRequest) Reset() {
+	*x = ExtractTemplateRequest{}
+	mi := &file_roc_face_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExtractTemplateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExtractTemplateRequest) ProtoMessage() {}
+
+func (x *ExtractTemplateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExtractTemplateRequest.ProtoReflect.Descriptor instead.
+func (*ExtractTemplateRequest) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ExtractTemplateRequest) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *ExtractTemplateRequest) GetOptions() *DetectionOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+type FaceTemplate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Box           *FaceBox               `protobuf:"bytes,1,opt,name=box,proto3" json:"box,omitempty"`
+	Template      []byte                 `protobuf:"bytes,2,opt,name=template,proto3" json:"template,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FaceTemplate) Reset() {
+	*x = FaceTemplate{}
+	mi := &file_roc_face_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FaceTemplate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FaceTemplate) ProtoMessage() {}
+
+func (x *FaceTemplate) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FaceTemplate.ProtoReflect.Descriptor instead.
+func (*FaceTemplate) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *FaceTemplate) GetBox() *FaceBox {
+	if x != nil {
+		return x.Box
+	}
+	return nil
+}
+
+func (x *FaceTemplate) GetTemplate() []byte {
+	if x != nil {
+		return x.Template
+	}
+	return nil
+}
+
+type ExtractTemplateResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Faces         []*FaceTemplate        `protobuf:"bytes,3,rep,name=faces,proto3" json:"faces,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ExtractTemplateResponse) Reset() {
+	*x = ExtractTemplateResponse{}
+	mi := &file_roc_face_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ExtractTemplateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExtractTemplateResponse) ProtoMessage() {}
+
+func (x *ExtractTemplateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExtractTemplateResponse.ProtoReflect.Descriptor instead.
+func (*ExtractTemplateResponse) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *ExtractTemplateResponse) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *ExtractTemplateResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *ExtractTemplateResponse) GetFaces() []*FaceTemplate {
+	if x != nil {
+		return x.Faces
+	}
+	return nil
+}
+
+type CompareRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Template1     []byte                 `protobuf:"bytes,1,opt,name=template1,proto3" json:"template1,omitempty"`
+	Template2     []byte                 `protobuf:"bytes,2,opt,name=template2,proto3" json:"template2,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompareRequest) Reset() {
+	*x = CompareRequest{}
+	mi := &file_roc_face_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompareRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompareRequest) ProtoMessage() {}
+
+func (x *CompareRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompareRequest.ProtoReflect.Descriptor instead.
+func (*CompareRequest) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *CompareRequest) GetTemplate1() []byte {
+	if x != nil {
+		return x.Template1
+	}
+	return nil
+}
+
+func (x *CompareRequest) GetTemplate2() []byte {
+	if x != nil {
+		return x.Template2
+	}
+	return nil
+}
+
+type CompareResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Similarity    float32                `protobuf:"fixed32,1,opt,name=similarity,proto3" json:"similarity,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CompareResponse) Reset() {
+	*x = CompareResponse{}
+	mi := &file_roc_face_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CompareResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompareResponse) ProtoMessage() {}
+
+func (x *CompareResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompareResponse.ProtoReflect.Descriptor instead.
+func (*CompareResponse) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *CompareResponse) GetSimilarity() float32 {
+	if x != nil {
+		return x.Similarity
+	}
+	return 0
+}
+
+type EnrollRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// defaults to "default"
+	Gallery   string `protobuf:"bytes,1,opt,name=gallery,proto3" json:"gallery,omitempty"`
+	SubjectId string `protobuf:"bytes,2,opt,name=subject_id,json=subjectId,proto3" json:"subject_id,omitempty"`
+	// the face found in the image is enrolled, an image with several faces
+	// fails with MultipleFacesDetected (at least 2 are looked for, whatever
+	// num_faces_to_detect)
+	//
+	// Types that are valid to be assigned to Face:
+	//
+	//	*EnrollRequest_Image
+	//	*EnrollRequest_Template
+	Face          isEnrollRequest_Face `protobuf_oneof:"face"`
+	Options       *DetectionOptions    `protobuf:"bytes,5,opt,name=options,proto3" json:"options,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnrollRequest) Reset() {
+	*x = EnrollRequest{}
+	mi := &file_roc_face_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnrollRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrollRequest) ProtoMessage() {}
+
+func (x *EnrollRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrollRequest.ProtoReflect.Descriptor instead.
+func (*EnrollRequest) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *EnrollRequest) GetGallery() string {
+	if x != nil {
+		return x.Gallery
+	}
+	return ""
+}
+
+func (x *EnrollRequest) GetSubjectId() string {
+	if x != nil {
+		return x.SubjectId
+	}
+	return ""
+}
+
+func (x *EnrollRequest) GetFace() isEnrollRequest_Face {
+	if x != nil {
+		return x.Face
+	}
+	return nil
+}
+
+func (x *EnrollRequest) GetImage() []byte {
+	if x != nil {
+		if x, ok := x.Face.(*EnrollRequest_Image); ok {
+			return x.Image
+		}
+	}
+	return nil
+}
+
+func (x *EnrollRequest) GetTemplate() []byte {
+	if x != nil {
+		if x, ok := x.Face.(*EnrollRequest_Template); ok {
+			return x.Template
+		}
+	}
+	return nil
+}
+
+func (x *EnrollRequest) GetOptions() *DetectionOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+type isEnrollRequest_Face interface {
+	isEnrollRequest_Face()
+}
+
+type EnrollRequest_Image struct {
+	Image []byte `protobuf:"bytes,3,opt,name=image,proto3,oneof"`
+}
+
+type EnrollRequest_Template struct {
+	Template []byte `protobuf:"bytes,4,opt,name=template,proto3,oneof"`
+}
+
+func (*EnrollRequest_Image) isEnrollRequest_Face() {}
+
+func (*EnrollRequest_Template) isEnrollRequest_Face() {}
+
+type EnrollResponse struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Code      string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message   string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Gallery   string                 `protobuf:"bytes,3,opt,name=gallery,proto3" json:"gallery,omitempty"`
+	SubjectId string                 `protobuf:"bytes,4,opt,name=subject_id,json=subjectId,proto3" json:"subject_id,omitempty"`
+	// templates enrolled for the subject, including this one
+	TemplateCount int32    `protobuf:"varint,5,opt,name=template_count,json=templateCount,proto3" json:"template_count,omitempty"`
+	Box           *FaceBox `protobuf:"bytes,6,opt,name=box,proto3" json:"box,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnrollResponse) Reset() {
+	*x = EnrollResponse{}
+	mi := &file_roc_face_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnrollResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrollResponse) ProtoMessage() {}
+
+func (x *EnrollResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrollResponse.ProtoReflect.Descriptor instead.
+func (*EnrollResponse) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *EnrollResponse) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *EnrollResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *EnrollResponse) GetGallery() string {
+	if x != nil {
+		return x.Gallery
+	}
+	return ""
+}
+
+func (x *EnrollResponse) GetSubjectId() string {
+	if x != nil {
+		return x.SubjectId
+	}
+	return ""
+}
+
+func (x *EnrollResponse) GetTemplateCount() int32 {
+	if x != nil {
+		return x.TemplateCount
+	}
+	return 0
+}
+
+func (x *EnrollResponse) GetBox() *FaceBox {
+	if x != nil {
+		return x.Box
+	}
+	return nil
+}
+
+type SearchRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Gallery string                 `protobuf:"bytes,1,opt,name=gallery,proto3" json:"gallery,omitempty"`
+	// Types that are valid to be assigned to Probe:
+	//
+	//	*SearchRequest_Image
+	//	*SearchRequest_Template
+	Probe   isSearchRequest_Probe `protobuf_oneof:"probe"`
+	Options *DetectionOptions     `protobuf:"bytes,4,opt,name=options,proto3" json:"options,omitempty"`
+	// defaults to 10
+	MaxResults int32 `protobuf:"varint,5,opt,name=max_results,json=maxResults,proto3" json:"max_results,omitempty"`
+	// minimum similarity of the returned subjects
+	Threshold     float32 `protobuf:"fixed32,6,opt,name=threshold,proto3" json:"threshold,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchRequest) Reset() {
+	*x = SearchRequest{}
+	mi := &file_roc_face_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchRequest) ProtoMessage() {}
+
+func (x *SearchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchRequest.ProtoReflect.Descriptor instead.
+func (*SearchRequest) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *SearchRequest) GetGallery() string {
+	if x != nil {
+		return x.Gallery
+	}
+	return ""
+}
+
+func (x *SearchRequest) GetProbe() isSearchRequest_Probe {
+	if x != nil {
+		return x.Probe
+	}
+	return nil
+}
+
+func (x *SearchRequest) GetImage() []byte {
+	if x != nil {
+		if x, ok := x.Probe.(*SearchRequest_Image); ok {
+			return x.Image
+		}
+	}
+	return nil
+}
+
+func (x *SearchRequest) GetTemplate() []byte {
+	if x != nil {
+		if x, ok := x.Probe.(*SearchRequest_Template); ok {
+			return x.Template
+		}
+	}
+	return nil
+}
+
+func (x *SearchRequest) GetOptions() *DetectionOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+func (x *SearchRequest) GetMaxResults() int32 {
+	if x != nil {
+		return x.MaxResults
+	}
+	return 0
+}
+
+func (x *SearchRequest) GetThreshold() float32 {
+	if x != nil {
+		return x.Threshold
+	}
+	return 0
+}
+
+type isSearchRequest_Probe interface {
+	isSearchRequest_Probe()
+}
+
+type SearchRequest_Image struct {
+	Image []byte `protobuf:"bytes,2,opt,name=image,proto3,oneof"`
+}
+
+type SearchRequest_Template struct {
+	Template []byte `protobuf:"bytes,3,opt,name=template,proto3,oneof"`
+}
+
+func (*SearchRequest_Image) isSearchRequest_Probe() {}
+
+func (*SearchRequest_Template) isSearchRequest_Probe() {}
+
+type SearchMatch struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SubjectId     string                 `protobuf:"bytes,1,opt,name=subject_id,json=subjectId,proto3" json:"subject_id,omitempty"`
+	Similarity    float32                `protobuf:"fixed32,2,opt,name=similarity,proto3" json:"similarity,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchMatch) Reset() {
+	*x = SearchMatch{}
+	mi := &file_roc_face_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchMatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchMatch) ProtoMessage() {}
+
+func (x *SearchMatch) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchMatch.ProtoReflect.Descriptor instead.
+func (*SearchMatch) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *SearchMatch) GetSubjectId() string {
+	if x != nil {
+		return x.SubjectId
+	}
+	return ""
+}
+
+func (x *SearchMatch) GetSimilarity() float32 {
+	if x != nil {
+		return x.Similarity
+	}
+	return 0
+}
+
+type SearchResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	Matches       []*SearchMatch         `protobuf:"bytes,3,rep,name=matches,proto3" json:"matches,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SearchResponse) Reset() {
+	*x = SearchResponse{}
+	mi := &file_roc_face_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SearchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchResponse) ProtoMessage() {}
+
+func (x *SearchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchResponse.ProtoReflect.Descriptor instead.
+func (*SearchResponse) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *SearchResponse) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *SearchResponse) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *SearchResponse) GetMatches() []*SearchMatch {
+	if x != nil {
+		return x.Matches
+	}
+	return nil
+}
+
+type VideoFrame struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Image         []byte                 `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+	TimestampMs   int64                  `protobuf:"varint,2,opt,name=timestamp_ms,json=timestampMs,proto3" json:"timestamp_ms,omitempty"`
+	Options       *AnalyzeOptions        `protobuf:"bytes,3,opt,name=options,proto3" json:"options,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VideoFrame) Reset() {
+	*x = VideoFrame{}
+	mi := &file_roc_face_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VideoFrame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VideoFrame) ProtoMessage() {}
+
+func (x *VideoFrame) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VideoFrame.ProtoReflect.Descriptor instead.
+func (*VideoFrame) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *VideoFrame) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *VideoFrame) GetTimestampMs() int64 {
+	if x != nil {
+		return x.TimestampMs
+	}
+	return 0
+}
+
+func (x *VideoFrame) GetOptions() *AnalyzeOptions {
+	if x != nil {
+		return x.Options
+	}
+	return nil
+}
+
+type FrameAnalysis struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TimestampMs   int64                  `protobuf:"varint,1,opt,name=timestamp_ms,json=timestampMs,proto3" json:"timestamp_ms,omitempty"`
+	Analysis      *AnalyzeResponse       `protobuf:"bytes,2,opt,name=analysis,proto3" json:"analysis,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FrameAnalysis) Reset() {
+	*x = FrameAnalysis{}
+	mi := &file_roc_face_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FrameAnalysis) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FrameAnalysis) ProtoMessage() {}
+
+func (x *FrameAnalysis) ProtoReflect() protoreflect.Message {
+	mi := &file_roc_face_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FrameAnalysis.ProtoReflect.Descriptor instead.
+func (*FrameAnalysis) Descriptor() ([]byte, []int) {
+	return file_roc_face_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *FrameAnalysis) GetTimestampMs() int64 {
+	if x != nil {
+		return x.TimestampMs
+	}
+	return 0
+}
+
+func (x *FrameAnalysis) GetAnalysis() *AnalyzeResponse {
+	if x != nil {
+		return x.Analysis
+	}
+	return nil
+}
+
+var File_roc_face_proto protoreflect.FileDescriptor
+
+const file_roc_face_proto_rawDesc = "" +
+	"\n" +
+	"\x0eroc_face.proto\x12\arocface\x1a\x1cgoogle/protobuf/struct.proto\"S\n" +
+	"\aFaceBox\x12\f\n" +
+	"\x01x\x18\x01 \x01(\x05R\x01x\x12\f\n" +
+	"\x01y\x18\x02 \x01(\x05R\x01y\x12\x14\n" +
+	"\x05width\x18\x03 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x04 \x01(\x05R\x06height\"k\n" +
+	"\fSpoofOptions\x12\x16\n" +
+	"\x06policy\x18\x01 \x01(\tR\x06policy\x12%\n" +
+	"\x0elive_threshold\x18\x02 \x01(\x01R\rliveThreshold\x12\x1c\n" +
+	"\tthreshold\x18\x03 \x01(\x01R\tthreshold\"=\n" +
+	"\vSpoofResult\x12\x18\n" +
+	"\averdict\x18\x01 \x01(\tR\averdict\x12\x14\n" +
+	"\x05score\x18\x02 \x01(\x01R\x05score\"\x98\x02\n" +
+	"\x10DetectionOptions\x12\x10\n" +
+	"\x03fdr\x18\x01 \x01(\x02R\x03fdr\x126\n" +
+	"\x18min_face_width_in_pixels\x18\x02 \x01(\x05R\x14minFaceWidthInPixels\x12-\n" +
+	"\x13num_faces_to_detect\x18\x03 \x01(\x05R\x10numFacesToDetect\x12\x1f\n" +
+	"\vcolor_space\x18\x04 \x01(\tR\n" +
+	"colorSpace\x12'\n" +
+	"\x0fretry_rotations\x18\x05 \x01(\bR\x0eretryRotations\x12#\n" +
+	"\rretry_upscale\x18\x06 \x01(\bR\fretryUpscale\x12\x1c\n" +
+	"\tdetection\x18\a \x01(\tR\tdetection\"]\n" +
+	"\vCropOptions\x12\x18\n" +
+	"\apadding\x18\x01 \x01(\x01R\apadding\x12\x16\n" +
+	"\x06format\x18\x02 \x01(\tR\x06format\x12\x1c\n" +
+	"\tunaligned\x18\x03 \x01(\bR\tunaligned\"v\n" +
+	"\bFaceCrop\x12\"\n" +
+	"\x03box\x18\x01 \x01(\v2\x10.rocface.FaceBoxR\x03box\x12\x16\n" +
+	"\x06format\x18\x02 \x01(\tR\x06format\x12\x14\n" +
+	"\x05image\x18\x03 \x01(\fR\x05image\x12\x18\n" +
+	"\aaligned\x18\x04 \x01(\fR\aaligned\"\xd8\x02\n" +
+	"\rVerifyOptions\x12\x1f\n" +
+	"\vcolor_space\x18\x01 \x01(\tR\n" +
+	"colorSpace\x12'\n" +
+	"\x0fretry_rotations\x18\x02 \x01(\bR\x0eretryRotations\x12#\n" +
+	"\rretry_upscale\x18\x03 \x01(\bR\fretryUpscale\x12\x1c\n" +
+	"\tdetection\x18\x04 \x01(\tR\tdetection\x12+\n" +
+	"\x05spoof\x18\x05 \x01(\v2\x15.rocface.SpoofOptionsR\x05spoof\x12\x18\n" +
+	"\averbose\x18\x06 \x01(\bR\averbose\x12%\n" +
+	"\x0eface_selection\x18\a \x01(\tR\rfaceSelection\x12-\n" +
+	"\x13num_faces_to_detect\x18\b \x01(\x05R\x10numFacesToDetect\x12\x1d\n" +
+	"\n" +
+	"skip_cache\x18\t \x01(\bR\tskipCache\"q\n" +
+	"\rVerifyRequest\x12\x16\n" +
+	"\x06image1\x18\x01 \x01(\fR\x06image1\x12\x16\n" +
+	"\x06image2\x18\x02 \x01(\fR\x06image2\x120\n" +
+	"\aoptions\x18\x03 \x01(\v2\x16.rocface.VerifyOptionsR\aoptions\"D\n" +
+	"\rFaceSelection\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x05R\x05index\x12\x1d\n" +
+	"\n" +
+	"face_count\x18\x02 \x01(\x05R\tfaceCount\"\xba\x02\n" +
+	"\vFaceDetails\x12,\n" +
+	"\btemplate\x18\x01 \x01(\v2\x10.rocface.FaceBoxR\btemplate\x12\x1d\n" +
+	"\n" +
+	"face_count\x18\x02 \x01(\x05R\tfaceCount\x12\x1d\n" +
+	"\aquality\x18\x03 \x01(\x01H\x00R\aquality\x88\x01\x01\x12\x12\n" +
+	"\x04pose\x18\x04 \x01(\tR\x04pose\x12\x15\n" +
+	"\x03yaw\x18\x05 \x01(\x01H\x01R\x03yaw\x88\x01\x01\x12\x19\n" +
+	"\x05pitch\x18\x06 \x01(\x01H\x02R\x05pitch\x88\x01\x01\x12\x17\n" +
+	"\x04roll\x18\a \x01(\x01H\x03R\x04roll\x88\x01\x01\x12\x15\n" +
+	"\x03iod\x18\b \x01(\x01H\x04R\x03iod\x88\x01\x01\x12\x1a\n" +
+	"\bwarnings\x18\t \x03(\tR\bwarningsB\n" +
+	"\n" +
+	"\b_qualityB\x06\n" +
+	"\x04_yawB\b\n" +
+	"\x06_pitchB\a\n" +
+	"\x05_rollB\x06\n" +
+	"\x04_iod\"\x8c\x03\n" +
+	"\x0eVerifyResponse\x12\x1e\n" +
+	"\n" +
+	"similarity\x18\x01 \x01(\x02R\n" +
+	"similarity\x12\x12\n" +
+	"\x04code\x18\x02 \x01(\tR\x04code\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\x12\x1f\n" +
+	"\vcolor_space\x18\x04 \x01(\tR\n" +
+	"colorSpace\x12\x1e\n" +
+	"\n" +
+	"transforms\x18\x05 \x03(\tR\n" +
+	"transforms\x12\x18\n" +
+	"\aretries\x18\x06 \x03(\tR\aretries\x12\x1c\n" +
+	"\tdetection\x18\a \x01(\tR\tdetection\x12%\n" +
+	"\x0eface_selection\x18\b \x01(\tR\rfaceSelection\x122\n" +
+	"\bselected\x18\t \x03(\v2\x16.rocface.FaceSelectionR\bselected\x12,\n" +
+	"\x06images\x18\n" +
+	" \x03(\v2\x14.rocface.FaceDetailsR\x06images\x12*\n" +
+	"\x05spoof\x18\v \x03(\v2\x14.rocface.SpoofResultR\x05spoof\"\xc2\x01\n" +
+	"\x0eAnalyzeOptions\x127\n" +
+	"\tdetection\x18\x01 \x01(\v2\x19.rocface.DetectionOptionsR\tdetection\x12\x1e\n" +
+	"\n" +
+	"attributes\x18\x02 \x03(\tR\n" +
+	"attributes\x12+\n" +
+	"\x05spoof\x18\x03 \x01(\v2\x15.rocface.SpoofOptionsR\x05spoof\x12*\n" +
+	"\x05crops\x18\x04 \x01(\v2\x14.rocface.CropOptionsR\x05crops\"Y\n" +
+	"\x0eAnalyzeRequest\x12\x14\n" +
+	"\x05image\x18\x01 \x01(\fR\x05image\x121\n" +
+	"\aoptions\x18\x02 \x01(\v2\x17.rocface.AnalyzeOptionsR\aoptions\"\xa4\x03\n" +
+	"\x0fAnalyzeResponse\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x10\n" +
+	"\x03fdr\x18\x03 \x01(\x02R\x03fdr\x126\n" +
+	"\x18min_face_width_in_pixels\x18\x04 \x01(\x05R\x14minFaceWidthInPixels\x12\x1f\n" +
+	"\vcolor_space\x18\x05 \x01(\tR\n" +
+	"colorSpace\x12\x1c\n" +
+	"\ttransform\x18\x06 \x01(\tR\ttransform\x12\x14\n" +
+	"\x05retry\x18\a \x01(\tR\x05retry\x12\x1c\n" +
+	"\tdetection\x18\b \x01(\tR\tdetection\x123\n" +
+	"\banalysis\x18\t \x01(\v2\x17.google.protobuf.StructR\banalysis\x12*\n" +
+	"\x05spoof\x18\n" +
+	" \x01(\v2\x14.rocface.SpoofResultR\x05spoof\x12\x1c\n" +
+	"\tthumbnail\x18\v \x01(\fR\tthumbnail\x12'\n" +
+	"\x05crops\x18\f \x03(\v2\x11.rocface.FaceCropR\x05crops\"c\n" +
+	"\x16ExtractTemplateRequest\x12\x14\n" +
+	"\x05image\x18\x01 \x01(\fR\x05image\x123\n" +
+	"\aoptions\x18\x02 \x01(\v2\x19.rocface.DetectionOptionsR\aoptions\"N\n" +
+	"\fFaceTemplate\x12\"\n" +
+	"\x03box\x18\x01 \x01(\v2\x10.rocface.FaceBoxR\x03box\x12\x1a\n" +
+	"\btemplate\x18\x02 \x01(\fR\btemplate\"t\n" +
+	"\x17ExtractTemplateResponse\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12+\n" +
+	"\x05faces\x18\x03 \x03(\v2\x15.rocface.FaceTemplateR\x05faces\"L\n" +
+	"\x0eCompareRequest\x12\x1c\n" +
+	"\ttemplate1\x18\x01 \x01(\fR\ttemplate1\x12\x1c\n" +
+	"\ttemplate2\x18\x02 \x01(\fR\ttemplate2\"1\n" +
+	"\x0fCompareResponse\x12\x1e\n" +
+	"\n" +
+	"similarity\x18\x01 \x01(\x02R\n" +
+	"similarity\"\xbb\x01\n" +
+	"\rEnrollRequest\x12\x18\n" +
+	"\agallery\x18\x01 \x01(\tR\agallery\x12\x1d\n" +
+	"\n" +
+	"subject_id\x18\x02 \x01(\tR\tsubjectId\x12\x16\n" +
+	"\x05image\x18\x03 \x01(\fH\x00R\x05image\x12\x1c\n" +
+	"\btemplate\x18\x04 \x01(\fH\x00R\btemplate\x123\n" +
+	"\aoptions\x18\x05 \x01(\v2\x19.rocface.DetectionOptionsR\aoptionsB\x06\n" +
+	"\x04face\"\xc2\x01\n" +
+	"\x0eEnrollResponse\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12\x18\n" +
+	"\agallery\x18\x03 \x01(\tR\agallery\x12\x1d\n" +
+	"\n" +
+	"subject_id\x18\x04 \x01(\tR\tsubjectId\x12%\n" +
+	"\x0etemplate_count\x18\x05 \x01(\x05R\rtemplateCount\x12\"\n" +
+	"\x03box\x18\x06 \x01(\v2\x10.rocface.FaceBoxR\x03box\"\xdc\x01\n" +
+	"\rSearchRequest\x12\x18\n" +
+	"\agallery\x18\x01 \x01(\tR\agallery\x12\x16\n" +
+	"\x05image\x18\x02 \x01(\fH\x00R\x05image\x12\x1c\n" +
+	"\btemplate\x18\x03 \x01(\fH\x00R\btemplate\x123\n" +
+	"\aoptions\x18\x04 \x01(\v2\x19.rocface.DetectionOptionsR\aoptions\x12\x1f\n" +
+	"\vmax_results\x18\x05 \x01(\x05R\n" +
+	"maxResults\x12\x1c\n" +
+	"\tthreshold\x18\x06 \x01(\x02R\tthresholdB\a\n" +
+	"\x05probe\"L\n" +
+	"\vSearchMatch\x12\x1d\n" +
+	"\n" +
+	"subject_id\x18\x01 \x01(\tR\tsubjectId\x12\x1e\n" +
+	"\n" +
+	"similarity\x18\x02 \x01(\x02R\n" +
+	"similarity\"n\n" +
+	"\x0eSearchResponse\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\x12.\n" +
+	"\amatches\x18\x03 \x03(\v2\x14.rocface.SearchMatchR\amatches\"x\n" +
+	"\n" +
+	"VideoFrame\x12\x14\n" +
+	"\x05image\x18\x01 \x01(\fR\x05image\x12!\n" +
+	"\ftimestamp_ms\x18\x02 \x01(\x03R\vtimestampMs\x121\n" +
+	"\aoptions\x18\x03 \x01(\v2\x17.rocface.AnalyzeOptionsR\aoptions\"h\n" +
+	"\rFrameAnalysis\x12!\n" +
+	"\ftimestamp_ms\x18\x01 \x01(\x03R\vtimestampMs\x124\n" +
+	"\banalysis\x18\x02 \x01(\v2\x18.rocface.AnalyzeResponseR\banalysis2\xcd\x03\n" +
+	"\aRocFace\x129\n" +
+	"\x06Verify\x12\x16.rocface.VerifyRequest\x1a\x17.rocface.VerifyResponse\x12<\n" +
+	"\aAnalyze\x12\x17.rocface.AnalyzeRequest\x1a\x18.rocface.AnalyzeResponse\x12T\n" +
+	"\x0fExtractTemplate\x12\x1f.rocface.ExtractTemplateRequest\x1a .rocface.ExtractTemplateResponse\x12<\n" +
+	"\aCompare\x12\x17.rocface.CompareRequest\x1a\x18.rocface.CompareResponse\x129\n" +
+	"\x06Enroll\x12\x16.rocface.EnrollRequest\x1a\x17.rocface.EnrollResponse\x129\n" +
+	"\x06Search\x12\x16.rocface.SearchRequest\x1a\x17.rocface.SearchResponse\x12?\n" +
+	"\fAnalyzeVideo\x12\x13.rocface.VideoFrame\x1a\x16.rocface.FrameAnalysis(\x010\x01B,Z*github.com/mvayngrib/roc-face/go/rocfacepbb\x06proto3"
+
+var (
+	file_roc_face_proto_rawDescOnce sync.Once
+	file_roc_face_proto_rawDescData []byte
+)
+
+func file_roc_face_proto_rawDescGZIP() []byte {
+	file_roc_face_proto_rawDescOnce.Do(func() {
+		file_roc_face_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_roc_face_proto_rawDesc), len(file_roc_face_proto_rawDesc)))
+	})
+	return file_roc_face_proto_rawDescData
+}
+
+var file_roc_face_proto_msgTypes = make([]protoimpl.MessageInfo, 26)
+var file_roc_face_proto_goTypes = []any{
+	(*FaceBox)(nil),                 // 0: rocface.FaceBox
+	(*SpoofOptions)(nil),            // 1: rocface.SpoofOptions
+	(*SpoofResult)(nil),             // 2: rocface.SpoofResult
+	(*DetectionOptions)(nil),        // 3: rocface.DetectionOptions
+	(*CropOptions)(nil),             // 4: rocface.CropOptions
+	(*FaceCrop)(nil),                // 5: rocface.FaceCrop
+	(*VerifyOptions)(nil),           // 6: rocface.VerifyOptions
+	(*VerifyRequest)(nil),           // 7: rocface.VerifyRequest
+	(*FaceSelection)(nil),           // 8: rocface.FaceSelection
+	(*FaceDetails)(nil),             // 9: rocface.FaceDetails
+	(*VerifyResponse)(nil),          // 10: rocface.VerifyResponse
+	(*AnalyzeOptions)(nil),          // 11: rocface.AnalyzeOptions
+	(*AnalyzeRequest)(nil),          // 12: rocface.AnalyzeRequest
+	(*AnalyzeResponse)(nil),         // 13: rocface.AnalyzeResponse
+	(*ExtractTemplateRequest)(nil),  // 14: rocface.ExtractTemplateRequest
+	(*FaceTemplate)(nil),            // 15: rocface.FaceTemplate
+	(*ExtractTemplateResponse)(nil), // 16: rocface.ExtractTemplateResponse
+	(*CompareRequest)(nil),          // 17: rocface.CompareRequest
+	(*CompareResponse)(nil),         // 18: rocface.CompareResponse
+	(*EnrollRequest)(nil),           // 19: rocface.EnrollRequest
+	(*EnrollResponse)(nil),          // 20: rocface.EnrollResponse
+	(*SearchRequest)(nil),           // 21: rocface.SearchRequest
+	(*SearchMatch)(nil),             // 22: rocface.SearchMatch
+	(*SearchResponse)(nil),          // 23: rocface.SearchResponse
+	(*VideoFrame)(nil),              // 24: rocface.VideoFrame
+	(*FrameAnalysis)(nil),           // 25: rocface.FrameAnalysis
+	(*structpb.Struct)(nil),         // 26: google.protobuf.Struct
+}
+var file_roc_face_proto_depIdxs = []int32{
+	0,  // 0: rocface.FaceCrop.box:type_name -> rocface.FaceBox
+	1,  // 1: rocface.VerifyOptions.spoof:type_name -> rocface.SpoofOptions
+	6,  // 2: rocface.VerifyRequest.options:type_name -> rocface.VerifyOptions
+	0,  // 3: rocface.FaceDetails.template:type_name -> rocface.FaceBox
+	8,  // 4: rocface.VerifyResponse.selected:type_name -> rocface.FaceSelection
+	9,  // 5: rocface.VerifyResponse.images:type_name -> rocface.FaceDetails
+	2,  // 6: rocface.VerifyResponse.spoof:type_name -> rocface.SpoofResult
+	3,  // 7: rocface.AnalyzeOptions.detection:type_name -> rocface.DetectionOptions
+	1,  // 8: rocface.AnalyzeOptions.spoof:type_name -> rocface.SpoofOptions
+	4,  // 9: rocface.AnalyzeOptions.crops:type_name -> rocface.CropOptions
+	11, // 10: rocface.AnalyzeRequest.options:type_name -> rocface.AnalyzeOptions
+	26, // 11: rocface.AnalyzeResponse.analysis:type_name -> google.protobuf.Struct
+	2,  // 12: rocface.AnalyzeResponse.spoof:type_name -> rocface.SpoofResult
+	5,  // 13: rocface.AnalyzeResponse.crops:type_name -> rocface.FaceCrop
+	3,  // 14: rocface.ExtractTemplateRequest.options:type_name -> rocface.DetectionOptions
+	0,  // 15: rocface.FaceTemplate.box:type_name -> rocface.FaceBox
+	15, // 16: rocface.ExtractTemplateResponse.faces:type_name -> rocface.FaceTemplate
+	3,  // 17: rocface.EnrollRequest.options:type_name -> rocface.DetectionOptions
+	0,  // 18: rocface.EnrollResponse.box:type_name -> rocface.FaceBox
+	3,  // 19: rocface.SearchRequest.options:type_name -> rocface.DetectionOptions
+	22, // 20: rocface.SearchResponse.matches:type_name -> rocface.SearchMatch
+	11, // 21: rocface.VideoFrame.options:type_name -> rocface.AnalyzeOptions
+	13, // 22: rocface.FrameAnalysis.analysis:type_name -> rocface.AnalyzeResponse
+	7,  // 23: rocface.RocFace.Verify:input_type -> rocface.VerifyRequest
+	12, // 24: rocface.RocFace.Analyze:input_type -> rocface.AnalyzeRequest
+	14, // 25: rocface.RocFace.ExtractTemplate:input_type -> rocface.ExtractTemplateRequest
+	17, // 26: rocface.RocFace.Compare:input_type -> rocface.CompareRequest
+	19, // 27: rocface.RocFace.Enroll:input_type -> rocface.EnrollRequest
+	21, // 28: rocface.RocFace.Search:input_type -> rocface.SearchRequest
+	24, // 29: rocface.RocFace.AnalyzeVideo:input_type -> rocface.VideoFrame
+	10, // 30: rocface.RocFace.Verify:output_type -> rocface.VerifyResponse
+	13, // 31: rocface.RocFace.Analyze:output_type -> rocface.AnalyzeResponse
+	16, // 32: rocface.RocFace.ExtractTemplate:output_type -> rocface.ExtractTemplateResponse
+	18, // 33: rocface.RocFace.Compare:output_type -> rocface.CompareResponse
+	20, // 34: rocface.RocFace.Enroll:output_type -> rocface.EnrollResponse
+	23, // 35: rocface.RocFace.Search:output_type -> rocface.SearchResponse
+	25, // 36: rocface.RocFace.AnalyzeVideo:output_type -> rocface.FrameAnalysis
+	30, // [30:37] is the sub-list for method output_type
+	23, // [23:30] is the sub-list for method input_type
+	23, // [23:23] is the sub-list for extension type_name
+	23, // [23:23] is the sub-list for extension extendee
+	0,  // [0:23] is the sub-list for field type_name
+}
+
+func init() { file_roc_face_proto_init() }
+func file_roc_face_proto_init() {
+	if File_roc_face_proto != nil {
+		return
+	}
+	file_roc_face_proto_msgTypes[9].OneofWrappers = []any{}
+	file_roc_face_proto_msgTypes[19].OneofWrappers = []any{
+		(*EnrollRequest_Image)(nil),
+		(*EnrollRequest_Template)(nil),
+	}
+	file_roc_face_proto_msgTypes[21].OneofWrappers = []any{
+		(*SearchRequest_Image)(nil),
+		(*SearchRequest_Template)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_roc_face_proto_rawDesc), len(file_roc_face_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   26,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_roc_face_proto_goTypes,
+		DependencyIndexes: file_roc_face_proto_depIdxs,
+		MessageInfos:      file_roc_face_proto_msgTypes,
+	}.Build()
+	File_roc_face_proto = out.File
+	file_roc_face_proto_goTypes = nil
+	file_roc_face_proto_depIdxs = nil
+}
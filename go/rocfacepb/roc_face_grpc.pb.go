@@ -0,0 +1,371 @@
+// gRPC API of roc-face, served next to the HTTP API by roc_server.go and
+// roc_mock_server.go when ROC_FACE_GRPC_PORT is set (see roc_grpc.go).
+//
+// Options left unset get the same defaults as the HTTP query parameters, and
+// results mirror the JSON responses: failures like FaceNotDetected are
+// reported with a code, invalid options with an INVALID_ARGUMENT status.
+//
+// Regenerate the Go code with ./generate.sh
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v3.21.12
+// source: roc_face.proto
+
+package rocfacepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	RocFace_Verify_FullMethodName          = "/rocface.RocFace/Verify"
+	RocFace_Analyze_FullMethodName         = "/rocface.RocFace/Analyze"
+	RocFace_ExtractTemplate_FullMethodName = "/rocface.RocFace/ExtractTemplate"
+	RocFace_Compare_FullMethodName         = "/rocface.RocFace/Compare"
+	RocFace_Enroll_FullMethodName          = "/rocface.RocFace/Enroll"
+	RocFace_Search_FullMethodName          = "/rocface.RocFace/Search"
+	RocFace_AnalyzeVideo_FullMethodName    = "/rocface.RocFace/AnalyzeVideo"
+)
+
+// RocFaceClient is the client API for RocFace service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RocFaceClient interface {
+	// Verify compares the faces in two images
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error)
+	// Analyze extracts the attributes of the face in an image
+	Analyze(ctx context.Context, in *AnalyzeRequest, opts ...grpc.CallOption) (*AnalyzeResponse, error)
+	// ExtractTemplate returns the flattened templates of the faces in an image
+	ExtractTemplate(ctx context.Context, in *ExtractTemplateRequest, opts ...grpc.CallOption) (*ExtractTemplateResponse, error)
+	// Compare compares two flattened templates
+	Compare(ctx context.Context, in *CompareRequest, opts ...grpc.CallOption) (*CompareResponse, error)
+	// Enroll adds a subject's face to a gallery, creating the gallery if needed
+	Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*EnrollResponse, error)
+	// Search ranks the subjects of a gallery by similarity to a probe
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// AnalyzeVideo analyzes a stream of frames, decoded by the client, and
+	// answers each frame with its analysis. The options of the first frame
+	// apply to the whole stream.
+	AnalyzeVideo(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[VideoFrame, FrameAnalysis], error)
+}
+
+type rocFaceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRocFaceClient(cc grpc.ClientConnInterface) RocFaceClient {
+	return &rocFaceClient{cc}
+}
+
+func (c *rocFaceClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (*VerifyResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyResponse)
+	err := c.cc.Invoke(ctx, RocFace_Verify_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rocFaceClient) Analyze(ctx context.Context, in *AnalyzeRequest, opts ...grpc.CallOption) (*AnalyzeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AnalyzeResponse)
+	err := c.cc.Invoke(ctx, RocFace_Analyze_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rocFaceClient) ExtractTemplate(ctx context.Context, in *ExtractTemplateRequest, opts ...grpc.CallOption) (*ExtractTemplateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExtractTemplateResponse)
+	err := c.cc.Invoke(ctx, RocFace_ExtractTemplate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rocFaceClient) Compare(ctx context.Context, in *CompareRequest, opts ...grpc.CallOption) (*CompareResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CompareResponse)
+	err := c.cc.Invoke(ctx, RocFace_Compare_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rocFaceClient) Enroll(ctx context.Context, in *EnrollRequest, opts ...grpc.CallOption) (*EnrollResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EnrollResponse)
+	err := c.cc.Invoke(ctx, RocFace_Enroll_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rocFaceClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, RocFace_Search_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *rocFaceClient) AnalyzeVideo(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[VideoFrame, FrameAnalysis], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &RocFace_ServiceDesc.Streams[0], RocFace_AnalyzeVideo_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[VideoFrame, FrameAnalysis]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RocFace_AnalyzeVideoClient = grpc.BidiStreamingClient[VideoFrame, FrameAnalysis]
+
+// RocFaceServer is the server API for RocFace service.
+// All implementations must embed UnimplementedRocFaceServer
+// for forward compatibility.
+type RocFaceServer interface {
+	// Verify compares the faces in two images
+	Verify(context.Context, *VerifyRequest) (*VerifyResponse, error)
+	// Analyze extracts the attributes of the face in an image
+	Analyze(context.Context, *AnalyzeRequest) (*AnalyzeResponse, error)
+	// ExtractTemplate returns the flattened templates of the faces in an image
+	ExtractTemplate(context.Context, *ExtractTemplateRequest) (*ExtractTemplateResponse, error)
+	// Compare compares two flattened templates
+	Compare(context.Context, *CompareRequest) (*CompareResponse, error)
+	// Enroll adds a subject's face to a gallery, creating the gallery if needed
+	Enroll(context.Context, *EnrollRequest) (*EnrollResponse, error)
+	// Search ranks the subjects of a gallery by similarity to a probe
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// AnalyzeVideo analyzes a stream of frames, decoded by the client, and
+	// answers each frame with its analysis. The options of the first frame
+	// apply to the whole stream.
+	AnalyzeVideo(grpc.BidiStreamingServer[VideoFrame, FrameAnalysis]) error
+	mustEmbedUnimplementedRocFaceServer()
+}
+
+// UnimplementedRocFaceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedRocFaceServer struct{}
+
+func (UnimplementedRocFaceServer) Verify(context.Context, *VerifyRequest) (*VerifyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedRocFaceServer) Analyze(context.Context, *AnalyzeRequest) (*AnalyzeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Analyze not implemented")
+}
+func (UnimplementedRocFaceServer) ExtractTemplate(context.Context, *ExtractTemplateRequest) (*ExtractTemplateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExtractTemplate not implemented")
+}
+func (UnimplementedRocFaceServer) Compare(context.Context, *CompareRequest) (*CompareResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Compare not implemented")
+}
+func (UnimplementedRocFaceServer) Enroll(context.Context, *EnrollRequest) (*EnrollResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Enroll not implemented")
+}
+func (UnimplementedRocFaceServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedRocFaceServer) AnalyzeVideo(grpc.BidiStreamingServer[VideoFrame, FrameAnalysis]) error {
+	return status.Errorf(codes.Unimplemented, "method AnalyzeVideo not implemented")
+}
+func (UnimplementedRocFaceServer) mustEmbedUnimplementedRocFaceServer() {}
+func (UnimplementedRocFaceServer) testEmbeddedByValue()                 {}
+
+// UnsafeRocFaceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RocFaceServer will
+// result in compilation errors.
+type UnsafeRocFaceServer interface {
+	mustEmbedUnimplementedRocFaceServer()
+}
+
+func RegisterRocFaceServer(s grpc.ServiceRegistrar, srv RocFaceServer) {
+	// If the following call pancis, it indicates UnimplementedRocFaceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&RocFace_ServiceDesc, srv)
+}
+
+func _RocFace_Verify_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RocFaceServer).Verify(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RocFace_Verify_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RocFaceServer).Verify(ctx, req.(*VerifyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RocFace_Analyze_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AnalyzeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RocFaceServer).Analyze(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RocFace_Analyze_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RocFaceServer).Analyze(ctx, req.(*AnalyzeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RocFace_ExtractTemplate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExtractTemplateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RocFaceServer).ExtractTemplate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RocFace_ExtractTemplate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RocFaceServer).ExtractTemplate(ctx, req.(*ExtractTemplateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RocFace_Compare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RocFaceServer).Compare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RocFace_Compare_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RocFaceServer).Compare(ctx, req.(*CompareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RocFace_Enroll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnrollRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RocFaceServer).Enroll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RocFace_Enroll_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RocFaceServer).Enroll(ctx, req.(*EnrollRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RocFace_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RocFaceServer).Search(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: RocFace_Search_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RocFaceServer).Search(ctx, req.(*SearchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RocFace_AnalyzeVideo_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(RocFaceServer).AnalyzeVideo(&grpc.GenericServerStream[VideoFrame, FrameAnalysis]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type RocFace_AnalyzeVideoServer = grpc.BidiStreamingServer[VideoFrame, FrameAnalysis]
+
+// RocFace_ServiceDesc is the grpc.ServiceDesc for RocFace service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var RocFace_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "rocface.RocFace",
+	HandlerType: (*RocFaceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Verify",
+			Handler:    _RocFace_Verify_Handler,
+		},
+		{
+			MethodName: "Analyze",
+			Handler:    _RocFace_Analyze_Handler,
+		},
+		{
+			MethodName: "ExtractTemplate",
+			Handler:    _RocFace_ExtractTemplate_Handler,
+		},
+		{
+			MethodName: "Compare",
+			Handler:    _RocFace_Compare_Handler,
+		},
+		{
+			MethodName: "Enroll",
+			Handler:    _RocFace_Enroll_Handler,
+		},
+		{
+			MethodName: "Search",
+			Handler:    _RocFace_Search_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "AnalyzeVideo",
+			Handler:       _RocFace_AnalyzeVideo_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "roc_face.proto",
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"net/http"
+	"testing"
+)
+
+func TestSelectFace(t *testing.T) {
+	var bounds = image.Rect(0, 0, 400, 200)
+	var faces = []detectedFace{
+		{Box: faceBox{X: 0, Y: 0, Width: 50, Height: 50}, Metadata: map[string]interface{}{"Quality": 0.5}},
+		{Box: faceBox{X: 300, Y: 20, Width: 90, Height: 90}, Metadata: map[string]interface{}{"Quality": 0.4}},
+		{Box: faceBox{X: 180, Y: 80, Width: 40, Height: 40}, Metadata: map[string]interface{}{"Quality": 0.8}},
+	}
+
+	for _, test := range []struct {
+		strategy string
+		faces    []detectedFace
+		want     int
+	}{
+		{selectionLargest, faces, 1},
+		{selectionMostCentral, faces, 2},
+		{selectionHighestQuality, faces, 2},
+		{selectionHighestQuality, []detectedFace{
+			{Box: faces[0].Box, Metadata: map[string]interface{}{}},
+			{Box: faces[1].Box, Metadata: map[string]interface{}{}},
+		}, 1},
+		// the first (best) face, verify() rejects the image
+		{selectionRejectIfMultiple, faces, 0},
+		{selectionLargest, faces[:1], 0},
+	} {
+		if got := selectFace(test.faces, test.strategy, bounds); got != test.want {
+			t.Errorf("%s of %d faces: %d, want %d", test.strategy, len(test.faces), got, test.want)
+		}
+	}
+}
+
+func TestSelectBestMatch(t *testing.T) {
+	var faces = [2][]detectedFace{
+		{{Template: []byte("a")}, {Template: []byte("b")}},
+		{{Template: []byte("c")}, {Template: []byte("b")}, {Template: []byte("d")}},
+	}
+
+	var i, j, similarity = selectBestMatch(faces, func(a detectedFace, b detectedFace) float32 {
+		if string(a.Template) == string(b.Template) {
+			return 0.9
+		}
+
+		return 0.1
+	})
+
+	if i != 1 || j != 1 || similarity != 0.9 {
+		t.Errorf("selectBestMatch = %d, %d, %v, want 1, 1, 0.9", i, j, similarity)
+	}
+}
+
+func TestVerifyFaceSelection(t *testing.T) {
+	var router = newRouter()
+	var images = map[string][]byte{
+		"image1": testImage("bob", "alice"),
+		"image2": testImage("alice"),
+	}
+
+	for _, test := range []struct {
+		strategy string
+		code     string
+		selected int
+		same     bool
+	}{
+		// bob is the first face of image1, best-match finds alice
+		{selectionBestMatch, "", 1, true},
+		{selectionRejectIfMultiple, "MultipleFacesDetected", 0, false},
+	} {
+		var w = postImages(t, router, "/verify?faceSelection="+test.strategy, images)
+		var result verificationResult
+		var err = json.Unmarshal(w.Body.Bytes(), &result)
+		if err != nil || w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d, %v: %s", test.strategy, w.Code, err, w.Body.String())
+		}
+
+		if result.Code != test.code || len(result.Selected) != 2 || result.Selected[0].Index != test.selected {
+			t.Errorf("%s: code %q, selected %+v, want code %q and face %d", test.strategy, result.Code, result.Selected, test.code, test.selected)
+			continue
+		}
+
+		if test.code == "" && (result.Similarity >= mockSameMinSimilarity) != test.same {
+			t.Errorf("%s: similarity %v, same subject: %v", test.strategy, result.Similarity, test.same)
+		}
+	}
+}
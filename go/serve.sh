@@ -9,4 +9,10 @@ export CGO_LDFLAGS=-L$(realpath "$HERE/../lib")
 
 PORT=${1-10001}
 
-go run -v $(dirname $0)/roc_server.go serve $PORT
+# the gRPC API needs grpc, so it's only built in when ROC_FACE_GRPC_PORT is set
+GRPC_FILES=""
+if [ -n "$ROC_FACE_GRPC_PORT" ]; then
+  GRPC_FILES="$HERE/roc_grpc.go"
+fi
+
+go run -v "$HERE"/roc_server.go "$HERE"/roc_face_*.go $GRPC_FILES serve $PORT
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"testing"
+)
+
+func TestSpoofOptionsValidate(t *testing.T) {
+	var nan = math.NaN()
+	var inf = math.Inf(1)
+	for _, opts := range []spoofOptions{
+		{Policy: spoofPolicyReject, LiveThreshold: nan, Threshold: defaultSpoofThreshold},
+		{Policy: spoofPolicyReject, LiveThreshold: defaultSpoofLiveThreshold, Threshold: nan},
+		{Policy: spoofPolicyStrict, LiveThreshold: -inf, Threshold: defaultSpoofThreshold},
+		{Policy: spoofPolicyStrict, LiveThreshold: defaultSpoofLiveThreshold, Threshold: inf},
+		{Policy: spoofPolicyReject, LiveThreshold: 0.8, Threshold: 0.5},
+		{Policy: "block", LiveThreshold: defaultSpoofLiveThreshold, Threshold: defaultSpoofThreshold},
+	} {
+		if opts.validate() == nil {
+			t.Errorf("validate accepted %+v", opts)
+		}
+	}
+
+	var opts = spoofOptions{Policy: spoofPolicyStrict, LiveThreshold: 0, Threshold: 1}
+	if err := opts.validate(); err != nil {
+		t.Errorf("validate(%+v): %v", opts, err)
+	}
+
+	// a score below the live threshold is live, from the threshold a spoof
+	for score, verdict := range map[float64]string{0: spoofVerdictLive, 0.5: spoofVerdictUncertain, 1: spoofVerdictSpoof} {
+		var result = spoofVerdict(map[string]interface{}{"SpoofAF": score}, spoofOptions{LiveThreshold: 0.3, Threshold: 0.7})
+		if result == nil || result.Verdict != verdict {
+			t.Errorf("score %v: %+v, want %s", score, result, verdict)
+		}
+	}
+}
+
+func TestSpoofOptionsRejects(t *testing.T) {
+	var live = &spoofResult{Verdict: spoofVerdictLive}
+	var uncertain = &spoofResult{Verdict: spoofVerdictUncertain}
+	var spoof = &spoofResult{Verdict: spoofVerdictSpoof}
+	for _, test := range []struct {
+		policy string
+		result *spoofResult
+		want   bool
+	}{
+		{spoofPolicyReport, spoof, false},
+		{spoofPolicyReport, nil, false},
+		{spoofPolicyReject, live, false},
+		{spoofPolicyReject, uncertain, false},
+		{spoofPolicyReject, spoof, true},
+		{spoofPolicyReject, nil, false},
+		{spoofPolicyStrict, live, false},
+		{spoofPolicyStrict, uncertain, true},
+		{spoofPolicyStrict, spoof, true},
+		// a face that couldn't be scored isn't known to be live
+		{spoofPolicyStrict, nil, true},
+	} {
+		var opts = spoofOptions{Policy: test.policy}
+		if got := opts.rejects(test.result); got != test.want {
+			t.Errorf("%s rejects %+v: %v, want %v", test.policy, test.result, got, test.want)
+		}
+	}
+}
+
+func TestAnalyzeSpoofPolicies(t *testing.T) {
+	var router = newRouter()
+	var image = map[string][]byte{"image": testImage("alice")}
+	var tests = []struct {
+		query string
+		code  string
+	}{
+		{"spoof=report&spoofLiveThreshold=0&spoofThreshold=0", ""},
+		{"spoof=reject&spoofLiveThreshold=1&spoofThreshold=1", ""},
+		{"spoof=reject&spoofLiveThreshold=0&spoofThreshold=0", "PresentationAttackDetected"},
+		{"spoof=strict&spoofLiveThreshold=0&spoofThreshold=1", "PresentationAttackDetected"},
+	}
+
+	for _, test := range tests {
+		var w = postImages(t, router, "/analyze?"+test.query, image)
+		var result analysisResult
+		json.NewDecoder(w.Body).Decode(&result)
+		if w.Code != http.StatusOK || result.Code != test.code || result.Spoof == nil {
+			t.Errorf("%s: status %d, result %+v, want code %q and a verdict", test.query, w.Code, result, test.code)
+		}
+	}
+
+	// without the spoof attribute there is no score to reject
+	if w := postImages(t, router, "/analyze?spoof=reject&attributes=pose", image); w.Code != http.StatusBadRequest {
+		t.Errorf("reject without the spoof attribute: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStorageKey is a base64 key of 32 times the byte
+func testStorageKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, storageKeySize))
+}
+
+func TestParseKeyring(t *testing.T) {
+	var ring, err = parseKeyring("# rotated in june\nnew:"+testStorageKey(1)+"\r\n, old : "+testStorageKey(2)+"\n", "test")
+	if err != nil || ring.active != "new" || len(ring.keys) != 2 || ring.keys["old"][0] != 2 {
+		t.Errorf("keyring = %+v, %v, want new and old, new active", ring, err)
+	}
+
+	for _, text := range []string{
+		"",
+		"# only a comment",
+		testStorageKey(1),
+		":" + testStorageKey(1),
+		"short:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"bad:not base64!",
+		"twice:" + testStorageKey(1) + ",twice:" + testStorageKey(2),
+	} {
+		if _, err = parseKeyring(text, "test"); err == nil {
+			t.Errorf("parseKeyring accepted %q", text)
+		}
+	}
+}
+
+func TestSealAndRekey(t *testing.T) {
+	var old, _ = parseKeyring("old:"+testStorageKey(1), "test")
+	var rotated, _ = parseKeyring("new:"+testStorageKey(2)+",old:"+testStorageKey(1), "test")
+	var plaintext = []byte(`{"default": []}`)
+	var sealed, err = old.sealData(plaintext, "test")
+	if err != nil || !bytes.HasPrefix(sealed, sealedPrefix) || bytes.Contains(sealed, plaintext) {
+		t.Fatalf("sealed = %q, %v", sealed, err)
+	}
+
+	var envelope sealedEnvelope
+	var dataKey []byte
+	envelope, dataKey, err = rotated.open(sealed)
+	if err != nil || envelope.KeyID != "old" {
+		t.Fatalf("open with the rotated keyring: %+v, %v", envelope, err)
+	}
+
+	var opened []byte
+	opened, err = openData(envelope, dataKey, "test")
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("opened %q, %v, want %q", opened, err, plaintext)
+	}
+
+	if _, err = openData(envelope, dataKey, "other"); err == nil {
+		t.Errorf("opened the data of another file")
+	}
+
+	// a tampered data key, and a missing key
+	var tampered = bytes.Replace(sealed, []byte(`"keyId":"old"`), []byte(`"keyId":"new"`), 1)
+	if _, _, err = rotated.open(tampered); err == nil {
+		t.Errorf("opened a data key under another key ID")
+	}
+
+	var other, _ = parseKeyring("other:"+testStorageKey(3), "test")
+	if _, _, err = other.open(sealed); err == nil || !strings.Contains(err.Error(), `"old"`) {
+		t.Errorf("open without the key: %v", err)
+	}
+
+	var dir string
+	dir, err = ioutil.TempDir("", "roc-face-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	defer func() {
+		storageKeys = nil
+	}()
+
+	// a file sealed with the old key, and a plaintext one
+	var sealedPath = filepath.Join(dir, "sealed")
+	var plainPath = filepath.Join(dir, "plain")
+	storageKeys = old
+	writeStoredFile(sealedPath, plaintext)
+	ioutil.WriteFile(plainPath, plaintext, 0600)
+	for _, path := range []string{sealedPath, plainPath} {
+		var changed bool
+		changed, err = rotated.rekeyFile(path)
+		if err != nil || !changed {
+			t.Errorf("rekey %s: %t, %v", path, changed, err)
+		}
+
+		var data, _ = ioutil.ReadFile(path)
+		envelope, _, err = rotated.open(data)
+		if err != nil || envelope.KeyID != "new" {
+			t.Errorf("%s after rekeying: %+v, %v, want it sealed with the new key", path, envelope, err)
+		}
+
+		// read back with the rotated keyring
+		storageKeys = rotated
+		data, err = readStoredFile(path)
+		if err != nil || !bytes.Equal(data, plaintext) {
+			t.Errorf("read %s: %q, %v", path, data, err)
+		}
+
+		changed, err = rotated.rekeyFile(path)
+		if err != nil || changed {
+			t.Errorf("rekey %s again: %t, %v, want it unchanged", path, changed, err)
+		}
+	}
+
+	// no temporary files are left
+	var files, _ = ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("%d files in the directory, want 2", len(files))
+	}
+}
+
+func TestStoredFilesBound(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "roc-face-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	os.Setenv("ROC_FACE_JOBS_DIR", filepath.Join(dir, "jobs"))
+	defer os.Unsetenv("ROC_FACE_JOBS_DIR")
+	storageKeys, _ = parseKeyring("key:"+testStorageKey(1), "test")
+	defer func() {
+		storageKeys = nil
+	}()
+
+	var alice, bob = filepath.Join(dir, "jobs", "alice", "job.json"), filepath.Join(dir, "jobs", "bob", "job.json")
+	for _, path := range []string{alice, bob} {
+		os.MkdirAll(filepath.Dir(path), 0700)
+		if err = writeStoredFile(path, []byte(path)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if id, _ := storedFileID(alice); id != "ROC_FACE_JOBS_DIR:alice/job.json" {
+		t.Errorf("alice's job file is %q", id)
+	}
+
+	// the store can be moved
+	var moved = filepath.Join(dir, "moved")
+	os.Rename(filepath.Join(dir, "jobs"), moved)
+	os.Setenv("ROC_FACE_JOBS_DIR", moved)
+	if data, err := readStoredFile(filepath.Join(moved, "alice", "job.json")); err != nil || string(data) != alice {
+		t.Errorf("read from the moved store: %q, %v", data, err)
+	}
+
+	// not its files
+	alice, bob = filepath.Join(moved, "alice", "job.json"), filepath.Join(moved, "bob", "job.json")
+	var aliceData, _ = ioutil.ReadFile(alice)
+	ioutil.WriteFile(bob, aliceData, 0600)
+	if _, err = readStoredFile(bob); err == nil {
+		t.Errorf("read alice's job file as bob's")
+	}
+
+	var rotated, _ = parseKeyring("new:"+testStorageKey(2)+",key:"+testStorageKey(1), "test")
+	if changed, err := rotated.rekeyFile(bob); err == nil || changed {
+		t.Errorf("rekeyed alice's job file as bob's: %t, %v", changed, err)
+	}
+
+	os.Setenv("ROC_FACE_JOBS_DIR", filepath.Join(dir, "jobs"))
+	if _, err = readStoredFile(alice); err == nil {
+		t.Errorf("read a job file out of the jobs directory")
+	}
+}
+
+func TestPlaintextFiles(t *testing.T) {
+	var file, err = ioutil.TempFile("", "roc-face-plaintext")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Remove(file.Name())
+	file.WriteString(`{"forged": []}`)
+	file.Close()
+	if _, err = readStoredFile(file.Name()); err != nil {
+		t.Errorf("read a plaintext file without a keyring: %v", err)
+	}
+
+	// a keyring doesn't read them, unless allowed
+	storageKeys, _ = parseKeyring("key:"+testStorageKey(1), "test")
+	defer func() {
+		storageKeys = nil
+		storageAllowPlaintext = false
+	}()
+
+	if _, err = readStoredFile(file.Name()); err == nil {
+		t.Errorf("read a plaintext file with a keyring")
+	}
+
+	if _, err = openStoredImage(file.Name()); err == nil {
+		t.Errorf("opened a plaintext image with a keyring")
+	}
+
+	storageAllowPlaintext = true
+	if _, err = readStoredFile(file.Name()); err != nil {
+		t.Errorf("read a plaintext file when allowed: %v", err)
+	}
+}
+
+func TestOverlappingStores(t *testing.T) {
+	defer os.Unsetenv("ROC_FACE_JOBS_DIR")
+	defer os.Unsetenv("ROC_FACE_CACHE_DIR")
+	for _, test := range []struct {
+		jobsDir, cacheDir string
+		overlap           bool
+	}{
+		{"/data/jobs", "/data/cache", false},
+		{"/data/jobs", "/data/jobs-cache", false},
+		{"/data/jobs", "/data/jobs/cache", true},
+		{"/data/jobs/../cache", "/data/cache", true},
+		{"/data", "/data/cache", true},
+	} {
+		os.Setenv("ROC_FACE_JOBS_DIR", test.jobsDir)
+		os.Setenv("ROC_FACE_CACHE_DIR", test.cacheDir)
+		if err := checkStoredPaths(); (err != nil) != test.overlap {
+			t.Errorf("jobs in %s, cache in %s: %v, want an error: %t", test.jobsDir, test.cacheDir, err, test.overlap)
+		}
+	}
+}
+
+func TestGallerySaves(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "roc-face-galleries")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "galleries.json")
+	var store = &galleryStore{galleries: map[string][]*galleryEntry{}, path: path}
+	var read = func() map[string][]*galleryEntry {
+		var saved map[string][]*galleryEntry
+		var data, _ = ioutil.ReadFile(path)
+		json.Unmarshal(data, &saved)
+		return saved
+	}
+
+	// enrollments are saved at once
+	var template = bytes.Repeat([]byte{1}, mockTemplateSize)
+	store.enroll(defaultGallery, "alice", template)
+	if saved := read(); len(saved[defaultGallery]) != 1 || !saved[defaultGallery][0].LastMatchedAt.IsZero() {
+		t.Fatalf("saved %+v, want alice's template", saved)
+	}
+
+	// matches only by save, e.g. from flush
+	var matches, _ = store.search(defaultGallery, template, 1, 0)
+	if len(matches) != 1 || !read()[defaultGallery][0].LastMatchedAt.IsZero() {
+		t.Errorf("the galleries were saved after a search")
+	}
+
+	store.save()
+	if saved := read(); time.Since(saved[defaultGallery][0].LastMatchedAt) > time.Minute {
+		t.Errorf("saved %+v, want alice's last match", saved[defaultGallery][0])
+	}
+
+	// erasures are saved at once
+	if _, err = store.erase("alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if saved := read(); len(saved[defaultGallery]) != 0 {
+		t.Errorf("saved %+v after erasing alice", saved)
+	}
+
+	// failed saves are reported, and enrollments undone
+	store.enroll(defaultGallery, "bob", template)
+	store.path = filepath.Join(dir, "missing", "galleries.json")
+	if _, err = store.enroll(defaultGallery, "carol", template); err == nil || len(store.galleries[defaultGallery]) != 1 {
+		t.Errorf("enrolled carol without saving: %v, %d templates", err, len(store.galleries[defaultGallery]))
+	}
+
+	var removed map[string][]*galleryEntry
+	removed, err = store.erase("bob")
+	if err == nil || len(removed[defaultGallery]) != 1 {
+		t.Errorf("erased bob without saving: %v, %+v", err, removed)
+	}
+
+	// and retried by the next save
+	store.path = path
+	if err = store.save(); err != nil || len(read()[defaultGallery]) != 0 {
+		t.Errorf("saved %+v, %v, want bob erased", read(), err)
+	}
+}
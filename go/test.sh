@@ -0,0 +1,17 @@
+#!/bin/bash
+
+# runs the tests against the mock engine, no SDK or license needed:
+# ./test.sh [GO TEST FLAGS]. Set ROC_FACE_GRPC_TEST=1 to test the gRPC API
+# too, it needs grpc (see setup.sh). Test files aren't named roc_face_*.go,
+# so that go run roc_face_*.go doesn't pick them up.
+
+HERE=$(dirname $0)
+
+GRPC_FILES=""
+if [ -n "$ROC_FACE_GRPC_TEST" ]; then
+  GRPC_FILES="$HERE/roc_grpc.go $HERE/grpc_test.go"
+fi
+
+TEST_FILES=$(ls "$HERE"/*_test.go | grep -v '/grpc_test.go$')
+
+go test "$@" "$HERE"/roc_mock_server.go "$HERE"/roc_face_*.go $TEST_FILES $GRPC_FILES
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestTiledDetectionMergesOverlap(t *testing.T) {
+	// like the SDK's adaptive minimum, faces under 1/16th of the image width
+	// aren't found, so this one only is in full resolution tiles. It's in the
+	// overlap of the first two, both find it.
+	var marker = image.Rect(800, 400, 880, 480)
+	var detections = 0
+	var detect = func(img image.Image) []detectedFace {
+		var found = markerBounds(img)
+		if found.Empty() || found.Dx() < img.Bounds().Dx()/16 {
+			return nil
+		}
+
+		detections++
+		return []detectedFace{{Box: boxOf(found), Metadata: map[string]interface{}{}}}
+	}
+
+	var img = markedImage(1792, 1024, marker)
+	if faces := detect(img); len(faces) != 0 {
+		t.Fatalf("found %+v without tiles", faces)
+	}
+
+	var faces = tiledDetection(img, 10, detect)
+	if detections != 2 {
+		t.Errorf("%d tiles found the face, want 2", detections)
+	}
+
+	if len(faces) != 1 || faces[0].Box != boxOf(marker) {
+		t.Errorf("faces = %+v, want one at %+v", faces, boxOf(marker))
+	}
+}
+
+func TestTiledDetectionMockEngine(t *testing.T) {
+	// the mock finds no face in an image tagged without subjects, but the
+	// tags are lost on tiles, where it finds faces as in any other image
+	var rgba = image.NewRGBA(image.Rect(0, 0, 1792, 1024))
+	for y := 0; y < 1024; y++ {
+		for x := 0; x < 1792; x++ {
+			rgba.Set(x, y, color.RGBA{uint8(x), uint8(y), 0, 255})
+		}
+	}
+
+	var img = taggedImage{Image: rgba}
+	var detect = func(img image.Image) []detectedFace {
+		return engine.detectFaces(img, colorSpaceGray8, []string{"recognition"}, adaptiveMinFaceWidth, 10, defaultFDR)
+	}
+
+	if faces := detect(img); len(faces) != 0 {
+		t.Fatalf("found %d faces without tiles", len(faces))
+	}
+
+	var faces = tiledDetection(img, 10, detect)
+	if len(faces) == 0 {
+		t.Fatal("no face found in the tiles")
+	}
+
+	for i, face := range faces {
+		if !face.Box.rect().In(rgba.Rect) {
+			t.Errorf("face %d at %+v, outside the image", i, face.Box)
+		}
+
+		for _, other := range faces[:i] {
+			if boxOverlap(face.Box, other.Box) > nmsMaxOverlap {
+				t.Errorf("faces at %+v and %+v weren't merged", face.Box, other.Box)
+			}
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	// Third party packages
+	"github.com/gorilla/mux"
+)
+
+// webhookReceiver records the deliveries POSTed to it, checking their
+// signature, and fails the first failures of them
+type webhookReceiver struct {
+	t        *testing.T
+	secret   string
+	failures int
+
+	mutex    sync.Mutex
+	received []time.Time
+	bodies   []webhookBody
+}
+
+func (receiver *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var data, _ = ioutil.ReadAll(r.Body)
+	var timestamp = r.Header.Get(webhookTimestampHeader)
+	if timestamp == "" || r.Header.Get(webhookSignatureHeader) != signWebhook(receiver.secret, timestamp, data) {
+		receiver.t.Errorf("delivery signature %q doesn't match its body", r.Header.Get(webhookSignatureHeader))
+	}
+
+	var body webhookBody
+	var err = json.Unmarshal(data, &body)
+	if err != nil || body.Event != r.Header.Get(webhookEventHeader) || body.ID != r.Header.Get(webhookDeliveryHeader) {
+		receiver.t.Errorf("delivery body %s doesn't match its headers, error: %v", data, err)
+	}
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	receiver.received = append(receiver.received, time.Now())
+	receiver.bodies = append(receiver.bodies, body)
+	if len(receiver.received) <= receiver.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// newTestWebhooks returns a dispatcher with a temporary file, delivering
+// to loopback addresses with millisecond backoffs, and its routes
+func newTestWebhooks(t *testing.T) (*webhookDispatcher, *mux.Router, func()) {
+	var file, err = ioutil.TempFile("", "roc-face-webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Close()
+	os.Remove(file.Name())
+	var dispatcher = newWebhookDispatcher(file.Name(), true)
+	dispatcher.MaxAttempts = 3
+	dispatcher.MinBackoff = 10 * time.Millisecond
+	dispatcher.MaxBackoff = 20 * time.Millisecond
+	var router = mux.NewRouter()
+	dispatcher.routes(router)
+	return dispatcher, router, func() {
+		dispatcher.waitWritten()
+		os.Remove(file.Name())
+	}
+}
+
+func registerWebhook(t *testing.T, router http.Handler, callbackURL string, events ...string) (*httptest.ResponseRecorder, webhook) {
+	var data, _ = json.Marshal(webhook{URL: callbackURL, Events: events})
+	var w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks", bytes.NewReader(data)))
+	var hook webhook
+	json.Unmarshal(w.Body.Bytes(), &hook)
+	return w, hook
+}
+
+// waitForDeliveries waits until the router lists count deliveries at url
+// with the status
+func waitForDeliveries(t *testing.T, router http.Handler, url string, count int, status string) []webhookDelivery {
+	var deliveries []webhookDelivery
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		var w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		deliveries = nil
+		json.Unmarshal(w.Body.Bytes(), &deliveries)
+		var matching = 0
+		for _, delivery := range deliveries {
+			if delivery.Status == status {
+				matching++
+			}
+		}
+
+		if matching == count {
+			return deliveries
+		}
+	}
+
+	t.Fatalf("%s = %+v, want %d %s deliveries", url, deliveries, count, status)
+	return nil
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	var dispatcher, router, cleanup = newTestWebhooks(t)
+	defer cleanup()
+
+	var receiver = &webhookReceiver{t: t, failures: 2}
+	var server = httptest.NewServer(receiver)
+	defer server.Close()
+
+	var w, hook = registerWebhook(t, router, server.URL, webhookEventWatchlistHit)
+	if w.Code != http.StatusCreated || hook.Secret == "" {
+		t.Fatalf("register: status %d: %s", w.Code, w.Body.String())
+	}
+
+	receiver.secret = hook.Secret
+
+	// only matches at or above the threshold are hits, and only for the
+	// webhook's events
+	dispatcher.notify(webhookEventJobFinished, "", map[string]string{"id": "job"})
+	dispatcher.notifyWatchlistHits("", "default", []galleryMatch{{"alice", 0.9}, {"bob", 0.5}}, "probe")
+	dispatcher.notifyWatchlistHits("", "default", []galleryMatch{{"bob", 0.5}}, "probe")
+	var deliveries = waitForDeliveries(t, router, "/webhooks/"+hook.ID+"/deliveries", 1, deliveryStatusDelivered)
+	if len(deliveries) != 1 || len(deliveries[0].Attempts) != 3 {
+		t.Fatalf("deliveries = %+v, want 1 delivered after 3 attempts", deliveries)
+	}
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	var hit watchlistHit
+	json.Unmarshal(receiver.bodies[2].Data, &hit)
+	if receiver.bodies[2].Event != webhookEventWatchlistHit || len(hit.Matches) != 1 || hit.Matches[0].SubjectID != "alice" || hit.ProbeHash != "probe" {
+		t.Errorf("delivered %+v, want a watchlist hit of alice", hit)
+	}
+
+	for i := 1; i < len(receiver.received); i++ {
+		var gap = receiver.received[i].Sub(receiver.received[i-1])
+		if gap < dispatcher.backoff(i) {
+			t.Errorf("attempt %d came %s after the previous one, want a backoff of %s", i+1, gap, dispatcher.backoff(i))
+		}
+	}
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	var dispatcher, router, cleanup = newTestWebhooks(t)
+	defer cleanup()
+
+	var receiver = &webhookReceiver{t: t, failures: 100}
+	var server = httptest.NewServer(receiver)
+	defer server.Close()
+
+	var _, hook = registerWebhook(t, router, server.URL)
+	receiver.secret = hook.Secret
+	dispatcher.notify(webhookEventJobFinished, "", map[string]string{"id": "job"})
+	var deadLetters = waitForDeliveries(t, router, "/webhooks/dead-letters", 1, deliveryStatusFailed)
+	if len(deadLetters[0].Attempts) != dispatcher.MaxAttempts || deadLetters[0].Attempts[0].StatusCode != http.StatusInternalServerError {
+		t.Errorf("dead letter = %+v, want %d failed attempts", deadLetters[0], dispatcher.MaxAttempts)
+	}
+
+	// retried once the receiver is back
+	receiver.mutex.Lock()
+	receiver.failures = 0
+	receiver.mutex.Unlock()
+	var w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/dead-letters/"+deadLetters[0].ID+"/retry", nil))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("retry: status %d: %s", w.Code, w.Body.String())
+	}
+
+	waitForDeliveries(t, router, "/webhooks/"+hook.ID+"/deliveries", 1, deliveryStatusDelivered)
+
+	// dead letters past their TTL are pruned
+	dispatcher.mutex.Lock()
+	dispatcher.deliveries["old"] = &webhookDelivery{ID: "old", Status: deliveryStatusFailed, CreatedAt: time.Now().Add(-2 * webhookDeadLetterTTL),
+		Attempts: []webhookAttempt{{At: time.Now().Add(-webhookDeadLetterTTL - time.Hour)}}}
+	dispatcher.deliveries["recent"] = &webhookDelivery{ID: "recent", Status: deliveryStatusFailed, CreatedAt: time.Now().Add(-2 * webhookDeadLetterTTL),
+		Attempts: []webhookAttempt{{At: time.Now().Add(-time.Hour)}}}
+	dispatcher.prune(time.Now())
+	var _, old = dispatcher.deliveries["old"]
+	var _, recent = dispatcher.deliveries["recent"]
+	dispatcher.mutex.Unlock()
+	if old || !recent {
+		t.Errorf("after pruning, old dead letter kept: %t, recent one kept: %t", old, recent)
+	}
+}
+
+func TestWebhookAddresses(t *testing.T) {
+	var dispatcher, router, cleanup = newTestWebhooks(t)
+	defer cleanup()
+
+	var receiver = &webhookReceiver{t: t}
+	var server = httptest.NewServer(receiver)
+	defer server.Close()
+
+	// registered while loopback addresses are allowed
+	var _, hook = registerWebhook(t, router, server.URL)
+	receiver.secret = hook.Secret
+	dispatcher.allowLoopback = false
+	for _, callbackURL := range []string{
+		server.URL,
+		"http://localhost/hook",
+		"http://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://0.0.0.0/hook",
+		"http://10.1.2.3/hook",
+		"http://172.16.0.1/hook",
+		"http://192.168.1.1/hook",
+		"http://[fd00::1]/hook",
+	} {
+		if w, _ := registerWebhook(t, router, callbackURL); w.Code != http.StatusBadRequest {
+			t.Errorf("register %s: status %d, want 400", callbackURL, w.Code)
+		}
+	}
+
+	for _, ip := range []string{"172.15.255.255", "172.32.0.1", "8.8.8.8", "2001:db8::1"} {
+		if isPrivateIP(net.ParseIP(ip)) {
+			t.Errorf("%s is private, want it public", ip)
+		}
+	}
+
+	// a proxy would connect to the callback address unchecked
+	if transport, ok := dispatcher.client.Transport.(*http.Transport); !ok || transport.Proxy != nil {
+		t.Errorf("delivery transport = %+v, want one without a proxy", dispatcher.client.Transport)
+	}
+
+	// checked again when delivering, e.g. if the host's address changed
+	dispatcher.notify(webhookEventJobFinished, "", map[string]string{"id": "job"})
+	var deadLetters = waitForDeliveries(t, router, "/webhooks/dead-letters", 1, deliveryStatusFailed)
+	if !strings.Contains(deadLetters[0].Attempts[0].Error, "loopback") || len(receiver.received) != 0 {
+		t.Errorf("delivery to a loopback address = %+v, %d received, want it refused", deadLetters[0], len(receiver.received))
+	}
+
+	// private addresses are allowed explicitly
+	dispatcher.allowPrivate = true
+	if w, _ := registerWebhook(t, router, "http://10.1.2.3/hook"); w.Code != http.StatusCreated {
+		t.Errorf("register a private address when allowed: status %d, want 201", w.Code)
+	}
+}
+
+func TestWebhookRedactSubject(t *testing.T) {
+	var dispatcher, router, cleanup = newTestWebhooks(t)
+	defer cleanup()
+
+	// the receiver never accepts, so the deliveries are kept
+	var receiver = &webhookReceiver{t: t, failures: 100}
+	var server = httptest.NewServer(receiver)
+	defer server.Close()
+
+	var _, hook = registerWebhook(t, router, server.URL, webhookEventWatchlistHit)
+	receiver.secret = hook.Secret
+	dispatcher.notifyWatchlistHits("", "default", []galleryMatch{{"alice", 0.9}, {"bob", 0.8}}, "probe1")
+	dispatcher.notifyWatchlistHits("", "default", []galleryMatch{{"alice", 0.9}}, "probe2")
+	waitForDeliveries(t, router, "/webhooks/dead-letters", 2, deliveryStatusFailed)
+	if redacted := dispatcher.redactSubject("alice"); redacted != 2 {
+		t.Errorf("redacted %d deliveries, want 2", redacted)
+	}
+
+	var deliveries = waitForDeliveries(t, router, "/webhooks/dead-letters", 1, deliveryStatusFailed)
+	var hit watchlistHit
+	json.Unmarshal(deliveries[0].Payload, &hit)
+	if len(hit.Matches) != 1 || hit.Matches[0].SubjectID != "bob" || hit.ProbeHash != "probe1" {
+		t.Errorf("hit left = %+v, want bob's match only", hit)
+	}
+}
+
+func TestWebhookStateFile(t *testing.T) {
+	var dispatcher, router, cleanup = newTestWebhooks(t)
+	defer cleanup()
+
+	var receiver = &webhookReceiver{t: t}
+	var server = httptest.NewServer(receiver)
+	defer server.Close()
+
+	var _, hook = registerWebhook(t, router, server.URL)
+	receiver.secret = hook.Secret
+	dispatcher.notify(webhookEventJobFinished, "", map[string]string{"id": "job1"})
+	waitForDeliveries(t, router, "/webhooks/"+hook.ID+"/deliveries", 1, deliveryStatusDelivered)
+	receiver.mutex.Lock()
+	receiver.failures = 100
+	receiver.mutex.Unlock()
+	dispatcher.notify(webhookEventJobFinished, "", map[string]string{"id": "job2"})
+	var deadLetters = waitForDeliveries(t, router, "/webhooks/dead-letters", 1, deliveryStatusFailed)
+
+	// the delivered history isn't written
+	dispatcher.waitWritten()
+	var data, err = readStoredFile(dispatcher.path)
+	var state webhookState
+	if err == nil {
+		err = json.Unmarshal(data, &state)
+	}
+
+	if err != nil || len(state.Webhooks) != 1 || len(state.Deliveries) != 1 || state.Deliveries[0].ID != deadLetters[0].ID {
+		t.Fatalf("webhooks file: %+v, %v, want the webhook and the dead letter", state, err)
+	}
+
+	var reloaded = newWebhookDispatcher(dispatcher.path, true)
+	if err = reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.webhooks[hook.ID] == nil || reloaded.deliveries[deadLetters[0].ID] == nil {
+		t.Errorf("reloaded %+v, %+v", reloaded.webhooks, reloaded.deliveries)
+	}
+}
+
+// withAPIKey sends every request to handler with the API key
+func withAPIKey(handler http.Handler, apiKey string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("X-Api-Key", apiKey)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func TestWebhooksBelongToTheirAPIKey(t *testing.T) {
+	var dispatcher, router, cleanup = newTestWebhooks(t)
+	defer cleanup()
+
+	var alice, bob = withAPIKey(router, "alice-key"), withAPIKey(router, "bob-key")
+	var aliceReceiver = &webhookReceiver{t: t}
+	var aliceServer = httptest.NewServer(aliceReceiver)
+	defer aliceServer.Close()
+
+	var bobReceiver = &webhookReceiver{t: t, failures: 100}
+	var bobServer = httptest.NewServer(bobReceiver)
+	defer bobServer.Close()
+
+	var _, aliceHook = registerWebhook(t, alice, aliceServer.URL)
+	var _, bobHook = registerWebhook(t, bob, bobServer.URL)
+	aliceReceiver.secret = aliceHook.Secret
+	bobReceiver.secret = bobHook.Secret
+	for _, test := range []struct {
+		handler http.Handler
+		want    []string
+	}{{alice, []string{aliceHook.ID}}, {bob, []string{bobHook.ID}}, {router, nil}} {
+		var w = httptest.NewRecorder()
+		test.handler.ServeHTTP(w, httptest.NewRequest("GET", "/webhooks", nil))
+		var hooks []webhook
+		json.Unmarshal(w.Body.Bytes(), &hooks)
+		var ids []string
+		for _, hook := range hooks {
+			ids = append(ids, hook.ID)
+		}
+
+		if !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("listed %v, want %v", ids, test.want)
+		}
+	}
+
+	// the events of a key's requests only go to its webhooks
+	dispatcher.notify(webhookEventJobFinished, apiKeyFingerprint("alice-key", ""), map[string]string{"id": "job"})
+	dispatcher.notifyWatchlistHits(apiKeyFingerprint("bob-key", ""), "default", []galleryMatch{{"carol", 0.9}}, "probe")
+	waitForDeliveries(t, alice, "/webhooks/"+aliceHook.ID+"/deliveries", 1, deliveryStatusDelivered)
+	var deadLetters = waitForDeliveries(t, bob, "/webhooks/dead-letters", 1, deliveryStatusFailed)
+	aliceReceiver.mutex.Lock()
+	if len(aliceReceiver.bodies) != 1 || aliceReceiver.bodies[0].Event != webhookEventJobFinished {
+		t.Errorf("alice received %+v, want her job only", aliceReceiver.bodies)
+	}
+
+	aliceReceiver.mutex.Unlock()
+
+	// nor can another key see or change them
+	for _, request := range []struct {
+		method string
+		url    string
+	}{
+		{"GET", "/webhooks/" + bobHook.ID + "/deliveries"},
+		{"DELETE", "/webhooks/" + bobHook.ID},
+		{"POST", "/webhooks/dead-letters/" + deadLetters[0].ID + "/retry"},
+	} {
+		var w = httptest.NewRecorder()
+		alice.ServeHTTP(w, httptest.NewRequest(request.method, request.url, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s %s with another key: status %d, want 404", request.method, request.url, w.Code)
+		}
+	}
+
+	var w = httptest.NewRecorder()
+	alice.ServeHTTP(w, httptest.NewRequest("GET", "/webhooks/dead-letters", nil))
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("alice's dead letters = %s, want none", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	bob.ServeHTTP(w, httptest.NewRequest("DELETE", "/webhooks/"+bobHook.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("delete with the webhook's key: status %d, want 200", w.Code)
+	}
+}
@@ -7,13 +7,19 @@ curl "$RANKONE_TAR" > rankone.tar.gz
 tar -xzvf rankone.tar.gz
 mv roc-linux-x64-fma3 rankone
 cp $RANKONE_LICENSE rankone/
-curl https://raw.githubusercontent.com/mvayngrib/roc-face/master/go/roc_server.go > rankone/go/roc_server.go
-curl https://raw.githubusercontent.com/mvayngrib/roc-face/master/go/serve.sh > rankone/go/serve.sh
+SERVER_FILES="roc_server.go roc_face_api.go roc_face_image.go roc_face_annotate.go roc_face_spoof.go roc_face_quality.go roc_face_attributes.go roc_face_exif.go roc_face_retry.go roc_face_tiling.go roc_face_selection.go roc_face_details.go roc_face_faults.go roc_face_idempotency.go roc_face_openapi.go roc_face_gallery.go roc_face_jobs.go roc_face_webhooks.go roc_face_cache.go roc_face_audit.go roc_face_receipts.go roc_face_storage.go roc_face_retention.go roc_grpc.go serve.sh"
+for FILE in $SERVER_FILES; do
+  curl "https://raw.githubusercontent.com/mvayngrib/roc-face/master/go/$FILE" > "rankone/go/$FILE"
+done
 chmod +x rankone/go/serve.sh
 cd rankone/go
-go get github.com/gorilla/mux
+go get github.com/gorilla/mux golang.org/x/image/font/basicfont golang.org/x/crypto/ed25519
 
 echo "
 to start the server:
 ./serve.sh [PORT]
+
+to serve the gRPC API too (needs a recent Go):
+go get google.golang.org/grpc github.com/mvayngrib/roc-face/go/rocfacepb
+ROC_FACE_GRPC_PORT=10002 ./serve.sh [PORT]
 "